@@ -2,11 +2,14 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
 
+	"github.com/andrewsvn/metrics-overseer/internal/apitoken"
+	"github.com/andrewsvn/metrics-overseer/internal/encrypt"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -34,6 +37,24 @@ func TestRSAKeyPairGeneration(t *testing.T) {
 	_ = os.RemoveAll(baseDir)
 }
 
+func TestX25519KeyPairGeneration(t *testing.T) {
+	baseDir := t.TempDir()
+
+	err := generateX25519KeyPair(baseDir, "priv.pem", "pub.pem")
+	require.NoError(t, err)
+
+	priv, err := encrypt.ReadX25519PrivateKeyFromFile(filepath.Join(baseDir, "priv.pem"))
+	require.NoError(t, err)
+	pub, err := encrypt.ReadX25519PublicKeyFromFile(filepath.Join(baseDir, "pub.pem"))
+	require.NoError(t, err)
+	assert.True(t, priv.PublicKey().Equal(pub))
+
+	// agent detects key type by public key file
+	encrypter, err := encrypt.ReadEncrypterFromFile(filepath.Join(baseDir, "pub.pem"))
+	require.NoError(t, err)
+	assert.Equal(t, encrypt.SchemeX25519, encrypter.Scheme())
+}
+
 func checkKeyFile(t *testing.T, filePath string, keyType string) {
 	f, err := os.OpenFile(filePath, os.O_RDONLY, 0644)
 	require.NoError(t, err)
@@ -59,3 +80,17 @@ func checkKeyFile(t *testing.T, filePath string, keyType string) {
 	assert.True(t, hasBeginKey)
 	assert.True(t, hasEndKey)
 }
+
+func TestTokenGeneration(t *testing.T) {
+	_, _, err := generateToken("agent", "write,delete")
+	assert.Error(t, err)
+
+	value, def, err := generateToken("agent", "write,read")
+	require.NoError(t, err)
+
+	var tok apitoken.Token
+	require.NoError(t, json.Unmarshal(def, &tok))
+	assert.Equal(t, "agent", tok.Name)
+	assert.Equal(t, apitoken.Hash(value), tok.Hash)
+	assert.Equal(t, []apitoken.Scope{apitoken.ScopeWrite, apitoken.ScopeRead}, tok.Scopes)
+}
@@ -2,15 +2,25 @@ package main
 
 import (
 	"bytes"
+	"crypto/ecdh"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
+	"encoding/json"
 	"encoding/pem"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
+
+	"github.com/andrewsvn/metrics-overseer/internal/apitoken"
+)
+
+const (
+	keyTypeRSA    = "rsa"
+	keyTypeX25519 = "x25519"
 )
 
 func generateKeyPair(baseDir string, privateKeyName string, publicKeyName string, nBits int) error {
@@ -50,23 +60,103 @@ func generateKeyPair(baseDir string, privateKeyName string, publicKeyName string
 	return nil
 }
 
+// generateX25519KeyPair creates X25519 keys for encryption engine based on key agreement:
+// private key is stored in PKCS8 format, public key - in PKIX format
+func generateX25519KeyPair(baseDir string, privateKeyName string, publicKeyName string) error {
+	privateKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return fmt.Errorf("error generating X25519 key pair: %w", err)
+	}
+
+	privateDER, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return fmt.Errorf("error encoding X25519 private key: %w", err)
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(privateKey.PublicKey())
+	if err != nil {
+		return fmt.Errorf("error encoding X25519 public key: %w", err)
+	}
+
+	privateKeyPem := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER})
+	publicKeyPem := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+
+	err = os.WriteFile(filepath.Join(baseDir, privateKeyName), privateKeyPem, 0600)
+	if err != nil {
+		return fmt.Errorf("error writing X25519 private key: %w", err)
+	}
+
+	err = os.WriteFile(filepath.Join(baseDir, publicKeyName), publicKeyPem, 0644)
+	if err != nil {
+		return fmt.Errorf("error writing X25519 public key: %w", err)
+	}
+
+	return nil
+}
+
+// generateToken creates a new API token value and its definition to be added to server tokens file
+func generateToken(name string, scopeNames string) (string, []byte, error) {
+	scopes, err := apitoken.ParseScopes(strings.Split(scopeNames, ","))
+	if err != nil {
+		return "", nil, err
+	}
+
+	value, err := apitoken.Generate()
+	if err != nil {
+		return "", nil, err
+	}
+
+	def, err := json.Marshal(&apitoken.Token{
+		Name:   name,
+		Hash:   apitoken.Hash(value),
+		Scopes: scopes,
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("error encoding token definition: %w", err)
+	}
+	return value, def, nil
+}
+
 func main() {
 	var baseDir string
 	var privKeyName string
 	var pubKeyName string
 	var bits int
+	var tokenName string
+	var tokenScopes string
+	var keyType string
 
 	flag.StringVar(&privKeyName, "pr", "private.pem", "File name for private key")
 	flag.StringVar(&pubKeyName, "pb", "public.pem", "File name for public key")
+	flag.StringVar(&keyType, "type", keyTypeRSA, "Key pair type: rsa or x25519")
 	flag.IntVar(&bits, "bits", 2048, "Key pair bit size (minimum 1024, not less than 2048 recommended)")
+	flag.StringVar(&tokenName, "token", "", "Generate API token with a given name instead of key pair")
+	flag.StringVar(&tokenScopes, "scopes", string(apitoken.ScopeWrite),
+		"Comma-separated list of API token scopes: write, read, admin")
 	flag.Parse()
 
+	if tokenName != "" {
+		value, def, err := generateToken(tokenName, tokenScopes)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("token: %s\ndefinition: %s\n", value, def)
+		return
+	}
+
 	baseDir = flag.Arg(0)
 	if baseDir == "" {
 		baseDir = "."
 	}
 
-	err := generateKeyPair(baseDir, privKeyName, pubKeyName, bits)
+	var err error
+	switch keyType {
+	case keyTypeRSA:
+		err = generateKeyPair(baseDir, privKeyName, pubKeyName, bits)
+	case keyTypeX25519:
+		err = generateX25519KeyPair(baseDir, privKeyName, pubKeyName)
+	default:
+		err = fmt.Errorf("unsupported key type: %s", keyType)
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
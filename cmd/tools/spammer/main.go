@@ -14,6 +14,7 @@ import (
 	"math/rand/v2"
 
 	"github.com/andrewsvn/metrics-overseer/internal/agent/sending"
+	"github.com/andrewsvn/metrics-overseer/internal/compress"
 	"github.com/andrewsvn/metrics-overseer/internal/config/spammercfg"
 	"github.com/andrewsvn/metrics-overseer/internal/logging"
 	"github.com/andrewsvn/metrics-overseer/internal/model"
@@ -30,7 +31,7 @@ func main() {
 		log.Fatalf("error initializing logger: %v", err)
 	}
 
-	sender, err := sending.NewRestSender(cfg.URL, &retrying.NoRetryPolicy{}, "", "", l)
+	sender, err := sending.NewRestSender(cfg.URL, &retrying.NoRetryPolicy{}, nil, nil, "", "", compress.NewGzipWriteEngine(), nil, l)
 	if err != nil {
 		log.Fatalf("error initializing sender: %v", err)
 	}
@@ -90,7 +91,7 @@ func sendBatch(sender *sending.RestSender, rnd *rand.PCG, batchSize int) error {
 	for i := 0; i < batchSize; i++ {
 		metrics[i] = generateMetric(rnd)
 	}
-	return sender.SendMetricArray(metrics)
+	return sender.SendMetricArray(nil, metrics)
 }
 
 func sendSingle(sender *sending.RestSender, rnd *rand.PCG) error {
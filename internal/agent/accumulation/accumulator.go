@@ -19,17 +19,23 @@ import (
 //  each poll calls @AccumulateGauge method to add new collected value to the list
 //  each report calls @ExtractAndSend method which takes average value of accumulated ones and tries to send it to the server,
 //    then removes processed slice of list so old values don't impact next sends
+// for "summary" type metrics:
+//  each poll calls @AccumulateSummary method to add new collected value to the quantile sketch
+//  each report calls @ExtractAndSend method which sends the whole sketch (quantiles, min, max, count and sum)
+//    so spikes between reports are not lost in averaging, then the sketch is reset
 
 type MetricAccumulator struct {
 	ID     string
 	MType  string
 	Delta  *int64
 	Values []float64
+	Sketch *model.SummaryValue
 
 	mutex        sync.Mutex
 	isStaged     bool
 	stagedDelta  int64
 	stagedValues []float64
+	stagedSketch *model.SummaryValue
 }
 
 var (
@@ -80,6 +86,46 @@ func (ma *MetricAccumulator) AccumulateGauge(value float64) error {
 	return nil
 }
 
+func (ma *MetricAccumulator) AccumulateSummary(value float64) error {
+	if len(ma.MType) == 0 {
+		ma.MType = model.Summary
+	}
+
+	if ma.MType != model.Summary {
+		return fmt.Errorf("%w: expected summary, got %v", ErrIncorrectMetricType, ma.MType)
+	}
+
+	ma.mutex.Lock()
+	defer ma.mutex.Unlock()
+
+	if ma.Sketch == nil {
+		ma.Sketch = model.NewSummaryValue()
+	}
+	ma.Sketch.Add(value)
+	return nil
+}
+
+// Pending returns the number of accumulated values not yet staged for sending:
+// polled values for gauge, observations for summary and 1 for counter with accumulated delta
+func (ma *MetricAccumulator) Pending() int {
+	ma.mutex.Lock()
+	defer ma.mutex.Unlock()
+
+	switch ma.MType {
+	case model.Counter:
+		if ma.Delta != nil {
+			return 1
+		}
+	case model.Gauge:
+		return len(ma.Values)
+	case model.Summary:
+		if ma.Sketch != nil {
+			return int(ma.Sketch.Count)
+		}
+	}
+	return 0
+}
+
 // StageChanges prepares accumulated metric for sending to server
 // if no values were accumulated then nil is returned, so a caller must explicitly check the returned metric for nil
 func (ma *MetricAccumulator) StageChanges() (*model.Metrics, error) {
@@ -95,6 +141,8 @@ func (ma *MetricAccumulator) StageChanges() (*model.Metrics, error) {
 		return ma.stageCounterChanges(), nil
 	case model.Gauge:
 		return ma.stageGaugeChanges(), nil
+	case model.Summary:
+		return ma.stageSummaryChanges(), nil
 	}
 	return nil, ErrUnknownMetricType
 }
@@ -129,6 +177,19 @@ func (ma *MetricAccumulator) stageGaugeChanges() *model.Metrics {
 	return model.NewMetrics(ma.ID, ma.MType, nil, &total)
 }
 
+func (ma *MetricAccumulator) stageSummaryChanges() *model.Metrics {
+	if ma.Sketch == nil || ma.Sketch.Count == 0 {
+		return nil
+	}
+
+	ma.isStaged = true
+	ma.stagedSketch = ma.Sketch
+	ma.Sketch = nil
+
+	ma.stagedSketch.Compress()
+	return model.NewSummaryMetricsWithValue(ma.ID, ma.stagedSketch.Clone())
+}
+
 func (ma *MetricAccumulator) RollbackStaged() error {
 	if !ma.isStaged {
 		return nil
@@ -142,6 +203,8 @@ func (ma *MetricAccumulator) RollbackStaged() error {
 		ma.rollbackStagedCounter()
 	case model.Gauge:
 		ma.rollbackStagedGauge()
+	case model.Summary:
+		ma.rollbackStagedSummary()
 	default:
 		ma.isStaged = false
 	}
@@ -165,6 +228,16 @@ func (ma *MetricAccumulator) rollbackStagedGauge() {
 	ma.stagedValues = ma.stagedValues[:0]
 }
 
+func (ma *MetricAccumulator) rollbackStagedSummary() {
+	ma.isStaged = false
+	if ma.Sketch != nil {
+		// sketch can't overflow in practice, if it does, observations made after staging are dropped
+		_ = ma.stagedSketch.Merge(ma.Sketch)
+	}
+	ma.Sketch = ma.stagedSketch
+	ma.stagedSketch = nil
+}
+
 func (ma *MetricAccumulator) CommitStaged() error {
 	if !ma.isStaged {
 		return ErrWrongStagingState
@@ -178,6 +251,8 @@ func (ma *MetricAccumulator) CommitStaged() error {
 		ma.stagedDelta = 0
 	case model.Gauge:
 		ma.stagedValues = ma.stagedValues[:0]
+	case model.Summary:
+		ma.stagedSketch = nil
 	}
 	ma.isStaged = false
 	return nil
@@ -89,3 +89,51 @@ func TestGaugeAccumulator(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, 2, len(gaAcc.Values))
 }
+
+func TestSummaryAccumulator(t *testing.T) {
+	sumAcc := NewMetricAccumulator("latency")
+	assert.Nil(t, sumAcc.Sketch)
+
+	err := sumAcc.AccumulateSummary(10)
+	require.NoError(t, err)
+
+	err = sumAcc.AccumulateGauge(1)
+	assert.ErrorAs(t, err, &ErrIncorrectMetricType)
+
+	for i := 1; i <= 99; i++ {
+		_ = sumAcc.AccumulateSummary(1)
+	}
+	_ = sumAcc.AccumulateSummary(500)
+
+	metric, err := sumAcc.StageChanges()
+	require.NoError(t, err)
+	assert.Equal(t, "latency", metric.ID)
+	assert.Equal(t, model.Summary, metric.MType)
+	require.NotNil(t, metric.Summary)
+	assert.Equal(t, int64(101), metric.Summary.Count)
+	assert.Equal(t, 1.0, metric.Summary.Min)
+	assert.Equal(t, 500.0, metric.Summary.Max)
+	assert.InDelta(t, 1.0, metric.Summary.P50, 0.0001)
+	assert.Nil(t, sumAcc.Sketch)
+
+	err = sumAcc.CommitStaged()
+	require.NoError(t, err)
+	assert.Nil(t, sumAcc.Sketch)
+
+	_ = sumAcc.AccumulateSummary(2)
+	metric, err = sumAcc.StageChanges()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), metric.Summary.Count)
+
+	_ = sumAcc.AccumulateSummary(4)
+	err = sumAcc.RollbackStaged()
+	require.NoError(t, err)
+	require.NotNil(t, sumAcc.Sketch)
+	assert.Equal(t, int64(2), sumAcc.Sketch.Count)
+	assert.InDelta(t, 6.0, sumAcc.Sketch.Sum, 0.0001)
+
+	metric, err = sumAcc.StageChanges()
+	require.NoError(t, err)
+	assert.Equal(t, 2.0, metric.Summary.Min)
+	assert.Equal(t, 4.0, metric.Summary.Max)
+}
@@ -45,3 +45,13 @@ func (storage *Storage) Length() int {
 	defer storage.mutex.RUnlock()
 	return len(storage.accums)
 }
+
+// Pending returns the number of accumulated metrics and the total number of their values not yet sent
+func (storage *Storage) Pending() (metrics int, values int) {
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+	for _, ma := range storage.accums {
+		values += ma.Pending()
+	}
+	return len(storage.accums), values
+}
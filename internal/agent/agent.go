@@ -11,16 +11,24 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/andrewsvn/metrics-overseer/internal/agent/accumulation"
+	"github.com/andrewsvn/metrics-overseer/internal/agent/collecting"
+	"github.com/andrewsvn/metrics-overseer/internal/agent/telemetry"
 	"github.com/andrewsvn/metrics-overseer/internal/config/agentcfg"
 )
 
+const healthyReportIntervals = 3
+
 type Agent struct {
 	gracePeriod time.Duration
 
 	pollr *Poller
 	repr  *Reporter
 
-	logger *zap.SugaredLogger
+	telemetry  *telemetry.Telemetry
+	statusAddr string
+
+	baseLogger *zap.Logger
+	logger     *zap.SugaredLogger
 }
 
 func NewAgent(cfg *agentcfg.Config, l *zap.Logger) (*Agent, error) {
@@ -31,8 +39,13 @@ func NewAgent(cfg *agentcfg.Config, l *zap.Logger) (*Agent, error) {
 		"parallel report requests", cfg.MaxNumberOfRequests)
 
 	stor := accumulation.NewAccumulatorStorage()
-	pollr := NewPoller(cfg, stor, l)
-	repr, err := NewReporter(cfg, stor, l)
+	// agent is considered unhealthy if several consecutive reports failed
+	tel := telemetry.NewTelemetry(stor, healthyReportIntervals*time.Duration(cfg.ReportIntervalSec)*time.Second)
+	pollr, err := NewPoller(cfg, collecting.NewDefaultRegistry(), stor, l)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create metric poller: %w", err)
+	}
+	repr, err := NewReporter(cfg, stor, tel, l)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create metric reporter: %w", err)
 	}
@@ -41,6 +54,9 @@ func NewAgent(cfg *agentcfg.Config, l *zap.Logger) (*Agent, error) {
 		gracePeriod: time.Duration(cfg.GracePeriodSec) * time.Second,
 		pollr:       pollr,
 		repr:        repr,
+		telemetry:   tel,
+		statusAddr:  cfg.StatusAddr,
+		baseLogger:  l,
 		logger:      agentLogger,
 	}
 	return a, nil
@@ -56,6 +72,15 @@ func (a *Agent) Run() {
 	wg := &sync.WaitGroup{}
 	a.pollr.Start(ctx, wg)
 	a.repr.Start(ctx, wg)
+	if a.statusAddr != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := a.telemetry.Serve(ctx, a.statusAddr, a.baseLogger); err != nil {
+				a.logger.Errorw("agent status server failed", "error", err)
+			}
+		}()
+	}
 
 	<-ctx.Done()
 
@@ -1,13 +1,22 @@
 package agent
 
 import (
+	"context"
+	"errors"
+	"fmt"
+
 	"github.com/andrewsvn/metrics-overseer/internal/agent/accumulation"
+	"github.com/andrewsvn/metrics-overseer/internal/agent/collecting"
 	"github.com/andrewsvn/metrics-overseer/internal/agent/reporting"
+	"github.com/andrewsvn/metrics-overseer/internal/agent/telemetry"
 	"github.com/andrewsvn/metrics-overseer/internal/logging"
 	"github.com/andrewsvn/metrics-overseer/internal/mocks"
 	"github.com/andrewsvn/metrics-overseer/internal/model"
 	"github.com/stretchr/testify/mock"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/andrewsvn/metrics-overseer/internal/config/agentcfg"
 	"github.com/stretchr/testify/assert"
@@ -18,35 +27,118 @@ func TestAgentPolling(t *testing.T) {
 	l, err := logging.NewZapLogger("info")
 	require.NoError(t, err)
 	stor := accumulation.NewAccumulatorStorage()
-	p := NewPoller(agentcfg.NewDefaultConfig(), stor, l)
+	p, err := NewPoller(agentcfg.NewDefaultConfig(), collecting.NewDefaultRegistry(), stor, l)
+	require.NoError(t, err)
 
-	p.execMemstatsPoll()
+	p.pollAll()
 	assert.Greater(t, p.stor.Length(), 2)
 	assert.NotNil(t, p.stor.Get("RandomValue"))
 	assert.NotNil(t, p.stor.Get("PollCount"))
-
-	p.execGopsPoll()
 	assert.NotNil(t, p.stor.Get("TotalMemory"))
 	assert.NotNil(t, p.stor.Get("FreeMemory"))
 }
 
+// fakeCollector reports the number of polls and a constant gauge
+type fakeCollector struct {
+	polls int64
+}
+
+func (c *fakeCollector) Collect(rec collecting.Recorder) error {
+	c.polls++
+	rec.Counter("polls", 1)
+	rec.Gauge("value", float64(c.polls))
+	return nil
+}
+
+func TestAgentPollingCollectors(t *testing.T) {
+	l, err := logging.NewZapLogger("info")
+	require.NoError(t, err)
+	fake := &fakeCollector{}
+	registry := collecting.NewDefaultRegistry()
+	registry.Register("fake", func(_ *agentcfg.Config) (collecting.Collector, error) {
+		return fake, nil
+	})
+
+	cfg := agentcfg.NewDefaultConfig()
+	cfg.EnabledCollectors = []string{"fake", collecting.RuntimeCollectorName}
+	cfg.DisabledCollectors = []string{collecting.RuntimeCollectorName}
+	cfg.CollectorIntervals = []string{"fake:5"}
+	cfg.CollectorPrefixes = []string{"fake:test."}
+	stor := accumulation.NewAccumulatorStorage()
+	p, err := NewPoller(cfg, registry, stor, l)
+	require.NoError(t, err)
+	require.Len(t, p.collectors, 1)
+	assert.Equal(t, 5*time.Second, p.collectors[0].interval)
+
+	p.pollAll()
+	p.pollAll()
+	assert.Equal(t, 2, stor.Length())
+	assert.Equal(t, int64(2), *stor.Get("test.polls").Delta)
+	assert.Equal(t, []float64{1, 2}, stor.Get("test.value").Values)
+	assert.Nil(t, stor.Get("PollCount"))
+
+	// collectors are polled with their own intervals
+	p.Register("fast", &fakeCollector{}, 10*time.Millisecond, "fast.")
+	ctx, cancel := context.WithTimeout(context.Background(), 55*time.Millisecond)
+	defer cancel()
+	wg := &sync.WaitGroup{}
+	p.Start(ctx, wg)
+	wg.Wait()
+	assert.GreaterOrEqual(t, *stor.Get("fast.polls").Delta, int64(3))
+	assert.Equal(t, int64(2), fake.polls)
+
+	// unknown collectors and invalid settings are rejected
+	cfg.EnabledCollectors = []string{"unknown"}
+	_, err = NewPoller(cfg, registry, stor, l)
+	assert.ErrorIs(t, err, collecting.ErrUnknownCollector)
+	cfg.EnabledCollectors = []string{"fake"}
+	cfg.CollectorIntervals = []string{"fake:0"}
+	_, err = NewPoller(cfg, registry, stor, l)
+	assert.Error(t, err)
+}
+
+func TestAgentPollingSummaries(t *testing.T) {
+	l, err := logging.NewZapLogger("info")
+	require.NoError(t, err)
+	stor := accumulation.NewAccumulatorStorage()
+	cfg := agentcfg.NewDefaultConfig()
+	cfg.SummaryMetrics = []string{"HeapAlloc"}
+	cfg.EnabledCollectors = []string{collecting.RuntimeCollectorName}
+	p, err := NewPoller(cfg, collecting.NewDefaultRegistry(), stor, l)
+	require.NoError(t, err)
+
+	p.pollAll()
+	p.pollAll()
+	require.NotNil(t, p.stor.Get("HeapAlloc"))
+	assert.Equal(t, model.Summary, p.stor.Get("HeapAlloc").MType)
+	assert.Equal(t, int64(2), p.stor.Get("HeapAlloc").Sketch.Count)
+	assert.Equal(t, model.Gauge, p.stor.Get("Alloc").MType)
+}
+
 func TestAgentReporting(t *testing.T) {
 	var mcnt int
 	var cnt1val, cnt2val int64
 	var gauge1val, gauge2val float64
 	var err error
+	agentMetrics := make(map[string]*model.Metrics)
 
 	l, err := logging.NewZapLogger("info")
 	require.NoError(t, err)
 	stor := accumulation.NewAccumulatorStorage()
-	r, err := NewReporter(agentcfg.NewDefaultConfig(), stor, l)
+	r, err := NewReporter(agentcfg.NewDefaultConfig(), stor, telemetry.NewTelemetry(stor, time.Minute), l)
 	require.NoError(t, err)
 
 	msender := new(mocks.MockMetricSender)
-	msender.EXPECT().SendMetricArray(mock.Anything).
-		RunAndReturn(func(metrics []*model.Metrics) error {
-			mcnt = len(metrics)
+	msender.EXPECT().SendMetricArray(mock.Anything, mock.Anything).
+		RunAndReturn(func(_ *model.BatchID, metrics []*model.Metrics) error {
+			mcnt = 0
 			for _, m := range metrics {
+				// agent telemetry is checked separately
+				if strings.HasPrefix(m.ID, telemetry.Prefix) {
+					agentMetrics[m.ID] = m.Clone()
+					continue
+				}
+				mcnt++
 				switch m.ID {
 				case "cnt1":
 					cnt1val = *m.Delta
@@ -91,4 +183,87 @@ func TestAgentReporting(t *testing.T) {
 	assert.Equal(t, 2, mcnt)
 	assert.Equal(t, int64(1), cnt1val)
 	assert.Equal(t, 1.6, gauge1val)
+
+	// statistics of the first report are sent with the second one
+	require.Contains(t, agentMetrics, "agent.report.count")
+	assert.Equal(t, int64(1), *agentMetrics["agent.report.count"].Delta)
+	// 4 polled metrics and 3 telemetry gauges
+	assert.Equal(t, int64(7), *agentMetrics["agent.report.metrics.committed"].Delta)
+	assert.NotContains(t, agentMetrics, "agent.report.failed")
+	// telemetry metrics are accumulated as well
+	assert.Greater(t, *agentMetrics["agent.accumulator.metrics"].Value, 4.0)
+
+	status := r.telemetry.Status()
+	assert.True(t, status.Healthy)
+	assert.Equal(t, int64(2), status.Reports)
+	assert.Zero(t, status.FailedReports)
+	assert.NotNil(t, status.LastReportSuccess)
+}
+
+func TestAgentQueuedReporting(t *testing.T) {
+	l, err := logging.NewZapLogger("info")
+	require.NoError(t, err)
+	cfg := agentcfg.NewDefaultConfig()
+	cfg.QueueDir = t.TempDir()
+
+	// batch IDs of all send attempts
+	var batchIDs []model.BatchID
+	newReporter := func(stor *accumulation.Storage, sendErr *error, sent *[]string) *Reporter {
+		r, err := NewReporter(cfg, stor, telemetry.NewTelemetry(stor, time.Minute), l)
+		require.NoError(t, err)
+		msender := new(mocks.MockMetricSender)
+		msender.EXPECT().SendMetricArray(mock.Anything, mock.Anything).
+			RunAndReturn(func(batchID *model.BatchID, metrics []*model.Metrics) error {
+				batchIDs = append(batchIDs, *batchID)
+				if *sendErr != nil {
+					return *sendErr
+				}
+				for _, m := range metrics {
+					if m.ID == "cnt1" {
+						*sent = append(*sent, fmt.Sprintf("%s:%d", m.ID, *m.Delta))
+					}
+				}
+				return nil
+			}).
+			Maybe()
+		r.executor = reporting.NewBatchExecutor(msender, l.Sugar())
+		return r
+	}
+
+	var sent []string
+	sendErr := errors.New("server is unreachable")
+	stor := accumulation.NewAccumulatorStorage()
+	r := newReporter(stor, &sendErr, &sent)
+
+	_ = stor.GetOrNew("cnt1").AccumulateCounter(1)
+	_ = stor.GetOrNew("gauge1").AccumulateGauge(1)
+	r.execReport()
+	_ = stor.GetOrNew("cnt1").AccumulateCounter(2)
+	_ = stor.GetOrNew("gauge1").AccumulateGauge(2)
+	r.execReport()
+
+	// staged values are moved to the queue instead of being kept in memory
+	assert.Zero(t, stor.Get("gauge1").Pending())
+	batches, _, _ := r.queue.Stats()
+	assert.Equal(t, 2, batches)
+	assert.Empty(t, sent)
+
+	// batches survive restart and are replayed in order once server is reachable
+	sendErr = nil
+	stor = accumulation.NewAccumulatorStorage()
+	r = newReporter(stor, &sendErr, &sent)
+	_ = stor.GetOrNew("cnt1").AccumulateCounter(4)
+	r.execReport()
+
+	assert.Equal(t, []string{"cnt1:1", "cnt1:2", "cnt1:4"}, sent)
+	// the oldest batch blocks the queue and is resent with the same ID even after restart,
+	// IDs of new batches don't repeat
+	require.Len(t, batchIDs, 5)
+	assert.Equal(t, batchIDs[0], batchIDs[1])
+	assert.Equal(t, batchIDs[0], batchIDs[2])
+	assert.Greater(t, batchIDs[3].Seq, batchIDs[2].Seq)
+	assert.Greater(t, batchIDs[4].Seq, batchIDs[3].Seq)
+	batches, _, _ = r.queue.Stats()
+	assert.Zero(t, batches)
+	assert.True(t, r.telemetry.Status().Healthy)
 }
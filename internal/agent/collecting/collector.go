@@ -0,0 +1,85 @@
+// Package collecting provides metric collectors polled by metrics-overseer agent. Each collector gathers
+// a group of related metrics (Go runtime statistics, host memory and CPU etc.), collectors are registered
+// by name in Registry and created from agent configuration, so a new group of metrics is added
+// by registering a new collector
+package collecting
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/andrewsvn/metrics-overseer/internal/config/agentcfg"
+)
+
+var ErrUnknownCollector = errors.New("unknown collector")
+
+// Recorder accepts metric values gathered by collector
+type Recorder interface {
+	// Gauge records the current value of gauge metric
+	Gauge(id string, value float64)
+	// Counter adds delta to counter metric
+	Counter(id string, delta int64)
+}
+
+// Collector gathers values of its metrics on each poll. Collect is never called concurrently
+// for the same collector, so it may keep state between polls (e.g. to calculate deltas)
+type Collector interface {
+	Collect(rec Recorder) error
+}
+
+// Factory creates a collector using agent configuration
+type Factory func(cfg *agentcfg.Config) (Collector, error)
+
+// Registry keeps collector factories by collector name
+type Registry struct {
+	names     []string
+	factories map[string]Factory
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		factories: make(map[string]Factory),
+	}
+}
+
+// NewDefaultRegistry creates a registry with all built-in collectors
+func NewDefaultRegistry() *Registry {
+	r := NewRegistry()
+	r.Register(RuntimeCollectorName, NewRuntimeCollector)
+	r.Register(GopsCollectorName, NewGopsCollector)
+	r.Register(DiskCollectorName, NewDiskCollector)
+	r.Register(NetworkCollectorName, NewNetworkCollector)
+	r.Register(LoadCollectorName, NewLoadCollector)
+	r.Register(SwapCollectorName, NewSwapCollector)
+	r.Register(ProcCountCollectorName, NewProcCountCollector)
+	r.Register(UptimeCollectorName, NewUptimeCollector)
+	r.Register(ProcessCollectorName, NewProcessCollector)
+	return r
+}
+
+// Register adds collector factory, factory registered earlier under the same name is replaced
+func (r *Registry) Register(name string, factory Factory) {
+	if _, exists := r.factories[name]; !exists {
+		r.names = append(r.names, name)
+	}
+	r.factories[name] = factory
+}
+
+// Names returns names of registered collectors in the order of registration
+func (r *Registry) Names() []string {
+	return slices.Clone(r.names)
+}
+
+// New creates a registered collector
+func (r *Registry) New(name string, cfg *agentcfg.Config) (Collector, error) {
+	factory, exists := r.factories[name]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownCollector, name)
+	}
+	c, err := factory(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("can't create collector %s: %w", name, err)
+	}
+	return c, nil
+}
@@ -0,0 +1,193 @@
+package collecting
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/andrewsvn/metrics-overseer/internal/config/agentcfg"
+	"github.com/shirou/gopsutil/v4/disk"
+	"github.com/shirou/gopsutil/v4/net"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mapRecorder keeps the last recorded gauge values and sums of counter deltas
+type mapRecorder struct {
+	gauges   map[string]float64
+	counters map[string]int64
+}
+
+func newMapRecorder() *mapRecorder {
+	return &mapRecorder{
+		gauges:   make(map[string]float64),
+		counters: make(map[string]int64),
+	}
+}
+
+func (r *mapRecorder) Gauge(id string, value float64) {
+	r.gauges[id] = value
+}
+
+func (r *mapRecorder) Counter(id string, delta int64) {
+	r.counters[id] += delta
+}
+
+func TestRegistry(t *testing.T) {
+	builtin := []string{
+		RuntimeCollectorName,
+		GopsCollectorName,
+		DiskCollectorName,
+		NetworkCollectorName,
+		LoadCollectorName,
+		SwapCollectorName,
+		ProcCountCollectorName,
+		UptimeCollectorName,
+		ProcessCollectorName,
+	}
+	r := NewDefaultRegistry()
+	assert.Equal(t, builtin, r.Names())
+
+	r.Register(RuntimeCollectorName, NewGopsCollector)
+	assert.Equal(t, builtin, r.Names())
+	c, err := r.New(RuntimeCollectorName, agentcfg.NewDefaultConfig())
+	require.NoError(t, err)
+	assert.IsType(t, &GopsCollector{}, c)
+
+	_, err = r.New("unknown", agentcfg.NewDefaultConfig())
+	assert.ErrorIs(t, err, ErrUnknownCollector)
+}
+
+func TestBuiltinCollectors(t *testing.T) {
+	rec := newMapRecorder()
+	c, err := NewRuntimeCollector(agentcfg.NewDefaultConfig())
+	require.NoError(t, err)
+	require.NoError(t, c.Collect(rec))
+	require.NoError(t, c.Collect(rec))
+	assert.Contains(t, rec.gauges, "HeapAlloc")
+	assert.Contains(t, rec.gauges, "RandomValue")
+	assert.Equal(t, int64(2), rec.counters["PollCount"])
+
+	rec = newMapRecorder()
+	c, err = NewGopsCollector(agentcfg.NewDefaultConfig())
+	require.NoError(t, err)
+	require.NoError(t, c.Collect(rec))
+	assert.Positive(t, rec.gauges["TotalMemory"])
+	assert.Contains(t, rec.gauges, "CPUutilization1")
+}
+
+func TestHostCollectors(t *testing.T) {
+	// prefixes of IDs recorded by each collector, collectors of per-device statistics
+	// may record nothing if devices are unavailable in sandboxed environment
+	prefixes := map[string][]string{
+		DiskCollectorName:      {"Disk"},
+		NetworkCollectorName:   {"Net"},
+		LoadCollectorName:      {"LoadAverage1", "LoadAverage5", "LoadAverage15"},
+		SwapCollectorName:      {"SwapTotal", "SwapUsed", "SwapFree", "SwapUsedPercent"},
+		ProcCountCollectorName: {"ProcessesTotal", "ProcessesRunning", "ProcessesBlocked"},
+		UptimeCollectorName:    {"Uptime"},
+	}
+	for name, expected := range prefixes {
+		t.Run(name, func(t *testing.T) {
+			c, err := NewDefaultRegistry().New(name, agentcfg.NewDefaultConfig())
+			require.NoError(t, err)
+			rec := newMapRecorder()
+			if err := c.Collect(rec); err != nil {
+				t.Skipf("statistics are not available: %v", err)
+			}
+			require.NoError(t, c.Collect(rec))
+
+			for id := range rec.gauges {
+				assert.True(t, hasAnyPrefix(id, expected), "unexpected gauge %s", id)
+			}
+			for id := range rec.counters {
+				assert.True(t, hasAnyPrefix(id, expected), "unexpected counter %s", id)
+			}
+			if name != DiskCollectorName && name != NetworkCollectorName {
+				for _, id := range expected {
+					assert.Contains(t, rec.gauges, id)
+				}
+			}
+		})
+	}
+
+	rec := newMapRecorder()
+	c, err := NewUptimeCollector(agentcfg.NewDefaultConfig())
+	require.NoError(t, err)
+	require.NoError(t, c.Collect(rec))
+	assert.Positive(t, rec.gauges["Uptime"])
+}
+
+func hasAnyPrefix(id string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(id, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestDiskCollector(t *testing.T) {
+	var readBytes uint64 = 1000
+	c := &DiskCollector{
+		deltas: newCounterDeltas(),
+		partitions: func(bool) ([]disk.PartitionStat, error) {
+			return []disk.PartitionStat{{Mountpoint: "/"}, {Mountpoint: "/var-lib"}, {Mountpoint: "/mnt/stale"}}, nil
+		},
+		usage: func(path string) (*disk.UsageStat, error) {
+			if path == "/mnt/stale" {
+				return nil, errors.New("stale file handle")
+			}
+			return &disk.UsageStat{Total: 100, Used: 40, Free: 60, UsedPercent: 40}, nil
+		},
+		ioCounters: func(...string) (map[string]disk.IOCountersStat, error) {
+			return map[string]disk.IOCountersStat{"sda1": {ReadBytes: readBytes, WriteCount: 7}}, nil
+		},
+	}
+
+	rec := newMapRecorder()
+	// unavailable mount point is reported, but doesn't hide the others
+	err := c.Collect(rec)
+	require.ErrorContains(t, err, "/mnt/stale")
+	assert.Equal(t, 100.0, rec.gauges["DiskTotal_."])
+	assert.Equal(t, 60.0, rec.gauges["DiskFree_.var-lib"])
+	assert.NotContains(t, rec.gauges, "DiskTotal_.mnt.stale")
+	// the first poll only remembers counter values
+	assert.Empty(t, rec.counters)
+
+	readBytes = 1500
+	require.Error(t, c.Collect(rec))
+	assert.Equal(t, int64(500), rec.counters["DiskReadBytes_sda1"])
+	assert.Equal(t, int64(0), rec.counters["DiskWriteCount_sda1"])
+
+	// counter reset is reported as a whole
+	readBytes = 200
+	require.Error(t, c.Collect(rec))
+	assert.Equal(t, int64(700), rec.counters["DiskReadBytes_sda1"])
+}
+
+func TestNetworkCollector(t *testing.T) {
+	counters := []net.IOCountersStat{{Name: "eth0", BytesSent: 100}, {Name: "br-lan", BytesRecv: 10}}
+	c := &NetworkCollector{
+		deltas: newCounterDeltas(),
+		ioCounters: func(bool) ([]net.IOCountersStat, error) {
+			return counters, nil
+		},
+	}
+
+	rec := newMapRecorder()
+	require.NoError(t, c.Collect(rec))
+	assert.Empty(t, rec.counters)
+
+	counters = []net.IOCountersStat{{Name: "eth0", BytesSent: 250, Errin: 2}, {Name: "br-lan", BytesRecv: 30}}
+	require.NoError(t, c.Collect(rec))
+	assert.Equal(t, int64(150), rec.counters["NetBytesSent_eth0"])
+	assert.Equal(t, int64(2), rec.counters["NetErrorsIn_eth0"])
+	assert.Equal(t, int64(20), rec.counters["NetBytesRecv_br-lan"])
+	assert.Len(t, rec.counters, 16)
+
+	c.ioCounters = func(bool) ([]net.IOCountersStat, error) {
+		return nil, errors.New("proc is not mounted")
+	}
+	assert.Error(t, c.Collect(rec))
+}
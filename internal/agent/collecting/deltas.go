@@ -0,0 +1,62 @@
+package collecting
+
+import (
+	"fmt"
+	"strings"
+)
+
+// counterDeltas converts cumulative counter values read from the system (e.g. bytes sent by network
+// interface since boot) to deltas between polls. The first value of each counter is only remembered,
+// a value lower than the previous one means the counter was reset and is reported as a whole
+type counterDeltas struct {
+	prev map[string]uint64
+	cur  map[string]uint64
+}
+
+func newCounterDeltas() *counterDeltas {
+	return &counterDeltas{
+		prev: make(map[string]uint64),
+		cur:  make(map[string]uint64),
+	}
+}
+
+// record reports the delta of counter since the previous poll
+func (d *counterDeltas) record(rec Recorder, id string, value uint64) {
+	d.cur[id] = value
+	prev, ok := d.prev[id]
+	if !ok {
+		return
+	}
+	if value < prev {
+		prev = 0
+	}
+	rec.Counter(id, int64(value-prev))
+}
+
+// finish completes the poll, counters not recorded during it are forgotten
+// so that values of removed devices don't pile up
+func (d *counterDeltas) finish() {
+	d.prev = d.cur
+	d.cur = make(map[string]uint64, len(d.prev))
+}
+
+// instanceSuffix turns a device, mount point, interface or process selector name into metric ID suffix.
+// Letters, digits, "-" and "_" are kept, "/" becomes "." and any other byte is escaped as "~" followed by
+// its hex code, so different names never share a suffix, e.g. "/var/lib" becomes "_.var.lib",
+// "/var-lib" becomes "_.var-lib" and "/" becomes "_."
+func instanceSuffix(name string) string {
+	var sb strings.Builder
+	sb.WriteByte('_')
+	for i := 0; i < len(name); i++ {
+		b := name[i]
+		switch {
+		case b >= 'a' && b <= 'z', b >= 'A' && b <= 'Z', b >= '0' && b <= '9', b == '-', b == '_':
+			sb.WriteByte(b)
+		case b == '/':
+			sb.WriteByte('.')
+		default:
+			fmt.Fprintf(&sb, "~%02x", b)
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,52 @@
+package collecting
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCounterDeltas(t *testing.T) {
+	d := newCounterDeltas()
+	rec := newMapRecorder()
+
+	d.record(rec, "eth0", 100)
+	d.record(rec, "eth1", 10)
+	d.finish()
+	assert.Empty(t, rec.counters)
+
+	d.record(rec, "eth0", 150)
+	d.record(rec, "eth1", 30)
+	d.finish()
+	assert.Equal(t, map[string]int64{"eth0": 50, "eth1": 20}, rec.counters)
+
+	// eth1 is gone and counters of eth0 are reset
+	d.record(rec, "eth0", 5)
+	d.finish()
+	d.record(rec, "eth0", 5)
+	d.record(rec, "eth1", 40)
+	d.finish()
+	assert.Equal(t, map[string]int64{"eth0": 55, "eth1": 20}, rec.counters)
+}
+
+func TestInstanceSuffix(t *testing.T) {
+	assert.Equal(t, "_.", instanceSuffix("/"))
+	assert.Equal(t, "_.root", instanceSuffix("/root"))
+	assert.Equal(t, "_.var.lib", instanceSuffix("/var/lib"))
+	assert.Equal(t, "_.var-lib", instanceSuffix("/var-lib"))
+	assert.Equal(t, "_sda1", instanceSuffix("sda1"))
+	assert.Equal(t, "_br-lan", instanceSuffix("br-lan"))
+	assert.Equal(t, "_C~3a~5c", instanceSuffix("C:\\"))
+
+	// different names never share a suffix
+	names := []string{"/", "/root", "/var/lib", "/var/lib/", "/var-lib", "/var_lib", "/var.lib", "/var~2elib",
+		"my-app", "my_app", "my.app", "my app", "myapp", "", "_", "."}
+	suffixes := make(map[string]string, len(names))
+	for _, name := range names {
+		suffix := instanceSuffix(name)
+		if other, ok := suffixes[suffix]; ok {
+			t.Errorf("names %q and %q share suffix %s", other, name, suffix)
+		}
+		suffixes[suffix] = name
+	}
+}
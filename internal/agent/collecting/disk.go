@@ -0,0 +1,68 @@
+package collecting
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/andrewsvn/metrics-overseer/internal/config/agentcfg"
+	"github.com/shirou/gopsutil/v4/disk"
+)
+
+const DiskCollectorName = "disk"
+
+// DiskCollector reports usage of each mounted partition and IO counters of each block device
+type DiskCollector struct {
+	deltas *counterDeltas
+
+	// system statistics sources, they are replaced in tests
+	partitions func(all bool) ([]disk.PartitionStat, error)
+	usage      func(path string) (*disk.UsageStat, error)
+	ioCounters func(names ...string) (map[string]disk.IOCountersStat, error)
+}
+
+func NewDiskCollector(_ *agentcfg.Config) (Collector, error) {
+	return &DiskCollector{
+		deltas:     newCounterDeltas(),
+		partitions: disk.Partitions,
+		usage:      disk.Usage,
+		ioCounters: disk.IOCounters,
+	}, nil
+}
+
+func (c *DiskCollector) Collect(rec Recorder) error {
+	partitions, err := c.partitions(false)
+	if err != nil {
+		return fmt.Errorf("failed to get disk partitions: %w", err)
+	}
+
+	// a single unavailable mount point (e.g. stale network share) shouldn't hide the others
+	var errs []error
+	for _, p := range partitions {
+		usage, err := c.usage(p.Mountpoint)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to get usage of %s: %w", p.Mountpoint, err))
+			continue
+		}
+		suffix := instanceSuffix(p.Mountpoint)
+		rec.Gauge("DiskTotal"+suffix, float64(usage.Total))
+		rec.Gauge("DiskUsed"+suffix, float64(usage.Used))
+		rec.Gauge("DiskFree"+suffix, float64(usage.Free))
+		rec.Gauge("DiskUsedPercent"+suffix, usage.UsedPercent)
+		rec.Gauge("DiskInodesUsedPercent"+suffix, usage.InodesUsedPercent)
+	}
+
+	counters, err := c.ioCounters()
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to get disk io counters: %w", err))
+	}
+	for device, io := range counters {
+		suffix := instanceSuffix(device)
+		c.deltas.record(rec, "DiskReadBytes"+suffix, io.ReadBytes)
+		c.deltas.record(rec, "DiskWriteBytes"+suffix, io.WriteBytes)
+		c.deltas.record(rec, "DiskReadCount"+suffix, io.ReadCount)
+		c.deltas.record(rec, "DiskWriteCount"+suffix, io.WriteCount)
+	}
+	c.deltas.finish()
+
+	return errors.Join(errs...)
+}
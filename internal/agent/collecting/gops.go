@@ -0,0 +1,38 @@
+package collecting
+
+import (
+	"fmt"
+
+	"github.com/andrewsvn/metrics-overseer/internal/config/agentcfg"
+	"github.com/shirou/gopsutil/v4/cpu"
+	"github.com/shirou/gopsutil/v4/mem"
+)
+
+const GopsCollectorName = "gopsutil"
+
+// GopsCollector reports host memory and per-CPU utilization obtained by gopsutil
+type GopsCollector struct{}
+
+func NewGopsCollector(_ *agentcfg.Config) (Collector, error) {
+	return &GopsCollector{}, nil
+}
+
+func (c *GopsCollector) Collect(rec Recorder) error {
+	vmStat, err := mem.VirtualMemory()
+	if err != nil {
+		return fmt.Errorf("failed to get memory statistics: %w", err)
+	}
+
+	rec.Gauge("TotalMemory", float64(vmStat.Total))
+	rec.Gauge("FreeMemory", float64(vmStat.Free))
+
+	cpuUtils, err := cpu.Percent(0, true)
+	if err != nil {
+		return fmt.Errorf("failed to get cpu utilization: %w", err)
+	}
+
+	for id, cpuUtil := range cpuUtils {
+		rec.Gauge(fmt.Sprintf("CPUutilization%d", id+1), cpuUtil)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package collecting
+
+import (
+	"fmt"
+
+	"github.com/andrewsvn/metrics-overseer/internal/config/agentcfg"
+	"github.com/shirou/gopsutil/v4/host"
+	"github.com/shirou/gopsutil/v4/load"
+	"github.com/shirou/gopsutil/v4/mem"
+)
+
+const (
+	LoadCollectorName      = "load"
+	SwapCollectorName      = "swap"
+	ProcCountCollectorName = "proccount"
+	UptimeCollectorName    = "uptime"
+)
+
+// LoadCollector reports system load averages
+type LoadCollector struct{}
+
+func NewLoadCollector(_ *agentcfg.Config) (Collector, error) {
+	return &LoadCollector{}, nil
+}
+
+func (c *LoadCollector) Collect(rec Recorder) error {
+	avg, err := load.Avg()
+	if err != nil {
+		return fmt.Errorf("failed to get load averages: %w", err)
+	}
+
+	rec.Gauge("LoadAverage1", avg.Load1)
+	rec.Gauge("LoadAverage5", avg.Load5)
+	rec.Gauge("LoadAverage15", avg.Load15)
+	return nil
+}
+
+// SwapCollector reports swap usage
+type SwapCollector struct{}
+
+func NewSwapCollector(_ *agentcfg.Config) (Collector, error) {
+	return &SwapCollector{}, nil
+}
+
+func (c *SwapCollector) Collect(rec Recorder) error {
+	swap, err := mem.SwapMemory()
+	if err != nil {
+		return fmt.Errorf("failed to get swap statistics: %w", err)
+	}
+
+	rec.Gauge("SwapTotal", float64(swap.Total))
+	rec.Gauge("SwapUsed", float64(swap.Used))
+	rec.Gauge("SwapFree", float64(swap.Free))
+	rec.Gauge("SwapUsedPercent", swap.UsedPercent)
+	return nil
+}
+
+// ProcCountCollector reports numbers of processes in the system
+type ProcCountCollector struct{}
+
+func NewProcCountCollector(_ *agentcfg.Config) (Collector, error) {
+	return &ProcCountCollector{}, nil
+}
+
+func (c *ProcCountCollector) Collect(rec Recorder) error {
+	misc, err := load.Misc()
+	if err != nil {
+		return fmt.Errorf("failed to get process counts: %w", err)
+	}
+
+	rec.Gauge("ProcessesTotal", float64(misc.ProcsTotal))
+	rec.Gauge("ProcessesRunning", float64(misc.ProcsRunning))
+	rec.Gauge("ProcessesBlocked", float64(misc.ProcsBlocked))
+	return nil
+}
+
+// UptimeCollector reports the number of seconds since host boot
+type UptimeCollector struct{}
+
+func NewUptimeCollector(_ *agentcfg.Config) (Collector, error) {
+	return &UptimeCollector{}, nil
+}
+
+func (c *UptimeCollector) Collect(rec Recorder) error {
+	uptime, err := host.Uptime()
+	if err != nil {
+		return fmt.Errorf("failed to get host uptime: %w", err)
+	}
+
+	rec.Gauge("Uptime", float64(uptime))
+	return nil
+}
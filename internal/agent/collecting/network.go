@@ -0,0 +1,46 @@
+package collecting
+
+import (
+	"fmt"
+
+	"github.com/andrewsvn/metrics-overseer/internal/config/agentcfg"
+	"github.com/shirou/gopsutil/v4/net"
+)
+
+const NetworkCollectorName = "network"
+
+// NetworkCollector reports traffic and error counters of each network interface as counter deltas
+type NetworkCollector struct {
+	deltas *counterDeltas
+
+	// ioCounters is a source of interface statistics, it's replaced in tests
+	ioCounters func(perNIC bool) ([]net.IOCountersStat, error)
+}
+
+func NewNetworkCollector(_ *agentcfg.Config) (Collector, error) {
+	return &NetworkCollector{
+		deltas:     newCounterDeltas(),
+		ioCounters: net.IOCounters,
+	}, nil
+}
+
+func (c *NetworkCollector) Collect(rec Recorder) error {
+	counters, err := c.ioCounters(true)
+	if err != nil {
+		return fmt.Errorf("failed to get network io counters: %w", err)
+	}
+
+	for _, io := range counters {
+		suffix := instanceSuffix(io.Name)
+		c.deltas.record(rec, "NetBytesSent"+suffix, io.BytesSent)
+		c.deltas.record(rec, "NetBytesRecv"+suffix, io.BytesRecv)
+		c.deltas.record(rec, "NetPacketsSent"+suffix, io.PacketsSent)
+		c.deltas.record(rec, "NetPacketsRecv"+suffix, io.PacketsRecv)
+		c.deltas.record(rec, "NetErrorsIn"+suffix, io.Errin)
+		c.deltas.record(rec, "NetErrorsOut"+suffix, io.Errout)
+		c.deltas.record(rec, "NetDropsIn"+suffix, io.Dropin)
+		c.deltas.record(rec, "NetDropsOut"+suffix, io.Dropout)
+	}
+	c.deltas.finish()
+	return nil
+}
@@ -0,0 +1,273 @@
+package collecting
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/andrewsvn/metrics-overseer/internal/config/agentcfg"
+	"github.com/shirou/gopsutil/v4/process"
+)
+
+const ProcessCollectorName = "process"
+
+var ErrNoWatchedProcesses = errors.New("no watched processes configured")
+
+// ProcessCollector reports resource usage of watched processes selected by PID file, name or command line.
+// Metrics of all processes matching a selector are summed up and named after the selector.
+// The oldest matching process is considered the main one, its replacement is counted as a restart
+type ProcessCollector struct {
+	watched []*watchedProcess
+	// listAll is set when some selector matches processes by name or command line
+	listAll bool
+	selfPID int32
+}
+
+type watchedProcess struct {
+	selector agentcfg.ProcessSelector
+	nameRe   *regexp.Regexp
+	suffix   string
+
+	// tracked contains processes matched on the previous poll, they are kept between polls
+	// to calculate CPU percent since the previous poll
+	tracked map[int32]*trackedProcess
+	// main identifies the main process seen last time, it is kept while no process is running
+	// so that a process started after downtime is counted as a restart
+	main     processIdentity
+	seenMain bool
+}
+
+type trackedProcess struct {
+	proc *process.Process
+	processIdentity
+}
+
+// processIdentity tells apart processes reusing the same PID
+type processIdentity struct {
+	pid        int32
+	createTime int64
+}
+
+func (pi processIdentity) before(other processIdentity) bool {
+	if pi.createTime != other.createTime {
+		return pi.createTime < other.createTime
+	}
+	return pi.pid < other.pid
+}
+
+func NewProcessCollector(cfg *agentcfg.Config) (Collector, error) {
+	selectors, err := cfg.ProcessSelectors()
+	if err != nil {
+		return nil, err
+	}
+	if len(selectors) == 0 {
+		return nil, ErrNoWatchedProcesses
+	}
+
+	c := &ProcessCollector{
+		selfPID: int32(os.Getpid()),
+	}
+	for _, sel := range selectors {
+		wp := &watchedProcess{
+			selector: sel,
+			suffix:   instanceSuffix(sel.Name),
+			tracked:  make(map[int32]*trackedProcess),
+		}
+		switch sel.Kind {
+		case agentcfg.ProcessByName:
+			wp.nameRe, err = regexp.Compile(sel.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid name pattern of watched process %s: %w", sel.Name, err)
+			}
+			c.listAll = true
+		case agentcfg.ProcessByCmdline:
+			c.listAll = true
+		}
+		c.watched = append(c.watched, wp)
+	}
+	return c, nil
+}
+
+func (c *ProcessCollector) Collect(rec Recorder) error {
+	var all []*process.Process
+	if c.listAll {
+		var err error
+		all, err = process.Processes()
+		if err != nil {
+			return fmt.Errorf("failed to list processes: %w", err)
+		}
+	}
+
+	var errs []error
+	for _, wp := range c.watched {
+		matched, err := c.match(wp, all)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		wp.track(matched, rec)
+		if err := wp.report(rec); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// match finds processes currently matching selector, agent process itself is never matched
+func (c *ProcessCollector) match(wp *watchedProcess, all []*process.Process) ([]*process.Process, error) {
+	if wp.selector.Kind == agentcfg.ProcessByPIDFile {
+		return matchPIDFile(wp.selector.Pattern)
+	}
+
+	var matched []*process.Process
+	for _, p := range all {
+		if p.Pid == c.selfPID {
+			continue
+		}
+		// processes exiting while being listed are skipped silently
+		switch wp.selector.Kind {
+		case agentcfg.ProcessByName:
+			name, err := p.Name()
+			if err == nil && wp.nameRe.MatchString(name) {
+				matched = append(matched, p)
+			}
+		case agentcfg.ProcessByCmdline:
+			cmdline, err := p.Cmdline()
+			if err == nil && strings.Contains(cmdline, wp.selector.Pattern) {
+				matched = append(matched, p)
+			}
+		}
+	}
+	return matched, nil
+}
+
+// matchPIDFile returns the process with PID from file, missing file or process means the service is down
+func matchPIDFile(path string) ([]*process.Process, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read pid file %s: %w", path, err)
+	}
+	pid, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pid in file %s: %w", path, err)
+	}
+
+	p, err := process.NewProcess(int32(pid))
+	if err != nil {
+		if errors.Is(err, process.ErrorProcessNotRunning) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get process %d from pid file %s: %w", pid, path, err)
+	}
+	return []*process.Process{p}, nil
+}
+
+// track replaces tracked processes with matched ones keeping those still running
+// and records a restart if the main process has changed
+func (wp *watchedProcess) track(matched []*process.Process, rec Recorder) {
+	tracked := make(map[int32]*trackedProcess, len(matched))
+	for _, p := range matched {
+		createTime, err := p.CreateTime()
+		if err != nil {
+			// process has exited since it was matched
+			continue
+		}
+		id := processIdentity{pid: p.Pid, createTime: createTime}
+		if tp, ok := wp.tracked[p.Pid]; ok && tp.processIdentity == id {
+			tracked[p.Pid] = tp
+			continue
+		}
+		tracked[p.Pid] = &trackedProcess{proc: p, processIdentity: id}
+	}
+	wp.tracked = tracked
+
+	var restarts int64
+	if len(tracked) > 0 {
+		var main processIdentity
+		first := true
+		for _, tp := range tracked {
+			if first || tp.before(main) {
+				main = tp.processIdentity
+				first = false
+			}
+		}
+		if wp.seenMain && main != wp.main {
+			restarts = 1
+		}
+		wp.main = main
+		wp.seenMain = true
+	}
+	rec.Counter("ProcessRestarts"+wp.suffix, restarts)
+}
+
+// report records summed up statistics of tracked processes, zero values are reported if none is running
+func (wp *watchedProcess) report(rec Recorder) error {
+	var (
+		cpuPercent float64
+		rss        uint64
+		fds        int32
+		threads    int32
+		errs       []error
+	)
+	for pid, tp := range wp.tracked {
+		stats, err := readProcessStats(tp.proc)
+		if err != nil {
+			if running, _ := tp.proc.IsRunning(); !running {
+				delete(wp.tracked, pid)
+				continue
+			}
+			errs = append(errs, fmt.Errorf("failed to get statistics of process %s (pid %d): %w",
+				wp.selector.Name, pid, err))
+		}
+		cpuPercent += stats.cpuPercent
+		rss += stats.rss
+		fds += stats.fds
+		threads += stats.threads
+	}
+
+	rec.Gauge("ProcessCount"+wp.suffix, float64(len(wp.tracked)))
+	rec.Gauge("ProcessCPUPercent"+wp.suffix, cpuPercent)
+	rec.Gauge("ProcessRSS"+wp.suffix, float64(rss))
+	rec.Gauge("ProcessOpenFDs"+wp.suffix, float64(fds))
+	rec.Gauge("ProcessThreads"+wp.suffix, float64(threads))
+	return errors.Join(errs...)
+}
+
+type processStats struct {
+	cpuPercent float64
+	rss        uint64
+	fds        int32
+	threads    int32
+}
+
+// readProcessStats reads all statistics available, e.g. open FDs of a process owned by another user
+// may be unavailable to agent while the rest of statistics is returned along with the error
+func readProcessStats(p *process.Process) (processStats, error) {
+	var (
+		stats processStats
+		errs  []error
+		err   error
+	)
+	// CPU percent is calculated since the previous call for the same process
+	if stats.cpuPercent, err = p.Percent(0); err != nil {
+		errs = append(errs, err)
+	}
+	if mi, err := p.MemoryInfo(); err != nil {
+		errs = append(errs, err)
+	} else {
+		stats.rss = mi.RSS
+	}
+	if stats.fds, err = p.NumFDs(); err != nil {
+		errs = append(errs, err)
+	}
+	if stats.threads, err = p.NumThreads(); err != nil {
+		errs = append(errs, err)
+	}
+	return stats, errors.Join(errs...)
+}
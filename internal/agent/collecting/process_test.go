@@ -0,0 +1,94 @@
+package collecting
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/andrewsvn/metrics-overseer/internal/config/agentcfg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sleepSeconds makes command line of started processes unique for a test run
+var sleepSeconds = strconv.Itoa(100000 + os.Getpid())
+
+func startSleep(t *testing.T) *exec.Cmd {
+	cmd := exec.Command("sleep", sleepSeconds)
+	require.NoError(t, cmd.Start())
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	})
+	return cmd
+}
+
+func stopSleep(t *testing.T, cmd *exec.Cmd) {
+	require.NoError(t, cmd.Process.Kill())
+	_ = cmd.Wait()
+}
+
+func TestProcessCollector(t *testing.T) {
+	if _, err := exec.LookPath("sleep"); err != nil {
+		t.Skip("sleep command is not available")
+	}
+
+	pidFile := filepath.Join(t.TempDir(), "self.pid")
+	require.NoError(t, os.WriteFile(pidFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644))
+
+	cfg := agentcfg.NewDefaultConfig()
+	cfg.WatchedProcesses = []string{
+		"self:pidfile=" + pidFile,
+		"missing:pidfile=" + filepath.Join(t.TempDir(), "missing.pid"),
+		"sleeper:cmdline=sleep " + sleepSeconds,
+		"sleep-by-name:name=^sleep$",
+	}
+	c, err := NewProcessCollector(cfg)
+	require.NoError(t, err)
+
+	sleep := startSleep(t)
+	rec := newMapRecorder()
+	require.NoError(t, c.Collect(rec))
+
+	assert.Equal(t, 1.0, rec.gauges["ProcessCount_self"])
+	assert.Positive(t, rec.gauges["ProcessRSS_self"])
+	assert.Positive(t, rec.gauges["ProcessOpenFDs_self"])
+	assert.Positive(t, rec.gauges["ProcessThreads_self"])
+	assert.Equal(t, 0.0, rec.gauges["ProcessCount_missing"])
+	assert.Equal(t, 0.0, rec.gauges["ProcessRSS_missing"])
+	assert.Equal(t, 1.0, rec.gauges["ProcessCount_sleeper"])
+	assert.Positive(t, rec.gauges["ProcessRSS_sleeper"])
+	assert.GreaterOrEqual(t, rec.gauges["ProcessCount_sleep-by-name"], 1.0)
+	assert.Equal(t, int64(0), rec.counters["ProcessRestarts_sleeper"])
+
+	// process disappears and is started again
+	stopSleep(t, sleep)
+	require.NoError(t, c.Collect(rec))
+	assert.Equal(t, 0.0, rec.gauges["ProcessCount_sleeper"])
+	assert.Equal(t, 0.0, rec.gauges["ProcessRSS_sleeper"])
+	assert.Equal(t, int64(0), rec.counters["ProcessRestarts_sleeper"])
+
+	startSleep(t)
+	require.NoError(t, c.Collect(rec))
+	require.NoError(t, c.Collect(rec))
+	assert.Equal(t, 1.0, rec.gauges["ProcessCount_sleeper"])
+	assert.Equal(t, int64(1), rec.counters["ProcessRestarts_sleeper"])
+	assert.Equal(t, int64(0), rec.counters["ProcessRestarts_self"])
+	assert.Contains(t, rec.gauges, "ProcessCPUPercent_self")
+}
+
+func TestProcessCollectorConfig(t *testing.T) {
+	cfg := agentcfg.NewDefaultConfig()
+	_, err := NewProcessCollector(cfg)
+	assert.ErrorIs(t, err, ErrNoWatchedProcesses)
+
+	cfg.WatchedProcesses = []string{"db:name=(postgres"}
+	_, err = NewProcessCollector(cfg)
+	assert.Error(t, err)
+
+	cfg.WatchedProcesses = []string{"db:exe=postgres"}
+	_, err = NewProcessCollector(cfg)
+	assert.Error(t, err)
+}
@@ -0,0 +1,55 @@
+package collecting
+
+import (
+	"math/rand"
+	"runtime"
+
+	"github.com/andrewsvn/metrics-overseer/internal/config/agentcfg"
+)
+
+const RuntimeCollectorName = "runtime"
+
+// RuntimeCollector reports Go runtime memory statistics of the agent process along with
+// RandomValue gauge and PollCount counter
+type RuntimeCollector struct{}
+
+func NewRuntimeCollector(_ *agentcfg.Config) (Collector, error) {
+	return &RuntimeCollector{}, nil
+}
+
+func (c *RuntimeCollector) Collect(rec Recorder) error {
+	ms := runtime.MemStats{}
+	runtime.ReadMemStats(&ms)
+
+	rec.Gauge("Alloc", float64(ms.Alloc))
+	rec.Gauge("BuckHashSys", float64(ms.BuckHashSys))
+	rec.Gauge("Frees", float64(ms.Frees))
+	rec.Gauge("GCCPUFraction", ms.GCCPUFraction)
+	rec.Gauge("GCSys", float64(ms.GCSys))
+	rec.Gauge("HeapAlloc", float64(ms.HeapAlloc))
+	rec.Gauge("HeapIdle", float64(ms.HeapIdle))
+	rec.Gauge("HeapInuse", float64(ms.HeapInuse))
+	rec.Gauge("HeapObjects", float64(ms.HeapObjects))
+	rec.Gauge("HeapReleased", float64(ms.HeapReleased))
+	rec.Gauge("HeapSys", float64(ms.HeapSys))
+	rec.Gauge("LastGC", float64(ms.LastGC))
+	rec.Gauge("Lookups", float64(ms.Lookups))
+	rec.Gauge("MCacheInuse", float64(ms.MCacheInuse))
+	rec.Gauge("MCacheSys", float64(ms.MCacheSys))
+	rec.Gauge("MSpanInuse", float64(ms.MSpanInuse))
+	rec.Gauge("MSpanSys", float64(ms.MSpanSys))
+	rec.Gauge("Mallocs", float64(ms.Mallocs))
+	rec.Gauge("NextGC", float64(ms.NextGC))
+	rec.Gauge("NumForcedGC", float64(ms.NumForcedGC))
+	rec.Gauge("NumGC", float64(ms.NumGC))
+	rec.Gauge("OtherSys", float64(ms.OtherSys))
+	rec.Gauge("PauseTotalNs", float64(ms.PauseTotalNs))
+	rec.Gauge("StackInuse", float64(ms.StackInuse))
+	rec.Gauge("StackSys", float64(ms.StackSys))
+	rec.Gauge("Sys", float64(ms.Sys))
+	rec.Gauge("TotalAlloc", float64(ms.TotalAlloc))
+
+	rec.Gauge("RandomValue", rand.Float64())
+	rec.Counter("PollCount", 1)
+	return nil
+}
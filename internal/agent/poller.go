@@ -2,36 +2,94 @@ package agent
 
 import (
 	"context"
-	"fmt"
+	"sync"
+	"time"
+
 	"github.com/andrewsvn/metrics-overseer/internal/agent/accumulation"
+	"github.com/andrewsvn/metrics-overseer/internal/agent/collecting"
 	"github.com/andrewsvn/metrics-overseer/internal/config/agentcfg"
-	"github.com/shirou/gopsutil/v4/cpu"
-	"github.com/shirou/gopsutil/v4/mem"
 	"go.uber.org/zap"
-	"math/rand"
-	"runtime"
-	"sync"
-	"time"
 )
 
+// Poller polls enabled collectors each with its own interval and accumulates collected values in storage
 type Poller struct {
-	interval time.Duration
+	collectors []*scheduledCollector
+	// summaryIDs contains gauge metric IDs to be accumulated as summaries
+	summaryIDs map[string]struct{}
 
 	stor   *accumulation.Storage
 	logger *zap.SugaredLogger
 }
 
-func NewPoller(cfg *agentcfg.Config, stor *accumulation.Storage, l *zap.Logger) *Poller {
-	return &Poller{
-		interval: time.Duration(cfg.PollIntervalSec) * time.Second,
-		stor:     stor,
-		logger:   l.Sugar().With("component", "agent-polling"),
+// scheduledCollector is a collector with its poll settings, it also records collected values
+// to poller storage adding collector prefix to metric IDs
+type scheduledCollector struct {
+	name      string
+	collector collecting.Collector
+	interval  time.Duration
+	prefix    string
+	poller    *Poller
+}
+
+// NewPoller creates collectors enabled in configuration using given registry
+func NewPoller(
+	cfg *agentcfg.Config,
+	registry *collecting.Registry,
+	stor *accumulation.Storage,
+	l *zap.Logger,
+) (*Poller, error) {
+	summaryIDs := make(map[string]struct{}, len(cfg.SummaryMetrics))
+	for _, id := range cfg.SummaryMetrics {
+		summaryIDs[id] = struct{}{}
+	}
+
+	p := &Poller{
+		summaryIDs: summaryIDs,
+		stor:       stor,
+		logger:     l.Sugar().With("component", "agent-polling"),
+	}
+
+	intervals, err := cfg.Intervals()
+	if err != nil {
+		return nil, err
+	}
+	prefixes, err := cfg.Prefixes()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range cfg.Collectors() {
+		c, err := registry.New(name, cfg)
+		if err != nil {
+			return nil, err
+		}
+		interval, ok := intervals[name]
+		if !ok {
+			interval = time.Duration(cfg.PollIntervalSec) * time.Second
+		}
+		p.Register(name, c, interval, prefixes[name])
 	}
+	return p, nil
+}
+
+// Register adds a collector polled with given interval, prefix is added to IDs of its metrics
+func (p *Poller) Register(name string, c collecting.Collector, interval time.Duration, prefix string) {
+	p.logger.Infow("registering collector",
+		"collector", name,
+		"interval", interval.String(),
+		"prefix", prefix)
+	p.collectors = append(p.collectors, &scheduledCollector{
+		name:      name,
+		collector: c,
+		interval:  interval,
+		prefix:    prefix,
+		poller:    p,
+	})
 }
 
 func (p *Poller) Start(ctx context.Context, wg *sync.WaitGroup) {
-	p.startPollFunc(ctx, wg, p.execMemstatsPoll, time.NewTicker(p.interval))
-	p.startPollFunc(ctx, wg, p.execGopsPoll, time.NewTicker(p.interval))
+	for _, sc := range p.collectors {
+		p.startPollFunc(ctx, wg, sc.poll, time.NewTicker(sc.interval))
+	}
 }
 
 func (p *Poller) startPollFunc(ctx context.Context, wg *sync.WaitGroup, pf func(), ticker *time.Ticker) {
@@ -51,65 +109,29 @@ func (p *Poller) startPollFunc(ctx context.Context, wg *sync.WaitGroup, pf func(
 	}()
 }
 
-func (p *Poller) execMemstatsPoll() {
-	p.logger.Info("polling memstats accumulation")
-
-	ms := runtime.MemStats{}
-	runtime.ReadMemStats(&ms)
-
-	p.storeGaugeMetric("Alloc", float64(ms.Alloc))
-	p.storeGaugeMetric("BuckHashSys", float64(ms.BuckHashSys))
-	p.storeGaugeMetric("Frees", float64(ms.Frees))
-	p.storeGaugeMetric("GCCPUFraction", ms.GCCPUFraction)
-	p.storeGaugeMetric("GCSys", float64(ms.GCSys))
-	p.storeGaugeMetric("HeapAlloc", float64(ms.HeapAlloc))
-	p.storeGaugeMetric("HeapIdle", float64(ms.HeapIdle))
-	p.storeGaugeMetric("HeapInuse", float64(ms.HeapInuse))
-	p.storeGaugeMetric("HeapObjects", float64(ms.HeapObjects))
-	p.storeGaugeMetric("HeapReleased", float64(ms.HeapReleased))
-	p.storeGaugeMetric("HeapSys", float64(ms.HeapSys))
-	p.storeGaugeMetric("LastGC", float64(ms.LastGC))
-	p.storeGaugeMetric("Lookups", float64(ms.Lookups))
-	p.storeGaugeMetric("MCacheInuse", float64(ms.MCacheInuse))
-	p.storeGaugeMetric("MCacheSys", float64(ms.MCacheSys))
-	p.storeGaugeMetric("MSpanInuse", float64(ms.MSpanInuse))
-	p.storeGaugeMetric("MSpanSys", float64(ms.MSpanSys))
-	p.storeGaugeMetric("Mallocs", float64(ms.Mallocs))
-	p.storeGaugeMetric("NextGC", float64(ms.NextGC))
-	p.storeGaugeMetric("NumForcedGC", float64(ms.NumForcedGC))
-	p.storeGaugeMetric("NumGC", float64(ms.NumGC))
-	p.storeGaugeMetric("OtherSys", float64(ms.OtherSys))
-	p.storeGaugeMetric("PauseTotalNs", float64(ms.PauseTotalNs))
-	p.storeGaugeMetric("StackInuse", float64(ms.StackInuse))
-	p.storeGaugeMetric("StackSys", float64(ms.StackSys))
-	p.storeGaugeMetric("Sys", float64(ms.Sys))
-	p.storeGaugeMetric("TotalAlloc", float64(ms.TotalAlloc))
-
-	p.storeGaugeMetric("RandomValue", rand.Float64())
-	p.storeCounterMetric("PollCount", 1)
+// pollAll polls all collectors once
+func (p *Poller) pollAll() {
+	for _, sc := range p.collectors {
+		sc.poll()
+	}
 }
 
-func (p *Poller) execGopsPoll() {
-	p.logger.Info("polling gops accumulation")
-
-	vmStat, err := mem.VirtualMemory()
-	if err != nil {
-		p.logger.Errorw("failed to get gops memory accumulation", "error", err)
-		return
+func (sc *scheduledCollector) poll() {
+	sc.poller.logger.Infow("polling collector", "collector", sc.name)
+	if err := sc.collector.Collect(sc); err != nil {
+		sc.poller.logger.Errorw("failed to poll collector",
+			"collector", sc.name,
+			"error", err,
+		)
 	}
+}
 
-	p.storeGaugeMetric("TotalMemory", float64(vmStat.Total))
-	p.storeGaugeMetric("FreeMemory", float64(vmStat.Free))
-
-	cpuUtils, err := cpu.Percent(0, true)
-	if err != nil {
-		p.logger.Errorw("failed to get gops cpu accumulation", "error", err)
-		return
-	}
+func (sc *scheduledCollector) Gauge(id string, value float64) {
+	sc.poller.storeGaugeMetric(sc.prefix+id, value)
+}
 
-	for id, cpuUtil := range cpuUtils {
-		p.storeGaugeMetric(fmt.Sprintf("CPUutilization%d", id+1), cpuUtil)
-	}
+func (sc *scheduledCollector) Counter(id string, delta int64) {
+	sc.poller.storeCounterMetric(sc.prefix+id, delta)
 }
 
 func (p *Poller) storeCounterMetric(id string, delta int64) {
@@ -124,6 +146,11 @@ func (p *Poller) storeCounterMetric(id string, delta int64) {
 }
 
 func (p *Poller) storeGaugeMetric(id string, value float64) {
+	if _, ok := p.summaryIDs[id]; ok {
+		p.storeSummaryMetric(id, value)
+		return
+	}
+
 	ma := p.stor.GetOrNew(id)
 	err := ma.AccumulateGauge(value)
 	if err != nil {
@@ -133,3 +160,14 @@ func (p *Poller) storeGaugeMetric(id string, value float64) {
 		)
 	}
 }
+
+func (p *Poller) storeSummaryMetric(id string, value float64) {
+	ma := p.stor.GetOrNew(id)
+	err := ma.AccumulateSummary(value)
+	if err != nil {
+		p.logger.Errorw("failed to store summary metric",
+			"metric", id,
+			"reason", err.Error(),
+		)
+	}
+}
@@ -0,0 +1,359 @@
+// Package queue provides a disk-backed queue of metric batches for metrics-overseer agent.
+// Staged metrics are persisted to the queue before sending, so they survive agent restarts
+// and long server outages, and are replayed in the order they were collected
+package queue
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andrewsvn/metrics-overseer/internal/model"
+	"go.uber.org/zap"
+)
+
+// Drop policies applied when queue size limit is reached
+const (
+	// DropOldest removes the oldest batches to free space for a new one
+	DropOldest = "oldest"
+	// DropNewest rejects a new batch keeping the queued ones
+	DropNewest = "newest"
+)
+
+const (
+	batchFileExt = ".json"
+	tmpFileExt   = ".tmp"
+	// maxReadFailures is the number of consecutive failed reads of the oldest batch after which
+	// it's dropped, so a broken file doesn't block replay of the queue forever
+	maxReadFailures = 3
+)
+
+var (
+	ErrQueueFull          = errors.New("queue is full")
+	ErrUnknownDropPolicy  = errors.New("unknown queue drop policy")
+	ErrBatchNotFound      = errors.New("batch not found in queue")
+	errInvalidBatchFormat = errors.New("invalid batch file name")
+)
+
+// Batch is a list of metrics staged by a single report
+type Batch struct {
+	Seq       uint64
+	CreatedAt time.Time
+	// ID is sent to server along with metrics, so the batch is applied once even if it's replayed
+	// after agent restart
+	ID      model.BatchID
+	Metrics []*model.Metrics
+}
+
+// batchFile is the content of batch file
+type batchFile struct {
+	ID      model.BatchID    `json:"id"`
+	Metrics []*model.Metrics `json:"metrics"`
+}
+
+type entry struct {
+	seq       uint64
+	createdAt time.Time
+	size      int64
+}
+
+// name of batch file contains its sequence number and creation time, so queue state is restored
+// on start without reading batches. Files are written to a temporary file first and renamed then,
+// so a batch file is either complete or absent
+func (e entry) fileName() string {
+	return fmt.Sprintf("%020d-%d%s", e.seq, e.createdAt.UnixNano(), batchFileExt)
+}
+
+func parseFileName(name string) (entry, error) {
+	base, ok := strings.CutSuffix(name, batchFileExt)
+	if !ok {
+		return entry{}, errInvalidBatchFormat
+	}
+	seqPart, tsPart, ok := strings.Cut(base, "-")
+	if !ok {
+		return entry{}, errInvalidBatchFormat
+	}
+	seq, err := strconv.ParseUint(seqPart, 10, 64)
+	if err != nil {
+		return entry{}, errInvalidBatchFormat
+	}
+	ts, err := strconv.ParseInt(tsPart, 10, 64)
+	if err != nil {
+		return entry{}, errInvalidBatchFormat
+	}
+	return entry{seq: seq, createdAt: time.Unix(0, ts)}, nil
+}
+
+// DiskQueue stores each batch in a separate file of the queue directory. Total size of batch files
+// is limited by maxBytes and batches older than maxAge are dropped, zero or negative limit means no limit.
+// All methods are safe for concurrent use
+type DiskQueue struct {
+	dir        string
+	maxBytes   int64
+	maxAge     time.Duration
+	dropPolicy string
+
+	mutex   sync.Mutex
+	entries []entry
+	bytes   int64
+	nextSeq uint64
+	dropped int64
+	// readFailures counts consecutive failed reads of batch failedSeq
+	failedSeq    uint64
+	readFailures int
+
+	logger *zap.SugaredLogger
+}
+
+// NewDiskQueue opens a queue in the given directory (creating it if needed) and restores batches
+// persisted by previous agent runs
+func NewDiskQueue(
+	dir string,
+	maxBytes int64,
+	maxAge time.Duration,
+	dropPolicy string,
+	logger *zap.Logger,
+) (*DiskQueue, error) {
+	if dropPolicy != DropOldest && dropPolicy != DropNewest {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownDropPolicy, dropPolicy)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("can't create queue directory: %w", err)
+	}
+
+	q := &DiskQueue{
+		dir:        dir,
+		maxBytes:   maxBytes,
+		maxAge:     maxAge,
+		dropPolicy: dropPolicy,
+		logger:     logger.Sugar().With(zap.String("component", "agent-queue")),
+	}
+	if err := q.restore(); err != nil {
+		return nil, err
+	}
+	q.logger.Infow("send queue opened",
+		"dir", dir,
+		"batches", len(q.entries),
+		"bytes", q.bytes)
+	return q, nil
+}
+
+func (q *DiskQueue) restore() error {
+	files, err := os.ReadDir(q.dir)
+	if err != nil {
+		return fmt.Errorf("can't read queue directory: %w", err)
+	}
+
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		if strings.HasSuffix(f.Name(), tmpFileExt) {
+			// temporary file of an interrupted write
+			_ = os.Remove(filepath.Join(q.dir, f.Name()))
+			continue
+		}
+		e, err := parseFileName(f.Name())
+		if err != nil {
+			continue
+		}
+		info, err := f.Info()
+		if err != nil {
+			return fmt.Errorf("can't read queue batch file info: %w", err)
+		}
+		e.size = info.Size()
+		q.entries = append(q.entries, e)
+		q.bytes += e.size
+		q.nextSeq = max(q.nextSeq, e.seq+1)
+	}
+	slices.SortFunc(q.entries, func(a, b entry) int {
+		return cmp.Compare(a.seq, b.seq)
+	})
+	return nil
+}
+
+// Push persists a new batch at the end of the queue. If size limit is exceeded, either the oldest batches
+// are dropped, or ErrQueueFull is returned depending on drop policy
+func (q *DiskQueue) Push(id model.BatchID, metrics []*model.Metrics) error {
+	data, err := json.Marshal(&batchFile{ID: id, Metrics: metrics})
+	if err != nil {
+		return fmt.Errorf("can't serialize metrics batch: %w", err)
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.dropExpired()
+	size := int64(len(data))
+	if q.maxBytes > 0 {
+		if size > q.maxBytes || (q.dropPolicy == DropNewest && q.bytes+size > q.maxBytes) {
+			q.dropped++
+			return ErrQueueFull
+		}
+		for len(q.entries) > 0 && q.bytes+size > q.maxBytes {
+			q.logger.Warnw("send queue is full, dropping the oldest batch", "seq", q.entries[0].seq)
+			q.drop(0)
+		}
+	}
+
+	e := entry{seq: q.nextSeq, createdAt: time.Now(), size: size}
+	if err := q.writeFile(e, data); err != nil {
+		return err
+	}
+	q.nextSeq++
+	q.entries = append(q.entries, e)
+	q.bytes += size
+	return nil
+}
+
+// Peek returns the oldest batch of the queue without removing it, nil is returned if queue is empty.
+// Expired, missing and corrupted batches are dropped. Read error is returned as is, since it may be temporary,
+// but the batch is dropped after maxReadFailures consecutive failures
+func (q *DiskQueue) Peek() (*Batch, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.dropExpired()
+	for len(q.entries) > 0 {
+		e := q.entries[0]
+		data, err := os.ReadFile(filepath.Join(q.dir, e.fileName()))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			if q.failedSeq != e.seq {
+				q.failedSeq, q.readFailures = e.seq, 0
+			}
+			q.readFailures++
+			if q.readFailures < maxReadFailures {
+				return nil, fmt.Errorf("can't read queue batch file: %w", err)
+			}
+		}
+
+		var bf batchFile
+		if err == nil {
+			err = json.Unmarshal(data, &bf)
+		}
+		if err != nil {
+			q.logger.Errorw("dropping unreadable batch", "seq", e.seq, "error", err)
+			q.drop(0)
+			continue
+		}
+		return &Batch{Seq: e.seq, CreatedAt: e.createdAt, ID: bf.ID, Metrics: bf.Metrics}, nil
+	}
+	return nil, nil
+}
+
+// Replace overwrites a queued batch with the same sequence number keeping its position in the queue,
+// it is used when only a part of the batch is sent
+func (q *DiskQueue) Replace(batch *Batch) error {
+	data, err := json.Marshal(&batchFile{ID: batch.ID, Metrics: batch.Metrics})
+	if err != nil {
+		return fmt.Errorf("can't serialize metrics batch: %w", err)
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	idx := q.find(batch.Seq)
+	if idx < 0 {
+		return ErrBatchNotFound
+	}
+	e := q.entries[idx]
+	if err := q.writeFile(e, data); err != nil {
+		return err
+	}
+	q.bytes += int64(len(data)) - e.size
+	q.entries[idx].size = int64(len(data))
+	return nil
+}
+
+// Remove deletes a batch from the queue after it has been sent
+func (q *DiskQueue) Remove(seq uint64) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	idx := q.find(seq)
+	if idx < 0 {
+		return ErrBatchNotFound
+	}
+	return q.remove(idx)
+}
+
+// Stats returns the number of queued batches, their total size in bytes and the number of dropped batches
+func (q *DiskQueue) Stats() (batches int, bytes int64, dropped int64) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return len(q.entries), q.bytes, q.dropped
+}
+
+func (q *DiskQueue) find(seq uint64) int {
+	for i, e := range q.entries {
+		if e.seq == seq {
+			return i
+		}
+	}
+	return -1
+}
+
+func (q *DiskQueue) dropExpired() {
+	if q.maxAge <= 0 {
+		return
+	}
+	deadline := time.Now().Add(-q.maxAge)
+	for len(q.entries) > 0 && q.entries[0].createdAt.Before(deadline) {
+		q.logger.Warnw("dropping expired batch", "seq", q.entries[0].seq, "created", q.entries[0].createdAt)
+		q.drop(0)
+	}
+}
+
+func (q *DiskQueue) drop(idx int) {
+	if err := q.remove(idx); err != nil {
+		q.logger.Errorw("can't remove dropped batch file", "error", err)
+	}
+	q.dropped++
+}
+
+// remove deletes batch file and forgets the batch even if file can't be deleted,
+// so a broken file doesn't block the queue
+func (q *DiskQueue) remove(idx int) error {
+	e := q.entries[idx]
+	q.entries = slices.Delete(q.entries, idx, idx+1)
+	q.bytes -= e.size
+
+	err := os.Remove(filepath.Join(q.dir, e.fileName()))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("can't remove queue batch file: %w", err)
+	}
+	return nil
+}
+
+func (q *DiskQueue) writeFile(e entry, data []byte) error {
+	tmp, err := os.CreateTemp(q.dir, "batch-*"+tmpFileExt)
+	if err != nil {
+		return fmt.Errorf("can't create queue batch file: %w", err)
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("can't write queue batch file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), filepath.Join(q.dir, e.fileName())); err != nil {
+		return fmt.Errorf("can't write queue batch file: %w", err)
+	}
+	return nil
+}
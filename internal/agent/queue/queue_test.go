@@ -0,0 +1,186 @@
+package queue
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/andrewsvn/metrics-overseer/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+var testBatchID = model.BatchID{AgentID: "agent", Seq: 1}
+
+func counterBatch(ids ...string) []*model.Metrics {
+	metrics := make([]*model.Metrics, 0, len(ids))
+	for i, id := range ids {
+		metrics = append(metrics, model.NewCounterMetricsWithDelta(id, int64(i+1)))
+	}
+	return metrics
+}
+
+func TestDiskQueuePersistence(t *testing.T) {
+	dir := t.TempDir()
+	q, err := NewDiskQueue(dir, 0, 0, DropOldest, zap.NewNop())
+	require.NoError(t, err)
+
+	require.NoError(t, q.Push(testBatchID, counterBatch("cnt1")))
+	require.NoError(t, q.Push(testBatchID, counterBatch("cnt2", "cnt3")))
+	require.NoError(t, q.Push(testBatchID, counterBatch("cnt4")))
+
+	// leftover of an interrupted write is cleaned up on start
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "batch-1"+tmpFileExt), []byte("[{"), 0644))
+
+	q, err = NewDiskQueue(dir, 0, 0, DropOldest, zap.NewNop())
+	require.NoError(t, err)
+	batches, bytes, _ := q.Stats()
+	assert.Equal(t, 3, batches)
+	assert.Positive(t, bytes)
+	assert.NoFileExists(t, filepath.Join(dir, "batch-1"+tmpFileExt))
+
+	// batches are replayed in the order they were pushed
+	var ids []string
+	for {
+		b, err := q.Peek()
+		require.NoError(t, err)
+		if b == nil {
+			break
+		}
+		for _, m := range b.Metrics {
+			ids = append(ids, m.ID)
+		}
+		require.NoError(t, q.Remove(b.Seq))
+	}
+	assert.Equal(t, []string{"cnt1", "cnt2", "cnt3", "cnt4"}, ids)
+
+	// sequence continues after restart
+	require.NoError(t, q.Push(testBatchID, counterBatch("cnt5")))
+	b, err := q.Peek()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), b.Seq)
+	assert.Equal(t, int64(1), *b.Metrics[0].Delta)
+}
+
+func TestDiskQueueSizeLimit(t *testing.T) {
+	batchSize := func() int64 {
+		q, err := NewDiskQueue(t.TempDir(), 0, 0, DropOldest, zap.NewNop())
+		require.NoError(t, err)
+		require.NoError(t, q.Push(testBatchID, counterBatch("cnt1")))
+		_, bytes, _ := q.Stats()
+		return bytes
+	}()
+
+	t.Run("drop oldest", func(t *testing.T) {
+		q, err := NewDiskQueue(t.TempDir(), 2*batchSize, 0, DropOldest, zap.NewNop())
+		require.NoError(t, err)
+		require.NoError(t, q.Push(testBatchID, counterBatch("cnt1")))
+		require.NoError(t, q.Push(testBatchID, counterBatch("cnt2")))
+		require.NoError(t, q.Push(testBatchID, counterBatch("cnt3")))
+
+		batches, bytes, dropped := q.Stats()
+		assert.Equal(t, 2, batches)
+		assert.Equal(t, 2*batchSize, bytes)
+		assert.Equal(t, int64(1), dropped)
+		b, err := q.Peek()
+		require.NoError(t, err)
+		assert.Equal(t, "cnt2", b.Metrics[0].ID)
+	})
+
+	t.Run("drop newest", func(t *testing.T) {
+		q, err := NewDiskQueue(t.TempDir(), 2*batchSize, 0, DropNewest, zap.NewNop())
+		require.NoError(t, err)
+		require.NoError(t, q.Push(testBatchID, counterBatch("cnt1")))
+		require.NoError(t, q.Push(testBatchID, counterBatch("cnt2")))
+		assert.ErrorIs(t, q.Push(testBatchID, counterBatch("cnt3")), ErrQueueFull)
+
+		batches, _, dropped := q.Stats()
+		assert.Equal(t, 2, batches)
+		assert.Equal(t, int64(1), dropped)
+		b, err := q.Peek()
+		require.NoError(t, err)
+		assert.Equal(t, "cnt1", b.Metrics[0].ID)
+	})
+
+	t.Run("oversize batch", func(t *testing.T) {
+		q, err := NewDiskQueue(t.TempDir(), batchSize, 0, DropOldest, zap.NewNop())
+		require.NoError(t, err)
+		require.NoError(t, q.Push(testBatchID, counterBatch("cnt1")))
+		assert.ErrorIs(t, q.Push(testBatchID, counterBatch("cnt2", "cnt3")), ErrQueueFull)
+
+		batches, _, _ := q.Stats()
+		assert.Equal(t, 1, batches)
+	})
+}
+
+func TestDiskQueueMaxAge(t *testing.T) {
+	q, err := NewDiskQueue(t.TempDir(), 0, 50*time.Millisecond, DropOldest, zap.NewNop())
+	require.NoError(t, err)
+	require.NoError(t, q.Push(testBatchID, counterBatch("cnt1")))
+	time.Sleep(60 * time.Millisecond)
+	require.NoError(t, q.Push(testBatchID, counterBatch("cnt2")))
+
+	b, err := q.Peek()
+	require.NoError(t, err)
+	assert.Equal(t, "cnt2", b.Metrics[0].ID)
+	batches, _, dropped := q.Stats()
+	assert.Equal(t, 1, batches)
+	assert.Equal(t, int64(1), dropped)
+}
+
+func TestDiskQueueReadFailures(t *testing.T) {
+	dir := t.TempDir()
+	q, err := NewDiskQueue(dir, 0, 0, DropOldest, zap.NewNop())
+	require.NoError(t, err)
+	require.NoError(t, q.Push(testBatchID, counterBatch("cnt1")))
+	require.NoError(t, q.Push(testBatchID, counterBatch("cnt2")))
+
+	// batch file replaced with a directory can't be read, but the error is not ErrNotExist
+	path := filepath.Join(dir, q.entries[0].fileName())
+	require.NoError(t, os.Remove(path))
+	require.NoError(t, os.Mkdir(path, 0755))
+
+	// read error is returned until the batch is dropped, so replay is not blocked forever
+	for range maxReadFailures - 1 {
+		_, err = q.Peek()
+		require.Error(t, err)
+	}
+	b, err := q.Peek()
+	require.NoError(t, err)
+	require.NotNil(t, b)
+	assert.Equal(t, "cnt2", b.Metrics[0].ID)
+	batches, _, dropped := q.Stats()
+	assert.Equal(t, 1, batches)
+	assert.Equal(t, int64(1), dropped)
+}
+
+func TestDiskQueueReplace(t *testing.T) {
+	dir := t.TempDir()
+	q, err := NewDiskQueue(dir, 0, 0, DropOldest, zap.NewNop())
+	require.NoError(t, err)
+	require.NoError(t, q.Push(testBatchID, counterBatch("cnt1", "cnt2", "cnt3")))
+	require.NoError(t, q.Push(testBatchID, counterBatch("cnt4")))
+
+	b, err := q.Peek()
+	require.NoError(t, err)
+	b.Metrics = b.Metrics[1:2]
+	require.NoError(t, q.Replace(b))
+	assert.ErrorIs(t, q.Replace(&Batch{Seq: 100}), ErrBatchNotFound)
+
+	q, err = NewDiskQueue(dir, 0, 0, DropOldest, zap.NewNop())
+	require.NoError(t, err)
+	b, err = q.Peek()
+	require.NoError(t, err)
+	require.Len(t, b.Metrics, 1)
+	assert.Equal(t, "cnt2", b.Metrics[0].ID)
+	assert.Equal(t, testBatchID, b.ID)
+	batches, _, _ := q.Stats()
+	assert.Equal(t, 2, batches)
+}
+
+func TestDiskQueueUnknownDropPolicy(t *testing.T) {
+	_, err := NewDiskQueue(t.TempDir(), 0, 0, "random", zap.NewNop())
+	assert.ErrorIs(t, err, ErrUnknownDropPolicy)
+}
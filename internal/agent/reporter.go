@@ -2,17 +2,25 @@ package agent
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"os"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/andrewsvn/metrics-overseer/internal/agent/accumulation"
+	"github.com/andrewsvn/metrics-overseer/internal/agent/queue"
 	"github.com/andrewsvn/metrics-overseer/internal/agent/reporting"
 	"github.com/andrewsvn/metrics-overseer/internal/agent/sending"
+	"github.com/andrewsvn/metrics-overseer/internal/agent/telemetry"
+	"github.com/andrewsvn/metrics-overseer/internal/compress"
 	"github.com/andrewsvn/metrics-overseer/internal/config/agentcfg"
 	"github.com/andrewsvn/metrics-overseer/internal/model"
 	"github.com/andrewsvn/metrics-overseer/internal/retrying"
+	"github.com/andrewsvn/metrics-overseer/internal/tlsconfig"
 	"go.uber.org/zap"
 )
 
@@ -22,32 +30,104 @@ type Reporter struct {
 	stor        *accumulation.Storage
 	executor    reporting.Executor
 	reportMutex sync.Mutex
+	telemetry   *telemetry.Telemetry
+	// queue persists staged metrics before sending if it is enabled
+	queue *queue.DiskQueue
+
+	// agentID and batchSeq make up IDs of reported batches, sequence starts from agent start time,
+	// so batch IDs don't repeat after agent restart
+	agentID  string
+	batchSeq uint64
 
 	logger *zap.SugaredLogger
 }
 
-func NewReporter(cfg *agentcfg.Config, storage *accumulation.Storage, l *zap.Logger) (*Reporter, error) {
+func NewReporter(
+	cfg *agentcfg.Config,
+	storage *accumulation.Storage,
+	tel *telemetry.Telemetry,
+	l *zap.Logger,
+) (*Reporter, error) {
 	reportRetryPolicy := retrying.NewLinearPolicy(
 		cfg.MaxRetryCount,
 		time.Duration(cfg.InitialRetryDelaySec)*time.Second,
 		time.Duration(cfg.RetryDelayIncrementSec)*time.Second,
 	)
 
-	serverAddr := strings.Trim(cfg.ServerAddr, "\"")
-	sndr, err := sending.NewRestSender(serverAddr, reportRetryPolicy, cfg.SecretKey, cfg.PublicKeyPath, l)
+	sndr, err := newSender(cfg, reportRetryPolicy, tel, l)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create sender: %w", err)
 	}
 
+	var q *queue.DiskQueue
+	if cfg.QueueConfig.IsSetUp() {
+		q, err = queue.NewDiskQueue(cfg.QueueDir, cfg.QueueMaxBytes,
+			time.Duration(cfg.QueueMaxAgeSec)*time.Second, cfg.QueueDropPolicy, l)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open send queue: %w", err)
+		}
+		tel.ObserveQueue(q)
+	}
+
 	rLogger := l.Sugar().With("component", "agent-reporting")
 	return &Reporter{
-		interval: time.Duration(cfg.ReportIntervalSec) * time.Second,
-		stor:     storage,
-		executor: newExecutor(cfg, sndr, rLogger),
-		logger:   rLogger,
+		interval:  time.Duration(cfg.ReportIntervalSec) * time.Second,
+		stor:      storage,
+		executor:  newExecutor(cfg, sndr, rLogger),
+		telemetry: tel,
+		queue:     q,
+		agentID:   agentID(cfg),
+		batchSeq:  uint64(time.Now().UnixNano()),
+		logger:    rLogger,
 	}, nil
 }
 
+// agentID returns configured agent ID falling back to host name
+func agentID(cfg *agentcfg.Config) string {
+	if cfg.AgentID != "" {
+		return cfg.AgentID
+	}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		return hostname
+	}
+	return "agent"
+}
+
+func newSender(
+	cfg *agentcfg.Config,
+	retryPolicy retrying.Policy,
+	retryObserver retrying.Observer,
+	l *zap.Logger,
+) (sending.MetricSender, error) {
+	keys, err := cfg.KeyRing()
+	if err != nil {
+		return nil, err
+	}
+
+	var tlsCfg *tls.Config
+	if cfg.TLSConfig.IsSetUp() {
+		tlsCfg, err = tlsconfig.NewClientConfig(cfg.TLSCACertPath, cfg.TLSCertPath, cfg.TLSKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("can't configure TLS: %w", err)
+		}
+	}
+
+	switch cfg.Transport {
+	case agentcfg.TransportREST:
+		serverAddr := strings.Trim(cfg.ServerAddr, "\"")
+		cwe, err := compress.NewWriteEngine(cfg.Compression)
+		if err != nil {
+			return nil, err
+		}
+		return sending.NewRestSender(serverAddr, retryPolicy, retryObserver, keys, cfg.APIToken, cfg.PublicKeyPath, cwe,
+			tlsCfg, l)
+	case agentcfg.TransportGRPC:
+		serverAddr := strings.Trim(cfg.GRPCServerAddr, "\"")
+		return sending.NewGRPCSender(serverAddr, retryPolicy, retryObserver, keys, cfg.APIToken, tlsCfg, l)
+	}
+	return nil, fmt.Errorf("unsupported transport: %s", cfg.Transport)
+}
+
 func newExecutor(cfg *agentcfg.Config, sndr sending.MetricSender, sl *zap.SugaredLogger) reporting.Executor {
 	if cfg.MaxNumberOfRequests > 0 {
 		return reporting.NewWorkerPoolExecutor(cfg.MaxNumberOfRequests, sndr, sl)
@@ -81,6 +161,11 @@ func (r *Reporter) execReport() {
 	defer r.reportMutex.Unlock()
 
 	r.logger.Info("reporting metrics to server")
+	// agent statistics of previous reports are sent along with polled metrics
+	if err := r.telemetry.Accumulate(); err != nil {
+		r.logger.Errorw("unable to accumulate agent telemetry", "error", err)
+	}
+
 	marray := make([]*model.Metrics, 0)
 	for ma := range r.stor.GetAll() {
 		metric, err := ma.StageChanges()
@@ -97,8 +182,88 @@ func (r *Reporter) execReport() {
 		}
 	}
 
-	result := r.executor.Execute(marray)
+	r.batchSeq++
+	batchID := model.BatchID{AgentID: r.agentID, Seq: r.batchSeq}
+	if r.queue != nil {
+		r.execQueuedReport(batchID, marray)
+		return
+	}
+
+	result := r.executor.Execute(&batchID, marray)
+	r.completeStaged(result)
+	r.telemetry.RecordReport(len(marray), len(result.SuccessIDs), len(result.FailureIDs))
+}
+
+// execQueuedReport persists staged metrics to disk queue and commits them, so they are neither accumulated
+// in memory nor lost on agent restart while server is unreachable. Then queued batches are sent
+// in the order they were collected
+func (r *Reporter) execQueuedReport(batchID model.BatchID, marray []*model.Metrics) {
+	if len(marray) > 0 {
+		ids := make([]string, 0, len(marray))
+		for _, m := range marray {
+			ids = append(ids, m.ID)
+		}
+
+		err := r.queue.Push(batchID, marray)
+		switch {
+		case err == nil:
+			r.completeStaged(reporting.SuccessResult(ids...))
+		case errors.Is(err, queue.ErrQueueFull):
+			r.logger.Warnw("send queue is full, dropping new metrics batch", "count", len(marray))
+			r.completeStaged(reporting.SuccessResult(ids...))
+		default:
+			r.logger.Errorw("unable to persist metrics batch, keeping it in memory", "error", err)
+			r.completeStaged(reporting.FailureResult(ids...))
+		}
+	}
+
+	sent, failed := r.replayQueue()
+	r.telemetry.RecordReport(len(marray), sent, failed)
+}
+
+// replayQueue sends queued batches starting from the oldest one until the queue is empty
+// or a batch fails. Sent metrics are removed from a partially failed batch, and the rest of it
+// is sent with the next report before newer batches
+func (r *Reporter) replayQueue() (sent int, failed int) {
+	for {
+		batch, err := r.queue.Peek()
+		if err != nil {
+			r.logger.Errorw("unable to read metrics batch from send queue", "error", err)
+			return
+		}
+		if batch == nil {
+			return
+		}
+
+		result := r.executor.Execute(&batch.ID, batch.Metrics)
+		sent += len(result.SuccessIDs)
+		if len(result.FailureIDs) == 0 {
+			if err := r.queue.Remove(batch.Seq); err != nil {
+				r.logger.Errorw("unable to remove sent batch from send queue", "error", err)
+				return
+			}
+			continue
+		}
+
+		failed += len(result.FailureIDs)
+		if len(result.SuccessIDs) > 0 {
+			remaining := make([]*model.Metrics, 0, len(result.FailureIDs))
+			for _, m := range batch.Metrics {
+				if slices.Contains(result.FailureIDs, m.ID) {
+					remaining = append(remaining, m)
+				}
+			}
+			batch.Metrics = remaining
+			if err := r.queue.Replace(batch); err != nil {
+				r.logger.Errorw("unable to update partially sent batch in send queue", "error", err)
+			}
+		}
+		return
+	}
+}
 
+// completeStaged commits staged values of sent metrics and rolls back staged values of failed ones
+func (r *Reporter) completeStaged(result *reporting.Result) {
 	for _, id := range result.SuccessIDs {
 		err := r.stor.Get(id).CommitStaged()
 		if err != nil {
@@ -20,7 +20,7 @@ func NewBatchExecutor(sndr sending.MetricSender, sl *zap.SugaredLogger) *BatchEx
 	}
 }
 
-func (e *BatchExecutor) Execute(ms []*model.Metrics) *Result {
+func (e *BatchExecutor) Execute(batchID *model.BatchID, ms []*model.Metrics) *Result {
 	ids := make([]string, 0, len(ms))
 	for _, m := range ms {
 		ids = append(ids, m.ID)
@@ -29,7 +29,7 @@ func (e *BatchExecutor) Execute(ms []*model.Metrics) *Result {
 	e.logger.Debugw("batch report execute started", "count", len(ms))
 	defer e.logger.Debugw("batch report execute finished", "count", len(ms))
 
-	err := e.mSender.SendMetricArray(ms)
+	err := e.mSender.SendMetricArray(batchID, ms)
 	if err != nil {
 		e.logger.Errorw("unable to send metrics to server",
 			"error", err,
@@ -9,8 +9,9 @@ import (
 // and FailureIDs contains IDs of those not sent due to errors
 type Executor interface {
 
-	// Execute is called in goroutine with prepared metric values
-	Execute(ms []*model.Metrics) *Result
+	// Execute is called in goroutine with prepared metric values, batchID identifies the report
+	// for executors sending all metrics as a single batch
+	Execute(batchID *model.BatchID, ms []*model.Metrics) *Result
 
 	// Shutdown must be called to correctly release all used resources
 	Shutdown()
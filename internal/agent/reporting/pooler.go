@@ -31,7 +31,8 @@ func NewWorkerPoolExecutor(rate int, sndr sending.MetricSender, sl *zap.SugaredL
 	return e
 }
 
-func (e *WorkerPoolExecutor) Execute(ms []*model.Metrics) *Result {
+// Execute sends metrics one by one, batch ID is not used since metrics are not sent as a batch
+func (e *WorkerPoolExecutor) Execute(_ *model.BatchID, ms []*model.Metrics) *Result {
 	e.logger.Debugw("worker pool report execute started", "count", len(ms))
 	go func() {
 		for _, m := range ms {
@@ -2,11 +2,14 @@ package sending
 
 import (
 	"fmt"
+	"net"
 	"regexp"
 	"strings"
 )
 
-func enrichServerAddress(addr string) (string, error) {
+// enrichServerAddress converts server address to a base URL, protocol defaults to https if TLS is used
+// and to http otherwise
+func enrichServerAddress(addr string, useTLS bool) (string, error) {
 	// regexp to accept server address in 3 possible formats:
 	// - protocol://host:port
 	// - host:port
@@ -22,9 +25,30 @@ func enrichServerAddress(addr string) (string, error) {
 
 	if parts[1] == "" {
 		parts[1] = "http://"
+		if useTLS {
+			parts[1] = "https://"
+		}
 	}
 	if parts[2] == "" {
 		parts[2] = "localhost"
 	}
 	return strings.Join(parts[1:], ""), nil
 }
+
+// outboundIP detects local address of network interface used to reach the server,
+// UDP "connection" doesn't send any packets, it only resolves the route
+func outboundIP(hostport string) (string, error) {
+	conn, err := net.Dial("udp", hostport)
+	if err != nil {
+		return "", fmt.Errorf("can't detect outbound address: %w", err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	addr, ok := conn.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		return "", fmt.Errorf("unexpected local address type %T", conn.LocalAddr())
+	}
+	return addr.IP.String(), nil
+}
@@ -0,0 +1,188 @@
+package sending
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/andrewsvn/metrics-overseer/internal/encrypt"
+	"github.com/andrewsvn/metrics-overseer/internal/grpcapi"
+	"github.com/andrewsvn/metrics-overseer/internal/model"
+	"github.com/andrewsvn/metrics-overseer/internal/retrying"
+	pb "github.com/andrewsvn/metrics-overseer/pkg/proto/metrics"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// GRPCSender sends metrics to metrics-overseer gRPC API, requests are signed with primary key of the key ring
+// by a client interceptor if the key is specified, API token is passed in metadata if specified.
+// Connection is secured with TLS if client configuration is specified
+type GRPCSender struct {
+	addr string
+
+	conn    *grpc.ClientConn
+	client  pb.MetricsClient
+	logger  *zap.SugaredLogger
+	retrier *retrying.Executor
+}
+
+func NewGRPCSender(
+	addr string,
+	retryPolicy retrying.Policy,
+	retryObserver retrying.Observer,
+	keys *encrypt.KeyRing,
+	apiToken string,
+	tlsCfg *tls.Config,
+	logger *zap.Logger,
+) (*GRPCSender, error) {
+	grpcLogger := logger.Sugar().With(zap.String("component", "grpc-sender"))
+
+	target, err := grpcTarget(addr)
+	if err != nil {
+		return nil, err
+	}
+	grpcLogger.Infow("sender address for sending reports",
+		"target", target,
+	)
+
+	realIP, err := outboundIP(target)
+	if err != nil {
+		grpcLogger.Warnw("can't detect outbound IP address", "error", err)
+	}
+
+	creds := insecure.NewCredentials()
+	if tlsCfg != nil {
+		creds = credentials.NewTLS(tlsCfg)
+	}
+	conn, err := grpc.NewClient(target,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithChainUnaryInterceptor(
+			grpcapi.RealIPUnaryInterceptor(realIP),
+			grpcapi.TokenUnaryInterceptor(apiToken),
+			grpcapi.SigningUnaryInterceptor(keys),
+		),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("can't create gRPC client: %w", err)
+	}
+
+	retrier := retrying.NewExecutorBuilder(retryPolicy).
+		WithLogger(grpcLogger, "sending metrics").
+		WithObserver(retryObserver).
+		Build()
+
+	return &GRPCSender{
+		addr:    target,
+		conn:    conn,
+		client:  pb.NewMetricsClient(conn),
+		logger:  grpcLogger,
+		retrier: retrier,
+	}, nil
+}
+
+// SendMetricValue parses string value according to metric type and sends it as a single metric update.
+// For histogram and summary the value is a single observation - histogram is sent with default bucket layout
+func (gs *GRPCSender) SendMetricValue(id string, mtype string, value string) error {
+	var metric *model.Metrics
+	switch mtype {
+	case model.Counter:
+		delta, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid counter value %s: %w", value, err)
+		}
+		metric = model.NewCounterMetricsWithDelta(id, delta)
+	case model.Gauge:
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid gauge value %s: %w", value, err)
+		}
+		metric = model.NewGaugeMetricsWithValue(id, v)
+	case model.Histogram:
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid histogram value %s: %w", value, err)
+		}
+		hist := model.NewHistogramValue()
+		hist.Observe(v)
+		metric = model.NewHistogramMetricsWithValue(id, hist)
+	case model.Summary:
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid summary value %s: %w", value, err)
+		}
+		summary := model.NewSummaryValue()
+		summary.Add(v)
+		metric = model.NewSummaryMetricsWithValue(id, summary)
+	default:
+		return fmt.Errorf("unsupported metric type: %s", mtype)
+	}
+	return gs.SendMetric(metric)
+}
+
+func (gs *GRPCSender) SendMetric(metric *model.Metrics) error {
+	req := &pb.UpdateMetricRequest{Metric: grpcapi.MetricToProto(metric)}
+	return gs.retrier.Run(func() error {
+		_, err := gs.client.UpdateMetric(context.Background(), req)
+		return gs.wrapError(err, false)
+	})
+}
+
+func (gs *GRPCSender) SendMetricArray(batchID *model.BatchID, metrics []*model.Metrics) error {
+	req := &pb.UpdateBatchRequest{Metrics: make([]*pb.Metric, 0, len(metrics))}
+	for _, m := range metrics {
+		req.Metrics = append(req.Metrics, grpcapi.MetricToProto(m))
+	}
+	ctx := context.Background()
+	if batchID != nil {
+		ctx = metadata.AppendToOutgoingContext(ctx, grpcapi.BatchIDMetadataKey, batchID.String())
+	}
+	return gs.retrier.Run(func() error {
+		_, err := gs.client.UpdateBatch(ctx, req)
+		return gs.wrapError(err, batchID != nil)
+	})
+}
+
+func (gs *GRPCSender) Close() error {
+	return gs.conn.Close()
+}
+
+// wrapError marks errors caused by temporary server or network unavailability as retryable.
+// Such a call may have been applied by server before the failure, so it's retried only if it's idempotent
+// (batch with ID), otherwise only calls rejected by server before processing are retried
+func (gs *GRPCSender) wrapError(err error, idempotent bool) error {
+	if err == nil {
+		return nil
+	}
+
+	wrapped := fmt.Errorf("error sending request to server %s: %w", gs.addr, err)
+	switch status.Code(err) {
+	case codes.ResourceExhausted:
+		return retrying.NewRetryableError(wrapped)
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Aborted:
+		if idempotent {
+			return retrying.NewRetryableError(wrapped)
+		}
+	}
+	return wrapped
+}
+
+// grpcTarget accepts server address in the same formats as REST sender (with optional scheme which is dropped),
+// gRPC target is always in host:port form
+func grpcTarget(addr string) (string, error) {
+	re := regexp.MustCompile(`^(?:(?:http|https|grpc)://)?([^:/]*)(:\d+)$`)
+	parts := re.FindStringSubmatch(addr)
+	if parts == nil {
+		return "", fmt.Errorf("incorrect network address: %s", addr)
+	}
+	if parts[1] == "" {
+		parts[1] = "localhost"
+	}
+	return parts[1] + parts[2], nil
+}
@@ -0,0 +1,141 @@
+package sending
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/andrewsvn/metrics-overseer/internal/apitoken"
+	"github.com/andrewsvn/metrics-overseer/internal/config/servercfg"
+	"github.com/andrewsvn/metrics-overseer/internal/grpcapi"
+	"github.com/andrewsvn/metrics-overseer/internal/logging"
+	"github.com/andrewsvn/metrics-overseer/internal/model"
+	"github.com/andrewsvn/metrics-overseer/internal/repository"
+	"github.com/andrewsvn/metrics-overseer/internal/retrying"
+	"github.com/andrewsvn/metrics-overseer/internal/service"
+	"github.com/andrewsvn/metrics-overseer/internal/tlsconfig"
+	"github.com/andrewsvn/metrics-overseer/internal/tlsconfig/tlstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGRPCTarget(t *testing.T) {
+	target, err := grpcTarget(":3200")
+	require.NoError(t, err)
+	assert.Equal(t, "localhost:3200", target)
+
+	target, err = grpcTarget("http://metrics:3200")
+	require.NoError(t, err)
+	assert.Equal(t, "metrics:3200", target)
+
+	_, err = grpcTarget("metrics")
+	assert.Error(t, err)
+}
+
+func TestGRPCSender(t *testing.T) {
+	logger, _ := logging.NewZapLogger("info")
+	stor := repository.NewMemStorage()
+	msrv := service.NewMetricsService(stor, logger)
+	gs, err := grpcapi.NewGRPCServer(msrv, &servercfg.SecurityConfig{
+		SecretKey:      "secret",
+		TrustedSubnets: []string{"127.0.0.0/8"},
+	}, apitoken.NewRegistry(nil, logger), nil, nil, logger)
+	require.NoError(t, err)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		_ = gs.Serve(lis)
+	}()
+	defer gs.Stop()
+
+	sndr, err := NewGRPCSender(lis.Addr().String(), &retrying.NoRetryPolicy{}, nil, newTestKeyRing(t, "secret"), "", nil, logger)
+	require.NoError(t, err)
+	defer func() {
+		_ = sndr.Close()
+	}()
+
+	require.NoError(t, sndr.SendMetricValue("cnt1", model.Counter, "5"))
+	require.NoError(t, sndr.SendMetric(model.NewCounterMetricsWithDelta("cnt1", 2)))
+	batch := []*model.Metrics{
+		model.NewGaugeMetricsWithValue("gauge1", 1.5),
+		model.NewCounterMetricsWithDelta("cnt1", 3),
+	}
+	batchID := &model.BatchID{AgentID: "agent", Seq: 1}
+	require.NoError(t, sndr.SendMetricArray(batchID, batch))
+	// resent batch is acknowledged without applying it again
+	require.NoError(t, sndr.SendMetricArray(batchID, batch))
+	require.NoError(t, sndr.SendMetricValue("sum1", model.Summary, "2"))
+	assert.Error(t, sndr.SendMetricValue("cnt1", model.Counter, "x"))
+	assert.Error(t, sndr.SendMetric(model.NewGaugeMetricsWithValue("cnt1", 1)))
+
+	ctx := context.Background()
+	cnt, err := stor.GetByID(ctx, "cnt1", nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), *cnt.Delta)
+	gauge, err := stor.GetByID(ctx, "gauge1", nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1.5, *gauge.Value)
+	sum, err := stor.GetByID(ctx, "sum1", nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), sum.Summary.Count)
+
+	// signature mismatch is not retried and reported as error
+	wrongKey, err := NewGRPCSender(lis.Addr().String(), &retrying.NoRetryPolicy{}, nil, newTestKeyRing(t, "other"), "", nil, logger)
+	require.NoError(t, err)
+	defer func() {
+		_ = wrongKey.Close()
+	}()
+	assert.Error(t, wrongKey.SendMetric(model.NewCounterMetricsWithDelta("cnt1", 1)))
+}
+
+func TestGRPCSenderMutualTLS(t *testing.T) {
+	files := tlstest.GenerateFiles(t)
+	serverCfg, err := tlsconfig.NewServerConfig(files.ServerCert, files.ServerKey, files.CACert)
+	require.NoError(t, err)
+
+	logger, _ := logging.NewZapLogger("info")
+	stor := repository.NewMemStorage()
+	msrv := service.NewMetricsService(stor, logger)
+	gs, err := grpcapi.NewGRPCServer(msrv, &servercfg.SecurityConfig{}, apitoken.NewRegistry(nil, logger),
+		serverCfg, nil, logger)
+	require.NoError(t, err)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		_ = gs.Serve(lis)
+	}()
+	defer gs.Stop()
+
+	clientCfg, err := tlsconfig.NewClientConfig(files.CACert, files.ClientCert, files.ClientKey)
+	require.NoError(t, err)
+	sndr, err := NewGRPCSender(lis.Addr().String(), &retrying.NoRetryPolicy{}, nil, nil, "", clientCfg, logger)
+	require.NoError(t, err)
+	defer func() {
+		_ = sndr.Close()
+	}()
+	require.NoError(t, sndr.SendMetric(model.NewCounterMetricsWithDelta("cnt1", 1)))
+
+	// server requires client certificate
+	clientCfg, err = tlsconfig.NewClientConfig(files.CACert, "", "")
+	require.NoError(t, err)
+	noCert, err := NewGRPCSender(lis.Addr().String(), &retrying.NoRetryPolicy{}, nil, nil, "", clientCfg, logger)
+	require.NoError(t, err)
+	defer func() {
+		_ = noCert.Close()
+	}()
+	assert.Error(t, noCert.SendMetric(model.NewCounterMetricsWithDelta("cnt1", 1)))
+
+	// plaintext connection is refused
+	plain, err := NewGRPCSender(lis.Addr().String(), &retrying.NoRetryPolicy{}, nil, nil, "", nil, logger)
+	require.NoError(t, err)
+	defer func() {
+		_ = plain.Close()
+	}()
+	assert.Error(t, plain.SendMetric(model.NewCounterMetricsWithDelta("cnt1", 1)))
+
+	cnt, err := stor.GetByID(context.Background(), "cnt1", nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), *cnt.Delta)
+}
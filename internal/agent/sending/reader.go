@@ -0,0 +1,190 @@
+package sending
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/andrewsvn/metrics-overseer/internal/apitoken"
+	"github.com/andrewsvn/metrics-overseer/internal/encrypt"
+	"github.com/andrewsvn/metrics-overseer/internal/model"
+	"github.com/andrewsvn/metrics-overseer/internal/retrying"
+	"go.uber.org/zap"
+)
+
+type MetricReader interface {
+	GetMetric(id string, mtype string) (*model.Metrics, error)
+	GetMetricValue(id string, mtype string) (string, error)
+}
+
+// RestReader reads metric values from server. If response key is specified, server is asked to encrypt
+// responses with its public key. Compressed responses are decompressed by HTTP client transparently,
+// then encrypted ones are decrypted and their signatures are verified if secret key is available
+type RestReader struct {
+	addr string
+	// apiToken is sent in Authorization header if not empty
+	apiToken string
+
+	keys *encrypt.KeyRing
+	// decrypter is used for decrypting responses, responseKey is its public key passed to server
+	decrypter   encrypt.Decrypter
+	responseKey string
+
+	cl      *http.Client
+	logger  *zap.SugaredLogger
+	retrier *retrying.Executor
+}
+
+func NewRestReader(
+	addr string,
+	retryPolicy retrying.Policy,
+	keys *encrypt.KeyRing,
+	apiToken string,
+	responseKeyPath string,
+	tlsCfg *tls.Config,
+	logger *zap.Logger,
+) (*RestReader, error) {
+	readerLogger := logger.Sugar().With(zap.String("component", "rest-reader"))
+
+	enrichedAddr, err := enrichServerAddress(addr, tlsCfg != nil)
+	if err != nil {
+		return nil, fmt.Errorf("can't enrich address for reader to a proper format: %w", err)
+	}
+
+	retrier := retrying.NewExecutorBuilder(retryPolicy).
+		WithLogger(readerLogger, "reading metrics").
+		Build()
+
+	rr := &RestReader{
+		addr:     enrichedAddr,
+		apiToken: apiToken,
+		keys:     keys,
+		cl:       newHTTPClient(tlsCfg),
+		logger:   readerLogger,
+		retrier:  retrier,
+	}
+
+	// responses are not encrypted if private key is not specified
+	if responseKeyPath != "" {
+		rr.decrypter, rr.responseKey, err = encrypt.ReadResponseKeyFromFile(responseKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("can't read private key for response decryption: %w", err)
+		}
+		readerLogger.Infow("using private key for response decryption", "scheme", rr.decrypter.Scheme())
+	}
+	return rr, nil
+}
+
+func (rr *RestReader) GetMetric(id string, mtype string) (*model.Metrics, error) {
+	body, err := json.Marshal(&model.Metrics{ID: id, MType: mtype})
+	if err != nil {
+		return nil, fmt.Errorf("can't construct metric read request: %w", err)
+	}
+
+	var metric model.Metrics
+	err = rr.retrier.Run(func() error {
+		req, err := http.NewRequest(http.MethodPost, rr.addr+"/value", bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("can't construct metric read request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		respBody, err := rr.readResponse(req)
+		if err != nil {
+			return err
+		}
+		if err = json.Unmarshal(respBody, &metric); err != nil {
+			return fmt.Errorf("can't parse metric from server response: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &metric, nil
+}
+
+func (rr *RestReader) GetMetricValue(id string, mtype string) (string, error) {
+	var value string
+	err := rr.retrier.Run(func() error {
+		req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/value/%s/%s", rr.addr, mtype, id), nil)
+		if err != nil {
+			return fmt.Errorf("can't construct metric value read request: %w", err)
+		}
+
+		respBody, err := rr.readResponse(req)
+		if err != nil {
+			return err
+		}
+		value = string(respBody)
+		return nil
+	})
+	return value, err
+}
+
+// readResponse executes request and returns decrypted and verified response body. Request is signed
+// (with empty payload) only to pass a nonce, so response signature can't be taken from another response
+func (rr *RestReader) readResponse(req *http.Request) ([]byte, error) {
+	if err := encrypt.SignRequest(rr.keys, req, nil); err != nil {
+		return nil, fmt.Errorf("can't sign request: %w", err)
+	}
+	if rr.apiToken != "" {
+		req.Header.Set(apitoken.AuthorizationHeader, apitoken.BearerValue(rr.apiToken))
+	}
+	if rr.responseKey != "" {
+		req.Header.Set(encrypt.ResponseKeyHeader, rr.responseKey)
+	}
+
+	resp, err := rr.cl.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error sending request to server %s: %w", rr.addr, err)
+	}
+	defer func() {
+		err := resp.Body.Close()
+		if err != nil {
+			rr.logger.Error("error closing response body", zap.Error(err))
+		}
+	}()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, retrying.NewRetryableError(
+			fmt.Errorf("can't read response body from metrics read operation: %w", err))
+	}
+	if err = responseStatusError(resp.StatusCode); err != nil {
+		return nil, err
+	}
+
+	respBody, err = rr.decryptResponse(resp.Header, respBody)
+	if err != nil {
+		return nil, err
+	}
+	if err = verifyResponse(rr.keys, req, resp.Header, respBody); err != nil {
+		return nil, err
+	}
+	return respBody, nil
+}
+
+// decryptResponse decrypts response body according to encryption scheme header. Unencrypted response
+// to a request asking for encryption is rejected, so a response can't be silently downgraded to cleartext
+func (rr *RestReader) decryptResponse(header http.Header, body []byte) ([]byte, error) {
+	scheme := header.Get(encrypt.EncryptionHeader)
+	if rr.decrypter == nil {
+		if scheme != "" {
+			return nil, fmt.Errorf("unexpected encrypted response with scheme %s", scheme)
+		}
+		return body, nil
+	}
+
+	if scheme != rr.decrypter.Scheme() {
+		return nil, fmt.Errorf("unexpected response encryption scheme %q, expected %s", scheme, rr.decrypter.Scheme())
+	}
+	decrypted, err := rr.decrypter.Decrypt(body)
+	if err != nil {
+		return nil, fmt.Errorf("can't decrypt server response: %w", err)
+	}
+	return decrypted, nil
+}
@@ -0,0 +1,77 @@
+package sending
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/andrewsvn/metrics-overseer/internal/encrypt"
+	"github.com/andrewsvn/metrics-overseer/internal/logging"
+	"github.com/andrewsvn/metrics-overseer/internal/model"
+	"github.com/andrewsvn/metrics-overseer/internal/retrying"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRestReader(t *testing.T) {
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	require.NoError(t, err)
+	keyPath := filepath.Join(t.TempDir(), "x25519.pem")
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600))
+
+	keys := newTestKeyRing(t, "secret")
+	metric := []byte(`{"id":"g1","type":"gauge","value":1.5}`)
+	var respond func(rw http.ResponseWriter, r *http.Request)
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		respond(rw, r)
+	}))
+	defer srv.Close()
+
+	logger, _ := logging.NewZapLogger("info")
+	rr, err := NewRestReader(srv.URL, &retrying.NoRetryPolicy{}, keys, "", keyPath, nil, logger)
+	require.NoError(t, err)
+
+	// response is encrypted with the public key passed in request and signed
+	respond = func(rw http.ResponseWriter, r *http.Request) {
+		encrypter, err := encrypt.ResponseEncrypter(r.Header, nil)
+		require.NoError(t, err)
+		encrypted, err := encrypter.Encrypt(metric)
+		require.NoError(t, err)
+		encrypt.SignResponse(keys, r.Header, metric, rw.Header())
+		rw.Header().Set(encrypt.EncryptionHeader, encrypter.Scheme())
+		_, _ = rw.Write(encrypted)
+	}
+	m, err := rr.GetMetric("g1", model.Gauge)
+	require.NoError(t, err)
+	require.NotNil(t, m.Value)
+	assert.Equal(t, 1.5, *m.Value)
+
+	// cleartext response is rejected if encryption was requested
+	respond = func(rw http.ResponseWriter, r *http.Request) {
+		encrypt.SignResponse(keys, r.Header, metric, rw.Header())
+		_, _ = rw.Write(metric)
+	}
+	_, err = rr.GetMetric("g1", model.Gauge)
+	assert.Error(t, err)
+
+	// cleartext responses are accepted if encryption was not requested, signature is still verified
+	rr, err = NewRestReader(srv.URL, &retrying.NoRetryPolicy{}, keys, "", "", nil, logger)
+	require.NoError(t, err)
+	_, err = rr.GetMetric("g1", model.Gauge)
+	require.NoError(t, err)
+
+	respond = func(rw http.ResponseWriter, r *http.Request) {
+		encrypt.SignResponse(keys, r.Header, metric, rw.Header())
+		_, _ = rw.Write([]byte(`{"id":"g1","type":"gauge","value":2}`))
+	}
+	_, err = rr.GetMetric("g1", model.Gauge)
+	assert.ErrorIs(t, err, encrypt.ErrSignatureInvalid)
+}
@@ -2,28 +2,36 @@ package sending
 
 import (
 	"bytes"
-	"crypto/rsa"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 
+	"github.com/andrewsvn/metrics-overseer/internal/apitoken"
 	"github.com/andrewsvn/metrics-overseer/internal/compress"
 	"github.com/andrewsvn/metrics-overseer/internal/encrypt"
 	"github.com/andrewsvn/metrics-overseer/internal/model"
 	"github.com/andrewsvn/metrics-overseer/internal/retrying"
+	"github.com/andrewsvn/metrics-overseer/internal/subnet"
 	"go.uber.org/zap"
 )
 
 type RestSender struct {
 	addr string
+	// realIP is an address of agent's outbound interface sent to server for trusted subnet checks
+	realIP string
+	// apiToken is sent in Authorization header if not empty
+	apiToken string
 
-	secretKey []byte
+	keys      *encrypt.KeyRing
 	encrypter encrypt.Encrypter
 
 	// we use a custom http client here for further customization
 	// and to enable connection reuse for sequential server calls
-	cl      *http.Client
+	cl *http.Client
+	// cwe compresses request bodies, they are sent uncompressed if it's nil
 	cwe     compress.WriteEngine
 	logger  *zap.SugaredLogger
 	retrier *retrying.Executor
@@ -32,13 +40,17 @@ type RestSender struct {
 func NewRestSender(
 	addr string,
 	retryPolicy retrying.Policy,
-	secretKey string,
-	rsaKeyPath string,
+	retryObserver retrying.Observer,
+	keys *encrypt.KeyRing,
+	apiToken string,
+	publicKeyPath string,
+	cwe compress.WriteEngine,
+	tlsCfg *tls.Config,
 	logger *zap.Logger,
 ) (*RestSender, error) {
 	restLogger := logger.Sugar().With(zap.String("component", "rest-sender"))
 
-	enrichedAddr, err := enrichServerAddress(addr)
+	enrichedAddr, err := enrichServerAddress(addr, tlsCfg != nil)
 	if err != nil {
 		return nil, fmt.Errorf("can't enrich address for sender to a proper format: %w", err)
 	}
@@ -48,30 +60,53 @@ func NewRestSender(
 
 	retrier := retrying.NewExecutorBuilder(retryPolicy).
 		WithLogger(restLogger, "sending metrics").
+		WithObserver(retryObserver).
 		Build()
 
-	var publicKey *rsa.PublicKey
-	if rsaKeyPath != "" {
-		var err error
-		publicKey, err = encrypt.ReadRSAPublicKeyFromFile(rsaKeyPath)
+	// encryption is disabled if public key is not specified
+	var encrypter encrypt.Encrypter = encrypt.NewRSAEngineBuilder().Build()
+	if publicKeyPath != "" {
+		encrypter, err = encrypt.ReadEncrypterFromFile(publicKeyPath)
 		if err != nil {
-			return nil, fmt.Errorf("can't read RSA public key for encryption: %w", err)
+			return nil, fmt.Errorf("can't read public key for encryption: %w", err)
+		}
+		restLogger.Infow("using public key for encryption", "scheme", encrypter.Scheme())
+	}
+
+	// server may be unreachable at startup, in this case requests are sent without X-Real-IP header
+	// and the server falls back to connection remote address
+	var realIP string
+	if u, err := url.Parse(enrichedAddr); err == nil {
+		realIP, err = outboundIP(u.Host)
+		if err != nil {
+			restLogger.Warnw("can't detect outbound IP address", "error", err)
 		}
-		restLogger.Infow("using RSA public key for encryption")
 	}
 
 	rs := &RestSender{
 		addr:      enrichedAddr,
-		cl:        &http.Client{},
-		cwe:       compress.NewGzipWriteEngine(),
+		realIP:    realIP,
+		apiToken:  apiToken,
+		cl:        newHTTPClient(tlsCfg),
+		cwe:       cwe,
 		logger:    restLogger,
 		retrier:   retrier,
-		secretKey: []byte(secretKey),
-		encrypter: encrypt.NewRSAEngineBuilder().PublicKey(publicKey).Build(),
+		keys:      keys,
+		encrypter: encrypter,
 	}
 	return rs, nil
 }
 
+// newHTTPClient creates a client using given TLS configuration for HTTPS connections (if specified)
+func newHTTPClient(tlsCfg *tls.Config) *http.Client {
+	if tlsCfg == nil {
+		return &http.Client{}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsCfg
+	return &http.Client{Transport: transport}
+}
+
 func (rs *RestSender) SendMetricValue(id string, mtype string, value string) error {
 	return rs.retrier.Run(func() error {
 		req, err := http.NewRequest(http.MethodPost, rs.composePostMetricByPathURL(id, mtype, value), nil)
@@ -79,8 +114,7 @@ func (rs *RestSender) SendMetricValue(id string, mtype string, value string) err
 			return fmt.Errorf("can't construct metric send request: %w", err)
 		}
 		req.Header.Add("Content-Type", "text/plain")
-		encrypt.AddSignature(rs.secretKey, nil, req.Header)
-		return rs.sendRequest(req)
+		return rs.sendRequest(req, nil)
 	})
 }
 
@@ -111,15 +145,17 @@ func (rs *RestSender) SendMetric(metric *model.Metrics) error {
 			return fmt.Errorf("can't send metric update request: %w", err)
 		}
 		req.Header.Add("Content-Type", "application/json")
+		if rs.encrypter.EncryptingEnabled() {
+			req.Header.Set(encrypt.EncryptionHeader, rs.encrypter.Scheme())
+		}
 		if rs.cwe != nil {
 			rs.cwe.SetContentEncoding(req.Header)
 		}
-		encrypt.AddSignature(rs.secretKey, body, req.Header)
-		return rs.sendRequest(req)
+		return rs.sendRequest(req, body)
 	})
 }
 
-func (rs *RestSender) SendMetricArray(metrics []*model.Metrics) error {
+func (rs *RestSender) SendMetricArray(batchID *model.BatchID, metrics []*model.Metrics) error {
 	body, err := json.Marshal(metrics)
 	if err != nil {
 		return fmt.Errorf("can't construct metrics array update request: %w", err)
@@ -146,18 +182,37 @@ func (rs *RestSender) SendMetricArray(metrics []*model.Metrics) error {
 			return fmt.Errorf("can't send metrics array update request: %w", err)
 		}
 		req.Header.Add("Content-Type", "application/json")
+		// the same batch ID is sent with each attempt, so the batch is not applied twice
+		// if server has applied it, but the response was lost
+		if batchID != nil {
+			req.Header.Set(model.BatchIDHeader, batchID.String())
+		}
+		if rs.encrypter.EncryptingEnabled() {
+			req.Header.Set(encrypt.EncryptionHeader, rs.encrypter.Scheme())
+		}
 		if rs.cwe != nil {
 			rs.cwe.SetContentEncoding(req.Header)
 		}
-
-		return rs.sendRequest(req)
+		return rs.sendRequest(req, body)
 	})
 }
 
-func (rs *RestSender) sendRequest(req *http.Request) error {
+// sendRequest signs request with the body as it is sent and executes it,
+// a new signature with its own timestamp and nonce is made for each attempt
+func (rs *RestSender) sendRequest(req *http.Request, body []byte) error {
+	if err := encrypt.SignRequest(rs.keys, req, body); err != nil {
+		return fmt.Errorf("can't sign request: %w", err)
+	}
+	if rs.realIP != "" {
+		req.Header.Set(subnet.RealIPHeader, rs.realIP)
+	}
+	if rs.apiToken != "" {
+		req.Header.Set(apitoken.AuthorizationHeader, apitoken.BearerValue(rs.apiToken))
+	}
+
 	resp, err := rs.cl.Do(req)
 	if err != nil {
-		return fmt.Errorf("error sending request to server %s: %w", rs.addr, err)
+		return deliveryError(req, fmt.Errorf("error sending request to server %s: %w", rs.addr, err))
 	}
 	defer func() {
 		err := resp.Body.Close()
@@ -166,27 +221,62 @@ func (rs *RestSender) sendRequest(req *http.Request) error {
 		}
 	}()
 
-	_, err = io.ReadAll(resp.Body)
+	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return retrying.NewRetryableError(
-			fmt.Errorf("can't read response body from metrics send operation: %w", err))
-	}
-	if resp.StatusCode != http.StatusOK {
-		err = fmt.Errorf("error response status %d from server ", resp.StatusCode)
-		switch resp.StatusCode {
-		case http.StatusRequestTimeout:
-		case http.StatusTooManyRequests:
-		case http.StatusInternalServerError:
-		case http.StatusBadGateway:
-		case http.StatusServiceUnavailable:
-		case http.StatusGatewayTimeout:
-			return retrying.NewRetryableError(err)
-		}
+		return deliveryError(req, fmt.Errorf("can't read response body from metrics send operation: %w", err))
+	}
+	if err = responseStatusError(resp.StatusCode); err != nil {
 		return err
 	}
+	return verifyResponse(rs.keys, req, resp.Header, respBody)
+}
+
+// deliveryError marks network failure as retryable only if the request carries batch ID. Request may have
+// reached the server and been applied before the failure, batches are resent with the same ID, so server
+// doesn't apply them twice, but other requests would be applied again
+func deliveryError(req *http.Request, err error) error {
+	if req.Header.Get(model.BatchIDHeader) == "" {
+		return err
+	}
+	return retrying.NewRetryableError(err)
+}
+
+// verifyResponse checks signature of a successful response if agent has a secret key. Missing or invalid
+// signature means that response is forged or corrupted, so the error is not retryable. Server sharing the key
+// with agent always signs its responses, so an unsigned one can't be trusted
+func verifyResponse(keys *encrypt.KeyRing, req *http.Request, header http.Header, body []byte) error {
+	if !keys.CanSign() {
+		return nil
+	}
+	respSign, err := encrypt.GetRequestSignature(header)
+	if err != nil {
+		return fmt.Errorf("%w: %w", encrypt.ErrSignatureInvalid, err)
+	}
+	if respSign == nil {
+		return fmt.Errorf("%w: response is not signed", encrypt.ErrSignatureInvalid)
+	}
+
+	err = encrypt.CheckResponseSignature(keys, req.Header.Get(encrypt.SignNonceHeader), body, respSign)
+	if err != nil {
+		return fmt.Errorf("server response verification failed: %w", err)
+	}
 	return nil
 }
 
+// responseStatusError converts unsuccessful response status to an error, retryable for temporary server failures
+func responseStatusError(status int) error {
+	if status == http.StatusOK {
+		return nil
+	}
+	err := fmt.Errorf("error response status %d from server ", status)
+	switch status {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusInternalServerError,
+		http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return retrying.NewRetryableError(err)
+	}
+	return err
+}
+
 func (rs *RestSender) composePostMetricByPathURL(id string, mtype string, value string) string {
 	return fmt.Sprintf("%s/update/%s/%s/%s", rs.addr, mtype, id, value)
 }
@@ -1,10 +1,20 @@
 package sending
 
 import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
 
+	"github.com/andrewsvn/metrics-overseer/internal/compress"
+	"github.com/andrewsvn/metrics-overseer/internal/encrypt"
 	"github.com/andrewsvn/metrics-overseer/internal/logging"
 	"github.com/andrewsvn/metrics-overseer/internal/retrying"
+	"github.com/andrewsvn/metrics-overseer/internal/tlsconfig"
+	"github.com/andrewsvn/metrics-overseer/internal/tlsconfig/tlstest"
 
 	"github.com/andrewsvn/metrics-overseer/internal/model"
 	"github.com/stretchr/testify/assert"
@@ -15,15 +25,252 @@ func TestRestSender(t *testing.T) {
 	logger, _ := logging.NewZapLogger("info")
 	retryPolicy := retrying.NewLinearPolicy(3, 1, 2)
 
-	_, err := NewRestSender("http:localhost:8080", retryPolicy, "", "", logger)
+	_, err := NewRestSender("http:localhost:8080", retryPolicy, nil, nil, "", "", gzipEngine, nil, logger)
 	require.Error(t, err)
 
-	_, err = NewRestSender("http://localhost:8o8o", retryPolicy, "", "", logger)
+	_, err = NewRestSender("http://localhost:8o8o", retryPolicy, nil, nil, "", "", gzipEngine, nil, logger)
 	require.Error(t, err)
 
-	rs, err := NewRestSender("localhost:8080", retryPolicy, "", "", logger)
+	rs, err := NewRestSender("localhost:8080", retryPolicy, nil, nil, "", "", gzipEngine, nil, logger)
 	require.NoError(t, err)
 
 	url := rs.composePostMetricByPathURL("cnt1", model.Counter, "10")
 	assert.Equal(t, "http://localhost:8080/update/counter/cnt1/10", url)
 }
+
+func TestRestSenderRealIP(t *testing.T) {
+	var realIP string
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		realIP = r.Header.Get("X-Real-IP")
+		rw.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	logger, _ := logging.NewZapLogger("info")
+	rs, err := NewRestSender(srv.URL, &retrying.NoRetryPolicy{}, nil, nil, "", "", gzipEngine, nil, logger)
+	require.NoError(t, err)
+
+	require.NoError(t, rs.SendMetric(model.NewCounterMetricsWithDelta("cnt1", 1)))
+	assert.Equal(t, "127.0.0.1", realIP)
+}
+
+func TestRestSenderAPIToken(t *testing.T) {
+	var authHeader string
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		authHeader = r.Header.Get("Authorization")
+		rw.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	logger, _ := logging.NewZapLogger("info")
+	rs, err := NewRestSender(srv.URL, &retrying.NoRetryPolicy{}, nil, nil, "agent-token", "", gzipEngine, nil, logger)
+	require.NoError(t, err)
+
+	require.NoError(t, rs.SendMetricArray(nil, []*model.Metrics{model.NewCounterMetricsWithDelta("cnt1", 1)}))
+	assert.Equal(t, "Bearer agent-token", authHeader)
+}
+
+func TestRestSenderSignsRequests(t *testing.T) {
+	keys := newTestKeyRing(t, "secret")
+	var verified int
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		rs, err := encrypt.GetRequestSignature(r.Header)
+		require.NoError(t, err)
+		require.NotNil(t, rs)
+		assert.False(t, rs.IsPayloadOnly())
+		assert.NoError(t, encrypt.CheckRequestSignature(keys, encrypt.RequestTarget(r), body, rs))
+		verified++
+		encrypt.SignResponse(keys, r.Header, nil, rw.Header())
+		rw.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	logger, _ := logging.NewZapLogger("info")
+	rs, err := NewRestSender(srv.URL, &retrying.NoRetryPolicy{}, nil, keys, "", "", gzipEngine, nil, logger)
+	require.NoError(t, err)
+
+	require.NoError(t, rs.SendMetricValue("cnt1", model.Counter, "1"))
+	require.NoError(t, rs.SendMetric(model.NewCounterMetricsWithDelta("cnt1", 1)))
+	require.NoError(t, rs.SendMetricArray(nil, []*model.Metrics{model.NewCounterMetricsWithDelta("cnt1", 1)}))
+	assert.Equal(t, 3, verified)
+}
+
+func TestRestSenderResendsLostBatch(t *testing.T) {
+	// batch IDs are appended by server goroutines and read by the test, so access is guarded
+	var mu sync.Mutex
+	var batchIDs []string
+	received := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		ids := batchIDs
+		batchIDs = nil
+		return ids
+	}
+	first := true
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		batchIDs = append(batchIDs, r.Header.Get(model.BatchIDHeader))
+		drop := first
+		first = false
+		mu.Unlock()
+
+		if drop {
+			// request is processed, but connection is dropped before response is written
+			conn, _, err := rw.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Errorf("failed to hijack connection: %v", err)
+				return
+			}
+			_ = conn.Close()
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	logger, _ := logging.NewZapLogger("info")
+	rs, err := NewRestSender(srv.URL, retrying.NewLinearPolicy(3, 0, 0), nil, nil, "", "", gzipEngine, nil, logger)
+	require.NoError(t, err)
+
+	batchID := &model.BatchID{AgentID: "agent", Seq: 7}
+	require.NoError(t, rs.SendMetricArray(batchID, []*model.Metrics{model.NewCounterMetricsWithDelta("cnt1", 1)}))
+	assert.Equal(t, []string{"agent:7", "agent:7"}, received())
+
+	// requests without batch ID may be applied twice if resent, so network failure is not retried
+	mu.Lock()
+	first = true
+	mu.Unlock()
+	assert.Error(t, rs.SendMetric(model.NewCounterMetricsWithDelta("cnt1", 1)))
+	assert.Equal(t, []string{""}, received())
+	mu.Lock()
+	first = true
+	mu.Unlock()
+	assert.Error(t, rs.SendMetricValue("cnt1", model.Counter, "1"))
+	assert.Equal(t, []string{""}, received())
+}
+
+func TestResponseStatusError(t *testing.T) {
+	tests := []struct {
+		status    int
+		wantErr   bool
+		retryable bool
+	}{
+		{status: http.StatusOK},
+		{status: http.StatusBadRequest, wantErr: true},
+		{status: http.StatusUnauthorized, wantErr: true},
+		{status: http.StatusNotFound, wantErr: true},
+		{status: http.StatusRequestTimeout, wantErr: true, retryable: true},
+		{status: http.StatusTooManyRequests, wantErr: true, retryable: true},
+		{status: http.StatusInternalServerError, wantErr: true, retryable: true},
+		{status: http.StatusNotImplemented, wantErr: true},
+		{status: http.StatusBadGateway, wantErr: true, retryable: true},
+		{status: http.StatusServiceUnavailable, wantErr: true, retryable: true},
+		{status: http.StatusGatewayTimeout, wantErr: true, retryable: true},
+	}
+	for _, test := range tests {
+		t.Run(http.StatusText(test.status), func(t *testing.T) {
+			err := responseStatusError(test.status)
+			if !test.wantErr {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			var rerr retrying.RetryableError
+			assert.Equal(t, test.retryable, errors.As(err, &rerr))
+		})
+	}
+}
+
+var gzipEngine = compress.NewGzipWriteEngine()
+
+func newTestKeyRing(t *testing.T, secret string) *encrypt.KeyRing {
+	keys, err := encrypt.NewKeyRingBuilder().PrimaryKey("", secret).Build()
+	require.NoError(t, err)
+	return keys
+}
+
+func TestRestSenderVerifiesResponses(t *testing.T) {
+	keys := newTestKeyRing(t, "secret")
+	ack := []byte(`{"updated":1}`)
+	var calls int
+	var respond func(rw http.ResponseWriter, r *http.Request)
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		calls++
+		respond(rw, r)
+	}))
+	defer srv.Close()
+
+	logger, _ := logging.NewZapLogger("info")
+	rs, err := NewRestSender(srv.URL, retrying.NewLinearPolicy(3, 0, 0), nil, keys, "", "", gzipEngine, nil, logger)
+	require.NoError(t, err)
+	send := func() error {
+		calls = 0
+		return rs.SendMetricArray(nil, []*model.Metrics{model.NewCounterMetricsWithDelta("cnt1", 1)})
+	}
+
+	// properly signed response
+	respond = func(rw http.ResponseWriter, r *http.Request) {
+		encrypt.SignResponse(keys, r.Header, ack, rw.Header())
+		_, _ = rw.Write(ack)
+	}
+	require.NoError(t, send())
+
+	// unsigned response is rejected without retries, as it may be forged by a server not knowing the key
+	respond = func(rw http.ResponseWriter, r *http.Request) {
+		_, _ = rw.Write(ack)
+	}
+	err = send()
+	assert.ErrorIs(t, err, encrypt.ErrSignatureInvalid)
+	assert.Equal(t, 1, calls)
+
+	// forged response is rejected without retries
+	respond = func(rw http.ResponseWriter, r *http.Request) {
+		encrypt.SignResponse(keys, r.Header, ack, rw.Header())
+		_, _ = rw.Write([]byte(`{"updated":2}`))
+	}
+	err = send()
+	assert.ErrorIs(t, err, encrypt.ErrSignatureInvalid)
+	assert.Equal(t, 1, calls)
+
+	// response signed for another request is rejected as well
+	other := http.Header{}
+	other.Set(encrypt.SignNonceHeader, "other")
+	respond = func(rw http.ResponseWriter, r *http.Request) {
+		encrypt.SignResponse(keys, other, ack, rw.Header())
+		_, _ = rw.Write(ack)
+	}
+	assert.ErrorIs(t, send(), encrypt.ErrSignatureInvalid)
+}
+
+func TestRestSenderMutualTLS(t *testing.T) {
+	files := tlstest.GenerateFiles(t)
+	serverCfg, err := tlsconfig.NewServerConfig(files.ServerCert, files.ServerKey, files.CACert)
+	require.NoError(t, err)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	}))
+	srv.TLS = serverCfg
+	srv.StartTLS()
+	defer srv.Close()
+
+	logger, _ := logging.NewZapLogger("info")
+	clientCfg, err := tlsconfig.NewClientConfig(files.CACert, files.ClientCert, files.ClientKey)
+	require.NoError(t, err)
+
+	// https is used by default if TLS is configured
+	addr := strings.TrimPrefix(srv.URL, "https://")
+	rs, err := NewRestSender(addr, &retrying.NoRetryPolicy{}, nil, nil, "", "", gzipEngine, clientCfg, logger)
+	require.NoError(t, err)
+	assert.Equal(t, srv.URL, rs.addr)
+	require.NoError(t, rs.SendMetric(model.NewCounterMetricsWithDelta("cnt1", 1)))
+
+	// server requires client certificate
+	clientCfg, err = tlsconfig.NewClientConfig(files.CACert, "", "")
+	require.NoError(t, err)
+	rs, err = NewRestSender(addr, &retrying.NoRetryPolicy{}, nil, nil, "", "", gzipEngine, clientCfg, logger)
+	require.NoError(t, err)
+	assert.Error(t, rs.SendMetric(model.NewCounterMetricsWithDelta("cnt1", 1)))
+}
@@ -7,5 +7,7 @@ import (
 type MetricSender interface {
 	SendMetricValue(id string, mtype string, value string) error
 	SendMetric(metric *model.Metrics) error
-	SendMetricArray(metrics []*model.Metrics) error
+	// SendMetricArray sends metrics as a single batch, server applies a batch with non-nil ID only once,
+	// so the batch can be resent if the response is lost
+	SendMetricArray(batchID *model.BatchID, metrics []*model.Metrics) error
 }
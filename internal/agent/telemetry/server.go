@@ -0,0 +1,56 @@
+package telemetry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const shutdownTimeout = 5 * time.Second
+
+// Handler serves agent status endpoints:
+// - /status returns Status in JSON format
+// - /healthz returns 200 if agent is healthy and 503 otherwise, so it can be used by liveness probes
+func (t *Telemetry) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", func(rw http.ResponseWriter, _ *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(rw).Encode(t.Status())
+	})
+	mux.HandleFunc("GET /healthz", func(rw http.ResponseWriter, _ *http.Request) {
+		if !t.Healthy() {
+			http.Error(rw, "no successful reports within healthy period", http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = rw.Write([]byte("ok"))
+	})
+	return mux
+}
+
+// Serve runs status endpoints on given address until context is done
+func (t *Telemetry) Serve(ctx context.Context, addr string, logger *zap.Logger) error {
+	sl := logger.Sugar().With(zap.String("component", "agent-status"))
+	server := &http.Server{
+		Addr:    addr,
+		Handler: t.Handler(),
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			sl.Errorw("failed to shutdown status server", "error", err)
+		}
+	}()
+
+	sl.Infow("starting agent status server", "address", addr)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
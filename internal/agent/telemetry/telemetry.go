@@ -0,0 +1,196 @@
+// Package telemetry tracks metrics-overseer agent own state: reports, staged and rolled back metrics,
+// accumulator sizes and retries. The state is exposed on a local HTTP endpoint and is reported to server
+// along with polled metrics with IDs under Prefix
+package telemetry
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/andrewsvn/metrics-overseer/internal/agent/accumulation"
+)
+
+// Prefix is prepended to IDs of agent self-metrics
+const Prefix = "agent."
+
+// QueueStats provides state of agent send queue
+type QueueStats interface {
+	Stats() (batches int, bytes int64, dropped int64)
+}
+
+// Telemetry collects agent statistics, all methods are safe for concurrent use.
+// It implements retrying.Observer to count retries of report requests
+type Telemetry struct {
+	stor  *accumulation.Storage
+	queue QueueStats
+	// healthyPeriod is a maximum time since the last successful report (or agent start) for agent to be healthy
+	healthyPeriod time.Duration
+	startedAt     time.Time
+
+	lastReportAttempt atomic.Int64
+	lastReportSuccess atomic.Int64
+
+	reports           atomic.Int64
+	failedReports     atomic.Int64
+	stagedMetrics     atomic.Int64
+	committedMetrics  atomic.Int64
+	rolledBackMetrics atomic.Int64
+	retryAttempts     atomic.Int64
+	retriesExhausted  atomic.Int64
+
+	// accumulated contains counter values already put into accumulators, so only increments are reported
+	accumulated map[string]int64
+	accMutex    sync.Mutex
+}
+
+// Status is a snapshot of agent statistics
+type Status struct {
+	Healthy           bool       `json:"healthy"`
+	StartedAt         time.Time  `json:"started_at"`
+	LastReportAttempt *time.Time `json:"last_report_attempt,omitempty"`
+	LastReportSuccess *time.Time `json:"last_report_success,omitempty"`
+
+	Reports           int64 `json:"reports"`
+	FailedReports     int64 `json:"failed_reports"`
+	StagedMetrics     int64 `json:"staged_metrics"`
+	CommittedMetrics  int64 `json:"committed_metrics"`
+	RolledBackMetrics int64 `json:"rolled_back_metrics"`
+	RetryAttempts     int64 `json:"retry_attempts"`
+	RetriesExhausted  int64 `json:"retries_exhausted"`
+
+	// AccumulatedMetrics is a number of metrics known to agent, PendingValues - number of values
+	// accumulated for them and not sent yet
+	AccumulatedMetrics int `json:"accumulated_metrics"`
+	PendingValues      int `json:"pending_values"`
+
+	// QueuedBatches, QueuedBytes and DroppedBatches describe disk queue state if it is used
+	QueuedBatches  int   `json:"queued_batches"`
+	QueuedBytes    int64 `json:"queued_bytes"`
+	DroppedBatches int64 `json:"dropped_batches"`
+}
+
+func NewTelemetry(stor *accumulation.Storage, healthyPeriod time.Duration) *Telemetry {
+	return &Telemetry{
+		stor:          stor,
+		healthyPeriod: healthyPeriod,
+		startedAt:     time.Now(),
+		accumulated:   make(map[string]int64),
+	}
+}
+
+// ObserveQueue adds disk queue state to agent statistics
+func (t *Telemetry) ObserveQueue(q QueueStats) {
+	t.queue = q
+}
+
+// RecordReport registers the result of report execution: numbers of staged metrics and metrics committed
+// or rolled back after sending (with disk queue - sent and kept in queue). Report is failed if any metric
+// is not sent
+func (t *Telemetry) RecordReport(staged, committed, rolledBack int) {
+	now := time.Now().UnixNano()
+	t.lastReportAttempt.Store(now)
+	t.reports.Add(1)
+	t.stagedMetrics.Add(int64(staged))
+	t.committedMetrics.Add(int64(committed))
+	t.rolledBackMetrics.Add(int64(rolledBack))
+	if rolledBack > 0 {
+		t.failedReports.Add(1)
+		return
+	}
+	t.lastReportSuccess.Store(now)
+}
+
+func (t *Telemetry) ObserveRetry(_ int) {
+	t.retryAttempts.Add(1)
+}
+
+func (t *Telemetry) ObserveExhausted() {
+	t.retriesExhausted.Add(1)
+}
+
+// Healthy checks that agent reported metrics successfully within healthy period,
+// a newly started agent is healthy until the period passes
+func (t *Telemetry) Healthy() bool {
+	last := t.startedAt
+	if ns := t.lastReportSuccess.Load(); ns != 0 {
+		last = time.Unix(0, ns)
+	}
+	return time.Since(last) <= t.healthyPeriod
+}
+
+func (t *Telemetry) Status() *Status {
+	status := &Status{
+		Healthy:           t.Healthy(),
+		StartedAt:         t.startedAt,
+		LastReportAttempt: timeOrNil(t.lastReportAttempt.Load()),
+		LastReportSuccess: timeOrNil(t.lastReportSuccess.Load()),
+		Reports:           t.reports.Load(),
+		FailedReports:     t.failedReports.Load(),
+		StagedMetrics:     t.stagedMetrics.Load(),
+		CommittedMetrics:  t.committedMetrics.Load(),
+		RolledBackMetrics: t.rolledBackMetrics.Load(),
+		RetryAttempts:     t.retryAttempts.Load(),
+		RetriesExhausted:  t.retriesExhausted.Load(),
+	}
+	status.AccumulatedMetrics, status.PendingValues = t.stor.Pending()
+	if t.queue != nil {
+		status.QueuedBatches, status.QueuedBytes, status.DroppedBatches = t.queue.Stats()
+	}
+	return status
+}
+
+// Accumulate puts current statistics into accumulator storage, so they are reported to server
+// with the next report. Counters are accumulated as increments since the previous call
+func (t *Telemetry) Accumulate() error {
+	t.accMutex.Lock()
+	defer t.accMutex.Unlock()
+
+	status := t.Status()
+	counters := map[string]int64{
+		"report.count":               status.Reports,
+		"report.failed":              status.FailedReports,
+		"report.metrics.staged":      status.StagedMetrics,
+		"report.metrics.committed":   status.CommittedMetrics,
+		"report.metrics.rolled_back": status.RolledBackMetrics,
+		"retry.attempts":             status.RetryAttempts,
+		"retry.exhausted":            status.RetriesExhausted,
+		"queue.dropped":              status.DroppedBatches,
+	}
+	for name, value := range counters {
+		id := Prefix + name
+		if delta := value - t.accumulated[id]; delta > 0 {
+			if err := t.stor.GetOrNew(id).AccumulateCounter(delta); err != nil {
+				return err
+			}
+			t.accumulated[id] = value
+		}
+	}
+
+	gauges := map[string]float64{
+		"accumulator.metrics": float64(status.AccumulatedMetrics),
+		"accumulator.pending": float64(status.PendingValues),
+		"uptime.seconds":      time.Since(t.startedAt).Seconds(),
+	}
+	if t.queue != nil {
+		gauges["queue.batches"] = float64(status.QueuedBatches)
+		gauges["queue.bytes"] = float64(status.QueuedBytes)
+	}
+	if status.LastReportSuccess != nil {
+		gauges["report.last_success.age.seconds"] = time.Since(*status.LastReportSuccess).Seconds()
+	}
+	for name, value := range gauges {
+		if err := t.stor.GetOrNew(Prefix + name).AccumulateGauge(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func timeOrNil(ns int64) *time.Time {
+	if ns == 0 {
+		return nil
+	}
+	ts := time.Unix(0, ns)
+	return &ts
+}
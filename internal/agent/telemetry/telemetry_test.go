@@ -0,0 +1,95 @@
+package telemetry
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/andrewsvn/metrics-overseer/internal/agent/accumulation"
+	"github.com/andrewsvn/metrics-overseer/internal/retrying"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTelemetryStatus(t *testing.T) {
+	stor := accumulation.NewAccumulatorStorage()
+	_ = stor.GetOrNew("gauge1").AccumulateGauge(1)
+	_ = stor.GetOrNew("gauge1").AccumulateGauge(2)
+	_ = stor.GetOrNew("cnt1").AccumulateCounter(1)
+	tel := NewTelemetry(stor, time.Minute)
+
+	retrier := retrying.NewExecutorBuilder(retrying.NewLinearPolicy(1, 0, 0)).
+		WithObserver(tel).
+		Build()
+	_ = retrier.Run(func() error {
+		return retrying.NewRetryableError(errors.New("connection refused"))
+	})
+	tel.RecordReport(2, 0, 2)
+
+	srv := httptest.NewServer(tel.Handler())
+	defer srv.Close()
+
+	res, err := srv.Client().Get(srv.URL + "/status")
+	require.NoError(t, err)
+	defer func() { _ = res.Body.Close() }()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	var status Status
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&status))
+	assert.True(t, status.Healthy)
+	assert.Equal(t, int64(1), status.Reports)
+	assert.Equal(t, int64(1), status.FailedReports)
+	assert.Equal(t, int64(2), status.RolledBackMetrics)
+	assert.Equal(t, int64(1), status.RetryAttempts)
+	assert.Equal(t, int64(1), status.RetriesExhausted)
+	assert.NotNil(t, status.LastReportAttempt)
+	assert.Nil(t, status.LastReportSuccess)
+	assert.Equal(t, 2, status.AccumulatedMetrics)
+	assert.Equal(t, 3, status.PendingValues)
+}
+
+func TestTelemetryHealth(t *testing.T) {
+	tel := NewTelemetry(accumulation.NewAccumulatorStorage(), 50*time.Millisecond)
+	srv := httptest.NewServer(tel.Handler())
+	defer srv.Close()
+
+	healthz := func() int {
+		res, err := srv.Client().Get(srv.URL + "/healthz")
+		require.NoError(t, err)
+		_ = res.Body.Close()
+		return res.StatusCode
+	}
+
+	// newly started agent is healthy until the first reports have a chance to succeed
+	assert.Equal(t, http.StatusOK, healthz())
+	time.Sleep(60 * time.Millisecond)
+	tel.RecordReport(1, 0, 1)
+	assert.Equal(t, http.StatusServiceUnavailable, healthz())
+	tel.RecordReport(1, 1, 0)
+	assert.Equal(t, http.StatusOK, healthz())
+}
+
+func TestTelemetryAccumulate(t *testing.T) {
+	stor := accumulation.NewAccumulatorStorage()
+	tel := NewTelemetry(stor, time.Minute)
+
+	tel.RecordReport(3, 3, 0)
+	tel.RecordReport(3, 0, 3)
+	require.NoError(t, tel.Accumulate())
+
+	reports := stor.Get("agent.report.count")
+	require.NotNil(t, reports)
+	assert.Equal(t, int64(2), *reports.Delta)
+	assert.Equal(t, int64(1), *stor.Get("agent.report.failed").Delta)
+	assert.Nil(t, stor.Get("agent.retry.attempts"))
+	assert.NotNil(t, stor.Get("agent.report.last_success.age.seconds"))
+
+	// only increments are accumulated
+	tel.RecordReport(1, 1, 0)
+	require.NoError(t, tel.Accumulate())
+	assert.Equal(t, int64(3), *reports.Delta)
+	assert.Equal(t, int64(1), *stor.Get("agent.report.failed").Delta)
+}
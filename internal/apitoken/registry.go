@@ -0,0 +1,112 @@
+package apitoken
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Registry keeps token definitions loaded from a store and authenticates token values against them.
+// Definitions can be reloaded at any time, the previous set stays active if reload fails.
+// Registry without a store is disabled - token checks should be skipped in this case
+type Registry struct {
+	store Store
+
+	mu     sync.RWMutex
+	byHash map[string]*Token
+
+	logger *zap.SugaredLogger
+}
+
+func NewRegistry(store Store, l *zap.Logger) *Registry {
+	return &Registry{
+		store:  store,
+		byHash: make(map[string]*Token),
+		logger: l.Sugar().With("component", "api-tokens"),
+	}
+}
+
+// Enabled checks that token store is configured
+func (r *Registry) Enabled() bool {
+	return r.store != nil
+}
+
+// Reload replaces active token definitions with the ones read from store,
+// the whole set is rejected if any definition is invalid or duplicated
+func (r *Registry) Reload(ctx context.Context) error {
+	if !r.Enabled() {
+		return nil
+	}
+
+	tokens, err := r.store.Load(ctx)
+	if err != nil {
+		return err
+	}
+
+	byHash := make(map[string]*Token, len(tokens))
+	names := make(map[string]struct{}, len(tokens))
+	for _, t := range tokens {
+		if err := t.validate(); err != nil {
+			return err
+		}
+		if _, ok := names[t.Name]; ok {
+			return fmt.Errorf("%w: duplicate token name %s", ErrInvalidToken, t.Name)
+		}
+		if _, ok := byHash[t.Hash]; ok {
+			return fmt.Errorf("%w: token %s has the same value as another token", ErrInvalidToken, t.Name)
+		}
+		names[t.Name] = struct{}{}
+		byHash[t.Hash] = t
+	}
+
+	r.mu.Lock()
+	r.byHash = byHash
+	r.mu.Unlock()
+
+	r.logger.Infow("API tokens loaded", "count", len(byHash))
+	return nil
+}
+
+// Authenticate finds token definition by token value
+func (r *Registry) Authenticate(value string) (*Token, bool) {
+	if value == "" {
+		return nil, false
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	t, ok := r.byHash[Hash(value)]
+	return t, ok
+}
+
+// Watch reloads tokens every interval (if positive) and on every received signal until context is done.
+// Reload errors are logged and don't stop watching
+func (r *Registry) Watch(ctx context.Context, interval time.Duration, signals <-chan os.Signal) {
+	if !r.Enabled() {
+		return
+	}
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick:
+		case <-signals:
+			r.logger.Infow("reloading API tokens on signal")
+		}
+		if err := r.Reload(ctx); err != nil {
+			r.logger.Errorw("failed to reload API tokens", "error", err)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package apitoken
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/andrewsvn/metrics-overseer/internal/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistryReload(t *testing.T) {
+	logger, _ := logging.NewZapLogger("info")
+
+	disabled := NewRegistry(nil, logger)
+	assert.False(t, disabled.Enabled())
+	require.NoError(t, disabled.Reload(context.Background()))
+	_, ok := disabled.Authenticate("any")
+	assert.False(t, ok)
+
+	tests := []struct {
+		name   string
+		tokens StaticStore
+		valid  bool
+	}{
+		{
+			name:   "valid",
+			tokens: StaticStore{{Name: "agent", Hash: Hash("a"), Scopes: []Scope{ScopeWrite}}},
+			valid:  true,
+		},
+		{
+			name:   "empty_name",
+			tokens: StaticStore{{Hash: Hash("a"), Scopes: []Scope{ScopeWrite}}},
+		},
+		{
+			name:   "plain_value_instead_of_hash",
+			tokens: StaticStore{{Name: "agent", Hash: "a", Scopes: []Scope{ScopeWrite}}},
+		},
+		{
+			name:   "unknown_scope",
+			tokens: StaticStore{{Name: "agent", Hash: Hash("a"), Scopes: []Scope{"delete"}}},
+		},
+		{
+			name: "duplicate_name",
+			tokens: StaticStore{
+				{Name: "agent", Hash: Hash("a"), Scopes: []Scope{ScopeWrite}},
+				{Name: "agent", Hash: Hash("b"), Scopes: []Scope{ScopeRead}},
+			},
+		},
+		{
+			name: "duplicate_value",
+			tokens: StaticStore{
+				{Name: "agent", Hash: Hash("a"), Scopes: []Scope{ScopeWrite}},
+				{Name: "reader", Hash: Hash("a"), Scopes: []Scope{ScopeRead}},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := NewRegistry(test.tokens, logger)
+			err := r.Reload(context.Background())
+			if !test.valid {
+				assert.ErrorIs(t, err, ErrInvalidToken)
+				return
+			}
+			require.NoError(t, err)
+			tok, ok := r.Authenticate("a")
+			require.True(t, ok)
+			assert.Equal(t, "agent", tok.Name)
+			_, ok = r.Authenticate("b")
+			assert.False(t, ok)
+		})
+	}
+}
+
+func TestRegistryWatch(t *testing.T) {
+	logger, _ := logging.NewZapLogger("info")
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	require.NoError(t, os.WriteFile(path, []byte(`[{"name":"agent","hash":"`+Hash("a")+`","scopes":["write"]}]`), 0600))
+
+	r := NewRegistry(NewFileStore(path), logger)
+	require.NoError(t, r.Reload(context.Background()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	go r.Watch(ctx, 0, signals)
+
+	// broken file keeps previous tokens active
+	require.NoError(t, os.WriteFile(path, []byte(`[{"name":"agent"`), 0600))
+	signals <- syscall.SIGHUP
+	require.Never(t, func() bool {
+		_, ok := r.Authenticate("a")
+		return !ok
+	}, 100*time.Millisecond, 10*time.Millisecond)
+
+	require.NoError(t, os.WriteFile(path, []byte(`[{"name":"reader","hash":"`+Hash("b")+`","scopes":["read"]}]`), 0600))
+	signals <- syscall.SIGHUP
+	require.Eventually(t, func() bool {
+		tok, ok := r.Authenticate("b")
+		return ok && tok.Allows(ScopeRead)
+	}, time.Second, 10*time.Millisecond)
+	_, ok := r.Authenticate("a")
+	assert.False(t, ok)
+}
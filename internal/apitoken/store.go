@@ -0,0 +1,93 @@
+package apitoken
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/andrewsvn/metrics-overseer/internal/db"
+)
+
+// Store is a source of token definitions, it is read on registry reload
+type Store interface {
+	Load(ctx context.Context) ([]*Token, error)
+}
+
+// StaticStore is a fixed set of token definitions kept in memory
+type StaticStore []*Token
+
+func (ss StaticStore) Load(_ context.Context) ([]*Token, error) {
+	return ss, nil
+}
+
+// FileStore reads token definitions from JSON file containing an array of tokens
+type FileStore struct {
+	path string
+}
+
+func NewFileStore(path string) *FileStore {
+	return &FileStore{
+		path: path,
+	}
+}
+
+func (fs *FileStore) Load(_ context.Context) ([]*Token, error) {
+	data, err := os.ReadFile(fs.path)
+	if err != nil {
+		return nil, fmt.Errorf("can't read tokens file: %w", err)
+	}
+
+	var tokens []*Token
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, fmt.Errorf("can't parse tokens file: %w", err)
+	}
+	return tokens, nil
+}
+
+// DBStore reads token definitions from API_TOKENS table of postgres database
+type DBStore struct {
+	conn db.Connection
+	sqrl squirrel.StatementBuilderType
+}
+
+func NewDBStore(conn db.Connection) *DBStore {
+	return &DBStore{
+		conn: conn,
+		sqrl: squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar),
+	}
+}
+
+func (dbs *DBStore) Load(ctx context.Context) ([]*Token, error) {
+	query, args, err := dbs.sqrl.Select("name", "token_hash", "scopes").
+		From("api_tokens").
+		OrderBy("name").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to compose tokens query: %w", err)
+	}
+
+	rows, err := dbs.conn.Pool().Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute tokens query: %w", err)
+	}
+	defer rows.Close()
+
+	var tokens []*Token
+	for rows.Next() {
+		t := &Token{}
+		var scopes []string
+		if err := rows.Scan(&t.Name, &t.Hash, &scopes); err != nil {
+			return nil, fmt.Errorf("failed to scan token row: %w", err)
+		}
+		for _, s := range scopes {
+			t.Scopes = append(t.Scopes, Scope(s))
+		}
+		tokens = append(tokens, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read tokens: %w", err)
+	}
+	return tokens, nil
+}
@@ -0,0 +1,143 @@
+// Package apitoken implements named API tokens with scopes used to authenticate clients of metrics-overseer server.
+// Token values are never stored on server side - tokens are identified by hex-encoded SHA-256 hash of their values
+package apitoken
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Scope defines a set of operations allowed for token owner
+type Scope string
+
+const (
+	// ScopeWrite allows updating metrics
+	ScopeWrite Scope = "write"
+	// ScopeRead allows reading metric values, history and pages
+	ScopeRead Scope = "read"
+	// ScopeAdmin allows maintenance operations and implies all other scopes
+	ScopeAdmin Scope = "admin"
+)
+
+const (
+	// AuthorizationHeader is a header carrying token in form of "Bearer <token>"
+	AuthorizationHeader = "Authorization"
+
+	bearerPrefix = "Bearer "
+	tokenBytes   = 32
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid API token definition")
+	ErrUnknownScope = errors.New("unknown API token scope")
+)
+
+// Token is a named API token definition as it is stored in file or database
+type Token struct {
+	Name string `json:"name"`
+	// Hash is a hex-encoded SHA-256 hash of token value
+	Hash   string  `json:"hash"`
+	Scopes []Scope `json:"scopes"`
+}
+
+// Allows checks that token has the given scope, admin scope allows everything
+func (t *Token) Allows(scope Scope) bool {
+	for _, s := range t.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Token) validate() error {
+	if t.Name == "" {
+		return fmt.Errorf("%w: name is empty", ErrInvalidToken)
+	}
+	if b, err := hex.DecodeString(t.Hash); err != nil || len(b) != sha256.Size {
+		return fmt.Errorf("%w: token %s hash is not a hex-encoded SHA-256 value", ErrInvalidToken, t.Name)
+	}
+	for _, s := range t.Scopes {
+		if err := s.validate(); err != nil {
+			return fmt.Errorf("%w: token %s: %w", ErrInvalidToken, t.Name, err)
+		}
+	}
+	return nil
+}
+
+func (s Scope) validate() error {
+	switch s {
+	case ScopeWrite, ScopeRead, ScopeAdmin:
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrUnknownScope, s)
+}
+
+// ParseScopes converts scope names to scopes checking that all of them are known
+func ParseScopes(names []string) ([]Scope, error) {
+	scopes := make([]Scope, 0, len(names))
+	for _, name := range names {
+		s := Scope(strings.TrimSpace(name))
+		if err := s.validate(); err != nil {
+			return nil, err
+		}
+		scopes = append(scopes, s)
+	}
+	return scopes, nil
+}
+
+// Hash returns hex-encoded SHA-256 hash of token value
+func Hash(value string) string {
+	h := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(h[:])
+}
+
+// Generate creates a new random token value
+func Generate() (string, error) {
+	b := make([]byte, tokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("can't generate token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// BearerValue formats token value for Authorization header
+func BearerValue(value string) string {
+	return bearerPrefix + value
+}
+
+// ParseBearer extracts token value from Authorization header value, empty string is returned
+// if header is not in form of "Bearer <token>"
+func ParseBearer(header string) string {
+	if len(header) < len(bearerPrefix) || !strings.EqualFold(header[:len(bearerPrefix)], bearerPrefix) {
+		return ""
+	}
+	return strings.TrimSpace(header[len(bearerPrefix):])
+}
+
+type contextKey struct{}
+
+// WithToken returns a copy of context holding authenticated token
+func WithToken(ctx context.Context, t *Token) context.Context {
+	return context.WithValue(ctx, contextKey{}, t)
+}
+
+// FromContext returns authenticated token stored in context if any
+func FromContext(ctx context.Context) (*Token, bool) {
+	t, ok := ctx.Value(contextKey{}).(*Token)
+	return t, ok && t != nil
+}
+
+// NameFromContext returns name of authenticated token stored in context or empty string
+func NameFromContext(ctx context.Context) string {
+	if t, ok := FromContext(ctx); ok {
+		return t.Name
+	}
+	return ""
+}
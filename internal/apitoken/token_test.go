@@ -0,0 +1,55 @@
+package apitoken
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenAllows(t *testing.T) {
+	writer := &Token{Name: "writer", Scopes: []Scope{ScopeWrite}}
+	assert.True(t, writer.Allows(ScopeWrite))
+	assert.False(t, writer.Allows(ScopeRead))
+	assert.False(t, writer.Allows(ScopeAdmin))
+
+	admin := &Token{Name: "admin", Scopes: []Scope{ScopeAdmin}}
+	assert.True(t, admin.Allows(ScopeWrite))
+	assert.True(t, admin.Allows(ScopeRead))
+	assert.True(t, admin.Allows(ScopeAdmin))
+
+	assert.False(t, (&Token{Name: "none"}).Allows(ScopeRead))
+}
+
+func TestParseScopes(t *testing.T) {
+	scopes, err := ParseScopes([]string{"write", " read"})
+	require.NoError(t, err)
+	assert.Equal(t, []Scope{ScopeWrite, ScopeRead}, scopes)
+
+	_, err = ParseScopes([]string{"write", "delete"})
+	assert.ErrorIs(t, err, ErrUnknownScope)
+}
+
+func TestBearer(t *testing.T) {
+	value, err := Generate()
+	require.NoError(t, err)
+	assert.NotEmpty(t, value)
+
+	assert.Equal(t, value, ParseBearer(BearerValue(value)))
+	assert.Equal(t, "abc", ParseBearer("bearer abc"))
+	assert.Equal(t, "", ParseBearer("Basic abc"))
+	assert.Equal(t, "", ParseBearer("Bearer"))
+	assert.Equal(t, "", ParseBearer(""))
+}
+
+func TestContext(t *testing.T) {
+	ctx := context.Background()
+	assert.Equal(t, "", NameFromContext(ctx))
+
+	ctx = WithToken(ctx, &Token{Name: "agent"})
+	tok, ok := FromContext(ctx)
+	require.True(t, ok)
+	assert.Equal(t, "agent", tok.Name)
+	assert.Equal(t, "agent", NameFromContext(ctx))
+}
@@ -35,12 +35,12 @@ func NewFileWriter(filename string, writeIntervalSec int, l *zap.Logger) *FileWr
 	return fw
 }
 
-func (fw *FileWriter) OnMetricsUpdate(ts time.Time, ipAddr string, metrics ...*model.Metrics) error {
+func (fw *FileWriter) OnMetricsUpdate(ts time.Time, ipAddr string, tokenName string, metrics ...*model.Metrics) error {
 	fw.bufMutex.Lock()
 	defer fw.bufMutex.Unlock()
 
 	// actual file write is done by timer
-	payload := NewPayload(ts, ipAddr, metrics...)
+	payload := NewPayload(ts, ipAddr, tokenName, metrics...)
 	fw.buf = append(fw.buf, payload)
 	return nil
 }
@@ -31,27 +31,31 @@ func TestFileWriterOnMetricsUpdate(t *testing.T) {
 	events := []struct {
 		timestamp time.Time
 		ipAddr    string
+		tokenName string
 		metrics   []*model.Metrics
 	}{
 		{
 			timestamp: ts,
 			ipAddr:    "10.10.10.10",
+			tokenName: "agent-1",
 			metrics:   metrics[0:2],
 		},
 		{
 			timestamp: ts.Add(5 * time.Second),
 			ipAddr:    "11.11.11.11",
+			tokenName: "agent-2",
 			metrics:   metrics[1:],
 		},
 		{
 			timestamp: ts.Add(2 * time.Minute),
 			ipAddr:    "12.12.12.12",
+			tokenName: "",
 			metrics:   metrics[:1],
 		},
 	}
 
 	for _, event := range events {
-		err := fsw.OnMetricsUpdate(event.timestamp, event.ipAddr, event.metrics...)
+		err := fsw.OnMetricsUpdate(event.timestamp, event.ipAddr, event.tokenName, event.metrics...)
 		assert.NoError(t, err)
 	}
 
@@ -68,12 +72,13 @@ func TestFileWriterOnMetricsUpdate(t *testing.T) {
 	payloadStrs := strings.Split(string(data), "\n")
 	// last line in file is always empty
 	assert.Equal(t, len(events), len(payloadStrs)-1)
-	var payload Payload
 	for i, event := range events {
+		var payload Payload
 		err := json.Unmarshal([]byte(payloadStrs[i]), &payload)
 		assert.NoError(t, err)
 		assert.Equal(t, event.timestamp.Unix(), payload.Timestamp)
 		assert.Equal(t, event.ipAddr, payload.IPAddress)
+		assert.Equal(t, event.tokenName, payload.TokenName)
 		assert.Equal(t, len(event.metrics), len(payload.MetricNames))
 		assert.Equal(t, event.metrics[0].ID, payload.MetricNames[0])
 	}
@@ -18,11 +18,11 @@ func NewHTTPWriter(url string) *HTTPWriter {
 	}
 }
 
-func (hw *HTTPWriter) OnMetricsUpdate(ts time.Time, ipAddr string, metrics ...*model.Metrics) error {
+func (hw *HTTPWriter) OnMetricsUpdate(ts time.Time, ipAddr string, tokenName string, metrics ...*model.Metrics) error {
 	req := resty.New().R()
 	req.URL = hw.url
 	req.SetHeader("Content-Type", "application/json")
-	req.SetBody(NewPayload(ts, ipAddr, metrics...))
+	req.SetBody(NewPayload(ts, ipAddr, tokenName, metrics...))
 
 	_, err := req.Post(hw.url)
 	if err != nil {
@@ -25,21 +25,25 @@ func TestHTTPWriterOnMetricsUpdate(t *testing.T) {
 	events := []struct {
 		timestamp time.Time
 		ipAddr    string
+		tokenName string
 		metrics   []*model.Metrics
 	}{
 		{
 			timestamp: ts,
 			ipAddr:    "0",
+			tokenName: "agent-1",
 			metrics:   metrics[0:2],
 		},
 		{
 			timestamp: ts.Add(5 * time.Second),
 			ipAddr:    "1",
+			tokenName: "agent-2",
 			metrics:   metrics[1:],
 		},
 		{
 			timestamp: ts.Add(2 * time.Minute),
 			ipAddr:    "2",
+			tokenName: "",
 			metrics:   metrics[:1],
 		},
 	}
@@ -54,6 +58,7 @@ func TestHTTPWriterOnMetricsUpdate(t *testing.T) {
 		assert.Less(t, int(id), len(events))
 
 		assert.Equal(t, events[id].timestamp.Unix(), body.Timestamp)
+		assert.Equal(t, events[id].tokenName, body.TokenName)
 		assert.Equal(t, len(events[id].metrics), len(body.MetricNames))
 		for i, metric := range body.MetricNames {
 			assert.Equal(t, events[id].metrics[i].ID, metric)
@@ -64,7 +69,7 @@ func TestHTTPWriterOnMetricsUpdate(t *testing.T) {
 
 	httpw := NewHTTPWriter(srv.URL)
 	for _, event := range events {
-		err := httpw.OnMetricsUpdate(event.timestamp, event.ipAddr, event.metrics...)
+		err := httpw.OnMetricsUpdate(event.timestamp, event.ipAddr, event.tokenName, event.metrics...)
 		assert.NoError(t, err)
 	}
 }
@@ -12,9 +12,11 @@ type Payload struct {
 	Timestamp   int64    `json:"ts"`
 	MetricNames []string `json:"metrics"`
 	IPAddress   string   `json:"ip_address"`
+	// TokenName is a name of API token used for update, omitted if API tokens are not configured
+	TokenName string `json:"token_name,omitempty"`
 }
 
-func NewPayload(ts time.Time, ipAddr string, metrics ...*model.Metrics) *Payload {
+func NewPayload(ts time.Time, ipAddr string, tokenName string, metrics ...*model.Metrics) *Payload {
 	names := make([]string, 0, len(metrics))
 	for _, m := range metrics {
 		names = append(names, m.ID)
@@ -24,6 +26,7 @@ func NewPayload(ts time.Time, ipAddr string, metrics ...*model.Metrics) *Payload
 		Timestamp:   ts.Unix(),
 		MetricNames: names,
 		IPAddress:   ipAddr,
+		TokenName:   tokenName,
 	}
 }
 
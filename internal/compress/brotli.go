@@ -0,0 +1,83 @@
+package compress
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/andybalholm/brotli"
+)
+
+const (
+	brotliEncoding = "br"
+)
+
+type BrotliWriteEngine struct{}
+
+func NewBrotliWriteEngine() *BrotliWriteEngine {
+	return &BrotliWriteEngine{}
+}
+
+func (bwe *BrotliWriteEngine) Name() string {
+	return brotliEncoding
+}
+
+func (bwe *BrotliWriteEngine) Applicable(header http.Header) bool {
+	return checkAcceptEncodingIncludes(header, brotliEncoding)
+}
+
+func (bwe *BrotliWriteEngine) NewResponseWriter(w http.ResponseWriter, level int) (CompressedResponseWriter, error) {
+	bw := brotli.NewWriterLevel(w, normalizeBrotliLevel(level))
+	return newEncodingResponseWriter(w, bw, brotliEncoding), nil
+}
+
+func (bwe *BrotliWriteEngine) WriteFlushed(data []byte, level int) ([]byte, error) {
+	var b bytes.Buffer
+	bw := brotli.NewWriterLevel(&b, normalizeBrotliLevel(level))
+	_, err := bw.Write(data)
+	if err != nil {
+		return nil, fmt.Errorf("error writing to brotli writer: %w", err)
+	}
+	err = bw.Close()
+	if err != nil {
+		return nil, fmt.Errorf("error compressing data with brotli: %w", err)
+	}
+	return b.Bytes(), nil
+}
+
+func (bwe *BrotliWriteEngine) SetContentEncoding(header http.Header) {
+	header.Set("Content-Encoding", brotliEncoding)
+}
+
+type BrotliReadEngine struct{}
+
+func NewBrotliReadEngine() *BrotliReadEngine {
+	return &BrotliReadEngine{}
+}
+
+func (bre *BrotliReadEngine) Name() string {
+	return brotliEncoding
+}
+
+func (bre *BrotliReadEngine) Applicable(header http.Header) bool {
+	return checkContentEncoding(header, brotliEncoding)
+}
+
+func (bre *BrotliReadEngine) ReadAll(r io.Reader, maxSize int64) ([]byte, error) {
+	data, err := readAllLimited(brotli.NewReader(r), maxSize)
+	if err != nil {
+		return nil, fmt.Errorf("error reading brotli data: %w", err)
+	}
+	return data, nil
+}
+
+func normalizeBrotliLevel(level int) int {
+	if level <= 0 {
+		return brotli.DefaultCompression
+	}
+	if level > brotli.BestCompression {
+		return brotli.BestCompression
+	}
+	return level
+}
@@ -0,0 +1,248 @@
+package compress
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andrewsvn/metrics-overseer/internal/logging"
+	"github.com/andrewsvn/metrics-overseer/internal/model"
+	"github.com/golang/snappy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAcceptEncodingQuality(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		encoding       string
+		want           float64
+	}{
+		{"gzip", "gzip", 1},
+		{"gzip, br", "br", 1},
+		{"gzip;q=0.5, br;q=0.8", "gzip", 0.5},
+		{"gzip; q=0.5", "gzip", 0.5},
+		{"gzip;q=0", "gzip", 0},
+		{"GZIP", "gzip", 1},
+		{"*;q=0.3", "zstd", 0.3},
+		{"*;q=0.3, zstd;q=0", "zstd", 0},
+		{"zstd;q=0.7, *", "zstd", 0.7},
+		{"gzip;q=bad", "gzip", 1},
+		{"gzip", "zstd", 0},
+		{"", "gzip", 0},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s/%s", tt.acceptEncoding, tt.encoding), func(t *testing.T) {
+			header := http.Header{}
+			header.Set("Accept-Encoding", tt.acceptEncoding)
+			assert.Equal(t, tt.want, acceptEncodingQuality(header, tt.encoding))
+		})
+	}
+}
+
+func TestCompressorNegotiation(t *testing.T) {
+	logger, _ := logging.NewZapLogger("info")
+	compr := NewCompressor(logger, NewZstdWriteEngine(), NewBrotliWriteEngine(), NewGzipWriteEngine())
+
+	tests := []struct {
+		acceptEncoding string
+		want           string
+	}{
+		{"gzip", gzipEncoding},
+		{"gzip, deflate, br, zstd", zstdEncoding},
+		{"gzip;q=1.0, br;q=0.9, zstd;q=0.8", gzipEncoding},
+		{"zstd;q=0, br", brotliEncoding},
+		{"*", zstdEncoding},
+		{"deflate", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.acceptEncoding, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			rec := httptest.NewRecorder()
+			crw, err := compr.CreateCompressWriter(rec, r)
+			require.NoError(t, err)
+			if tt.want == "" {
+				assert.Nil(t, crw)
+				return
+			}
+			require.NotNil(t, crw)
+
+			crw.Header().Set("Content-Type", "application/json")
+			crw.WriteHeader(http.StatusOK)
+			_, err = crw.Write([]byte(`{"id":"cnt1"}`))
+			require.NoError(t, err)
+			require.NoError(t, crw.Close())
+			assert.Equal(t, tt.want, rec.Header().Get("Content-Encoding"))
+		})
+	}
+}
+
+func TestEnginesRoundTrip(t *testing.T) {
+	logger, _ := logging.NewZapLogger("info")
+	decompr := NewDecompressor(logger, 0, NewGzipReadEngine(), NewZstdReadEngine(), NewBrotliReadEngine())
+	payload := updatesPayload(t, 30)
+
+	for _, name := range []string{gzipEncoding, zstdEncoding, brotliEncoding} {
+		t.Run(name, func(t *testing.T) {
+			cwe, err := NewWriteEngine(name)
+			require.NoError(t, err)
+			compressed, err := cwe.WriteFlushed(payload, 0)
+			require.NoError(t, err)
+			assert.Less(t, len(compressed), len(payload))
+
+			r := httptest.NewRequest(http.MethodPost, "/updates", bytes.NewReader(compressed))
+			cwe.SetContentEncoding(r.Header)
+			data, err := decompr.ReadRequestBody(r)
+			require.NoError(t, err)
+			assert.Equal(t, payload, data)
+		})
+	}
+
+	cwe, err := NewWriteEngine(NoCompression)
+	require.NoError(t, err)
+	assert.Nil(t, cwe)
+	_, err = NewWriteEngine("lz4")
+	assert.Error(t, err)
+
+	// unknown encodings are rejected instead of being treated as plain data
+	r := httptest.NewRequest(http.MethodPost, "/updates", bytes.NewReader(payload))
+	r.Header.Set("Content-Encoding", "lz4")
+	_, err = decompr.ReadRequestBody(r)
+	assert.ErrorIs(t, err, ErrUnsupportedEncoding)
+	assert.Equal(t, "gzip, zstd, br", decompr.AcceptEncoding())
+}
+
+func TestZstdResponseWritersReused(t *testing.T) {
+	zwe := NewZstdWriteEngine()
+	zre := NewZstdReadEngine()
+
+	// encoders are pooled, so each response must still be an independent zstd stream
+	for i := 0; i < 3; i++ {
+		body := fmt.Sprintf(`{"id":"cnt%d"}`, i)
+		rec := httptest.NewRecorder()
+		crw, err := zwe.NewResponseWriter(rec, 0)
+		require.NoError(t, err)
+		crw.Header().Set("Content-Type", "application/json")
+		crw.WriteHeader(http.StatusOK)
+		_, err = crw.Write([]byte(body))
+		require.NoError(t, err)
+		require.NoError(t, crw.Close())
+
+		data, err := zre.ReadAll(bytes.NewReader(rec.Body.Bytes()), 0)
+		require.NoError(t, err)
+		assert.Equal(t, body, string(data))
+
+		// encoder of a response which is not compressed is returned to the pool as well
+		rec = httptest.NewRecorder()
+		crw, err = zwe.NewResponseWriter(rec, 0)
+		require.NoError(t, err)
+		crw.Header().Set("Content-Type", "image/png")
+		_, err = crw.Write([]byte("png"))
+		require.NoError(t, err)
+		require.NoError(t, crw.Close())
+		assert.Equal(t, "png", rec.Body.String())
+	}
+}
+
+func TestDecompressionLimits(t *testing.T) {
+	logger, _ := logging.NewZapLogger("info")
+	decompr := NewDecompressor(logger, 64<<10,
+		NewGzipReadEngine(), NewZstdReadEngine(), NewBrotliReadEngine(), NewSnappyReadEngine())
+	// highly compressible body which is much larger than the limit after decompression
+	bomb := make([]byte, 16<<20)
+
+	for _, name := range []string{gzipEncoding, zstdEncoding, brotliEncoding} {
+		t.Run(name, func(t *testing.T) {
+			cwe, err := NewWriteEngine(name)
+			require.NoError(t, err)
+			compressed, err := cwe.WriteFlushed(bomb, 0)
+			require.NoError(t, err)
+			assert.Less(t, len(compressed), 64<<10)
+
+			r := httptest.NewRequest(http.MethodPost, "/updates", bytes.NewReader(compressed))
+			cwe.SetContentEncoding(r.Header)
+			_, err = decompr.ReadRequestBody(r)
+			assert.ErrorIs(t, err, ErrBodyTooLarge)
+
+			compressed, err = cwe.WriteFlushed(bomb[:64<<10], 0)
+			require.NoError(t, err)
+			r = httptest.NewRequest(http.MethodPost, "/updates", bytes.NewReader(compressed))
+			cwe.SetContentEncoding(r.Header)
+			data, err := decompr.ReadRequestBody(r)
+			require.NoError(t, err)
+			assert.Len(t, data, 64<<10)
+		})
+	}
+
+	// snappy block is checked by its declared length before decoding
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/write", bytes.NewReader(snappy.Encode(nil, bomb)))
+	r.Header.Set("Content-Encoding", snappyEncoding)
+	_, err := decompr.ReadRequestBody(r)
+	assert.ErrorIs(t, err, ErrBodyTooLarge)
+
+	// uncompressed bodies are limited as well
+	r = httptest.NewRequest(http.MethodPost, "/updates", bytes.NewReader(bomb))
+	_, err = decompr.ReadRequestBody(r)
+	assert.ErrorIs(t, err, ErrBodyTooLarge)
+}
+
+// BenchmarkWriteEngines compares engines on /updates payloads of a typical agent report size,
+// compression ratio is reported as an additional metric
+func BenchmarkWriteEngines(b *testing.B) {
+	logger, _ := logging.NewZapLogger("info")
+	decompr := NewDecompressor(logger, 0, NewGzipReadEngine(), NewZstdReadEngine(), NewBrotliReadEngine())
+
+	for _, size := range []int{30, 300} {
+		payload := updatesPayload(b, size)
+		for _, name := range []string{gzipEncoding, zstdEncoding, brotliEncoding} {
+			cwe, err := NewWriteEngine(name)
+			require.NoError(b, err)
+
+			b.Run(fmt.Sprintf("compress/%s/%d", name, size), func(b *testing.B) {
+				var compressed []byte
+				b.SetBytes(int64(len(payload)))
+				for i := 0; i < b.N; i++ {
+					compressed, err = cwe.WriteFlushed(payload, 0)
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.ReportMetric(float64(len(payload))/float64(len(compressed)), "ratio")
+			})
+
+			compressed, err := cwe.WriteFlushed(payload, 0)
+			require.NoError(b, err)
+			b.Run(fmt.Sprintf("decompress/%s/%d", name, size), func(b *testing.B) {
+				b.SetBytes(int64(len(payload)))
+				for i := 0; i < b.N; i++ {
+					r := httptest.NewRequest(http.MethodPost, "/updates", bytes.NewReader(compressed))
+					cwe.SetContentEncoding(r.Header)
+					if _, err := decompr.ReadRequestBody(r); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// updatesPayload generates a JSON batch of gauges and counters similar to agent reports
+func updatesPayload(tb testing.TB, size int) []byte {
+	metrics := make([]*model.Metrics, 0, size)
+	for i := 0; i < size; i++ {
+		if i%10 == 0 {
+			metrics = append(metrics, model.NewCounterMetricsWithDelta(fmt.Sprintf("PollCount%d", i), rand.Int64N(100)))
+			continue
+		}
+		metrics = append(metrics, model.NewGaugeMetricsWithValue(fmt.Sprintf("HeapMetric%d", i), rand.Float64()*1e8))
+	}
+	payload, err := json.Marshal(metrics)
+	require.NoError(tb, err)
+	return payload
+}
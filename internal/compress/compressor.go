@@ -6,6 +6,8 @@ import (
 	"net/http"
 )
 
+// Compressor chooses an engine for response writing by Accept-Encoding q-values,
+// engines with equal q-values are preferred in order they are passed to compressor
 type Compressor struct {
 	engines []WriteEngine
 	logger  *zap.SugaredLogger
@@ -20,18 +22,48 @@ func NewCompressor(logger *zap.Logger, engines ...WriteEngine) *Compressor {
 }
 
 func (c *Compressor) CreateCompressWriter(w http.ResponseWriter, r *http.Request) (CompressedResponseWriter, error) {
+	wEngine := c.chooseEngine(r.Header)
+	if wEngine == nil {
+		return nil, nil
+	}
+
+	c.logger.Debug(
+		"Compression engine chosen for response writing",
+		zap.String("name", wEngine.Name()),
+	)
+	crw, err := wEngine.NewResponseWriter(w, 0)
+	if err != nil {
+		return nil, fmt.Errorf("error creating compress writer: %w", err)
+	}
+	return crw, nil
+}
+
+func (c *Compressor) chooseEngine(header http.Header) WriteEngine {
+	var chosen WriteEngine
+	bestQuality := 0.0
 	for _, wEngine := range c.engines {
-		if wEngine.Applicable(r.Header) {
-			c.logger.Debug(
-				"Compression engine chosen for response writing",
-				zap.String("name", wEngine.Name()),
-			)
-			crw, err := wEngine.NewResponseWriter(w, 0)
-			if err != nil {
-				return nil, fmt.Errorf("error creating compress writer: %w", err)
-			}
-			return crw, nil
+		if !wEngine.Applicable(header) {
+			continue
 		}
+		if quality := acceptEncodingQuality(header, wEngine.Name()); quality > bestQuality {
+			chosen, bestQuality = wEngine, quality
+		}
+	}
+	return chosen
+}
+
+// NewWriteEngine creates a write engine by its encoding name: gzip, zstd or br.
+// Nil engine is returned for "none", which means that data should be sent uncompressed
+func NewWriteEngine(name string) (WriteEngine, error) {
+	switch name {
+	case gzipEncoding:
+		return NewGzipWriteEngine(), nil
+	case zstdEncoding:
+		return NewZstdWriteEngine(), nil
+	case brotliEncoding:
+		return NewBrotliWriteEngine(), nil
+	case NoCompression:
+		return nil, nil
 	}
-	return nil, nil
+	return nil, fmt.Errorf("unsupported compression algorithm: %s", name)
 }
@@ -1,21 +1,31 @@
 package compress
 
 import (
+	"errors"
 	"fmt"
 	"go.uber.org/zap"
-	"io"
 	"net/http"
+	"strings"
 )
 
+var (
+	ErrUnsupportedEncoding = errors.New("unsupported content encoding")
+)
+
+// Decompressor reads request bodies decompressing them by an engine chosen by Content-Encoding header.
+// Decompressed bodies larger than maxSize are rejected with ErrBodyTooLarge while being read,
+// so compressed bodies with a high compression ratio can't exhaust memory
 type Decompressor struct {
 	engines []ReadEngine
+	maxSize int64
 	logger  *zap.SugaredLogger
 }
 
-func NewDecompressor(logger *zap.Logger, engines ...ReadEngine) *Decompressor {
+func NewDecompressor(logger *zap.Logger, maxSize int64, engines ...ReadEngine) *Decompressor {
 	dcmpLogger := logger.Sugar().With(zap.String("component", "decompressor"))
 	return &Decompressor{
 		engines: engines,
+		maxSize: maxSize,
 		logger:  dcmpLogger,
 	}
 }
@@ -27,13 +37,26 @@ func (d *Decompressor) ReadRequestBody(r *http.Request) ([]byte, error) {
 				"Decompress reader chosen for request body",
 				zap.String("name", engine.Name()),
 			)
-			b, err := engine.ReadAll(r.Body)
+			b, err := engine.ReadAll(r.Body, d.maxSize)
 			if err != nil {
 				return nil, fmt.Errorf("can't decompress request body: %w", err)
 			}
 			return b, nil
 		}
 	}
-	b, err := io.ReadAll(r.Body)
-	return b, err
+
+	if encoding := r.Header.Get("Content-Encoding"); encoding != "" && encoding != "identity" {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedEncoding, encoding)
+	}
+	return readAllLimited(r.Body, d.maxSize)
+}
+
+// AcceptEncoding lists supported request encodings in Accept-Encoding header format, so it can be sent
+// to a client whose request encoding is not supported
+func (d *Decompressor) AcceptEncoding() string {
+	names := make([]string, 0, len(d.engines))
+	for _, engine := range d.engines {
+		names = append(names, engine.Name())
+	}
+	return strings.Join(names, ", ")
 }
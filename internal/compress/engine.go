@@ -1,11 +1,21 @@
 package compress
 
 import (
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
 )
 
+// NoCompression can be used instead of encoding name to disable compression
+const NoCompression = "none"
+
+var (
+	ErrBodyTooLarge = errors.New("body is too large")
+)
+
 var compressableContentTypes = []string{
 	"application/json",
 	"text/html",
@@ -35,22 +45,68 @@ type ReadEngine interface {
 
 	// Applicable determines if engine can be used to read request body
 	Applicable(header http.Header) bool
-	// ReadAll extracts all data from reader decompressing it by underlying algorithm
-	ReadAll(r io.Reader) ([]byte, error)
+	// ReadAll extracts all data from reader decompressing it by underlying algorithm,
+	// ErrBodyTooLarge is returned as soon as decompressed data exceeds maxSize (no limit if it's not positive)
+	ReadAll(r io.Reader, maxSize int64) ([]byte, error)
+}
+
+// readAllLimited reads decompressed data from reader until maxSize is exceeded,
+// so no more than maxSize+1 bytes are ever kept in memory
+func readAllLimited(r io.Reader, maxSize int64) ([]byte, error) {
+	if maxSize <= 0 {
+		return io.ReadAll(r)
+	}
+	data, err := io.ReadAll(io.LimitReader(r, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("%w: more than %d bytes", ErrBodyTooLarge, maxSize)
+	}
+	return data, nil
 }
 
 func checkAcceptEncodingIncludes(header http.Header, encodings ...string) bool {
-	acceptEncoding := header.Get("Accept-Encoding")
-	for _, acceptable := range strings.Split(acceptEncoding, ",") {
-		for _, encoding := range encodings {
-			if encoding == strings.TrimSpace(acceptable) {
-				return true
-			}
+	for _, encoding := range encodings {
+		if acceptEncodingQuality(header, encoding) > 0 {
+			return true
 		}
 	}
 	return false
 }
 
+// acceptEncodingQuality returns a quality value (q-value) of encoding in Accept-Encoding header,
+// explicitly listed encoding takes precedence over "*", zero means that encoding is not acceptable
+func acceptEncodingQuality(header http.Header, encoding string) float64 {
+	wildcard := 0.0
+	for _, acceptable := range strings.Split(header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(acceptable, ";")
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case encoding:
+			return parseQuality(params)
+		case "*":
+			wildcard = parseQuality(params)
+		}
+	}
+	return wildcard
+}
+
+// parseQuality extracts q-value from Accept-Encoding element parameters, malformed values are treated as 1
+func parseQuality(params string) float64 {
+	for _, param := range strings.Split(params, ";") {
+		key, value, ok := strings.Cut(param, "=")
+		if !ok || strings.TrimSpace(key) != "q" {
+			continue
+		}
+		q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || q > 1 {
+			return 1
+		}
+		return max(q, 0)
+	}
+	return 1
+}
+
 func checkContentEncoding(header http.Header, encodings ...string) bool {
 	contentEncoding := header.Get("Content-Encoding")
 	for _, encoding := range encodings {
@@ -71,3 +127,56 @@ func checkContentTypeForCompression(header http.Header) bool {
 	}
 	return false
 }
+
+// encodingResponseWriter compresses response body with a given encoder if its content type is compressable,
+// other responses are written as is
+type encodingResponseWriter struct {
+	http.ResponseWriter
+	encoder  io.WriteCloser
+	encoding string
+	written  bool
+}
+
+func newEncodingResponseWriter(w http.ResponseWriter, encoder io.WriteCloser, encoding string) *encodingResponseWriter {
+	return &encodingResponseWriter{
+		ResponseWriter: w,
+		encoder:        encoder,
+		encoding:       encoding,
+	}
+}
+
+func (erw *encodingResponseWriter) Write(data []byte) (int, error) {
+	if checkContentTypeForCompression(erw.ResponseWriter.Header()) {
+		bcnt, err := erw.encoder.Write(data)
+		if err == nil {
+			erw.written = true
+		}
+		return bcnt, err
+	} else {
+		return erw.ResponseWriter.Write(data)
+	}
+}
+
+func (erw *encodingResponseWriter) WriteHeader(statusCode int) {
+	if checkContentTypeForCompression(erw.ResponseWriter.Header()) {
+		erw.ResponseWriter.Header().Del("Content-Encoding")
+		erw.ResponseWriter.Header().Add("Content-Encoding", erw.encoding)
+	}
+	erw.ResponseWriter.WriteHeader(statusCode)
+}
+
+// releaser is implemented by pooled encoders, they are released when response is complete
+// even if nothing was written to them
+type releaser interface {
+	release()
+}
+
+func (erw *encodingResponseWriter) Close() error {
+	if r, ok := erw.encoder.(releaser); ok {
+		defer r.release()
+	}
+	if !erw.written {
+		return nil
+	}
+	return erw.encoder.Close()
+}
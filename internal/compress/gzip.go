@@ -31,7 +31,7 @@ func (gwe *GzipWriteEngine) NewResponseWriter(w http.ResponseWriter, level int)
 	if err != nil {
 		return nil, fmt.Errorf("error intitalizing gzip writer: %w", err)
 	}
-	return newGzipResponseWriter(w, gw), nil
+	return newEncodingResponseWriter(w, gw, gzipEncoding), nil
 }
 
 func (gwe *GzipWriteEngine) WriteFlushed(data []byte, level int) ([]byte, error) {
@@ -69,13 +69,13 @@ func (gre *GzipReadEngine) Applicable(header http.Header) bool {
 	return checkContentEncoding(header, gzipEncoding)
 }
 
-func (gre *GzipReadEngine) ReadAll(r io.Reader) ([]byte, error) {
+func (gre *GzipReadEngine) ReadAll(r io.Reader, maxSize int64) ([]byte, error) {
 	gz, err := gzip.NewReader(r)
 	if err != nil {
 		return nil, fmt.Errorf("error initializing gzip reader: %w", err)
 	}
 
-	data, err := io.ReadAll(gz)
+	data, err := readAllLimited(gz, maxSize)
 	if err != nil {
 		return nil, fmt.Errorf("error reading gzipped data: %w", err)
 	}
@@ -91,43 +91,3 @@ func normalizeGzipLevel(level int) int {
 	}
 	return level
 }
-
-type gzipResponseWriter struct {
-	http.ResponseWriter
-	gw      *gzip.Writer
-	written bool
-}
-
-func newGzipResponseWriter(w http.ResponseWriter, gw *gzip.Writer) *gzipResponseWriter {
-	return &gzipResponseWriter{
-		ResponseWriter: w,
-		gw:             gw,
-	}
-}
-
-func (grw *gzipResponseWriter) Write(data []byte) (int, error) {
-	if checkContentTypeForCompression(grw.ResponseWriter.Header()) {
-		bcnt, err := grw.gw.Write(data)
-		if err == nil {
-			grw.written = true
-		}
-		return bcnt, err
-	} else {
-		return grw.ResponseWriter.Write(data)
-	}
-}
-
-func (grw *gzipResponseWriter) WriteHeader(statusCode int) {
-	if checkContentTypeForCompression(grw.ResponseWriter.Header()) {
-		grw.ResponseWriter.Header().Del("Content-Encoding")
-		grw.ResponseWriter.Header().Add("Content-Encoding", gzipEncoding)
-	}
-	grw.ResponseWriter.WriteHeader(statusCode)
-}
-
-func (grw *gzipResponseWriter) Close() error {
-	if !grw.written {
-		return nil
-	}
-	return grw.gw.Close()
-}
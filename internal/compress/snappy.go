@@ -0,0 +1,52 @@
+package compress
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/golang/snappy"
+)
+
+const (
+	snappyEncoding = "snappy"
+)
+
+// SnappyReadEngine decodes request bodies compressed with snappy block format
+// as used by Prometheus remote-write protocol (not the framed stream format)
+type SnappyReadEngine struct{}
+
+func NewSnappyReadEngine() *SnappyReadEngine {
+	return &SnappyReadEngine{}
+}
+
+func (sre *SnappyReadEngine) Name() string {
+	return snappyEncoding
+}
+
+func (sre *SnappyReadEngine) Applicable(header http.Header) bool {
+	return checkContentEncoding(header, snappyEncoding)
+}
+
+func (sre *SnappyReadEngine) ReadAll(r io.Reader, maxSize int64) ([]byte, error) {
+	compressed, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("error reading snappy data: %w", err)
+	}
+
+	// snappy block can't be decoded in a streaming way, but its header contains decoded length,
+	// so it's checked before allocating memory for decoded data
+	size, err := snappy.DecodedLen(compressed)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding snappy data: %w", err)
+	}
+	if maxSize > 0 && int64(size) > maxSize {
+		return nil, fmt.Errorf("%w: %d bytes", ErrBodyTooLarge, size)
+	}
+
+	data, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding snappy data: %w", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,129 @@
+package compress
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	zstdEncoding = "zstd"
+)
+
+// ZstdWriteEngine compresses data with Zstandard algorithm. Encoders are expensive to create, so they are
+// reused per compression level: encoders used for WriteFlushed are shared between goroutines
+// (EncodeAll is safe for concurrent use), streaming encoders of responses are pooled and reset for each response
+type ZstdWriteEngine struct {
+	encoders       sync.Map
+	streamEncoders sync.Map
+}
+
+func NewZstdWriteEngine() *ZstdWriteEngine {
+	return &ZstdWriteEngine{}
+}
+
+func (zwe *ZstdWriteEngine) Name() string {
+	return zstdEncoding
+}
+
+func (zwe *ZstdWriteEngine) Applicable(header http.Header) bool {
+	return checkAcceptEncodingIncludes(header, zstdEncoding)
+}
+
+func (zwe *ZstdWriteEngine) NewResponseWriter(w http.ResponseWriter, level int) (CompressedResponseWriter, error) {
+	zlevel := normalizeZstdLevel(level)
+	pool, ok := zwe.streamEncoders.Load(zlevel)
+	if !ok {
+		pool, _ = zwe.streamEncoders.LoadOrStore(zlevel, &sync.Pool{})
+	}
+
+	zp := pool.(*sync.Pool)
+	zw, ok := zp.Get().(*zstd.Encoder)
+	if ok {
+		zw.Reset(w)
+	} else {
+		var err error
+		zw, err = zstd.NewWriter(w, zstd.WithEncoderLevel(zlevel), zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, fmt.Errorf("error intitalizing zstd writer: %w", err)
+		}
+	}
+	return newEncodingResponseWriter(w, &pooledZstdEncoder{Encoder: zw, pool: zp}, zstdEncoding), nil
+}
+
+func (zwe *ZstdWriteEngine) WriteFlushed(data []byte, level int) ([]byte, error) {
+	zlevel := normalizeZstdLevel(level)
+	encoder, ok := zwe.encoders.Load(zlevel)
+	if !ok {
+		zw, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zlevel))
+		if err != nil {
+			return nil, fmt.Errorf("error intitalizing zstd writer: %w", err)
+		}
+		encoder, _ = zwe.encoders.LoadOrStore(zlevel, zw)
+	}
+	return encoder.(*zstd.Encoder).EncodeAll(data, nil), nil
+}
+
+func (zwe *ZstdWriteEngine) SetContentEncoding(header http.Header) {
+	header.Set("Content-Encoding", zstdEncoding)
+}
+
+// pooledZstdEncoder returns encoder to the pool once response is complete
+type pooledZstdEncoder struct {
+	*zstd.Encoder
+	pool *sync.Pool
+}
+
+func (pze *pooledZstdEncoder) release() {
+	// encoder must not keep a reference to response writer while it's idle in the pool
+	pze.Encoder.Reset(nil)
+	pze.pool.Put(pze.Encoder)
+}
+
+type ZstdReadEngine struct{}
+
+func NewZstdReadEngine() *ZstdReadEngine {
+	return &ZstdReadEngine{}
+}
+
+func (zre *ZstdReadEngine) Name() string {
+	return zstdEncoding
+}
+
+func (zre *ZstdReadEngine) Applicable(header http.Header) bool {
+	return checkContentEncoding(header, zstdEncoding)
+}
+
+func (zre *ZstdReadEngine) ReadAll(r io.Reader, maxSize int64) ([]byte, error) {
+	opts := []zstd.DOption{zstd.WithDecoderConcurrency(1), zstd.WithDecoderLowmem(true)}
+	if maxSize > 0 {
+		// frames may declare large window sizes, so decoder memory is limited as well
+		opts = append(opts, zstd.WithDecoderMaxMemory(uint64(maxSize)))
+	}
+	zr, err := zstd.NewReader(r, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("error initializing zstd reader: %w", err)
+	}
+	defer zr.Close()
+
+	data, err := readAllLimited(zr, maxSize)
+	if errors.Is(err, zstd.ErrWindowSizeExceeded) || errors.Is(err, zstd.ErrDecoderSizeExceeded) {
+		return nil, fmt.Errorf("%w: %w", ErrBodyTooLarge, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading zstd data: %w", err)
+	}
+	return data, nil
+}
+
+// normalizeZstdLevel maps level in zstd scale (1-22) to one of encoder levels, default level is used for level <= 0
+func normalizeZstdLevel(level int) zstd.EncoderLevel {
+	if level <= 0 {
+		return zstd.SpeedDefault
+	}
+	return zstd.EncoderLevelFromZstd(level)
+}
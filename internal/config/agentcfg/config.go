@@ -6,18 +6,40 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"dario.cat/mergo"
+	"github.com/andrewsvn/metrics-overseer/internal/encrypt"
 	"github.com/caarlos0/env/v6"
 	flag "github.com/spf13/pflag"
 )
 
+// Transports supported by agent for reporting metrics to server
+const (
+	TransportREST = "rest"
+	TransportGRPC = "grpc"
+)
+
 const (
 	defaultServerAddr        = "http://localhost:8080"
+	defaultGRPCServerAddr    = "localhost:3200"
+	defaultTransport         = TransportREST
 	defaultPollIntervalSec   = 2
 	defaultReportIntervalSec = 10
 	defaultGracePeriodSec    = 30
 	defaultLogLevel          = "info"
+	defaultCompression       = "gzip"
+
+	// host collectors (disk, network, load, swap, proccount, uptime) and process collector
+	// are polled only when enabled explicitly
+	defaultCollectors = "runtime,gopsutil"
+
+	defaultQueueMaxBytes   = 64 << 20
+	defaultQueueMaxAgeSec  = 24 * 60 * 60
+	defaultQueueDropPolicy = "oldest"
 
 	defaultReportMaxRetries             = 3
 	defaultReportInitialRetryDelaySec   = 1
@@ -37,10 +59,151 @@ type ReportingConfig struct {
 	MaxNumberOfRequests int `env:"RATE_LIMIT" json:"rate_limit"`
 }
 
+// TLSConfig contains settings of TLS connection to metrics-overseer server, it's used by both REST (HTTPS)
+// and gRPC transports. Server certificate is verified
+// against CACertPath (or system roots if not specified), client certificate is presented to server for mutual TLS
+// if specified
+type TLSConfig struct {
+	TLSCACertPath string `env:"TLS_CA" json:"tls_ca"`
+	TLSCertPath   string `env:"TLS_CERT" json:"tls_cert"`
+	TLSKeyPath    string `env:"TLS_KEY" json:"tls_key"`
+}
+
+// IsSetUp method checks that agent should connect to server using TLS
+func (tlscfg *TLSConfig) IsSetUp() bool {
+	return tlscfg.TLSCACertPath != "" || tlscfg.TLSCertPath != "" || tlscfg.TLSKeyPath != ""
+}
+
+// QueueConfig contains settings of disk-backed queue of staged metrics batches. Batches are persisted before
+// sending, so they survive agent restarts and server outages. Queue is disabled if QueueDir is not specified
+type QueueConfig struct {
+	QueueDir string `env:"QUEUE_DIR" json:"queue_dir"`
+	// QueueMaxBytes and QueueMaxAgeSec limit total size of queued batches and their age. Zero value
+	// is replaced with the default limit, negative value disables the limit
+	QueueMaxBytes  int64 `env:"QUEUE_MAX_BYTES" json:"queue_max_bytes"`
+	QueueMaxAgeSec int   `env:"QUEUE_MAX_AGE" json:"queue_max_age_sec"`
+	// QueueDropPolicy chooses batches dropped when queue is full: "oldest" or "newest"
+	QueueDropPolicy string `env:"QUEUE_DROP_POLICY" json:"queue_drop_policy"`
+}
+
+// IsSetUp method checks that staged metrics should be persisted to disk queue
+func (qcfg *QueueConfig) IsSetUp() bool {
+	return qcfg.QueueDir != ""
+}
+
+// CollectorsConfig chooses metric collectors polled by agent. Per-collector settings are given as name:value pairs
+type CollectorsConfig struct {
+	// EnabledCollectors lists names of polled collectors, collectors from DisabledCollectors are excluded from it
+	EnabledCollectors  []string `env:"COLLECTORS" json:"collectors"`
+	DisabledCollectors []string `env:"DISABLED_COLLECTORS" json:"disabled_collectors"`
+	// CollectorIntervals overrides poll interval in seconds for collectors, e.g. "gopsutil:30"
+	CollectorIntervals []string `env:"COLLECTOR_INTERVALS" json:"collector_intervals"`
+	// CollectorPrefixes sets prefixes of metric IDs reported by collectors, e.g. "gopsutil:host."
+	CollectorPrefixes []string `env:"COLLECTOR_PREFIXES" json:"collector_prefixes"`
+	// WatchedProcesses selects processes reported by process collector in name:kind=pattern form,
+	// e.g. "nginx:pidfile=/run/nginx.pid", "db:name=^postgres$" or "api:cmdline=gunicorn".
+	// Patterns may contain commas (e.g. regexp "^worker-[0-9]{1,3}$"), so selectors are separated by newlines
+	// in environment variable and the flag is repeated for each selector instead of splitting its value
+	WatchedProcesses []string `env:"WATCH_PROCESSES" envSeparator:"\n" json:"watch_processes"`
+}
+
+const (
+	// ProcessByPIDFile selects the process with PID read from a file
+	ProcessByPIDFile = "pidfile"
+	// ProcessByName selects processes with names matching a regular expression
+	ProcessByName = "name"
+	// ProcessByCmdline selects processes with command lines containing a substring
+	ProcessByCmdline = "cmdline"
+)
+
+// ProcessSelector describes a watched process, its metrics are named after Name
+type ProcessSelector struct {
+	Name    string
+	Kind    string
+	Pattern string
+}
+
+// Collectors returns names of enabled collectors except disabled ones
+func (ccfg *CollectorsConfig) Collectors() []string {
+	names := make([]string, 0, len(ccfg.EnabledCollectors))
+	for _, name := range ccfg.EnabledCollectors {
+		if !slices.Contains(ccfg.DisabledCollectors, name) && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Intervals parses CollectorIntervals to poll intervals by collector name
+func (ccfg *CollectorsConfig) Intervals() (map[string]time.Duration, error) {
+	pairs, err := parseNamedValues(ccfg.CollectorIntervals)
+	if err != nil {
+		return nil, err
+	}
+	intervals := make(map[string]time.Duration, len(pairs))
+	for name, value := range pairs {
+		sec, err := strconv.Atoi(value)
+		if err != nil || sec <= 0 {
+			return nil, fmt.Errorf("invalid poll interval of collector %s: %s", name, value)
+		}
+		intervals[name] = time.Duration(sec) * time.Second
+	}
+	return intervals, nil
+}
+
+// Prefixes parses CollectorPrefixes to metric ID prefixes by collector name
+func (ccfg *CollectorsConfig) Prefixes() (map[string]string, error) {
+	return parseNamedValues(ccfg.CollectorPrefixes)
+}
+
+// ProcessSelectors parses WatchedProcesses, selectors are ordered by name. Metrics of a watched process
+// are named after its selector, so selectors with the same name are rejected
+func (ccfg *CollectorsConfig) ProcessSelectors() ([]ProcessSelector, error) {
+	selectors := make([]ProcessSelector, 0, len(ccfg.WatchedProcesses))
+	for _, pair := range ccfg.WatchedProcesses {
+		name, value, ok := strings.Cut(pair, ":")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid watched process %q, name:kind=pattern expected", pair)
+		}
+		if slices.ContainsFunc(selectors, func(sel ProcessSelector) bool { return sel.Name == name }) {
+			return nil, fmt.Errorf("duplicate name of watched process: %s", name)
+		}
+		kind, pattern, ok := strings.Cut(value, "=")
+		if !ok || pattern == "" {
+			return nil, fmt.Errorf("invalid selector of watched process %s: %s", name, value)
+		}
+		switch kind {
+		case ProcessByPIDFile, ProcessByName, ProcessByCmdline:
+		default:
+			return nil, fmt.Errorf("unknown selector kind of watched process %s: %s", name, kind)
+		}
+		selectors = append(selectors, ProcessSelector{Name: name, Kind: kind, Pattern: pattern})
+	}
+	slices.SortFunc(selectors, func(a, b ProcessSelector) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return selectors, nil
+}
+
+func parseNamedValues(pairs []string) (map[string]string, error) {
+	values := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		name, value, ok := strings.Cut(pair, ":")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid collector setting %q, name:value expected", pair)
+		}
+		values[name] = value
+	}
+	return values, nil
+}
+
 // Config embeds all agent configuration properties to be set by env.Parse or flag.Parse and be used in agent code
 type Config struct {
 	ReportingConfig
 	ReportRetryConfig
+	TLSConfig
+	QueueConfig
+	CollectorsConfig
 
 	ServerAddr        string `env:"ADDRESS" json:"address"`
 	PollIntervalSec   int    `env:"POLL_INTERVAL" json:"poll_interval_sec"`
@@ -49,6 +212,28 @@ type Config struct {
 	SecretKey         string `env:"KEY" json:"key"`
 	PublicKeyPath     string `env:"CRYPTO_KEY" json:"crypto_key"`
 	LogLevel          string `env:"AGENT_LOG_LEVEL" json:"agent_log_level"`
+	// SecretKeyID is an identifier of SecretKey sent with signatures, so server can pick a matching key
+	SecretKeyID string `env:"KEY_ID" json:"key_id"`
+	// APIToken is a named client token passed to server in Authorization header (or metadata for gRPC)
+	APIToken string `env:"API_TOKEN" json:"api_token"`
+	// AgentID identifies agent in batch IDs, server uses them to apply each batch only once.
+	// Host name is used if not specified
+	AgentID string `env:"AGENT_ID" json:"agent_id"`
+
+	// Transport selects API used for reporting: TransportREST (ServerAddr is used)
+	// or TransportGRPC (GRPCServerAddr is used)
+	Transport      string `env:"TRANSPORT" json:"transport"`
+	GRPCServerAddr string `env:"GRPC_ADDRESS" json:"grpc_address"`
+	// Compression is an algorithm used for compressing REST request bodies: gzip, zstd, br or none
+	Compression string `env:"COMPRESSION" json:"compression"`
+
+	// StatusAddr is a local address of agent status endpoints (/status and /healthz),
+	// endpoints are disabled if not specified
+	StatusAddr string `env:"STATUS_ADDRESS" json:"status_address"`
+
+	// SummaryMetrics lists polled gauge metric IDs which are reported as summaries (quantile sketches)
+	// instead of values averaged between reports
+	SummaryMetrics []string `env:"SUMMARY_METRICS" json:"summary_metrics"`
 
 	ConfigFile string `env:"AGENT_CONFIG"`
 }
@@ -86,6 +271,16 @@ func Read() (*Config, error) {
 func (cfg *Config) bindFlags() {
 	flag.StringVarP(&cfg.ServerAddr, "addr", "a", "",
 		fmt.Sprintf("server address in form of host:port (default: %s)", defaultServerAddr))
+	flag.StringVar(&cfg.Transport, "transport", "",
+		fmt.Sprintf("transport for reporting metrics: %s or %s (default: %s)", TransportREST, TransportGRPC, defaultTransport))
+	flag.StringVar(&cfg.GRPCServerAddr, "grpc-addr", "",
+		fmt.Sprintf("server gRPC API address in form of host:port (default: %s)", defaultGRPCServerAddr))
+	flag.StringVar(&cfg.Compression, "compression", "",
+		fmt.Sprintf("request compression algorithm: gzip, zstd, br or none (default: %s)", defaultCompression))
+	flag.StringVar(&cfg.AgentID, "agent-id", "",
+		"agent identifier used in IDs of reported batches (default: host name)")
+	flag.StringVar(&cfg.StatusAddr, "status-addr", "",
+		"agent status endpoints address in form of host:port (status endpoints disabled if not specified)")
 	flag.IntVarP(&cfg.PollIntervalSec, "poll-interval", "p", 0,
 		fmt.Sprintf("accumulation polling interval, seconds (default: %d)", defaultPollIntervalSec))
 	flag.IntVarP(&cfg.ReportIntervalSec, "report-interval", "r", 0,
@@ -97,10 +292,46 @@ func (cfg *Config) bindFlags() {
 		fmt.Sprintf("maximum number of simultaneous reporting requests (default: 0). "+
 			"If 0, single-thread batching is used"))
 
+	flag.StringVar(&cfg.QueueDir, "queue-dir", "",
+		"directory of disk queue for metrics batches (queue disabled if not specified)")
+	flag.Int64Var(&cfg.QueueMaxBytes, "queue-max-bytes", 0,
+		fmt.Sprintf("maximum total size of queued batches in bytes, negative for no limit (default: %d)",
+			defaultQueueMaxBytes))
+	flag.IntVar(&cfg.QueueMaxAgeSec, "queue-max-age", 0,
+		fmt.Sprintf("maximum age of queued batch in seconds, negative for no limit (default: %d)",
+			defaultQueueMaxAgeSec))
+	flag.StringVar(&cfg.QueueDropPolicy, "queue-drop-policy", "",
+		fmt.Sprintf("batches dropped when queue is full: oldest or newest (default: %s)", defaultQueueDropPolicy))
+
+	flag.StringSliceVar(&cfg.EnabledCollectors, "collectors", nil,
+		fmt.Sprintf("comma-separated list of polled collectors (default: %s)", defaultCollectors))
+	flag.StringSliceVar(&cfg.DisabledCollectors, "disabled-collectors", nil,
+		"comma-separated list of collectors excluded from polled ones")
+	flag.StringSliceVar(&cfg.CollectorIntervals, "collector-intervals", nil,
+		"comma-separated list of collector poll intervals in name:seconds form (default: poll interval)")
+	flag.StringSliceVar(&cfg.CollectorPrefixes, "collector-prefixes", nil,
+		"comma-separated list of collector metric ID prefixes in name:prefix form")
+	flag.StringArrayVar(&cfg.WatchedProcesses, "watch-processes", nil,
+		"process watched by process collector in name:kind=pattern form, kind is one of pidfile, "+
+			"name (regexp) or cmdline (substring); repeat the flag for each process")
+
+	flag.StringSliceVar(&cfg.SummaryMetrics, "summary-metrics", nil,
+		"comma-separated list of gauge metric IDs to be reported as summaries with quantiles")
+
 	flag.StringVarP(&cfg.SecretKey, "secret-key", "k", "",
 		"secret key for request signing")
+	flag.StringVar(&cfg.SecretKeyID, "secret-key-id", "",
+		fmt.Sprintf("identifier of secret key sent with signatures (default: %s)", encrypt.DefaultKeyID))
 	flag.StringVar(&cfg.PublicKeyPath, "crypto-key", "",
-		"path to PEM file with RSA public key for encrypting requests (no encryption if empty)")
+		"path to PEM file with RSA or X25519 public key for encrypting requests (no encryption if empty)")
+	flag.StringVar(&cfg.TLSCACertPath, "tls-ca", "",
+		"path to PEM file with CA for server certificate verification (system roots are used if empty)")
+	flag.StringVar(&cfg.TLSCertPath, "tls-cert", "",
+		"path to PEM file with client TLS certificate for mutual TLS")
+	flag.StringVar(&cfg.TLSKeyPath, "tls-key", "",
+		"path to PEM file with client TLS private key")
+	flag.StringVar(&cfg.APIToken, "api-token", "",
+		"API token for server authentication (not sent if empty)")
 
 	flag.StringVarP(&cfg.ConfigFile, "config", "c", "", "path to JSON config file with default configuration")
 }
@@ -127,11 +358,35 @@ func NewDefaultConfig() *Config {
 			InitialRetryDelaySec:   defaultReportInitialRetryDelaySec,
 			RetryDelayIncrementSec: defaultReportRetryDelayIncrementSec,
 		},
+		CollectorsConfig: CollectorsConfig{
+			EnabledCollectors: strings.Split(defaultCollectors, ","),
+		},
+		QueueConfig: QueueConfig{
+			QueueMaxBytes:   defaultQueueMaxBytes,
+			QueueMaxAgeSec:  defaultQueueMaxAgeSec,
+			QueueDropPolicy: defaultQueueDropPolicy,
+		},
 		ServerAddr:        defaultServerAddr,
+		Transport:         defaultTransport,
+		GRPCServerAddr:    defaultGRPCServerAddr,
+		Compression:       defaultCompression,
 		PollIntervalSec:   defaultPollIntervalSec,
 		ReportIntervalSec: defaultReportIntervalSec,
 		GracePeriodSec:    defaultGracePeriodSec,
 		LogLevel:          defaultLogLevel,
+		SecretKeyID:       encrypt.DefaultKeyID,
 	}
 	return cfg
 }
+
+// KeyRing creates a key ring for request signing from secret key and its identifier,
+// the ring can't sign anything if secret key is empty
+func (cfg *Config) KeyRing() (*encrypt.KeyRing, error) {
+	keys, err := encrypt.NewKeyRingBuilder().
+		PrimaryKey(cfg.SecretKeyID, cfg.SecretKey).
+		Build()
+	if err != nil {
+		return nil, fmt.Errorf("invalid signing key configuration: %w", err)
+	}
+	return keys, nil
+}
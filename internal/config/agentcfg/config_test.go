@@ -4,8 +4,10 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"dario.cat/mergo"
+	"github.com/caarlos0/env/v6"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -18,7 +20,22 @@ var config string = `{
 "poll_interval_sec": 1,
 "report_interval_sec": 6,
 "grace_period_sec": 20,
-"crypto_key": "path/to/public_key"
+"crypto_key": "path/to/public_key",
+"api_token": "agent-token",
+"agent_id": "web-1",
+"key_id": "agent-2025",
+"tls_ca": "path/to/ca.pem",
+"summary_metrics": ["Alloc", "HeapInuse"],
+"transport": "grpc",
+"compression": "zstd",
+"status_address": "localhost:9090",
+"queue_dir": "/var/lib/agent/queue",
+"queue_max_bytes": 1048576,
+"collectors": ["runtime", "gopsutil"],
+"disabled_collectors": ["gopsutil"],
+"collector_intervals": ["runtime:5"],
+"collector_prefixes": ["runtime:go."],
+"watch_processes": ["nginx:pidfile=/run/nginx.pid", "db:name=^postgres$"]
 }`
 
 func TestJSONAndDefaultConfigs(t *testing.T) {
@@ -32,11 +49,38 @@ func TestJSONAndDefaultConfigs(t *testing.T) {
 	assert.Equal(t, 20, jsonConfig.GracePeriodSec)
 	assert.Equal(t, "", jsonConfig.SecretKey)
 	assert.Equal(t, "path/to/public_key", jsonConfig.PublicKeyPath)
+	assert.Equal(t, "agent-token", jsonConfig.APIToken)
+	assert.Equal(t, "web-1", jsonConfig.AgentID)
+	assert.Equal(t, "agent-2025", jsonConfig.SecretKeyID)
+	assert.Equal(t, "path/to/ca.pem", jsonConfig.TLSCACertPath)
+	assert.True(t, jsonConfig.TLSConfig.IsSetUp())
 	assert.Equal(t, 5, jsonConfig.MaxRetryCount)
 	assert.Equal(t, 2, jsonConfig.InitialRetryDelaySec)
 	assert.Equal(t, 0, jsonConfig.RetryDelayIncrementSec)
 	assert.Equal(t, 1, jsonConfig.PollIntervalSec)
 	assert.Equal(t, 6, jsonConfig.ReportIntervalSec)
+	assert.Equal(t, []string{"Alloc", "HeapInuse"}, jsonConfig.SummaryMetrics)
+	assert.Equal(t, "localhost:9090", jsonConfig.StatusAddr)
+	assert.Equal(t, "/var/lib/agent/queue", jsonConfig.QueueDir)
+	assert.Equal(t, int64(1048576), jsonConfig.QueueMaxBytes)
+	assert.Equal(t, 0, jsonConfig.QueueMaxAgeSec)
+	assert.True(t, jsonConfig.QueueConfig.IsSetUp())
+	assert.Equal(t, []string{"runtime"}, jsonConfig.Collectors())
+	intervals, err := jsonConfig.Intervals()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]time.Duration{"runtime": 5 * time.Second}, intervals)
+	prefixes, err := jsonConfig.Prefixes()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"runtime": "go."}, prefixes)
+	selectors, err := jsonConfig.ProcessSelectors()
+	require.NoError(t, err)
+	assert.Equal(t, []ProcessSelector{
+		{Name: "db", Kind: ProcessByName, Pattern: "^postgres$"},
+		{Name: "nginx", Kind: ProcessByPIDFile, Pattern: "/run/nginx.pid"},
+	}, selectors)
+	assert.Equal(t, TransportGRPC, jsonConfig.Transport)
+	assert.Equal(t, "", jsonConfig.GRPCServerAddr)
+	assert.Equal(t, "zstd", jsonConfig.Compression)
 
 	initialConfig := &Config{
 		ServerAddr:     "localhost:10000",
@@ -57,6 +101,39 @@ func TestJSONAndDefaultConfigs(t *testing.T) {
 	assert.Equal(t, 0, initialConfig.RetryDelayIncrementSec)
 	assert.Equal(t, 1, initialConfig.PollIntervalSec)
 	assert.Equal(t, 6, initialConfig.ReportIntervalSec)
+
+	assert.Equal(t, []string{"runtime", "gopsutil"}, NewDefaultConfig().Collectors())
+
+	// zero queue limits are replaced with defaults, negative ones are kept to disable the limits
+	require.NoError(t, mergo.Merge(initialConfig, NewDefaultConfig()))
+	assert.Equal(t, int64(1048576), initialConfig.QueueMaxBytes)
+	assert.Equal(t, defaultQueueMaxAgeSec, initialConfig.QueueMaxAgeSec)
+	unlimited := &Config{QueueConfig: QueueConfig{QueueMaxBytes: -1, QueueMaxAgeSec: -1}}
+	require.NoError(t, mergo.Merge(unlimited, NewDefaultConfig()))
+	assert.Equal(t, int64(-1), unlimited.QueueMaxBytes)
+	assert.Equal(t, -1, unlimited.QueueMaxAgeSec)
+}
+
+func TestProcessSelectors(t *testing.T) {
+	// patterns with commas are not split, selectors are separated by newlines in environment variable
+	t.Setenv("WATCH_PROCESSES", "worker:name=^worker-[0-9]{1,3}$\napi:cmdline=gunicorn --workers 2,3")
+	cfg := &Config{}
+	require.NoError(t, env.Parse(cfg))
+	selectors, err := cfg.ProcessSelectors()
+	require.NoError(t, err)
+	assert.Equal(t, []ProcessSelector{
+		{Name: "api", Kind: ProcessByCmdline, Pattern: "gunicorn --workers 2,3"},
+		{Name: "worker", Kind: ProcessByName, Pattern: "^worker-[0-9]{1,3}$"},
+	}, selectors)
+
+	// metrics of selectors with the same name would be mixed up
+	cfg.WatchedProcesses = []string{"db:name=^postgres$", "db:pidfile=/run/postgres.pid"}
+	_, err = cfg.ProcessSelectors()
+	assert.ErrorContains(t, err, "duplicate")
+
+	cfg.WatchedProcesses = []string{"name=^postgres$"}
+	_, err = cfg.ProcessSelectors()
+	assert.Error(t, err)
 }
 
 func prepareConfigFile(t *testing.T) string {
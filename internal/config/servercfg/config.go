@@ -6,10 +6,12 @@ package servercfg
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 
 	"dario.cat/mergo"
+	"github.com/andrewsvn/metrics-overseer/internal/encrypt"
 	"github.com/caarlos0/env/v6"
 	flag "github.com/spf13/pflag"
 )
@@ -21,6 +23,14 @@ const (
 	defaultGracePeriodSec        = 30
 	defaultStoreIntervalSec      = 300
 	defaultRestoreOnStartup      = false
+	defaultHistorySize           = 1000
+	defaultStatsDMaxPacketSize   = 8192
+	defaultTokensReloadInterval  = 60
+	defaultSignatureMaxAgeSec    = 300
+	defaultNonceCacheSize        = 100000
+	defaultMaxBodySize           = 4 << 20
+	defaultMaxDecompressedSize   = 32 << 20
+	defaultSelfMetricsInterval   = 10
 
 	defaultPGMaxRetryCount       = 3
 	defaultPGInitialRetryDelay   = 1
@@ -32,6 +42,9 @@ type FileStorageConfig struct {
 	StorageFilePath  string `env:"FILE_STORAGE_PATH" json:"file_storage_path"`
 	StoreIntervalSec int    `env:"STORE_INTERVAL" json:"store_interval"`
 	RestoreOnStartup bool   `env:"RESTORE" json:"restore"`
+	// HistorySize is a number of last samples kept in memory for each metric series (history is not stored to file).
+	// Zero value is replaced with the default size, negative value disables history
+	HistorySize int `env:"HISTORY_SIZE" json:"history_size"`
 }
 
 // IsSetUp method checks that file storage mode can be chosen on server start - if not,
@@ -64,6 +77,86 @@ type PostgresRetryConfig struct {
 type SecurityConfig struct {
 	SecretKey      string `env:"KEY" json:"key"`
 	PrivateKeyPath string `env:"CRYPTO_KEY" json:"crypto_key"`
+	// X25519PrivateKeyPath is a path to X25519 private key, server accepts requests encrypted
+	// with both RSA and X25519 engines if both keys are specified
+	X25519PrivateKeyPath string `env:"CRYPTO_KEY_X25519" json:"crypto_key_x25519"`
+	// ResponseKeys are client public keys in form of "<id>:<path>" for encrypting responses,
+	// clients may refer to them by id instead of passing their public key with each request
+	ResponseKeys []string `env:"RESPONSE_KEYS" json:"response_keys"`
+	// SecretKeyID is an identifier of SecretKey used in signatures made by server
+	SecretKeyID string `env:"KEY_ID" json:"key_id"`
+	// SigningKeys are additional keys in form of "<id>:<secret>" accepted for signature verification,
+	// they allow rotating secret keys of clients without downtime
+	SigningKeys []string `env:"SIGNING_KEYS" json:"signing_keys"`
+	// LegacySignaturesUntil is a deadline in RFC3339 format for accepting signatures made with legacy hash scheme
+	// (without key id). Legacy signatures leak the secret key, so they are rejected if deadline is not specified
+	LegacySignaturesUntil string `env:"LEGACY_SIGNATURES_UNTIL" json:"legacy_signatures_until"`
+	// TrustedSubnets is a list of subnets in CIDR notation allowed to update metrics, any client is allowed if empty
+	TrustedSubnets []string `env:"TRUSTED_SUBNET" json:"trusted_subnet"`
+	// StrictSignatures requires secured requests to be signed with timestamp and nonce if secret key is set,
+	// otherwise unsigned requests and legacy signatures covering payload only are accepted
+	StrictSignatures bool `env:"STRICT_SIGNATURES" json:"strict_signatures"`
+	// SignatureMaxAgeSec is a maximum allowed difference between signature timestamp and server time
+	SignatureMaxAgeSec int `env:"SIGNATURE_MAX_AGE" json:"signature_max_age_sec"`
+	// NonceCacheSize is a number of remembered signature nonces used to reject replayed requests
+	NonceCacheSize int `env:"NONCE_CACHE_SIZE" json:"nonce_cache_size"`
+	// MaxBodySize is a maximum size of request body in bytes as it is sent (compressed and encrypted)
+	MaxBodySize int64 `env:"MAX_BODY_SIZE" json:"max_body_size"`
+	// MaxDecompressedBodySize is a maximum size of request body in bytes after decompression,
+	// it protects server from bodies with a high compression ratio ("zip bombs")
+	MaxDecompressedBodySize int64 `env:"MAX_DECOMPRESSED_BODY_SIZE" json:"max_decompressed_body_size"`
+}
+
+// KeyRing builds signing key ring from security settings: SecretKey is a primary key used for signing,
+// SigningKeys are accepted for verification only
+func (scfg *SecurityConfig) KeyRing() (*encrypt.KeyRing, error) {
+	keys, err := encrypt.NewKeyRingBuilder().
+		PrimaryKey(scfg.SecretKeyID, scfg.SecretKey).
+		Keys(scfg.SigningKeys...).
+		LegacyUntil(scfg.LegacySignaturesUntil).
+		Build()
+	if err != nil {
+		return nil, fmt.Errorf("error building signing key ring: %w", err)
+	}
+	return keys, nil
+}
+
+// TLSConfig contains settings of TLS for server REST (HTTPS) and gRPC APIs. Clients must present a certificate
+// signed by ClientCAPath if it is specified (mutual TLS), plain connections are served if TLS is not configured
+type TLSConfig struct {
+	TLSCertPath     string `env:"TLS_CERT" json:"tls_cert"`
+	TLSKeyPath      string `env:"TLS_KEY" json:"tls_key"`
+	TLSClientCAPath string `env:"TLS_CLIENT_CA" json:"tls_client_ca"`
+}
+
+// IsSetUp method checks that server should serve HTTPS, client CA alone enables it as well,
+// so that mutual TLS is never silently turned off by a missing certificate
+func (tlscfg *TLSConfig) IsSetUp() bool {
+	return tlscfg.TLSCertPath != "" || tlscfg.TLSKeyPath != "" || tlscfg.TLSClientCAPath != ""
+}
+
+// Validate checks that both certificate and private key are specified if TLS is set up
+func (tlscfg *TLSConfig) Validate() error {
+	if tlscfg.IsSetUp() && (tlscfg.TLSCertPath == "" || tlscfg.TLSKeyPath == "") {
+		return errors.New("both server TLS certificate and private key must be specified " +
+			"if TLS or client CA is configured")
+	}
+	return nil
+}
+
+// TokensConfig contains settings of per-client API tokens. Tokens are read either from a JSON file or
+// from postgres database, token checks are disabled if neither source is specified
+type TokensConfig struct {
+	TokensFilePath string `env:"API_TOKENS_FILE" json:"api_tokens_file"`
+	// TokensFromDB enables reading tokens from API_TOKENS table, it requires database storage to be set up
+	TokensFromDB bool `env:"API_TOKENS_DB" json:"api_tokens_db"`
+	// TokensReloadIntervalSec is a period of re-reading tokens, they are also reloaded on SIGHUP
+	TokensReloadIntervalSec int `env:"API_TOKENS_RELOAD_INTERVAL" json:"api_tokens_reload_interval"`
+}
+
+// IsSetUp method checks that API tokens should be checked
+func (tcfg *TokensConfig) IsSetUp() bool {
+	return tcfg.TokensFilePath != "" || tcfg.TokensFromDB
 }
 
 // AuditConfig contains settings related to audit of metrics updates - it can be forwarded to a file and/or
@@ -74,18 +167,40 @@ type AuditConfig struct {
 	AuditURL                  string `env:"AUDIT_URL" json:"audit_url"`
 }
 
+// StatsDConfig contains settings of UDP listener accepting metrics in StatsD format
+type StatsDConfig struct {
+	// StatsDAddr is a UDP address of StatsD listener, listener is disabled if not specified.
+	// StatsD packets are not authenticated (only trusted subnets are checked), so it should be a localhost address
+	StatsDAddr          string `env:"STATSD_ADDRESS" json:"statsd_address"`
+	StatsDMaxPacketSize int    `env:"STATSD_MAX_PACKET_SIZE" json:"statsd_max_packet_size"`
+}
+
+// IsSetUp method checks that StatsD listener should be started
+func (sdcfg *StatsDConfig) IsSetUp() bool {
+	return sdcfg.StatsDAddr != ""
+}
+
 // Config embeds all server configuration properties to be set by env.Parse or flag.Parse and be used in server code
 type Config struct {
 	FileStorageConfig
 	DatabaseConfig
 	PostgresRetryConfig
 	SecurityConfig
+	TLSConfig
+	TokensConfig
 	AuditConfig
+	StatsDConfig
 
 	LogLevel       string `env:"SERVER_LOG_LEVEL" json:"server_log_level"`
 	Addr           string `env:"ADDRESS" json:"address"`
 	GracePeriodSec int    `env:"SERVER_GRACE_PERIOD" json:"server_grace_period"`
 	PprofAddr      string `env:"PPROF_ADDRESS" json:"pprof_address"`
+	// GRPCAddr is an address of gRPC API, it must be different from HTTP server address.
+	// gRPC API is disabled if not specified
+	GRPCAddr string `env:"GRPC_ADDRESS" json:"grpc_address"`
+	// SelfMetricsIntervalSec is a period of writing server self-metrics to storage,
+	// they are always available without delay on /metrics/self endpoint
+	SelfMetricsIntervalSec int `env:"SELF_METRICS_INTERVAL" json:"self_metrics_interval"`
 
 	// ConfigFile specifies path to application config in JSON format, if specified
 	// it will be parsed to extract mapping that can be used if neither flag nor environment variable is not specified
@@ -118,6 +233,9 @@ func Read() (*Config, error) {
 		return nil, fmt.Errorf("unable to merge server configs: %w", err)
 	}
 
+	if err = cfg.TLSConfig.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid TLS configuration: %w", err)
+	}
 	return cfg, nil
 }
 
@@ -129,6 +247,19 @@ func (cfg *Config) bindFlags() {
 	flag.StringVar(&cfg.PprofAddr, "pprof", "",
 		"pprof endpoints address in form of host:port, must be different from server address "+
 			"(pprof disabled if not specified)")
+	flag.StringVar(&cfg.GRPCAddr, "grpc-addr", "",
+		"gRPC API address in form of host:port, must be different from server address "+
+			"(gRPC disabled if not specified)")
+	flag.IntVar(&cfg.SelfMetricsIntervalSec, "self-metrics-interval", 0,
+		fmt.Sprintf("interval in seconds of writing server self-metrics to storage (default: %d)",
+			defaultSelfMetricsInterval))
+
+	flag.StringVar(&cfg.StatsDAddr, "statsd-addr", "",
+		"StatsD UDP listener address in form of host:port, should be a localhost address "+
+			"since packets are not authenticated (StatsD disabled if not specified)")
+	flag.IntVar(&cfg.StatsDMaxPacketSize, "statsd-max-packet-size", 0,
+		fmt.Sprintf("max size of StatsD UDP packet in bytes, larger packets are truncated (default: %d)",
+			defaultStatsDMaxPacketSize))
 
 	flag.StringVarP(&cfg.StorageFilePath, "store-file", "f", "",
 		"metrics storage file path (should be specified to enable file storage)")
@@ -136,14 +267,52 @@ func (cfg *Config) bindFlags() {
 		"metrics storing interval in seconds (0 for synchronous store)")
 	flag.BoolVarP(&cfg.RestoreOnStartup, "restore", "r", false,
 		"flag for restoring metrics on startup")
+	flag.IntVar(&cfg.HistorySize, "history-size", 0,
+		fmt.Sprintf("number of samples kept in memory for each metric series, negative to disable history (default: %d)",
+			defaultHistorySize))
 
 	flag.StringVarP(&cfg.DBConnString, "database-dsn", "d", "",
 		"postgres database connection string (should be specified to enable postgres storage)")
 
 	flag.StringVarP(&cfg.SecretKey, "secret-key", "k", "",
 		"secret key for verifying requests and signing responses")
+	flag.StringVar(&cfg.SecretKeyID, "secret-key-id", "",
+		fmt.Sprintf("identifier of secret key used in signatures (default: %s)", encrypt.DefaultKeyID))
+	flag.StringSliceVar(&cfg.SigningKeys, "signing-keys", nil,
+		"comma-separated list of additional keys in form of <id>:<secret> accepted for signature verification")
+	flag.StringVar(&cfg.LegacySignaturesUntil, "legacy-signatures-until", "",
+		"deadline in RFC3339 format for accepting signatures without key id (rejected if not specified)")
+	flag.StringVar(&cfg.TLSCertPath, "tls-cert", "",
+		"path to PEM file with server TLS certificate (HTTPS disabled if not specified)")
+	flag.StringVar(&cfg.TLSKeyPath, "tls-key", "",
+		"path to PEM file with server TLS private key")
+	flag.StringVar(&cfg.TLSClientCAPath, "tls-client-ca", "",
+		"path to PEM file with CA for client certificates verification (client certificates not required if empty)")
 	flag.StringVar(&cfg.PrivateKeyPath, "crypto-key", "",
 		"path to PEM file with RSA private key for decrypting requests (no decryption if empty)")
+	flag.StringVar(&cfg.X25519PrivateKeyPath, "crypto-key-x25519", "",
+		"path to PEM file with X25519 private key for decrypting requests (no decryption if empty)")
+	flag.StringSliceVar(&cfg.ResponseKeys, "response-keys", nil,
+		"comma-separated list of client public keys in form of <id>:<path> for encrypting responses")
+	flag.StringSliceVarP(&cfg.TrustedSubnets, "trusted-subnet", "t", nil,
+		"comma-separated list of subnets in CIDR notation allowed to update metrics (no restriction if empty)")
+	flag.BoolVar(&cfg.StrictSignatures, "strict-signatures", false,
+		"reject unsigned requests and signatures without timestamp and nonce if secret key is specified")
+	flag.IntVar(&cfg.SignatureMaxAgeSec, "signature-max-age", 0,
+		fmt.Sprintf("maximum age of request signature in seconds (default: %d)", defaultSignatureMaxAgeSec))
+	flag.IntVar(&cfg.NonceCacheSize, "nonce-cache-size", 0,
+		fmt.Sprintf("number of remembered request nonces for replay protection (default: %d)", defaultNonceCacheSize))
+	flag.Int64Var(&cfg.MaxBodySize, "max-body-size", 0,
+		fmt.Sprintf("maximum size of request body in bytes as it is sent (default: %d)", defaultMaxBodySize))
+	flag.Int64Var(&cfg.MaxDecompressedBodySize, "max-decompressed-body-size", 0,
+		fmt.Sprintf("maximum size of request body in bytes after decompression (default: %d)", defaultMaxDecompressedSize))
+
+	flag.StringVar(&cfg.TokensFilePath, "api-tokens-file", "",
+		"path to JSON file with API tokens (should be specified to enable token checks)")
+	flag.BoolVar(&cfg.TokensFromDB, "api-tokens-db", false,
+		"read API tokens from postgres database (should be specified to enable token checks)")
+	flag.IntVar(&cfg.TokensReloadIntervalSec, "api-tokens-reload-interval", 0,
+		fmt.Sprintf("API tokens reload interval in seconds (default: %d)", defaultTokensReloadInterval))
 
 	flag.StringVar(&cfg.AuditFilePath, "audit-file", "",
 		"audit file path (should be specified to enable file audit)")
@@ -173,18 +342,35 @@ func NewDefaultConfig() *Config {
 		FileStorageConfig: FileStorageConfig{
 			StoreIntervalSec: defaultStoreIntervalSec,
 			RestoreOnStartup: defaultRestoreOnStartup,
+			HistorySize:      defaultHistorySize,
 		},
 		DatabaseConfig: DatabaseConfig{},
+		SecurityConfig: SecurityConfig{
+			SecretKeyID:        encrypt.DefaultKeyID,
+			SignatureMaxAgeSec: defaultSignatureMaxAgeSec,
+			NonceCacheSize:     defaultNonceCacheSize,
+
+			MaxBodySize:             defaultMaxBodySize,
+			MaxDecompressedBodySize: defaultMaxDecompressedSize,
+		},
 		PostgresRetryConfig: PostgresRetryConfig{
 			MaxRetryCount:          defaultPGMaxRetryCount,
 			InitialRetryDelaySec:   defaultPGInitialRetryDelay,
 			RetryDelayIncrementSec: defaultPGRetryDelayIncrement,
 		},
+		TokensConfig: TokensConfig{
+			TokensReloadIntervalSec: defaultTokensReloadInterval,
+		},
 		AuditConfig: AuditConfig{
 			AuditFileWriteIntervalSec: defaultAuditWriteIntervalSec,
 		},
+		StatsDConfig: StatsDConfig{
+			StatsDMaxPacketSize: defaultStatsDMaxPacketSize,
+		},
 		Addr:           defaultAddr,
 		LogLevel:       defaultServerLogLevel,
 		GracePeriodSec: defaultGracePeriodSec,
+
+		SelfMetricsIntervalSec: defaultSelfMetricsInterval,
 	}
 }
@@ -35,14 +35,9 @@ func aesEncrypt(data []byte) (*aesEncrypted, error) {
 		return nil, fmt.Errorf("error generating AES key bytes: %w", err)
 	}
 
-	aesBlock, err := aes.NewCipher(enc.Key)
+	aesGCM, err := newAESGCM(enc.Key)
 	if err != nil {
-		return nil, fmt.Errorf("error creating AES cipher %w", err)
-	}
-
-	aesGCM, err := cipher.NewGCM(aesBlock)
-	if err != nil {
-		return nil, fmt.Errorf("error creating AES GCM: %w", err)
+		return nil, err
 	}
 
 	enc.Nonce, err = generateRandomBytes(aesGCM.NonceSize())
@@ -55,14 +50,9 @@ func aesEncrypt(data []byte) (*aesEncrypted, error) {
 }
 
 func aesDecrypt(enc *aesEncrypted) ([]byte, error) {
-	aesBlock, err := aes.NewCipher(enc.Key)
+	aesGCM, err := newAESGCM(enc.Key)
 	if err != nil {
-		return nil, fmt.Errorf("error creating AES cipher: %w", err)
-	}
-
-	aesGCM, err := cipher.NewGCM(aesBlock)
-	if err != nil {
-		return nil, fmt.Errorf("error creating AES GCM: %w", err)
+		return nil, err
 	}
 
 	data, err := aesGCM.Open(nil, enc.Nonce, enc.Ciphertext, nil)
@@ -71,3 +61,16 @@ func aesDecrypt(enc *aesEncrypted) ([]byte, error) {
 	}
 	return data, nil
 }
+
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	aesBlock, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("error creating AES cipher: %w", err)
+	}
+
+	aesGCM, err := cipher.NewGCM(aesBlock)
+	if err != nil {
+		return nil, fmt.Errorf("error creating AES GCM: %w", err)
+	}
+	return aesGCM, nil
+}
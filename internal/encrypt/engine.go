@@ -2,14 +2,25 @@ package encrypt
 
 import "errors"
 
+// Encryption schemes are passed to server in EncryptionHeader, so it can choose a corresponding Decrypter.
+// Requests without the header are treated as encrypted with SchemeRSAAES for backward compatibility
+const (
+	EncryptionHeader = "X-Encryption"
+
+	SchemeRSAAES = "rsa-aes"
+	SchemeX25519 = "x25519-hkdf-aes-gcm"
+)
+
 type Encrypter interface {
 	Encrypt([]byte) ([]byte, error)
 	EncryptingEnabled() bool
+	Scheme() string
 }
 
 type Decrypter interface {
 	Decrypt([]byte) ([]byte, error)
 	DecryptingEnabled() bool
+	Scheme() string
 }
 
 var (
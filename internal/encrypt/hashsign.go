@@ -1,29 +1,60 @@
 package encrypt
 
 import (
-	"bytes"
-	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 const (
 	SignHeader = "HashSHA256"
+	// SignKeyIDHeader holds identifier of a key used for HMAC-SHA256 signature,
+	// signatures without it are made with legacy hash scheme
+	SignKeyIDHeader = "X-Sign-Key-Id"
+	// SignTimestampHeader holds request creation time as unix seconds, it is covered by request signature
+	SignTimestampHeader = "X-Sign-Timestamp"
+	// SignNonceHeader holds random request identifier, it is covered by request signature
+	SignNonceHeader = "X-Sign-Nonce"
+
+	// ResponseTarget is used instead of request target in response signatures,
+	// so a response signature can't be passed off as a request one
+	ResponseTarget = "RESPONSE"
+
+	nonceSize = 16
 )
 
 var (
 	ErrSignatureInvalid = errors.New("request signature invalid")
 )
 
-func AddSignature(key []byte, payload []byte, header http.Header) {
-	if len(key) == 0 {
+// RequestSignature is a request signature along with key identifier, timestamp and nonce covered by it.
+// Signatures made by older clients may cover payload only (zero timestamp and empty nonce)
+// and may be made with legacy hash scheme (empty key identifier)
+type RequestSignature struct {
+	KeyID     string
+	Sign      []byte
+	Timestamp time.Time
+	Nonce     string
+}
+
+// IsPayloadOnly checks that signature covers payload only, so it doesn't protect from replaying request
+func (rs *RequestSignature) IsPayloadOnly() bool {
+	return rs.Timestamp.IsZero() && rs.Nonce == ""
+}
+
+// AddSignature adds payload signature made with the primary key of key ring to headers,
+// nothing is added if key ring has no primary key
+func AddSignature(ring *KeyRing, payload []byte, header http.Header) {
+	if !ring.CanSign() {
 		return
 	}
-
-	sign := calculateHash(key, payload)
-	header.Add(SignHeader, base64.StdEncoding.EncodeToString(sign))
+	keyID, sign := ring.Sign(payload)
+	header.Set(SignKeyIDHeader, keyID)
+	header.Set(SignHeader, base64.StdEncoding.EncodeToString(sign))
 }
 
 func GetSignature(header http.Header) ([]byte, error) {
@@ -39,18 +70,124 @@ func GetSignature(header http.Header) ([]byte, error) {
 	return sign, nil
 }
 
-func CheckSignature(key []byte, payload []byte, sign []byte) error {
-	calculated := calculateHash(key, payload)
-	if !bytes.Equal(calculated, sign) {
-		return ErrSignatureInvalid
+// SignRequest adds key identifier, timestamp, nonce and signature covering them, request method, URI and payload
+// to request headers. Payload must be the request body as it is sent (after encryption and compression)
+func SignRequest(ring *KeyRing, req *http.Request, payload []byte) error {
+	if !ring.CanSign() {
+		return nil
 	}
+
+	nonce, err := NewNonce()
+	if err != nil {
+		return err
+	}
+	ts := time.Now().Unix()
+	keyID, sign := ring.Sign(RequestContent(RequestTarget(req), ts, nonce, payload))
+
+	req.Header.Set(SignKeyIDHeader, keyID)
+	req.Header.Set(SignTimestampHeader, strconv.FormatInt(ts, 10))
+	req.Header.Set(SignNonceHeader, nonce)
+	req.Header.Set(SignHeader, base64.StdEncoding.EncodeToString(sign))
 	return nil
 }
 
-func calculateHash(key []byte, payload []byte) []byte {
-	hfunc := sha256.New()
-	if payload != nil {
-		hfunc.Write(payload)
+// GetRequestSignature reads signature with its key identifier, timestamp and nonce from request headers,
+// nil is returned if request is not signed
+func GetRequestSignature(header http.Header) (*RequestSignature, error) {
+	sign, err := GetSignature(header)
+	if err != nil || sign == nil {
+		return nil, err
+	}
+
+	return ParseRequestSignature(header.Get(SignKeyIDHeader), sign,
+		header.Get(SignTimestampHeader), header.Get(SignNonceHeader))
+}
+
+// ParseRequestSignature composes RequestSignature from its transferred parts,
+// timestamp and nonce must be either both present or both empty (payload-only signature)
+func ParseRequestSignature(keyID string, sign []byte, timestamp string, nonce string) (*RequestSignature, error) {
+	rs := &RequestSignature{
+		KeyID: keyID,
+		Sign:  sign,
+		Nonce: nonce,
+	}
+	if timestamp == "" && nonce == "" {
+		return rs, nil
+	}
+	if timestamp == "" || nonce == "" {
+		return nil, errors.New("signature timestamp and nonce must be specified together")
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse signature timestamp: %w", err)
+	}
+	rs.Timestamp = time.Unix(ts, 0)
+	return rs, nil
+}
+
+// CheckRequestSignature verifies signature against request target and payload,
+// payload-only signatures are verified against payload only
+func CheckRequestSignature(ring *KeyRing, target string, payload []byte, rs *RequestSignature) error {
+	content := payload
+	if !rs.IsPayloadOnly() {
+		content = RequestContent(target, rs.Timestamp.Unix(), rs.Nonce, payload)
+	}
+	return ring.Verify(rs.KeyID, content, rs.Sign)
+}
+
+// SignResponse adds response payload signature to headers. Response is signed with the key used by request signature
+// (if server has it), so a client having a single key can verify it. If request signature has a nonce, response
+// signature covers it along with response timestamp, so a response can't be substituted with a response to another
+// request. Nothing is added if there is no key to sign with
+func SignResponse(ring *KeyRing, reqHeader http.Header, payload []byte, header http.Header) {
+	nonce := reqHeader.Get(SignNonceHeader)
+	ts := time.Now().Unix()
+	content := payload
+	if nonce != "" {
+		content = RequestContent(ResponseTarget, ts, nonce, payload)
+	}
+
+	keyID, sign, ok := ring.SignWith(reqHeader.Get(SignKeyIDHeader), content)
+	if !ok {
+		return
+	}
+	header.Set(SignKeyIDHeader, keyID)
+	if nonce != "" {
+		header.Set(SignTimestampHeader, strconv.FormatInt(ts, 10))
+		header.Set(SignNonceHeader, nonce)
+	}
+	header.Set(SignHeader, base64.StdEncoding.EncodeToString(sign))
+}
+
+// CheckResponseSignature verifies response signature against payload and nonce of the request it was sent for,
+// signature must cover request nonce if it was specified
+func CheckResponseSignature(ring *KeyRing, nonce string, payload []byte, rs *RequestSignature) error {
+	if rs.Nonce != nonce {
+		return fmt.Errorf("%w: response signature doesn't belong to request", ErrSignatureInvalid)
+	}
+	return CheckRequestSignature(ring, ResponseTarget, payload, rs)
+}
+
+// RequestTarget returns a request identification covered by signature: method and URI
+func RequestTarget(req *http.Request) string {
+	return req.Method + " " + req.URL.RequestURI()
+}
+
+// RequestContent composes data covered by request signature: payload along with request target, timestamp
+// and nonce. Target identifies request destination (e.g. HTTP method and URI or gRPC method), so the signature
+// can't be reused for another endpoint
+func RequestContent(target string, ts int64, nonce string, payload []byte) []byte {
+	content := make([]byte, 0, len(target)+len(nonce)+len(payload)+24)
+	content = fmt.Appendf(content, "%s\n%d\n%s\n", target, ts, nonce)
+	return append(content, payload...)
+}
+
+// NewNonce generates random request nonce
+func NewNonce() (string, error) {
+	b, err := generateRandomBytes(nonceSize)
+	if err != nil {
+		return "", fmt.Errorf("error generating nonce: %w", err)
 	}
-	return hfunc.Sum(key)
+	return hex.EncodeToString(b), nil
 }
@@ -0,0 +1,129 @@
+package encrypt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestSignature(t *testing.T) {
+	key, err := NewKeyRingBuilder().PrimaryKey("k1", "secret").Build()
+	require.NoError(t, err)
+	other, err := NewKeyRingBuilder().PrimaryKey("k1", "other").Build()
+	require.NoError(t, err)
+	payload := []byte(`[{"id":"cnt1","type":"counter","delta":1}]`)
+
+	req, err := http.NewRequest(http.MethodPost, "http://localhost:8080/updates/", bytes.NewReader(payload))
+	require.NoError(t, err)
+	require.NoError(t, SignRequest(key, req, payload))
+
+	rs, err := GetRequestSignature(req.Header)
+	require.NoError(t, err)
+	require.NotNil(t, rs)
+	assert.False(t, rs.IsPayloadOnly())
+	assert.Equal(t, "k1", rs.KeyID)
+	assert.NotEmpty(t, rs.Nonce)
+	assert.NoError(t, CheckRequestSignature(key, RequestTarget(req), payload, rs))
+
+	// signature can't be reused for another endpoint, payload or key
+	assert.ErrorIs(t, CheckRequestSignature(key, "POST /update/", payload, rs), ErrSignatureInvalid)
+	assert.ErrorIs(t, CheckRequestSignature(key, RequestTarget(req), []byte("[]"), rs), ErrSignatureInvalid)
+	assert.ErrorIs(t, CheckRequestSignature(other, RequestTarget(req), payload, rs), ErrSignatureInvalid)
+
+	// each signed request gets its own nonce
+	req2, err := http.NewRequest(http.MethodPost, "http://localhost:8080/updates/", bytes.NewReader(payload))
+	require.NoError(t, err)
+	require.NoError(t, SignRequest(key, req2, payload))
+	assert.NotEqual(t, req.Header.Get(SignNonceHeader), req2.Header.Get(SignNonceHeader))
+}
+
+func TestPayloadOnlyRequestSignature(t *testing.T) {
+	key, err := NewKeyRingBuilder().PrimaryKey("", "secret").Build()
+	require.NoError(t, err)
+	payload := []byte("payload")
+
+	header := http.Header{}
+	AddSignature(key, payload, header)
+	assert.Equal(t, DefaultKeyID, header.Get(SignKeyIDHeader))
+	rs, err := GetRequestSignature(header)
+	require.NoError(t, err)
+	assert.True(t, rs.IsPayloadOnly())
+	assert.NoError(t, CheckRequestSignature(key, "any", payload, rs))
+
+	// signature made by older clients without key id
+	header = http.Header{}
+	header.Set(SignHeader, base64.StdEncoding.EncodeToString(legacyHash([]byte("secret"), payload)))
+	rs, err = GetRequestSignature(header)
+	require.NoError(t, err)
+	assert.Empty(t, rs.KeyID)
+	assert.ErrorIs(t, CheckRequestSignature(key, "any", payload, rs), ErrLegacySignature)
+	legacyKey, err := NewKeyRingBuilder().PrimaryKey("", "secret").LegacyUntil("2100-01-01T00:00:00Z").Build()
+	require.NoError(t, err)
+	assert.NoError(t, CheckRequestSignature(legacyKey, "any", payload, rs))
+
+	rs, err = GetRequestSignature(http.Header{})
+	require.NoError(t, err)
+	assert.Nil(t, rs)
+
+	header.Set(SignNonceHeader, "abc")
+	_, err = GetRequestSignature(header)
+	assert.Error(t, err)
+
+	header.Set(SignTimestampHeader, "yesterday")
+	_, err = GetRequestSignature(header)
+	assert.Error(t, err)
+
+	header = http.Header{}
+	header.Set(SignHeader, "not base64!")
+	_, err = GetRequestSignature(header)
+	assert.Error(t, err)
+
+	header.Set(SignHeader, base64.StdEncoding.EncodeToString([]byte("sign")))
+	require.NoError(t, SignRequest(nil, &http.Request{Header: header}, payload))
+	assert.Empty(t, header.Get(SignNonceHeader))
+}
+
+func TestResponseSignature(t *testing.T) {
+	client, err := NewKeyRingBuilder().PrimaryKey("k1", "old-secret").Build()
+	require.NoError(t, err)
+	server, err := NewKeyRingBuilder().PrimaryKey("k2", "new-secret").Keys("k1:old-secret").Build()
+	require.NoError(t, err)
+	payload := []byte(`{"updated":1}`)
+
+	req, err := http.NewRequest(http.MethodPost, "http://localhost:8080/updates/", nil)
+	require.NoError(t, err)
+	require.NoError(t, SignRequest(client, req, nil))
+	nonce := req.Header.Get(SignNonceHeader)
+
+	// response is signed with the key of request signature and covers its nonce
+	header := http.Header{}
+	SignResponse(server, req.Header, payload, header)
+	assert.Equal(t, "k1", header.Get(SignKeyIDHeader))
+	assert.Equal(t, nonce, header.Get(SignNonceHeader))
+	rs, err := GetRequestSignature(header)
+	require.NoError(t, err)
+	assert.NoError(t, CheckResponseSignature(client, nonce, payload, rs))
+	assert.ErrorIs(t, CheckResponseSignature(client, nonce, []byte(`{"updated":2}`), rs), ErrSignatureInvalid)
+	assert.ErrorIs(t, CheckResponseSignature(client, "other", payload, rs), ErrSignatureInvalid)
+
+	// response signature can't be used as a request one
+	assert.ErrorIs(t, CheckRequestSignature(server, RequestTarget(req), payload, rs), ErrSignatureInvalid)
+
+	// requests without nonce get payload-only response signed with primary key if key is unknown
+	header = http.Header{}
+	SignResponse(server, http.Header{}, payload, header)
+	assert.Equal(t, "k2", header.Get(SignKeyIDHeader))
+	rs, err = GetRequestSignature(header)
+	require.NoError(t, err)
+	assert.True(t, rs.IsPayloadOnly())
+	assert.NoError(t, CheckResponseSignature(server, "", payload, rs))
+
+	// nothing is signed without keys
+	header = http.Header{}
+	SignResponse(nil, req.Header, payload, header)
+	assert.Empty(t, header)
+}
@@ -0,0 +1,182 @@
+package encrypt
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DefaultKeyID is an identifier of a single secret key when no explicit identifier is configured
+const DefaultKeyID = "default"
+
+var (
+	ErrUnknownKeyID    = errors.New("unknown signing key id")
+	ErrLegacySignature = errors.New("legacy signature scheme is not accepted anymore")
+)
+
+// KeyRing holds named secret keys for HMAC-SHA256 signatures. Signatures are made with the primary key
+// and carry its identifier, so a verifying side can pick a corresponding key - this allows rotating keys
+// without downtime: a new key is added to the ring of verifying side, then signing sides switch to it,
+// then the old key is removed.
+// Signatures without key identifier are made with legacy hash scheme, they are verified against all keys
+// of the ring until legacy deadline, they are rejected if the deadline is not set.
+// KeyRing instances must be constructed using KeyRingBuilder
+type KeyRing struct {
+	keys        map[string][]byte
+	primaryID   string
+	legacyUntil time.Time
+
+	now func() time.Time
+}
+
+// Enabled checks that ring contains at least one key, so signatures can be verified.
+// A nil ring is a valid empty ring
+func (kr *KeyRing) Enabled() bool {
+	return kr != nil && len(kr.keys) > 0
+}
+
+// CanSign checks that ring has a primary key for signing
+func (kr *KeyRing) CanSign() bool {
+	return kr != nil && kr.primaryID != ""
+}
+
+// Sign calculates HMAC-SHA256 of content with the primary key and returns primary key identifier along with it
+func (kr *KeyRing) Sign(content []byte) (string, []byte) {
+	return kr.primaryID, hmacSHA256(kr.keys[kr.primaryID], content)
+}
+
+// SignWith calculates HMAC-SHA256 of content with a key identified by keyID, so the signature can be verified
+// by a side having this key only. Primary key is used if keyID is empty or unknown, false is returned
+// if there is no key to sign with
+func (kr *KeyRing) SignWith(keyID string, content []byte) (string, []byte, bool) {
+	if kr == nil {
+		return "", nil, false
+	}
+	key, ok := kr.keys[keyID]
+	if !ok {
+		if !kr.CanSign() {
+			return "", nil, false
+		}
+		keyID, key = kr.primaryID, kr.keys[kr.primaryID]
+	}
+	return keyID, hmacSHA256(key, content), true
+}
+
+// Verify checks content signature made with a key identified by keyID, empty keyID denotes legacy hash scheme
+func (kr *KeyRing) Verify(keyID string, content []byte, sign []byte) error {
+	if keyID == "" {
+		return kr.verifyLegacy(content, sign)
+	}
+
+	key, ok := kr.keys[keyID]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownKeyID, keyID)
+	}
+	if !hmac.Equal(hmacSHA256(key, content), sign) {
+		return ErrSignatureInvalid
+	}
+	return nil
+}
+
+func (kr *KeyRing) verifyLegacy(content []byte, sign []byte) error {
+	if kr.legacyUntil.IsZero() || kr.now().After(kr.legacyUntil) {
+		return ErrLegacySignature
+	}
+	for _, key := range kr.keys {
+		if hmac.Equal(legacyHash(key, content), sign) {
+			return nil
+		}
+	}
+	return ErrSignatureInvalid
+}
+
+func hmacSHA256(key []byte, content []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(content)
+	return mac.Sum(nil)
+}
+
+// legacyHash is a signature scheme used by older clients. It's not a keyed MAC: sha256 of payload
+// is simply prefixed with the key, so it's kept for backward compatibility only
+func legacyHash(key []byte, payload []byte) []byte {
+	hfunc := sha256.New()
+	if payload != nil {
+		hfunc.Write(payload)
+	}
+	return hfunc.Sum(key)
+}
+
+// KeyRingBuilder is used to construct KeyRing, keys with empty secrets are ignored
+type KeyRingBuilder struct {
+	ring *KeyRing
+	err  error
+}
+
+func NewKeyRingBuilder() *KeyRingBuilder {
+	return &KeyRingBuilder{
+		ring: &KeyRing{
+			keys: make(map[string][]byte),
+			now:  time.Now,
+		},
+	}
+}
+
+// PrimaryKey adds a key used for signing, DefaultKeyID is used if id is empty
+func (b *KeyRingBuilder) PrimaryKey(id string, secret string) *KeyRingBuilder {
+	if secret == "" {
+		return b
+	}
+	if id == "" {
+		id = DefaultKeyID
+	}
+	b.addKey(id, secret)
+	b.ring.primaryID = id
+	return b
+}
+
+// Keys adds keys accepted for verification only, each key is defined as "<id>:<secret>"
+func (b *KeyRingBuilder) Keys(defs ...string) *KeyRingBuilder {
+	for i, def := range defs {
+		id, secret, ok := strings.Cut(def, ":")
+		if !ok || id == "" || secret == "" {
+			// definition itself is not included into error since it may contain a secret
+			b.err = errors.Join(b.err, fmt.Errorf("signing key #%d must be defined as <id>:<secret>", i+1))
+			continue
+		}
+		b.addKey(id, secret)
+	}
+	return b
+}
+
+// LegacyUntil sets a deadline in RFC3339 format until which legacy signatures are accepted,
+// legacy signatures are rejected right away if deadline is empty
+func (b *KeyRingBuilder) LegacyUntil(deadline string) *KeyRingBuilder {
+	if deadline == "" {
+		return b
+	}
+	t, err := time.Parse(time.RFC3339, deadline)
+	if err != nil {
+		b.err = errors.Join(b.err, fmt.Errorf("can't parse legacy signatures deadline: %w", err))
+		return b
+	}
+	b.ring.legacyUntil = t
+	return b
+}
+
+func (b *KeyRingBuilder) Build() (*KeyRing, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	return b.ring, nil
+}
+
+func (b *KeyRingBuilder) addKey(id string, secret string) {
+	if existing, ok := b.ring.keys[id]; ok && string(existing) != secret {
+		b.err = errors.Join(b.err, fmt.Errorf("signing key %s is defined twice with different secrets", id))
+		return
+	}
+	b.ring.keys[id] = []byte(secret)
+}
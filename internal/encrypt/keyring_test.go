@@ -0,0 +1,88 @@
+package encrypt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyRingSignAndVerify(t *testing.T) {
+	content := []byte("content")
+
+	ring, err := NewKeyRingBuilder().
+		PrimaryKey("k2", "new-secret").
+		Keys("k1:old-secret").
+		Build()
+	require.NoError(t, err)
+	assert.True(t, ring.Enabled())
+	assert.True(t, ring.CanSign())
+
+	keyID, sign := ring.Sign(content)
+	assert.Equal(t, "k2", keyID)
+	assert.Len(t, sign, 32)
+	assert.NoError(t, ring.Verify(keyID, content, sign))
+	assert.ErrorIs(t, ring.Verify(keyID, []byte("other"), sign), ErrSignatureInvalid)
+	assert.ErrorIs(t, ring.Verify("k1", content, sign), ErrSignatureInvalid)
+	assert.ErrorIs(t, ring.Verify("k3", content, sign), ErrUnknownKeyID)
+
+	// clients still using the old key are accepted during rotation
+	oldRing, err := NewKeyRingBuilder().PrimaryKey("k1", "old-secret").Build()
+	require.NoError(t, err)
+	keyID, sign = oldRing.Sign(content)
+	assert.NoError(t, ring.Verify(keyID, content, sign))
+
+	// HMAC is not a concatenation of key and content hash
+	assert.NotEqual(t, legacyHash([]byte("old-secret"), content), sign)
+}
+
+func TestKeyRingLegacySignatures(t *testing.T) {
+	content := []byte("content")
+	legacySign := legacyHash([]byte("old-secret"), content)
+
+	ring, err := NewKeyRingBuilder().
+		PrimaryKey("k2", "new-secret").
+		Keys("k1:old-secret").
+		LegacyUntil("2030-01-01T00:00:00Z").
+		Build()
+	require.NoError(t, err)
+
+	ring.now = func() time.Time { return time.Date(2029, 12, 31, 0, 0, 0, 0, time.UTC) }
+	assert.NoError(t, ring.Verify("", content, legacySign))
+	assert.ErrorIs(t, ring.Verify("", []byte("other"), legacySign), ErrSignatureInvalid)
+
+	ring.now = func() time.Time { return time.Date(2030, 1, 2, 0, 0, 0, 0, time.UTC) }
+	assert.ErrorIs(t, ring.Verify("", content, legacySign), ErrLegacySignature)
+
+	// legacy signatures are accepted only if deadline is set explicitly
+	ring, err = NewKeyRingBuilder().Keys("k1:old-secret").Build()
+	require.NoError(t, err)
+	assert.ErrorIs(t, ring.Verify("", content, legacySign), ErrLegacySignature)
+}
+
+func TestKeyRingBuilder(t *testing.T) {
+	ring, err := NewKeyRingBuilder().PrimaryKey("k1", "").Build()
+	require.NoError(t, err)
+	assert.False(t, ring.Enabled())
+	assert.False(t, ring.CanSign())
+
+	var nilRing *KeyRing
+	assert.False(t, nilRing.Enabled())
+	assert.False(t, nilRing.CanSign())
+
+	ring, err = NewKeyRingBuilder().Keys("k1:secret").Build()
+	require.NoError(t, err)
+	assert.True(t, ring.Enabled())
+	assert.False(t, ring.CanSign())
+
+	_, err = NewKeyRingBuilder().Keys("no-secret").Build()
+	require.Error(t, err)
+	assert.NotContains(t, err.Error(), "no-secret")
+
+	_, err = NewKeyRingBuilder().PrimaryKey("k1", "a").Keys("k1:b").Build()
+	assert.Error(t, err)
+
+	_, err = NewKeyRingBuilder().LegacyUntil("tomorrow").Build()
+	assert.Error(t, err)
+}
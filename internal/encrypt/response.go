@@ -0,0 +1,111 @@
+package encrypt
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Clients may ask server to encrypt response body by passing their own public key in ResponseKeyHeader
+// (BASE64 encoded DER: PKCS1 for RSA, PKIX for X25519) or an identifier of a public key known to server
+// in ResponseKeyIDHeader. Encrypted responses have EncryptionHeader set to encryption scheme
+const (
+	ResponseKeyHeader   = "X-Response-Key"
+	ResponseKeyIDHeader = "X-Response-Key-Id"
+)
+
+var (
+	ErrUnknownResponseKeyID = errors.New("unknown response key id")
+)
+
+// ParseEncrypter creates an Encrypter for a public key in DER format:
+// RSAAESEngine for RSA key in PKCS1 format, X25519Engine for X25519 key in PKIX format
+func ParseEncrypter(der []byte) (Encrypter, error) {
+	if rsaPub, err := x509.ParsePKCS1PublicKey(der); err == nil {
+		return NewRSAEngineBuilder().PublicKey(rsaPub).Build(), nil
+	}
+	pub, err := parseX25519PublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("neither RSA nor X25519 public key: %w", err)
+	}
+	return NewX25519EngineBuilder().PublicKey(pub).Build(), nil
+}
+
+// ReadResponseKeys reads public keys for response encryption from definitions in form of "<id>:<path>"
+func ReadResponseKeys(defs ...string) (map[string]Encrypter, error) {
+	keys := make(map[string]Encrypter, len(defs))
+	for _, def := range defs {
+		id, path, ok := strings.Cut(def, ":")
+		if !ok || id == "" || path == "" {
+			return nil, fmt.Errorf("invalid response key definition %q, expected <id>:<path>", def)
+		}
+		enc, err := ReadEncrypterFromFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading response key %s: %w", id, err)
+		}
+		keys[id] = enc
+	}
+	return keys, nil
+}
+
+// ResponseEncrypter chooses an Encrypter for response by request headers: a public key passed by client
+// takes precedence over a key identifier. Nil is returned if client didn't ask for response encryption
+func ResponseEncrypter(reqHeader http.Header, keys map[string]Encrypter) (Encrypter, error) {
+	if encoded := reqHeader.Get(ResponseKeyHeader); encoded != "" {
+		der, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("unable to decode response key: %w", err)
+		}
+		return ParseEncrypter(der)
+	}
+
+	if keyID := reqHeader.Get(ResponseKeyIDHeader); keyID != "" {
+		enc, ok := keys[keyID]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownResponseKeyID, keyID)
+		}
+		return enc, nil
+	}
+	return nil, nil
+}
+
+// ReadResponseKeyFromFile reads client private key (RSA in PKCS1 or X25519 in PKCS8 format) for decrypting
+// responses and returns a Decrypter for it along with a corresponding public key encoded for ResponseKeyHeader
+func ReadResponseKeyFromFile(filePath string) (Decrypter, string, error) {
+	bytes, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, "", fmt.Errorf("error reading key file %s: %w", filePath, err)
+	}
+	block, _ := pem.Decode(bytes)
+	if block == nil {
+		return nil, "", fmt.Errorf("error reading key file %s: invalid PEM block", filePath)
+	}
+
+	switch block.Type {
+	case privateKeyBlockType:
+		priv, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, "", fmt.Errorf("error parsing private key: %w", err)
+		}
+		pub := base64.StdEncoding.EncodeToString(x509.MarshalPKCS1PublicKey(&priv.PublicKey))
+		return NewRSAEngineBuilder().PrivateKey(priv).Build(), pub, nil
+	case x25519PrivateKeyBlockType:
+		priv, err := ReadX25519PrivateKeyFromFile(filePath)
+		if err != nil {
+			return nil, "", err
+		}
+		der, err := x509.MarshalPKIXPublicKey(priv.PublicKey())
+		if err != nil {
+			return nil, "", fmt.Errorf("error marshalling public key: %w", err)
+		}
+		pub := base64.StdEncoding.EncodeToString(der)
+		return NewX25519EngineBuilder().PrivateKey(priv).Build(), pub, nil
+	default:
+		return nil, "", fmt.Errorf("error reading key file %s: unsupported key type %s", filePath, block.Type)
+	}
+}
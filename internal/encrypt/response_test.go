@@ -0,0 +1,92 @@
+package encrypt
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseEncryption(t *testing.T) {
+	dir := t.TempDir()
+	rsaPriv, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	x25519Priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	x25519PrivDER, err := x509.MarshalPKCS8PrivateKey(x25519Priv)
+	require.NoError(t, err)
+	x25519PubDER, err := x509.MarshalPKIXPublicKey(x25519Priv.PublicKey())
+	require.NoError(t, err)
+
+	rsaPrivPath := writePEM(t, dir, "rsa.pem", privateKeyBlockType, x509.MarshalPKCS1PrivateKey(rsaPriv))
+	x25519PrivPath := writePEM(t, dir, "x25519.pem", x25519PrivateKeyBlockType, x25519PrivDER)
+	x25519PubPath := writePEM(t, dir, "x25519.pub", publicKeyBlockType, x25519PubDER)
+
+	data := []byte(`{"id":"cnt1","type":"counter","delta":1}`)
+	for _, path := range []string{rsaPrivPath, x25519PrivPath} {
+		// client passes its public key, server encrypts response with it
+		decrypter, pub, err := ReadResponseKeyFromFile(path)
+		require.NoError(t, err)
+		header := http.Header{}
+		header.Set(ResponseKeyHeader, pub)
+		encrypter, err := ResponseEncrypter(header, nil)
+		require.NoError(t, err)
+		require.NotNil(t, encrypter)
+		assert.Equal(t, decrypter.Scheme(), encrypter.Scheme())
+
+		encrypted, err := encrypter.Encrypt(data)
+		require.NoError(t, err)
+		decrypted, err := decrypter.Decrypt(encrypted)
+		require.NoError(t, err)
+		assert.Equal(t, data, decrypted)
+	}
+
+	// client passes identifier of a key known to server
+	keys, err := ReadResponseKeys("agent1:" + x25519PubPath)
+	require.NoError(t, err)
+	header := http.Header{}
+	header.Set(ResponseKeyIDHeader, "agent1")
+	encrypter, err := ResponseEncrypter(header, keys)
+	require.NoError(t, err)
+	assert.Equal(t, SchemeX25519, encrypter.Scheme())
+
+	header.Set(ResponseKeyIDHeader, "agent2")
+	_, err = ResponseEncrypter(header, keys)
+	assert.ErrorIs(t, err, ErrUnknownResponseKeyID)
+
+	// no encryption requested
+	encrypter, err = ResponseEncrypter(http.Header{}, keys)
+	require.NoError(t, err)
+	assert.Nil(t, encrypter)
+
+	// malformed keys
+	header = http.Header{}
+	header.Set(ResponseKeyHeader, "not base64!")
+	_, err = ResponseEncrypter(header, nil)
+	assert.Error(t, err)
+	header.Set(ResponseKeyHeader, "AAAA")
+	_, err = ResponseEncrypter(header, nil)
+	assert.Error(t, err)
+
+	_, err = ReadResponseKeys("agent1")
+	assert.Error(t, err)
+	_, err = ReadResponseKeys("agent1:" + filepath.Join(dir, "missing.pem"))
+	assert.Error(t, err)
+	_, _, err = ReadResponseKeyFromFile(x25519PubPath)
+	assert.Error(t, err)
+}
+
+func writePEM(t *testing.T, dir string, name string, blockType string, der []byte) string {
+	path := filepath.Join(dir, name)
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	require.NoError(t, os.WriteFile(path, data, 0600))
+	return path
+}
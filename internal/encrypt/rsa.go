@@ -115,6 +115,10 @@ func (engine *RSAAESEngine) DecryptingEnabled() bool {
 	return engine.privateKey != nil
 }
 
+func (engine *RSAAESEngine) Scheme() string {
+	return SchemeRSAAES
+}
+
 // RSAEngineBuilder is a builder structure for RSAAESEngine which can provide any combination of private and public keys
 // depending on functional needed from the engine
 type RSAEngineBuilder struct {
@@ -156,14 +160,9 @@ func (b *RSAEngineBuilder) Build() *RSAAESEngine {
 
 // ReadRSAPublicKeyFromFile is utility function to read an RSA public key from file
 func ReadRSAPublicKeyFromFile(filePath string) (*rsa.PublicKey, error) {
-	bytes, err := os.ReadFile(filePath)
+	block, err := readPEMBlock(filePath, publicKeyBlockType)
 	if err != nil {
-		return nil, fmt.Errorf("error reading key file %s: %w", filePath, err)
-	}
-
-	block, _ := pem.Decode(bytes)
-	if block == nil || block.Type != publicKeyBlockType {
-		return nil, fmt.Errorf("error reading key file %s: invalid PEM block or key type", filePath)
+		return nil, err
 	}
 
 	pub, err := x509.ParsePKCS1PublicKey(block.Bytes)
@@ -176,14 +175,9 @@ func ReadRSAPublicKeyFromFile(filePath string) (*rsa.PublicKey, error) {
 
 // ReadRSAPrivateKeyFromFile is utility function to read an RSA private key from file
 func ReadRSAPrivateKeyFromFile(filePath string) (*rsa.PrivateKey, error) {
-	bytes, err := os.ReadFile(filePath)
+	block, err := readPEMBlock(filePath, privateKeyBlockType)
 	if err != nil {
-		return nil, fmt.Errorf("error reading key file %s: %w", filePath, err)
-	}
-
-	block, _ := pem.Decode(bytes)
-	if block == nil || block.Type != privateKeyBlockType {
-		return nil, fmt.Errorf("error reading key file %s: invalid PEM block or key type", filePath)
+		return nil, err
 	}
 
 	priv, err := x509.ParsePKCS1PrivateKey(block.Bytes)
@@ -193,3 +187,16 @@ func ReadRSAPrivateKeyFromFile(filePath string) (*rsa.PrivateKey, error) {
 
 	return priv, nil
 }
+
+func readPEMBlock(filePath string, blockType string) (*pem.Block, error) {
+	bytes, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading key file %s: %w", filePath, err)
+	}
+
+	block, _ := pem.Decode(bytes)
+	if block == nil || block.Type != blockType {
+		return nil, fmt.Errorf("error reading key file %s: invalid PEM block or key type", filePath)
+	}
+	return block, nil
+}
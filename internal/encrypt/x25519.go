@@ -0,0 +1,202 @@
+package encrypt
+
+import (
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+	"fmt"
+)
+
+const (
+	x25519PrivateKeyBlockType = "PRIVATE KEY"
+
+	x25519KeySize = 32
+	// x25519KDFInfo binds derived keys to this scheme, so they can't be reused in another protocol
+	x25519KDFInfo = "metrics-overseer " + SchemeX25519
+)
+
+// X25519Engine allows encryption/decryption of byte data using ephemeral-static X25519 key agreement
+// (similar to HPKE base mode). For each message a fresh ephemeral key pair is generated, AES key is derived
+// with HKDF-SHA256 from the shared secret and both public keys, payload is encrypted with AES-GCM.
+// Unlike RSAAESEngine it doesn't make any expensive private key operations on encrypting side,
+// and decrypting side makes a single X25519 multiplication per message.
+// Encrypted data is a concatenation of ephemeral public key (32 bytes), AES-GCM nonce (12 bytes) and ciphertext.
+// Same as RSAAESEngine, engine built only with a public key can encrypt only, with a private key - decrypt only.
+// X25519Engine instances must be constructed using X25519EngineBuilder
+type X25519Engine struct {
+	publicKey  *ecdh.PublicKey
+	privateKey *ecdh.PrivateKey
+}
+
+func (engine *X25519Engine) Encrypt(data []byte) ([]byte, error) {
+	if engine.publicKey == nil {
+		return nil, errEncryptingDisabled
+	}
+
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("error generating ephemeral key: %w", err)
+	}
+	ephemeralPub := ephemeral.PublicKey().Bytes()
+
+	aesGCM, err := x25519AEAD(ephemeral, engine.publicKey, ephemeralPub, engine.publicKey.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := generateRandomBytes(aesGCM.NonceSize())
+	if err != nil {
+		return nil, fmt.Errorf("error generating AES nonce bytes: %w", err)
+	}
+
+	encrypted := make([]byte, 0, len(ephemeralPub)+len(nonce)+len(data)+aesGCM.Overhead())
+	encrypted = append(encrypted, ephemeralPub...)
+	encrypted = append(encrypted, nonce...)
+	return aesGCM.Seal(encrypted, nonce, data, ephemeralPub), nil
+}
+
+func (engine *X25519Engine) EncryptingEnabled() bool {
+	return engine.publicKey != nil
+}
+
+func (engine *X25519Engine) Decrypt(encrypted []byte) ([]byte, error) {
+	if engine.privateKey == nil {
+		return nil, errDecryptingDisabled
+	}
+	if len(encrypted) < x25519KeySize {
+		return nil, errors.New("encrypted data is too short")
+	}
+
+	ephemeralPub := encrypted[:x25519KeySize]
+	peer, err := ecdh.X25519().NewPublicKey(ephemeralPub)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ephemeral key: %w", err)
+	}
+
+	aesGCM, err := x25519AEAD(engine.privateKey, peer, ephemeralPub, engine.privateKey.PublicKey().Bytes())
+	if err != nil {
+		return nil, err
+	}
+	rest := encrypted[x25519KeySize:]
+	if len(rest) < aesGCM.NonceSize()+aesGCM.Overhead() {
+		return nil, errors.New("encrypted data is too short")
+	}
+
+	nonce, ciphertext := rest[:aesGCM.NonceSize()], rest[aesGCM.NonceSize():]
+	data, err := aesGCM.Open(nil, nonce, ciphertext, ephemeralPub)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting AES ciphertext: %w", err)
+	}
+	return data, nil
+}
+
+func (engine *X25519Engine) DecryptingEnabled() bool {
+	return engine.privateKey != nil
+}
+
+func (engine *X25519Engine) Scheme() string {
+	return SchemeX25519
+}
+
+// x25519AEAD derives AES-GCM cipher from X25519 shared secret, both public keys are used as HKDF salt
+// so the key is bound to a particular key exchange
+func x25519AEAD(priv *ecdh.PrivateKey, peer *ecdh.PublicKey, ephemeralPub []byte, staticPub []byte) (cipher.AEAD, error) {
+	shared, err := priv.ECDH(peer)
+	if err != nil {
+		return nil, fmt.Errorf("X25519 key agreement error: %w", err)
+	}
+
+	salt := make([]byte, 0, len(ephemeralPub)+len(staticPub))
+	salt = append(salt, ephemeralPub...)
+	salt = append(salt, staticPub...)
+	key, err := hkdf.Key(sha256.New, shared, salt, x25519KDFInfo, aesKeySize)
+	if err != nil {
+		return nil, fmt.Errorf("key derivation error: %w", err)
+	}
+	return newAESGCM(key)
+}
+
+// X25519EngineBuilder is a builder structure for X25519Engine which can provide any combination
+// of private and public keys depending on functional needed from the engine
+type X25519EngineBuilder struct {
+	publicKey  *ecdh.PublicKey
+	privateKey *ecdh.PrivateKey
+}
+
+func NewX25519EngineBuilder() *X25519EngineBuilder {
+	return &X25519EngineBuilder{}
+}
+
+func (b *X25519EngineBuilder) PublicKey(pub *ecdh.PublicKey) *X25519EngineBuilder {
+	b.publicKey = pub
+	return b
+}
+
+func (b *X25519EngineBuilder) PrivateKey(priv *ecdh.PrivateKey) *X25519EngineBuilder {
+	b.privateKey = priv
+	return b
+}
+
+func (b *X25519EngineBuilder) Build() *X25519Engine {
+	return &X25519Engine{
+		publicKey:  b.publicKey,
+		privateKey: b.privateKey,
+	}
+}
+
+// ReadX25519PublicKeyFromFile is utility function to read an X25519 public key in PKIX format from file
+func ReadX25519PublicKeyFromFile(filePath string) (*ecdh.PublicKey, error) {
+	block, err := readPEMBlock(filePath, publicKeyBlockType)
+	if err != nil {
+		return nil, err
+	}
+	return parseX25519PublicKey(block.Bytes)
+}
+
+// ReadX25519PrivateKeyFromFile is utility function to read an X25519 private key in PKCS8 format from file
+func ReadX25519PrivateKeyFromFile(filePath string) (*ecdh.PrivateKey, error) {
+	block, err := readPEMBlock(filePath, x25519PrivateKeyBlockType)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing private key: %w", err)
+	}
+	priv, ok := key.(*ecdh.PrivateKey)
+	if !ok || priv.Curve() != ecdh.X25519() {
+		return nil, errors.New("error parsing private key: not an X25519 key")
+	}
+	return priv, nil
+}
+
+// ReadEncrypterFromFile reads a public key from file and creates a corresponding Encrypter:
+// RSAAESEngine for RSA key in PKCS1 format, X25519Engine for X25519 key in PKIX format
+func ReadEncrypterFromFile(filePath string) (Encrypter, error) {
+	block, err := readPEMBlock(filePath, publicKeyBlockType)
+	if err != nil {
+		return nil, err
+	}
+
+	enc, err := ParseEncrypter(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("key in %s is %w", filePath, err)
+	}
+	return enc, nil
+}
+
+func parseX25519PublicKey(der []byte) (*ecdh.PublicKey, error) {
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing public key: %w", err)
+	}
+	pub, ok := key.(*ecdh.PublicKey)
+	if !ok || pub.Curve() != ecdh.X25519() {
+		return nil, errors.New("error parsing public key: not an X25519 key")
+	}
+	return pub, nil
+}
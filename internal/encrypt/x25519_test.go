@@ -0,0 +1,110 @@
+package encrypt
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestX25519Engine(t *testing.T) {
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	encrypter := NewX25519EngineBuilder().PublicKey(priv.PublicKey()).Build()
+	decrypter := NewX25519EngineBuilder().PrivateKey(priv).Build()
+
+	// sunny day scenario
+	data := []byte("a quick brown fox jumps over the lazy dog")
+	encrypted, err := encrypter.Encrypt(data)
+	require.NoError(t, err)
+	decrypted, err := decrypter.Decrypt(encrypted)
+	require.NoError(t, err)
+	assert.Equal(t, data, decrypted)
+
+	// each message has its own ephemeral key
+	encrypted2, err := encrypter.Encrypt(data)
+	require.NoError(t, err)
+	assert.NotEqual(t, encrypted[:x25519KeySize], encrypted2[:x25519KeySize])
+
+	// decryption/encryption enabled
+	assert.True(t, encrypter.EncryptingEnabled())
+	assert.False(t, encrypter.DecryptingEnabled())
+	assert.False(t, decrypter.EncryptingEnabled())
+	assert.True(t, decrypter.DecryptingEnabled())
+	_, err = decrypter.Encrypt(data)
+	assert.ErrorIs(t, err, errEncryptingDisabled)
+	_, err = encrypter.Decrypt(encrypted)
+	assert.ErrorIs(t, err, errDecryptingDisabled)
+
+	// big data chunk and empty data
+	for _, data := range [][]byte{make([]byte, 100000), {}} {
+		_, _ = rand.Read(data)
+		encrypted, err = encrypter.Encrypt(data)
+		require.NoError(t, err)
+		decrypted, err = decrypter.Decrypt(encrypted)
+		require.NoError(t, err)
+		assert.Equal(t, len(data), len(decrypted))
+	}
+
+	// rainy day scenario - tampered data, truncated data and different keys
+	encrypted, err = encrypter.Encrypt(data)
+	require.NoError(t, err)
+	encrypted[len(encrypted)-1] ^= 1
+	_, err = decrypter.Decrypt(encrypted)
+	assert.Error(t, err)
+	_, err = decrypter.Decrypt(encrypted[:x25519KeySize+4])
+	assert.Error(t, err)
+	_, err = decrypter.Decrypt([]byte("short"))
+	assert.Error(t, err)
+
+	other, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	encrypted, err = NewX25519EngineBuilder().PublicKey(other.PublicKey()).Build().Encrypt(data)
+	require.NoError(t, err)
+	_, err = decrypter.Decrypt(encrypted)
+	assert.Error(t, err)
+}
+
+func BenchmarkEncryption(b *testing.B) {
+	data := make([]byte, 4096)
+	_, _ = rand.Read(data)
+
+	rsaPriv, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(b, err)
+	x25519Priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(b, err)
+
+	engines := []struct {
+		name      string
+		encrypter Encrypter
+		decrypter Decrypter
+	}{
+		{
+			name:      SchemeRSAAES,
+			encrypter: NewRSAEngineBuilder().PublicKey(&rsaPriv.PublicKey).Build(),
+			decrypter: NewRSAEngineBuilder().PrivateKey(rsaPriv).Build(),
+		},
+		{
+			name:      SchemeX25519,
+			encrypter: NewX25519EngineBuilder().PublicKey(x25519Priv.PublicKey()).Build(),
+			decrypter: NewX25519EngineBuilder().PrivateKey(x25519Priv).Build(),
+		},
+	}
+	for _, engine := range engines {
+		b.Run(engine.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				encrypted, err := engine.encrypter.Encrypt(data)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, err := engine.decrypter.Decrypt(encrypted); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package grpcapi
+
+import (
+	"fmt"
+
+	"github.com/andrewsvn/metrics-overseer/internal/model"
+	pb "github.com/andrewsvn/metrics-overseer/pkg/proto/metrics"
+)
+
+var (
+	typeToProto = map[string]pb.MetricType{
+		model.Counter:   pb.MetricType_METRIC_TYPE_COUNTER,
+		model.Gauge:     pb.MetricType_METRIC_TYPE_GAUGE,
+		model.Histogram: pb.MetricType_METRIC_TYPE_HISTOGRAM,
+		model.Summary:   pb.MetricType_METRIC_TYPE_SUMMARY,
+	}
+	typeFromProto = map[pb.MetricType]string{
+		pb.MetricType_METRIC_TYPE_COUNTER:   model.Counter,
+		pb.MetricType_METRIC_TYPE_GAUGE:     model.Gauge,
+		pb.MetricType_METRIC_TYPE_HISTOGRAM: model.Histogram,
+		pb.MetricType_METRIC_TYPE_SUMMARY:   model.Summary,
+	}
+)
+
+// TypeToProto converts model metric type to protobuf enum, METRIC_TYPE_UNSPECIFIED is returned for unknown types
+func TypeToProto(mtype string) pb.MetricType {
+	return typeToProto[mtype]
+}
+
+// TypeFromProto converts protobuf metric type enum to model type
+func TypeFromProto(mtype pb.MetricType) (string, error) {
+	t, ok := typeFromProto[mtype]
+	if !ok {
+		return "", fmt.Errorf("unsupported metric type: %s", mtype)
+	}
+	return t, nil
+}
+
+func MetricToProto(m *model.Metrics) *pb.Metric {
+	res := &pb.Metric{
+		Id:     m.ID,
+		Type:   TypeToProto(m.MType),
+		Delta:  m.Delta,
+		Value:  m.Value,
+		Labels: m.Labels,
+	}
+	if m.Histogram != nil {
+		res.Histogram = &pb.Histogram{
+			Bounds: m.Histogram.Bounds,
+			Counts: m.Histogram.Counts,
+			Count:  m.Histogram.Count,
+			Sum:    m.Histogram.Sum,
+		}
+	}
+	if m.Summary != nil {
+		centroids := make([]*pb.Centroid, 0, len(m.Summary.Centroids))
+		for _, c := range m.Summary.Centroids {
+			centroids = append(centroids, &pb.Centroid{Mean: c.Mean, Weight: c.Weight})
+		}
+		res.Summary = &pb.Summary{
+			Count:     m.Summary.Count,
+			Sum:       m.Summary.Sum,
+			Min:       m.Summary.Min,
+			Max:       m.Summary.Max,
+			P50:       m.Summary.P50,
+			P90:       m.Summary.P90,
+			P99:       m.Summary.P99,
+			Centroids: centroids,
+		}
+	}
+	return res
+}
+
+// MetricFromProto converts protobuf metric to model, values are copied so the result doesn't share memory
+// with the protobuf message. Metric hash is updated as for metrics created with model constructors
+func MetricFromProto(m *pb.Metric) (*model.Metrics, error) {
+	if m == nil {
+		return nil, fmt.Errorf("metric is not provided")
+	}
+	mtype, err := TypeFromProto(m.GetType())
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		delta *int64
+		value *float64
+	)
+	if m.Delta != nil {
+		d := m.GetDelta()
+		delta = &d
+	}
+	if m.Value != nil {
+		v := m.GetValue()
+		value = &v
+	}
+
+	res := model.NewMetrics(m.GetId(), mtype, delta, value)
+	if h := m.GetHistogram(); h != nil {
+		res.Histogram = (&model.HistogramValue{
+			Bounds: h.GetBounds(),
+			Counts: h.GetCounts(),
+			Count:  h.GetCount(),
+			Sum:    h.GetSum(),
+		}).Clone()
+	}
+	if s := m.GetSummary(); s != nil {
+		centroids := make([]model.Centroid, 0, len(s.GetCentroids()))
+		for _, c := range s.GetCentroids() {
+			centroids = append(centroids, model.Centroid{Mean: c.GetMean(), Weight: c.GetWeight()})
+		}
+		res.Summary = &model.SummaryValue{
+			Count:     s.GetCount(),
+			Sum:       s.GetSum(),
+			Min:       s.GetMin(),
+			Max:       s.GetMax(),
+			P50:       s.GetP50(),
+			P90:       s.GetP90(),
+			P99:       s.GetP99(),
+			Centroids: centroids,
+		}
+	}
+	// labels are set last since it also updates the hash with histogram and summary values
+	return res.WithLabels(m.GetLabels()), nil
+}
@@ -0,0 +1,555 @@
+package grpcapi
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andrewsvn/metrics-overseer/internal/apitoken"
+	"github.com/andrewsvn/metrics-overseer/internal/encrypt"
+	"github.com/andrewsvn/metrics-overseer/internal/model"
+	"github.com/andrewsvn/metrics-overseer/internal/replay"
+	"github.com/andrewsvn/metrics-overseer/internal/selfmetrics"
+	"github.com/andrewsvn/metrics-overseer/internal/subnet"
+	pb "github.com/andrewsvn/metrics-overseer/pkg/proto/metrics"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// SignMetadataKey is a metadata key holding base64-encoded request signature - an analogue of REST signature header
+var SignMetadataKey = strings.ToLower(encrypt.SignHeader)
+
+// SignKeyIDMetadataKey, SignTimestampMetadataKey and SignNonceMetadataKey hold signature key id, timestamp
+// and nonce the same way as REST headers do
+var (
+	SignKeyIDMetadataKey     = strings.ToLower(encrypt.SignKeyIDHeader)
+	SignTimestampMetadataKey = strings.ToLower(encrypt.SignTimestampHeader)
+	SignNonceMetadataKey     = strings.ToLower(encrypt.SignNonceHeader)
+)
+
+// TokenMetadataKey is a metadata key holding API token - an analogue of REST Authorization header
+var TokenMetadataKey = strings.ToLower(apitoken.AuthorizationHeader)
+
+// BatchIDMetadataKey is a metadata key holding ID of UpdateBatch call - an analogue of REST batch ID header
+var BatchIDMetadataKey = strings.ToLower(model.BatchIDHeader)
+
+// signedMessage is implemented by streamed messages which carry their own signature
+type signedMessage interface {
+	GetMetric() *pb.Metric
+	GetSignature() []byte
+	GetKeyId() string
+}
+
+// Authorization verifies request signatures the same way as REST authorization middleware does:
+// unary call signature covers method name, timestamp, nonce and request message, timestamp and nonce are checked
+// by replay guard. Requests without signature and legacy signatures are passed through for backward compatibility
+// unless strict mode is on (reading calls are not required to be signed in strict mode similar to REST reading
+// endpoints), requests with invalid signature are rejected with Unauthenticated status.
+// Stream call signature covers method name, timestamp and nonce, and each streamed message carries its own
+// signature covering them along with the message sequence number, so a message can't be replayed in another
+// stream or repeated in the same one. Legacy streams with messages signed over the message only are accepted
+// unless strict mode is on
+type Authorization struct {
+	keys   *encrypt.KeyRing
+	strict bool
+	guard  *replay.Guard
+	logger *zap.SugaredLogger
+}
+
+func NewAuthorization(l *zap.Logger, keys *encrypt.KeyRing, strict bool, guard *replay.Guard) *Authorization {
+	return &Authorization{
+		keys:   keys,
+		strict: strict,
+		guard:  guard,
+		logger: l.Sugar().With("component", "grpc-authorization"),
+	}
+}
+
+func (auth *Authorization) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if !auth.keys.Enabled() {
+			return handler(ctx, req)
+		}
+
+		reqSign, err := signatureFromMetadata(ctx)
+		if err != nil {
+			auth.logger.Warnw("Error getting signature", "error", err)
+			return nil, status.Error(codes.InvalidArgument, "can't read signature from incoming request")
+		}
+		if reqSign == nil || reqSign.IsPayloadOnly() {
+			if auth.strict && info.FullMethod != pb.Metrics_GetValue_FullMethodName {
+				auth.logger.Debugw("call without signature timestamp and nonce rejected in strict mode")
+				return nil, status.Error(codes.Unauthenticated, "signature with timestamp and nonce is required")
+			}
+			if reqSign == nil {
+				return handler(ctx, req)
+			}
+		}
+
+		msg, ok := req.(proto.Message)
+		if !ok {
+			return nil, status.Error(codes.Internal, "unsupported request message")
+		}
+		if err := auth.verify(info.FullMethod, msg, reqSign); err != nil {
+			return nil, err
+		}
+		if !reqSign.IsPayloadOnly() {
+			if err := auth.guard.Check(reqSign.Timestamp, reqSign.Nonce); err != nil {
+				auth.logger.Debugw("call rejected by replay protection", "error", err)
+				return nil, status.Error(codes.Unauthenticated, "signature is stale or replayed")
+			}
+		}
+		return handler(ctx, req)
+	}
+}
+
+func (auth *Authorization) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !auth.keys.Enabled() {
+			return handler(srv, ss)
+		}
+
+		reqSign, err := signatureFromMetadata(ss.Context())
+		if err != nil {
+			auth.logger.Warnw("Error getting signature", "error", err)
+			return status.Error(codes.InvalidArgument, "can't read signature from incoming request")
+		}
+		if reqSign == nil || reqSign.IsPayloadOnly() {
+			if auth.strict {
+				auth.logger.Debugw("stream without signature timestamp and nonce rejected in strict mode")
+				return status.Error(codes.Unauthenticated, "signature with timestamp and nonce is required")
+			}
+			return handler(srv, &verifyingStream{ServerStream: ss, auth: auth, target: info.FullMethod})
+		}
+
+		if err := auth.verifyPayload(info.FullMethod, nil, reqSign); err != nil {
+			return err
+		}
+		if err := auth.guard.Check(reqSign.Timestamp, reqSign.Nonce); err != nil {
+			auth.logger.Debugw("stream rejected by replay protection", "error", err)
+			return status.Error(codes.Unauthenticated, "signature is stale or replayed")
+		}
+		return handler(srv, &verifyingStream{ServerStream: ss, auth: auth, target: info.FullMethod, streamSign: reqSign})
+	}
+}
+
+func (auth *Authorization) verify(target string, msg proto.Message, reqSign *encrypt.RequestSignature) error {
+	payload, err := marshalForSigning(msg)
+	if err != nil {
+		auth.logger.Warnw("can't marshal message for verification", "error", err)
+		return status.Error(codes.Internal, "can't verify signature")
+	}
+	return auth.verifyPayload(target, payload, reqSign)
+}
+
+func (auth *Authorization) verifyPayload(target string, payload []byte, reqSign *encrypt.RequestSignature) error {
+	err := encrypt.CheckRequestSignature(auth.keys, target, payload, reqSign)
+	if err != nil {
+		if errors.Is(err, encrypt.ErrSignatureInvalid) ||
+			errors.Is(err, encrypt.ErrUnknownKeyID) ||
+			errors.Is(err, encrypt.ErrLegacySignature) {
+			auth.logger.Debugw("incoming request signature is invalid", "error", err)
+			return status.Error(codes.Unauthenticated, "signature is invalid")
+		}
+		auth.logger.Warnw("can't verify signature", "error", err)
+		return status.Error(codes.Internal, "can't verify signature")
+	}
+	if reqSign.KeyID == "" {
+		auth.logger.Warnw("accepted call signed with legacy scheme, client should switch to key id signatures",
+			"target", target)
+	}
+	return nil
+}
+
+// verifyingStream checks signature of each received message. Messages of a signed stream must be signed
+// with stream timestamp, nonce and message sequence number. Messages of a legacy stream are signed over
+// the message only, unsigned messages of a legacy stream are passed through
+type verifyingStream struct {
+	grpc.ServerStream
+	auth   *Authorization
+	target string
+	// streamSign is a verified signature of stream call, it is nil for legacy streams
+	streamSign *encrypt.RequestSignature
+	seq        uint64
+}
+
+func (vs *verifyingStream) RecvMsg(m any) error {
+	if err := vs.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	sm, ok := m.(signedMessage)
+	if !ok {
+		return nil
+	}
+	vs.seq++
+
+	if len(sm.GetSignature()) == 0 {
+		if vs.streamSign != nil {
+			return status.Error(codes.Unauthenticated, "message signature is required")
+		}
+		return nil
+	}
+	if vs.streamSign == nil {
+		return vs.auth.verify("", sm.GetMetric(), &encrypt.RequestSignature{KeyID: sm.GetKeyId(), Sign: sm.GetSignature()})
+	}
+	return vs.auth.verify(vs.target, sm.GetMetric(), &encrypt.RequestSignature{
+		KeyID:     sm.GetKeyId(),
+		Sign:      sm.GetSignature(),
+		Timestamp: vs.streamSign.Timestamp,
+		Nonce:     streamMessageNonce(vs.streamSign.Nonce, vs.seq),
+	})
+}
+
+// streamMessageNonce binds streamed message signature to the stream and to message position in it
+func streamMessageNonce(streamNonce string, seq uint64) string {
+	return streamNonce + "." + strconv.FormatUint(seq, 10)
+}
+
+// SigningUnaryInterceptor is a client interceptor adding request signature along with key id, timestamp and nonce
+// to outgoing metadata, no signature is added if key ring has no primary key
+func SigningUnaryInterceptor(keys *encrypt.KeyRing) grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply any,
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		if keys.CanSign() {
+			msg, ok := req.(proto.Message)
+			if !ok {
+				return fmt.Errorf("unsupported request message type %T", req)
+			}
+			payload, err := marshalForSigning(msg)
+			if err != nil {
+				return fmt.Errorf("can't marshal request for signing: %w", err)
+			}
+			nonce, err := encrypt.NewNonce()
+			if err != nil {
+				return err
+			}
+			ts := time.Now().Unix()
+			keyID, sign := keys.Sign(encrypt.RequestContent(method, ts, nonce, payload))
+			ctx = metadata.AppendToOutgoingContext(ctx,
+				SignKeyIDMetadataKey, keyID,
+				SignTimestampMetadataKey, strconv.FormatInt(ts, 10),
+				SignNonceMetadataKey, nonce,
+				SignMetadataKey, base64.StdEncoding.EncodeToString(sign),
+			)
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// SigningStreamInterceptor is a client interceptor adding stream signature along with key id, timestamp and nonce
+// to outgoing metadata and signing each sent metric update with them and its sequence number in the stream,
+// nothing is signed if key ring has no primary key
+func SigningStreamInterceptor(keys *encrypt.KeyRing) grpc.StreamClientInterceptor {
+	return func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		if !keys.CanSign() {
+			return streamer(ctx, desc, cc, method, opts...)
+		}
+
+		nonce, err := encrypt.NewNonce()
+		if err != nil {
+			return nil, err
+		}
+		ts := time.Now().Unix()
+		keyID, sign := keys.Sign(encrypt.RequestContent(method, ts, nonce, nil))
+		ctx = metadata.AppendToOutgoingContext(ctx,
+			SignKeyIDMetadataKey, keyID,
+			SignTimestampMetadataKey, strconv.FormatInt(ts, 10),
+			SignNonceMetadataKey, nonce,
+			SignMetadataKey, base64.StdEncoding.EncodeToString(sign),
+		)
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return &signingStream{ClientStream: cs, keys: keys, method: method, ts: ts, nonce: nonce}, nil
+	}
+}
+
+// signingStream signs sent metric updates with stream timestamp, nonce and message sequence number
+type signingStream struct {
+	grpc.ClientStream
+	keys   *encrypt.KeyRing
+	method string
+	ts     int64
+	nonce  string
+	seq    uint64
+}
+
+func (ss *signingStream) SendMsg(m any) error {
+	if upd, ok := m.(*pb.MetricUpdate); ok {
+		payload, err := marshalForSigning(upd.GetMetric())
+		if err != nil {
+			return fmt.Errorf("can't marshal metric for signing: %w", err)
+		}
+		ss.seq++
+		upd.KeyId, upd.Signature = ss.keys.Sign(
+			encrypt.RequestContent(ss.method, ss.ts, streamMessageNonce(ss.nonce, ss.seq), payload))
+	}
+	return ss.ClientStream.SendMsg(m)
+}
+
+// SignMetricUpdate sets legacy signature of streamed metric update covering the metric only.
+// Such messages can be replayed, so they are rejected in strict mode, SigningStreamInterceptor
+// should be used instead
+func SignMetricUpdate(keys *encrypt.KeyRing, upd *pb.MetricUpdate) error {
+	if !keys.CanSign() {
+		return nil
+	}
+	payload, err := marshalForSigning(upd.GetMetric())
+	if err != nil {
+		return fmt.Errorf("can't marshal metric for signing: %w", err)
+	}
+	upd.KeyId, upd.Signature = keys.Sign(payload)
+	return nil
+}
+
+// marshalForSigning uses deterministic marshalling, so map fields (labels) produce the same bytes
+// on client and server
+func marshalForSigning(msg proto.Message) ([]byte, error) {
+	return proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+}
+
+func signatureFromMetadata(ctx context.Context) (*encrypt.RequestSignature, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil, nil
+	}
+	value := firstMetadataValue(md, SignMetadataKey)
+	if value == "" {
+		return nil, nil
+	}
+
+	sign, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode signature from metadata: %w", err)
+	}
+	return encrypt.ParseRequestSignature(firstMetadataValue(md, SignKeyIDMetadataKey), sign,
+		firstMetadataValue(md, SignTimestampMetadataKey), firstMetadataValue(md, SignNonceMetadataKey))
+}
+
+func firstMetadataValue(md metadata.MD, key string) string {
+	values := md.Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// TrustedSubnet rejects calls from clients outside of trusted subnets with PermissionDenied status.
+// Client address is taken from x-real-ip metadata if present, otherwise peer address is used
+type TrustedSubnet struct {
+	checker *subnet.Checker
+	logger  *zap.SugaredLogger
+}
+
+func NewTrustedSubnet(l *zap.Logger, checker *subnet.Checker) *TrustedSubnet {
+	return &TrustedSubnet{
+		checker: checker,
+		logger:  l.Sugar().With("component", "grpc-trusted-subnet"),
+	}
+}
+
+func (ts *TrustedSubnet) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if err := ts.check(ctx); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func (ts *TrustedSubnet) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := ts.check(ss.Context()); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+func (ts *TrustedSubnet) check(ctx context.Context) error {
+	if !ts.checker.Enabled() {
+		return nil
+	}
+
+	addr := peerAddress(ctx)
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(subnet.RealIPHeader); len(values) > 0 && values[0] != "" {
+			addr = values[0]
+		}
+	}
+	if !ts.checker.Trusted(addr) {
+		ts.logger.Debugw("call from untrusted address rejected", "address", addr)
+		return status.Error(codes.PermissionDenied, "address is not trusted")
+	}
+	return nil
+}
+
+// CallMetrics records duration and status code of each call in self-metrics the same way as REST logging
+// middleware records served requests. It goes first in the chain, so rejected calls are recorded as well
+type CallMetrics struct {
+	reg *selfmetrics.Registry
+}
+
+func NewCallMetrics(reg *selfmetrics.Registry) *CallMetrics {
+	return &CallMetrics{
+		reg: reg,
+	}
+}
+
+func (cm *CallMetrics) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		cm.record(info.FullMethod, err, time.Since(start))
+		return resp, err
+	}
+}
+
+func (cm *CallMetrics) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		err := handler(srv, ss)
+		cm.record(info.FullMethod, err, time.Since(start))
+		return err
+	}
+}
+
+func (cm *CallMetrics) record(method string, err error, duration time.Duration) {
+	labels := model.Labels{"method": method}
+	cm.reg.ObserveDuration("grpc.call.duration.seconds", labels, duration)
+
+	labels["code"] = status.Code(err).String()
+	cm.reg.AddCounter("grpc.calls", labels, 1)
+}
+
+// RealIPUnaryInterceptor is a client interceptor passing client IP address in x-real-ip metadata,
+// nothing is added if address is empty
+func RealIPUnaryInterceptor(ip string) grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply any,
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		if ip != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, strings.ToLower(subnet.RealIPHeader), ip)
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// TokenAuth authenticates calls by API token passed in authorization metadata as "Bearer <token>" the same way
+// as REST token middleware does: GetValue requires read scope, all other methods require write scope.
+// Calls without a known token are rejected with Unauthenticated status, calls lacking required scope -
+// with PermissionDenied status. All calls are passed through if API tokens are not configured
+type TokenAuth struct {
+	tokens *apitoken.Registry
+	logger *zap.SugaredLogger
+}
+
+func NewTokenAuth(l *zap.Logger, tokens *apitoken.Registry) *TokenAuth {
+	return &TokenAuth{
+		tokens: tokens,
+		logger: l.Sugar().With("component", "grpc-token-auth"),
+	}
+}
+
+func (ta *TokenAuth) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := ta.authenticate(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func (ta *TokenAuth) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := ta.authenticate(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &tokenStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+func (ta *TokenAuth) authenticate(ctx context.Context, method string) (context.Context, error) {
+	if !ta.tokens.Enabled() {
+		return ctx, nil
+	}
+
+	var value string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(TokenMetadataKey); len(values) > 0 {
+			value = apitoken.ParseBearer(values[0])
+		}
+	}
+	token, ok := ta.tokens.Authenticate(value)
+	if !ok {
+		ta.logger.Debugw("call without valid API token rejected", "method", method)
+		return nil, status.Error(codes.Unauthenticated, "API token is missing or invalid")
+	}
+
+	scope := apitoken.ScopeWrite
+	if method == pb.Metrics_GetValue_FullMethodName {
+		scope = apitoken.ScopeRead
+	}
+	if !token.Allows(scope) {
+		ta.logger.Debugw("API token lacks required scope", "token", token.Name, "scope", scope, "method", method)
+		return nil, status.Error(codes.PermissionDenied, "API token lacks required scope")
+	}
+	return apitoken.WithToken(ctx, token), nil
+}
+
+// tokenStream overrides stream context to pass authenticated token to the handler
+type tokenStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (ts *tokenStream) Context() context.Context {
+	return ts.ctx
+}
+
+// TokenUnaryInterceptor is a client interceptor passing API token in authorization metadata,
+// nothing is added if token is empty
+func TokenUnaryInterceptor(token string) grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply any,
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		if token != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, TokenMetadataKey, apitoken.BearerValue(token))
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
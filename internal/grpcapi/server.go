@@ -0,0 +1,260 @@
+// Package grpcapi contains gRPC transport of metrics-overseer: service implementation for the server,
+// conversions between protobuf and model types and interceptors for request signing and verification
+package grpcapi
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"time"
+
+	"github.com/andrewsvn/metrics-overseer/internal/apitoken"
+	"github.com/andrewsvn/metrics-overseer/internal/config/servercfg"
+	"github.com/andrewsvn/metrics-overseer/internal/model"
+	"github.com/andrewsvn/metrics-overseer/internal/replay"
+	"github.com/andrewsvn/metrics-overseer/internal/repository"
+	"github.com/andrewsvn/metrics-overseer/internal/selfmetrics"
+	"github.com/andrewsvn/metrics-overseer/internal/service"
+	"github.com/andrewsvn/metrics-overseer/internal/subnet"
+	pb "github.com/andrewsvn/metrics-overseer/pkg/proto/metrics"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// MetricsServer implements gRPC Metrics service on top of service.MetricsService,
+// it follows the same validation rules as REST handlers and maps errors to corresponding gRPC status codes
+type MetricsServer struct {
+	pb.UnimplementedMetricsServer
+
+	msrv   *service.MetricsService
+	logger *zap.SugaredLogger
+}
+
+func NewMetricsServer(msrv *service.MetricsService, l *zap.Logger) *MetricsServer {
+	return &MetricsServer{
+		msrv:   msrv,
+		logger: l.Sugar().With("component", "grpc-metrics-server"),
+	}
+}
+
+// NewGRPCServer creates gRPC server with Metrics service registered and security interceptors applied
+// in the same order as REST middlewares: trusted subnet check goes before API token and signature verification.
+// Connections are served over TLS with the same configuration as HTTPS if tlsCfg is specified,
+// all calls are recorded in self-metrics
+func NewGRPCServer(
+	msrv *service.MetricsService,
+	securityCfg *servercfg.SecurityConfig,
+	tokens *apitoken.Registry,
+	tlsCfg *tls.Config,
+	selfMetrics *selfmetrics.Registry,
+	l *zap.Logger,
+) (*grpc.Server, error) {
+	subnets, err := subnet.NewChecker(securityCfg.TrustedSubnets)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing trusted subnets: %w", err)
+	}
+	ts := NewTrustedSubnet(l, subnets)
+	ta := NewTokenAuth(l, tokens)
+	guard := replay.NewGuard(time.Duration(securityCfg.SignatureMaxAgeSec)*time.Second, securityCfg.NonceCacheSize)
+	keys, err := securityCfg.KeyRing()
+	if err != nil {
+		return nil, err
+	}
+	auth := NewAuthorization(l, keys, securityCfg.StrictSignatures, guard)
+	cm := NewCallMetrics(selfMetrics)
+
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(cm.UnaryInterceptor(),
+			ts.UnaryInterceptor(), ta.UnaryInterceptor(), auth.UnaryInterceptor()),
+		grpc.ChainStreamInterceptor(cm.StreamInterceptor(),
+			ts.StreamInterceptor(), ta.StreamInterceptor(), auth.StreamInterceptor()),
+	}
+	if tlsCfg != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsCfg)))
+	}
+	gs := grpc.NewServer(opts...)
+	pb.RegisterMetricsServer(gs, NewMetricsServer(msrv, l))
+	return gs, nil
+}
+
+func (s *MetricsServer) UpdateMetric(ctx context.Context, req *pb.UpdateMetricRequest) (*pb.UpdateMetricResponse, error) {
+	metric, err := s.metricFromRequest(req.GetMetric())
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.msrv.AccumulateMetric(ctx, metric, peerAddress(ctx))
+	if err != nil {
+		return nil, s.updateError(err)
+	}
+	return &pb.UpdateMetricResponse{}, nil
+}
+
+// UpdateBatch accumulates all metrics of the request, if batch ID is passed in metadata
+// the batch is applied only once and a resent batch is acknowledged without applying it again
+func (s *MetricsServer) UpdateBatch(ctx context.Context, req *pb.UpdateBatchRequest) (*pb.UpdateBatchResponse, error) {
+	batchID, err := batchIDFromContext(ctx)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	metrics := make([]*model.Metrics, 0, len(req.GetMetrics()))
+	for _, pm := range req.GetMetrics() {
+		metric, err := s.metricFromRequest(pm)
+		if err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, metric)
+	}
+
+	s.logger.Debugw("Trying to update metrics",
+		"count", len(metrics),
+	)
+	_, err = s.msrv.BatchAccumulateMetricsOnce(ctx, batchID, metrics, peerAddress(ctx))
+	if err != nil {
+		return nil, s.updateError(err)
+	}
+	return &pb.UpdateBatchResponse{}, nil
+}
+
+// batchIDFromContext parses batch ID from incoming metadata, nil is returned if it's not passed
+func batchIDFromContext(ctx context.Context) (*model.BatchID, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil, nil
+	}
+	value := firstMetadataValue(md, BatchIDMetadataKey)
+	if value == "" {
+		return nil, nil
+	}
+	return model.ParseBatchID(value)
+}
+
+func (s *MetricsServer) GetValue(ctx context.Context, req *pb.GetValueRequest) (*pb.GetValueResponse, error) {
+	mtype, err := TypeFromProto(req.GetType())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	labels := model.Labels(req.GetLabels())
+	if err := labels.Validate(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	metric, err := s.msrv.GetMetric(ctx, req.GetId(), mtype, labels)
+	if err != nil {
+		if errors.Is(err, repository.ErrMetricNotFound) || errors.Is(err, repository.ErrIncorrectAccess) {
+			return nil, status.Errorf(codes.NotFound, "metric %s not found", req.GetId())
+		}
+		s.logger.Errorw("error getting metric", "id", req.GetId(), "error", err)
+		return nil, status.Error(codes.Internal, "error getting metric")
+	}
+	return &pb.GetValueResponse{Metric: MetricToProto(metric)}, nil
+}
+
+// Updates accumulates streamed metrics one by one, the stream is aborted on the first invalid metric
+// and the response contains number of metrics accepted before the failure
+func (s *MetricsServer) Updates(stream pb.Metrics_UpdatesServer) error {
+	ctx := stream.Context()
+	addr := peerAddress(ctx)
+
+	var accepted int64
+	for {
+		upd, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return stream.SendAndClose(&pb.UpdatesResponse{Accepted: accepted})
+		}
+		if err != nil {
+			return err
+		}
+
+		metric, err := s.metricFromRequest(upd.GetMetric())
+		if err != nil {
+			return err
+		}
+		err = s.msrv.AccumulateMetric(ctx, metric, addr)
+		if err != nil {
+			return s.updateError(err)
+		}
+		accepted++
+	}
+}
+
+func (s *MetricsServer) metricFromRequest(pm *pb.Metric) (*model.Metrics, error) {
+	metric, err := MetricFromProto(pm)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := validateMetric(metric); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "metric %s: %v", metric.ID, err)
+	}
+	return metric, nil
+}
+
+func (s *MetricsServer) updateError(err error) error {
+	switch {
+	case errors.Is(err, service.ErrUnsupportedMetricType), errors.Is(err, service.ErrMetricValueNotProvided),
+		errors.Is(err, service.ErrReservedMetricID), errors.Is(err, model.ErrInvalidMetricID):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, repository.ErrIncorrectAccess):
+		return status.Error(codes.FailedPrecondition, "wrong metric type")
+	case errors.Is(err, model.ErrHistogramBucketsMismatch):
+		return status.Error(codes.FailedPrecondition, "histogram buckets don't match stored ones")
+	case errors.Is(err, model.ErrValueOverflow):
+		return status.Error(codes.FailedPrecondition, "accumulated metric value overflows")
+	}
+	s.logger.Errorw("error updating metrics", "error", err)
+	return status.Error(codes.Internal, "error updating metrics")
+}
+
+func validateMetric(metric *model.Metrics) error {
+	if err := metric.Labels.Validate(); err != nil {
+		return err
+	}
+
+	switch metric.MType {
+	case model.Counter:
+		if metric.Delta == nil {
+			return fmt.Errorf("missing counter metric value")
+		}
+	case model.Gauge:
+		if metric.Value == nil {
+			return fmt.Errorf("missing gauge metric value")
+		}
+		if math.IsNaN(*metric.Value) || math.IsInf(*metric.Value, 0) {
+			return fmt.Errorf("gauge metric value must be finite")
+		}
+	case model.Histogram:
+		if metric.Histogram == nil {
+			return fmt.Errorf("missing histogram metric value")
+		}
+		return metric.Histogram.Validate()
+	case model.Summary:
+		if metric.Summary == nil {
+			return fmt.Errorf("missing summary metric value")
+		}
+		return metric.Summary.Validate()
+	}
+	return nil
+}
+
+// peerAddress returns client IP address for auditing, "N/A" is returned if it's unknown as in REST handlers
+func peerAddress(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return "N/A"
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return "N/A"
+	}
+	return host
+}
@@ -0,0 +1,473 @@
+package grpcapi
+
+import (
+	"context"
+	"encoding/base64"
+	"math"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/andrewsvn/metrics-overseer/internal/apitoken"
+	"github.com/andrewsvn/metrics-overseer/internal/config/servercfg"
+	"github.com/andrewsvn/metrics-overseer/internal/encrypt"
+	"github.com/andrewsvn/metrics-overseer/internal/logging"
+	"github.com/andrewsvn/metrics-overseer/internal/model"
+	"github.com/andrewsvn/metrics-overseer/internal/repository"
+	"github.com/andrewsvn/metrics-overseer/internal/selfmetrics"
+	"github.com/andrewsvn/metrics-overseer/internal/service"
+	pb "github.com/andrewsvn/metrics-overseer/pkg/proto/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const testSecretKey = "secret"
+
+func testKeyRing(t *testing.T) *encrypt.KeyRing {
+	keys, err := encrypt.NewKeyRingBuilder().PrimaryKey("", testSecretKey).Build()
+	require.NoError(t, err)
+	return keys
+}
+
+func TestUpdateAndGetValue(t *testing.T) {
+	client := setupClient(t, &servercfg.SecurityConfig{})
+	ctx := context.Background()
+
+	_, err := client.UpdateMetric(ctx, &pb.UpdateMetricRequest{
+		Metric: MetricToProto(model.NewCounterMetricsWithDelta("cnt1", 5)),
+	})
+	require.NoError(t, err)
+	_, err = client.UpdateBatch(ctx, &pb.UpdateBatchRequest{
+		Metrics: []*pb.Metric{
+			MetricToProto(model.NewCounterMetricsWithDelta("cnt1", 7)),
+			MetricToProto(model.NewGaugeMetricsWithValue("cpu", 0.5).WithLabels(model.Labels{"host": "a"})),
+		},
+	})
+	require.NoError(t, err)
+
+	resp, err := client.GetValue(ctx, &pb.GetValueRequest{Id: "cnt1", Type: pb.MetricType_METRIC_TYPE_COUNTER})
+	require.NoError(t, err)
+	assert.Equal(t, int64(12), resp.GetMetric().GetDelta())
+
+	resp, err = client.GetValue(ctx, &pb.GetValueRequest{
+		Id:     "cpu",
+		Type:   pb.MetricType_METRIC_TYPE_GAUGE,
+		Labels: map[string]string{"host": "a"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 0.5, resp.GetMetric().GetValue())
+	assert.Equal(t, map[string]string{"host": "a"}, resp.GetMetric().GetLabels())
+
+	_, err = client.GetValue(ctx, &pb.GetValueRequest{Id: "cpu", Type: pb.MetricType_METRIC_TYPE_GAUGE})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = client.UpdateMetric(ctx, &pb.UpdateMetricRequest{
+		Metric: &pb.Metric{Id: "cnt1", Type: pb.MetricType_METRIC_TYPE_COUNTER},
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.UpdateMetric(ctx, &pb.UpdateMetricRequest{
+		Metric: MetricToProto(model.NewGaugeMetricsWithValue("cnt1", 1)),
+	})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
+
+func TestNonFiniteValuesRejected(t *testing.T) {
+	client := setupClient(t, &servercfg.SecurityConfig{})
+	ctx := context.Background()
+
+	hv := model.NewHistogramValue(1, 5)
+	hv.Observe(3)
+	hv.Sum = math.Inf(1)
+	sv := model.NewSummaryValue()
+	sv.Add(1)
+	sv.Min = math.NaN()
+
+	for _, m := range []*model.Metrics{
+		model.NewGaugeMetricsWithValue("gauge1", math.NaN()),
+		model.NewGaugeMetricsWithValue("gauge1", math.Inf(-1)),
+		model.NewHistogramMetricsWithValue("hist1", hv),
+		model.NewSummaryMetricsWithValue("sum1", sv),
+	} {
+		_, err := client.UpdateMetric(ctx, &pb.UpdateMetricRequest{Metric: MetricToProto(m)})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), m.ID)
+	}
+
+	_, err := client.UpdateBatch(ctx, &pb.UpdateBatchRequest{
+		Metrics: []*pb.Metric{
+			MetricToProto(model.NewCounterMetricsWithDelta("cnt1", 1)),
+			MetricToProto(model.NewGaugeMetricsWithValue("gauge1", math.Inf(1))),
+		},
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = client.GetValue(ctx, &pb.GetValueRequest{Id: "cnt1", Type: pb.MetricType_METRIC_TYPE_COUNTER})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestUpdatesStream(t *testing.T) {
+	client := setupClient(t, &servercfg.SecurityConfig{SecretKey: testSecretKey},
+		grpc.WithChainStreamInterceptor(SigningStreamInterceptor(testKeyRing(t))))
+	ctx := context.Background()
+
+	stream, err := client.Updates(ctx)
+	require.NoError(t, err)
+	for _, m := range []*model.Metrics{
+		model.NewCounterMetricsWithDelta("cnt1", 1),
+		model.NewCounterMetricsWithDelta("cnt1", 2),
+		model.NewGaugeMetricsWithValue("gauge1", 3.14),
+	} {
+		require.NoError(t, stream.Send(&pb.MetricUpdate{Metric: MetricToProto(m)}))
+	}
+	resp, err := stream.CloseAndRecv()
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), resp.GetAccepted())
+
+	value, err := client.GetValue(ctx, &pb.GetValueRequest{Id: "cnt1", Type: pb.MetricType_METRIC_TYPE_COUNTER})
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), value.GetMetric().GetDelta())
+
+	// legacy stream with messages signed over the metric only is accepted outside of strict mode
+	legacy := setupClient(t, &servercfg.SecurityConfig{SecretKey: testSecretKey})
+	stream, err = legacy.Updates(ctx)
+	require.NoError(t, err)
+	upd := &pb.MetricUpdate{Metric: MetricToProto(model.NewCounterMetricsWithDelta("cnt1", 4))}
+	require.NoError(t, SignMetricUpdate(testKeyRing(t), upd))
+	require.NoError(t, stream.Send(upd))
+	resp, err = stream.CloseAndRecv()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), resp.GetAccepted())
+
+	stream, err = legacy.Updates(ctx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&pb.MetricUpdate{
+		Metric:    MetricToProto(model.NewCounterMetricsWithDelta("cnt1", 1)),
+		Signature: []byte("wrong"),
+	}))
+	_, err = stream.CloseAndRecv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestSignatureVerification(t *testing.T) {
+	// client signs with the same key as server
+	client := setupClient(t, &servercfg.SecurityConfig{SecretKey: testSecretKey}, grpc.WithChainUnaryInterceptor(SigningUnaryInterceptor(testKeyRing(t))))
+	req := &pb.UpdateMetricRequest{
+		Metric: MetricToProto(model.NewGaugeMetricsWithValue("cpu", 0.5).WithLabels(model.Labels{"host": "a", "core": "1"})),
+	}
+	_, err := client.UpdateMetric(context.Background(), req)
+	require.NoError(t, err)
+
+	// unsigned requests are accepted for backward compatibility
+	unsigned := setupClient(t, &servercfg.SecurityConfig{SecretKey: testSecretKey})
+	_, err = unsigned.UpdateMetric(context.Background(), req)
+	require.NoError(t, err)
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(),
+		SignMetadataKey, base64.StdEncoding.EncodeToString([]byte("wrong")))
+	_, err = unsigned.UpdateMetric(ctx, req)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	ctx = metadata.AppendToOutgoingContext(context.Background(), SignMetadataKey, "not base64!")
+	_, err = unsigned.UpdateMetric(ctx, req)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestStrictSignatures(t *testing.T) {
+	securityCfg := &servercfg.SecurityConfig{SecretKey: testSecretKey, StrictSignatures: true}
+	req := &pb.UpdateMetricRequest{Metric: MetricToProto(model.NewCounterMetricsWithDelta("cnt1", 1))}
+
+	// client capturing metadata of signed calls to replay them later
+	var captured metadata.MD
+	capture := func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		captured, _ = metadata.FromOutgoingContext(ctx)
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+	signed := setupClient(t, securityCfg,
+		grpc.WithChainUnaryInterceptor(SigningUnaryInterceptor(testKeyRing(t)), capture))
+	_, err := signed.UpdateMetric(context.Background(), req)
+	require.NoError(t, err)
+
+	// unsigned calls are rejected, reading calls don't require signature
+	_, err = signed.UpdateBatch(context.Background(), &pb.UpdateBatchRequest{})
+	require.NoError(t, err)
+	_, err = signed.GetValue(context.Background(), &pb.GetValueRequest{Id: "cnt1", Type: pb.MetricType_METRIC_TYPE_COUNTER})
+	require.NoError(t, err)
+
+	unsigned := setupClient(t, securityCfg)
+	_, err = unsigned.UpdateMetric(context.Background(), req)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = unsigned.GetValue(context.Background(), &pb.GetValueRequest{Id: "cnt1", Type: pb.MetricType_METRIC_TYPE_COUNTER})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	// signature is bound to method: captured metadata belongs to GetValue call
+	replayed := setupClient(t, securityCfg)
+	_, err = replayed.UpdateMetric(metadata.NewOutgoingContext(context.Background(), captured), req)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// the same signed call is accepted only once by a server
+
+	_, err = signed.UpdateMetric(context.Background(), req)
+	require.NoError(t, err)
+	replayCtx := metadata.NewOutgoingContext(context.Background(), captured)
+	_, err = replayed.UpdateMetric(replayCtx, req)
+	require.NoError(t, err)
+	_, err = replayed.UpdateMetric(replayCtx, req)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// payload-only signature covering message only
+	payload, err := marshalForSigning(req)
+	require.NoError(t, err)
+	keyID, sign := testKeyRing(t).Sign(payload)
+	legacyCtx := metadata.AppendToOutgoingContext(context.Background(),
+		SignKeyIDMetadataKey, keyID,
+		SignMetadataKey, base64.StdEncoding.EncodeToString(sign))
+	_, err = unsigned.UpdateMetric(legacyCtx, req)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// streams must be signed with timestamp and nonce, legacy message signatures are not enough
+	stream, err := unsigned.Updates(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&pb.MetricUpdate{Metric: req.GetMetric()}))
+	_, err = stream.CloseAndRecv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	stream, err = unsigned.Updates(context.Background())
+	require.NoError(t, err)
+	upd := &pb.MetricUpdate{Metric: req.GetMetric()}
+	require.NoError(t, SignMetricUpdate(testKeyRing(t), upd))
+	require.NoError(t, stream.Send(upd))
+	_, err = stream.CloseAndRecv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestStreamReplayProtection(t *testing.T) {
+	client := setupClient(t, &servercfg.SecurityConfig{SecretKey: testSecretKey, StrictSignatures: true})
+	ctx := context.Background()
+	ts := time.Now().Unix()
+
+	// record a signed stream
+	recordedCtx := signedStreamContext(t, ctx, ts, "recorded")
+	recorded := []*pb.MetricUpdate{
+		signedStreamMessage(t, ts, "recorded", 1, model.NewCounterMetricsWithDelta("cnt1", 1)),
+		signedStreamMessage(t, ts, "recorded", 2, model.NewCounterMetricsWithDelta("cnt1", 2)),
+	}
+	stream, err := client.Updates(recordedCtx)
+	require.NoError(t, err)
+	for _, upd := range recorded {
+		require.NoError(t, stream.Send(upd))
+	}
+	resp, err := stream.CloseAndRecv()
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), resp.GetAccepted())
+
+	// recorded stream can't be opened again
+	stream, err = client.Updates(recordedCtx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(recorded[0]))
+	_, err = stream.CloseAndRecv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// recorded message is not valid in another stream
+	stream, err = client.Updates(signedStreamContext(t, ctx, ts, "another"))
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(recorded[0]))
+	_, err = stream.CloseAndRecv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// message can't be repeated within the same stream
+	stream, err = client.Updates(signedStreamContext(t, ctx, ts, "repeated"))
+	require.NoError(t, err)
+	upd := signedStreamMessage(t, ts, "repeated", 1, model.NewCounterMetricsWithDelta("cnt1", 5))
+	require.NoError(t, stream.Send(upd))
+	require.NoError(t, stream.Send(upd))
+	_, err = stream.CloseAndRecv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// the first message of the repeated stream has been accepted before the failure
+	value, err := client.GetValue(ctx, &pb.GetValueRequest{Id: "cnt1", Type: pb.MetricType_METRIC_TYPE_COUNTER})
+	require.NoError(t, err)
+	assert.Equal(t, int64(8), value.GetMetric().GetDelta())
+}
+
+// signedStreamMessage signs metric update the same way SigningStreamInterceptor does
+func signedStreamMessage(t *testing.T, ts int64, nonce string, seq uint64, m *model.Metrics) *pb.MetricUpdate {
+	upd := &pb.MetricUpdate{Metric: MetricToProto(m)}
+	payload, err := marshalForSigning(upd.GetMetric())
+	require.NoError(t, err)
+	upd.KeyId, upd.Signature = testKeyRing(t).Sign(encrypt.RequestContent(pb.Metrics_Updates_FullMethodName, ts,
+		streamMessageNonce(nonce, seq), payload))
+	return upd
+}
+
+// signedStreamContext signs Updates stream call with given timestamp and nonce
+func signedStreamContext(t *testing.T, ctx context.Context, ts int64, nonce string) context.Context {
+	keyID, sign := testKeyRing(t).Sign(encrypt.RequestContent(pb.Metrics_Updates_FullMethodName, ts, nonce, nil))
+	return metadata.AppendToOutgoingContext(ctx,
+		SignKeyIDMetadataKey, keyID,
+		SignTimestampMetadataKey, strconv.FormatInt(ts, 10),
+		SignNonceMetadataKey, nonce,
+		SignMetadataKey, base64.StdEncoding.EncodeToString(sign),
+	)
+}
+
+func TestTrustedSubnet(t *testing.T) {
+	req := &pb.UpdateMetricRequest{Metric: MetricToProto(model.NewCounterMetricsWithDelta("cnt1", 1))}
+
+	// bufconn peer address is not an IP, so only x-real-ip metadata can pass the check
+	client := setupClient(t, &servercfg.SecurityConfig{TrustedSubnets: []string{"10.0.0.0/8"}})
+	_, err := client.UpdateMetric(context.Background(), req)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	trusted := setupClient(t, &servercfg.SecurityConfig{TrustedSubnets: []string{"10.0.0.0/8"}},
+		grpc.WithChainUnaryInterceptor(RealIPUnaryInterceptor("10.1.2.3")))
+	_, err = trusted.UpdateMetric(context.Background(), req)
+	require.NoError(t, err)
+
+	untrusted := setupClient(t, &servercfg.SecurityConfig{TrustedSubnets: []string{"10.0.0.0/8"}},
+		grpc.WithChainUnaryInterceptor(RealIPUnaryInterceptor("192.168.1.1")))
+	_, err = untrusted.UpdateMetric(context.Background(), req)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestTokenAuth(t *testing.T) {
+	store := apitoken.StaticStore{
+		{Name: "writer", Hash: apitoken.Hash("write-token"), Scopes: []apitoken.Scope{apitoken.ScopeWrite}},
+		{Name: "reader", Hash: apitoken.Hash("read-token"), Scopes: []apitoken.Scope{apitoken.ScopeRead}},
+	}
+	updReq := &pb.UpdateMetricRequest{Metric: MetricToProto(model.NewCounterMetricsWithDelta("cnt1", 1))}
+	getReq := &pb.GetValueRequest{Id: "cnt1", Type: pb.MetricType_METRIC_TYPE_COUNTER}
+
+	anonymous := setupClientWithTokens(t, &servercfg.SecurityConfig{}, store)
+	_, err := anonymous.UpdateMetric(context.Background(), updReq)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	invalid := setupClientWithTokens(t, &servercfg.SecurityConfig{}, store,
+		grpc.WithChainUnaryInterceptor(TokenUnaryInterceptor("unknown")))
+	_, err = invalid.UpdateMetric(context.Background(), updReq)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	writer := setupClientWithTokens(t, &servercfg.SecurityConfig{}, store,
+		grpc.WithChainUnaryInterceptor(TokenUnaryInterceptor("write-token")))
+	_, err = writer.UpdateMetric(context.Background(), updReq)
+	require.NoError(t, err)
+	_, err = writer.GetValue(context.Background(), getReq)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	reader := setupClientWithTokens(t, &servercfg.SecurityConfig{}, store,
+		grpc.WithChainUnaryInterceptor(TokenUnaryInterceptor("read-token")))
+	_, err = reader.UpdateMetric(context.Background(), updReq)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = reader.GetValue(context.Background(), getReq)
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestCallMetrics(t *testing.T) {
+	reg := selfmetrics.NewRegistry()
+	cm := NewCallMetrics(reg)
+
+	unary := cm.UnaryInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: pb.Metrics_UpdateMetric_FullMethodName}
+	ok := func(context.Context, any) (any, error) {
+		return &pb.UpdateMetricResponse{}, nil
+	}
+	rejected := func(context.Context, any) (any, error) {
+		return nil, status.Error(codes.PermissionDenied, "address is not trusted")
+	}
+	_, err := unary(context.Background(), nil, info, ok)
+	require.NoError(t, err)
+	_, err = unary(context.Background(), nil, info, ok)
+	require.NoError(t, err)
+	_, err = unary(context.Background(), nil, info, rejected)
+	require.Error(t, err)
+
+	stream := cm.StreamInterceptor()
+	streamInfo := &grpc.StreamServerInfo{FullMethod: pb.Metrics_Updates_FullMethodName}
+	require.NoError(t, stream(nil, nil, streamInfo, func(any, grpc.ServerStream) error { return nil }))
+
+	calls := make(map[string]int64)
+	durations := make(map[string]int64)
+	for _, m := range reg.Snapshot() {
+		switch m.ID {
+		case selfmetrics.Prefix + "grpc.calls":
+			calls[m.Labels["method"]+" "+m.Labels["code"]] = *m.Delta
+		case selfmetrics.Prefix + "grpc.call.duration.seconds":
+			durations[m.Labels["method"]] = m.Histogram.Count
+		}
+	}
+	assert.Equal(t, map[string]int64{
+		pb.Metrics_UpdateMetric_FullMethodName + " OK":               2,
+		pb.Metrics_UpdateMetric_FullMethodName + " PermissionDenied": 1,
+		pb.Metrics_Updates_FullMethodName + " OK":                    1,
+	}, calls)
+	assert.Equal(t, map[string]int64{
+		pb.Metrics_UpdateMetric_FullMethodName: 3,
+		pb.Metrics_Updates_FullMethodName:      1,
+	}, durations)
+}
+
+func TestMetricConversion(t *testing.T) {
+	hv := model.NewHistogramValue(1, 5)
+	hv.Observe(3)
+	sv := model.NewSummaryValue()
+	sv.Add(1)
+	sv.Add(2)
+
+	for _, m := range []*model.Metrics{
+		model.NewCounterMetricsWithDelta("cnt1", 10),
+		model.NewGaugeMetricsWithValue("gauge1", 0).WithLabels(model.Labels{"host": "a"}),
+		model.NewHistogramMetricsWithValue("hist1", hv),
+		model.NewSummaryMetricsWithValue("sum1", sv),
+	} {
+		converted, err := MetricFromProto(MetricToProto(m))
+		require.NoError(t, err)
+		assert.Equal(t, m, converted)
+	}
+
+	_, err := MetricFromProto(&pb.Metric{Id: "x"})
+	assert.Error(t, err)
+}
+
+func setupClient(t *testing.T, securityCfg *servercfg.SecurityConfig, opts ...grpc.DialOption) pb.MetricsClient {
+	return setupClientWithTokens(t, securityCfg, nil, opts...)
+}
+
+// setupClientWithTokens starts server with API tokens checks enabled if store is not nil
+func setupClientWithTokens(
+	t *testing.T,
+	securityCfg *servercfg.SecurityConfig,
+	store apitoken.Store,
+	opts ...grpc.DialOption,
+) pb.MetricsClient {
+	logger, _ := logging.NewZapLogger("info")
+	msrv := service.NewMetricsService(repository.NewMemStorage(), logger)
+	tokens := apitoken.NewRegistry(store, logger)
+	require.NoError(t, tokens.Reload(context.Background()))
+	gs, err := NewGRPCServer(msrv, securityCfg, tokens, nil, nil, logger)
+	require.NoError(t, err)
+
+	lis := bufconn.Listen(1024 * 1024)
+	go func() {
+		_ = gs.Serve(lis)
+	}()
+	t.Cleanup(gs.Stop)
+
+	opts = append(opts,
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return lis.Dial()
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	conn, err := grpc.NewClient("passthrough:///bufnet", opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	return pb.NewMetricsClient(conn)
+}
@@ -1,6 +1,11 @@
 package errorhandling
 
-import "net/http"
+import (
+	"errors"
+	"net/http"
+
+	"github.com/andrewsvn/metrics-overseer/internal/compress"
+)
 
 type Error struct {
 	StatusCode int
@@ -22,6 +27,24 @@ func NewNotFoundHandlerError(message string) *Error {
 	}
 }
 
+// NewBodyReadError reports request body reading failure, it's 413 if body exceeds size limits
+// (either as sent or decompressed) and 400 otherwise
+func NewBodyReadError(message string, err error) *Error {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) || errors.Is(err, compress.ErrBodyTooLarge) {
+		return &Error{
+			StatusCode: http.StatusRequestEntityTooLarge,
+			Message:    message + ": request body is too large",
+			Error:      err,
+		}
+	}
+	return &Error{
+		StatusCode: http.StatusBadRequest,
+		Message:    message,
+		Error:      err,
+	}
+}
+
 func NewInternalServerError(err error) *Error {
 	return &Error{
 		StatusCode: http.StatusInternalServerError,
@@ -3,23 +3,32 @@ package handler
 import (
 	"bytes"
 	"context"
+	"crypto/ecdh"
 	"crypto/rsa"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/andrewsvn/metrics-overseer/internal/apitoken"
 	"github.com/andrewsvn/metrics-overseer/internal/config/servercfg"
 	"github.com/andrewsvn/metrics-overseer/internal/encrypt"
 	"github.com/andrewsvn/metrics-overseer/internal/handler/errorhandling"
 	"github.com/andrewsvn/metrics-overseer/internal/handler/middleware"
 	"github.com/andrewsvn/metrics-overseer/internal/model"
+	"github.com/andrewsvn/metrics-overseer/internal/promtext"
+	"github.com/andrewsvn/metrics-overseer/internal/remotewrite"
+	"github.com/andrewsvn/metrics-overseer/internal/replay"
 	"github.com/andrewsvn/metrics-overseer/internal/repository"
+	"github.com/andrewsvn/metrics-overseer/internal/selfmetrics"
 	"github.com/andrewsvn/metrics-overseer/internal/service"
+	"github.com/andrewsvn/metrics-overseer/internal/subnet"
 	"github.com/go-chi/chi/v5"
 	"go.uber.org/zap"
 )
@@ -35,6 +44,10 @@ import (
 // @In Header
 // @Name signAuth
 
+// @SecurityDefinitions.apikey TokenAuth
+// @In Header
+// @Name Authorization
+
 // @Tag.name Maintenance
 // @Tag.description endpoints group for controlling and providing inner service state
 
@@ -47,7 +60,14 @@ import (
 type MetricsHandlers struct {
 	msrv        *service.MetricsService
 	securityCfg *servercfg.SecurityConfig
-	decrypter   encrypt.Decrypter
+	decrypters  []encrypt.Decrypter
+	respKeys    map[string]encrypt.Encrypter
+	keys        *encrypt.KeyRing
+	subnets     *subnet.Checker
+	tokens      *apitoken.Registry
+	replayGuard *replay.Guard
+	bodyLimit   *middleware.BodyLimit
+	selfMetrics *selfmetrics.Registry
 
 	baseLogger *zap.Logger
 	logger     *zap.SugaredLogger
@@ -56,11 +76,18 @@ type MetricsHandlers struct {
 const (
 	logErrorWriteBody = "error writing response body"
 	logErrorGenHTML   = "error generating metrics html"
+	logErrorGenProm   = "error generating prometheus metrics"
+
+	defaultHistoryRange = time.Hour
+	// labelParamPrefix marks query parameters holding metric labels, e.g. label.host=web-1
+	labelParamPrefix = "label."
 )
 
 func NewMetricsHandlers(
 	ms *service.MetricsService,
 	securityCfg *servercfg.SecurityConfig,
+	tokens *apitoken.Registry,
+	selfMetrics *selfmetrics.Registry,
 	logger *zap.Logger,
 ) (*MetricsHandlers, error) {
 	mhLogger := logger.Sugar().With(zap.String("component", "metrics-handlers"))
@@ -74,35 +101,108 @@ func NewMetricsHandlers(
 		}
 		mhLogger.Infow("using RSA private key for request decryption")
 	}
+	var x25519Key *ecdh.PrivateKey
+	if securityCfg.X25519PrivateKeyPath != "" {
+		var err error
+		x25519Key, err = encrypt.ReadX25519PrivateKeyFromFile(securityCfg.X25519PrivateKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("error reading X25519 private key for decryption: %w", err)
+		}
+		mhLogger.Infow("using X25519 private key for request decryption")
+	}
+
+	respKeys, err := encrypt.ReadResponseKeys(securityCfg.ResponseKeys...)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := securityCfg.KeyRing()
+	if err != nil {
+		return nil, err
+	}
+
+	subnets, err := subnet.NewChecker(securityCfg.TrustedSubnets)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing trusted subnets: %w", err)
+	}
+	if subnets.Enabled() {
+		mhLogger.Infow("restricting updates to trusted subnets", "subnets", securityCfg.TrustedSubnets)
+	}
+
+	replayGuard := replay.NewGuard(time.Duration(securityCfg.SignatureMaxAgeSec)*time.Second, securityCfg.NonceCacheSize)
+
+	decrypters := []encrypt.Decrypter{
+		encrypt.NewRSAEngineBuilder().PrivateKey(privKey).Build(),
+		encrypt.NewX25519EngineBuilder().PrivateKey(x25519Key).Build(),
+	}
+
+	bodyLimit := middleware.NewBodyLimit(logger, securityCfg.MaxBodySize)
+	selfMetrics.CounterFunc("http.body.rejected", model.Labels{"limit": "sent"}, bodyLimit.RejectedSent)
+	selfMetrics.CounterFunc("http.body.rejected", model.Labels{"limit": "decompressed"}, bodyLimit.RejectedDecompressed)
 
 	return &MetricsHandlers{
 		msrv:        ms,
-		decrypter:   encrypt.NewRSAEngineBuilder().PrivateKey(privKey).Build(),
+		decrypters:  decrypters,
+		respKeys:    respKeys,
+		keys:        keys,
+		subnets:     subnets,
+		tokens:      tokens,
+		replayGuard: replayGuard,
+		bodyLimit:   bodyLimit,
+		selfMetrics: selfMetrics,
 		baseLogger:  logger,
 		logger:      mhLogger,
 		securityCfg: securityCfg,
 	}, nil
 }
 
+// BodyLimit returns request body limiting middleware shared by all routes, it counts rejected requests
+func (mh *MetricsHandlers) BodyLimit() *middleware.BodyLimit {
+	return mh.bodyLimit
+}
+
 func (mh *MetricsHandlers) GetRouter() *chi.Mux {
 	r := chi.NewRouter()
 
 	// For secured requests middlewares applied in a given order - so a client which applies multiple transformations
 	// and checks to their request must apply them in the corresponding order (only for the body part):
-	// - encrypt request body with an RSA public key if it is specified
+	// - encrypt request body with an RSA or X25519 public key if it is specified (and set encryption scheme header)
 	// - compress the body if needed
-	// - sign the body if secret key is available
+	// - sign the body along with timestamp, nonce, method and URI if secret key is available
+	tokenAuth := middleware.NewTokenAuth(mh.baseLogger, mh.tokens)
 	secureR := r.With(
-		middleware.NewHTTPLogging(mh.baseLogger).Middleware,
-		middleware.NewAuthorization(mh.baseLogger, mh.securityCfg.SecretKey).Middleware,
-		middleware.NewCompressing(mh.baseLogger).Middleware,
-		middleware.NewDecryption(mh.baseLogger, mh.decrypter).Middleware,
+		middleware.NewHTTPLogging(mh.baseLogger, mh.selfMetrics).Middleware,
+		mh.bodyLimit.Middleware,
+		middleware.NewTrustedSubnet(mh.baseLogger, mh.subnets).Middleware,
+		tokenAuth.Require(apitoken.ScopeWrite),
+		middleware.NewAuthorization(mh.baseLogger, mh.keys, mh.securityCfg.StrictSignatures, mh.replayGuard).Middleware,
+		middleware.NewCompressing(mh.baseLogger, mh.securityCfg.MaxDecompressedBodySize).Middleware,
+		middleware.NewDecryption(mh.baseLogger, mh.decrypters...).Middleware,
 	)
 
-	// For non-secured requests (status, metrics reading) sign verification and authentication are disabled
+	// For non-secured requests (metrics reading) sign verification is disabled, only API token is checked.
+	// Responses are processed in reverse order, so a client asking for encrypted response must:
+	// - decompress the body if it is compressed
+	// - decrypt the body with its private key if encryption scheme header is set
+	// - verify signature of the decrypted body if secret key is available
 	plainR := r.With(
-		middleware.NewHTTPLogging(mh.baseLogger).Middleware,
-		middleware.NewCompressing(mh.baseLogger).Middleware,
+		middleware.NewHTTPLogging(mh.baseLogger, mh.selfMetrics).Middleware,
+		mh.bodyLimit.Middleware,
+		tokenAuth.Require(apitoken.ScopeRead),
+		middleware.NewCompressing(mh.baseLogger, mh.securityCfg.MaxDecompressedBodySize).Middleware,
+		middleware.NewResponseEncryption(mh.baseLogger, mh.respKeys).Middleware,
+	)
+
+	// Status requests are available without authentication so they can be used by health checks
+	statusR := r.With(
+		middleware.NewHTTPLogging(mh.baseLogger, mh.selfMetrics).Middleware,
+	)
+
+	adminR := r.With(
+		middleware.NewHTTPLogging(mh.baseLogger, mh.selfMetrics).Middleware,
+		mh.bodyLimit.Middleware,
+		middleware.NewTrustedSubnet(mh.baseLogger, mh.subnets).Middleware,
+		tokenAuth.Require(apitoken.ScopeAdmin),
 	)
 
 	// secure routes
@@ -113,21 +213,33 @@ func (mh *MetricsHandlers) GetRouter() *chi.Mux {
 	secureR.Route("/updates", func(r chi.Router) {
 		r.Post("/", mh.updateBatchHandler())
 	})
+	secureR.Post("/api/v1/write", mh.remoteWriteHandler())
 
 	// unsecure routes
 	plainR.Route("/value", func(r chi.Router) {
 		r.Post("/", mh.getJSONValueHandler())
 	})
 	plainR.Get("/value/{mtype}/{id}", mh.getPlainValueHandler())
+	plainR.Route("/values", func(r chi.Router) {
+		r.Post("/", mh.getJSONValuesHandler())
+	})
+	plainR.Get("/history/{mtype}/{id}", mh.getHistoryHandler())
+
+	// prometheus scraping
+	plainR.Get("/metrics", mh.prometheusMetricsHandler())
+	plainR.Get("/metrics/self", mh.selfMetricsHandler())
 
 	// UI
 	plainR.Get("/", mh.showMetricsPageHandler())
 
 	// ping storage
-	plainR.Route("/ping", func(r chi.Router) {
+	statusR.Route("/ping", func(r chi.Router) {
 		r.Get("/", mh.pingStorageHandler())
 	})
 
+	// administration
+	adminR.Post("/admin/tokens/reload", mh.reloadTokensHandler())
+
 	return r
 }
 
@@ -136,6 +248,8 @@ func (mh *MetricsHandlers) GetRouter() *chi.Mux {
 // @Description Renders metrics page containing table with all collected metrics and their values - sorted alphabetically
 // @ID uiMetricsPage
 // @Produce html
+// @Param X-Response-Key header string false "Client public key (BASE64 DER) for response encryption"
+// @Param X-Response-Key-Id header string false "Identifier of server-known client public key for response encryption"
 // @Success 200 {object} service.MetricsPage
 // @Failure 500 {string} string "Internal server error"
 // @Security SecretKeyAuth
@@ -158,7 +272,7 @@ func (mh *MetricsHandlers) showMetricsPage(rw http.ResponseWriter, r *http.Reque
 
 	payload := pageWriter.Bytes()
 
-	encrypt.AddSignature([]byte(mh.securityCfg.SecretKey), payload, rw.Header())
+	encrypt.SignResponse(mh.keys, r.Header, payload, rw.Header())
 	rw.Header().Add("Content-Type", "text/html")
 	rw.WriteHeader(http.StatusOK)
 	_, err = rw.Write(payload)
@@ -167,14 +281,82 @@ func (mh *MetricsHandlers) showMetricsPage(rw http.ResponseWriter, r *http.Reque
 	}
 }
 
+// @Tags Metrics
+// @Summary Expose all collected metrics for Prometheus scraping
+// @Description Renders all collected metrics in Prometheus text exposition format (version 0.0.4).
+// @Description Metric names are sanitized to match Prometheus naming rules, labels are rendered if present.
+// @ID prometheusMetrics
+// @Produce plain
+// @Success 200 {string} string "Metrics in text exposition format"
+// @Failure 500 {string} string "Internal server error"
+// @Router /metrics [get]
+func (mh *MetricsHandlers) prometheusMetricsHandler() http.HandlerFunc {
+	return mh.prometheusMetrics
+}
+
+// prometheusMetrics writes all collected metrics in Prometheus text format:
+// counters and gauges are exposed as corresponding prometheus types, histograms - as cumulative buckets
+// with sum and count, summaries - as p50/p90/p99 quantiles with sum and count.
+// in case metrics can't be rendered, HTTP code 500 is set to response.
+func (mh *MetricsHandlers) prometheusMetrics(rw http.ResponseWriter, r *http.Request) {
+	buf := new(bytes.Buffer)
+	err := mh.msrv.WritePrometheusMetrics(r.Context(), buf)
+	if err != nil {
+		mh.logger.Error(logErrorGenProm, zap.Error(err))
+		http.Error(rw, "unable to render metrics", http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", promtext.ContentType)
+	rw.WriteHeader(http.StatusOK)
+	_, err = rw.Write(buf.Bytes())
+	if err != nil {
+		mh.logger.Error(logErrorWriteBody, zap.Error(err))
+	}
+}
+
+// @Tags Maintenance
+// @Summary Expose server self-metrics for Prometheus scraping
+// @Description Renders metrics describing the server itself (requests, storage operations, retries, audit errors)
+// @Description in Prometheus text exposition format. The same metrics are stored with IDs prefixed by "overseer."
+// @ID selfMetrics
+// @Produce plain
+// @Success 200 {string} string "Metrics in text exposition format"
+// @Failure 500 {string} string "Internal server error"
+// @Router /metrics/self [get]
+func (mh *MetricsHandlers) selfMetricsHandler() http.HandlerFunc {
+	return mh.writeSelfMetrics
+}
+
+// writeSelfMetrics writes current values of server self-metrics in Prometheus text format,
+// unlike stored ones they are not delayed by export interval
+func (mh *MetricsHandlers) writeSelfMetrics(rw http.ResponseWriter, _ *http.Request) {
+	buf := new(bytes.Buffer)
+	err := mh.selfMetrics.WritePrometheus(buf)
+	if err != nil {
+		mh.logger.Error(logErrorGenProm, zap.Error(err))
+		http.Error(rw, "unable to render metrics", http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", promtext.ContentType)
+	rw.WriteHeader(http.StatusOK)
+	_, err = rw.Write(buf.Bytes())
+	if err != nil {
+		mh.logger.Error(logErrorWriteBody, zap.Error(err))
+	}
+}
+
 // @Tags Metrics
 // @Summary Accumulate single metric value provided by path parameters
 // @Description Accumulate metric value for ID and metric type provided in parameters
 // @ID updateMetricByPath
-// @Param mtype path string true "Metric Type" Enums(Counter, Gauge)
+// @Param mtype path string true "Metric Type" Enums(Counter, Gauge, Histogram, Summary)
 // @Param id path string true "Metric ID"
-// @Param value path string true "Metric Value"
-// @Success 200
+// @Param value path string true "Metric Value (single observation for histogram and summary)"
+// @Param labels query string false "Metric labels, each label.<name> query parameter is treated as a label"
+// @Produce json
+// @Success 200 {object} model.UpdateAck
 // @Failure 400 {string} string "Bad request"
 // @Failure 401 {string} string "Unauthorized"
 // @Failure 500 {string} string "Internal server error"
@@ -186,20 +368,25 @@ func (mh *MetricsHandlers) updateByPathHandler() http.HandlerFunc {
 
 // updateByPath takes mtype, id and value parameters from request path and tries to update corresponding metric
 // in storage (or create new if it doesn't exist).
-// in successful case HTTP code 200 is written into response
+// For histogram metric value is treated as a single observation put into buckets of the stored histogram
+// (or model.DefaultHistogramBuckets for a new one), for summary metric - as a single observation merged into the stored sketch.
+// Query parameters of the request prefixed with "label." are treated as metric labels.
+// in successful case HTTP code 200 is written into response along with signed model.UpdateAck
 // in case provided metric data is invaild or any of input fields are not provided, HTTP code 400 is written into response
 // in any other case error is considered unprocessable and HTTP code 500 is written
 func (mh *MetricsHandlers) updateByPath(rw http.ResponseWriter, r *http.Request) {
 	mtype := chi.URLParam(r, "mtype")
 	id := chi.URLParam(r, "id")
 	svalue := chi.URLParam(r, "value")
+	labels := queryLabels(r)
 	mh.logger.Debugw("Trying to update metric",
 		"mtype", mtype,
 		"id", id,
+		"labels", labels,
 		"value", svalue,
 	)
 
-	metric, he := mh.buildMetric(id, mtype, svalue)
+	metric, he := mh.buildMetric(r.Context(), id, mtype, svalue, labels)
 	if he != nil {
 		if he.Error != nil {
 			mh.logger.Error(he.Message, zap.Error(he.Error))
@@ -217,7 +404,7 @@ func (mh *MetricsHandlers) updateByPath(rw http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	rw.WriteHeader(http.StatusOK)
+	mh.renderJSON(rw, r, &model.UpdateAck{Updated: 1})
 }
 
 // @Tags Metrics
@@ -226,7 +413,8 @@ func (mh *MetricsHandlers) updateByPath(rw http.ResponseWriter, r *http.Request)
 // @ID updateMetricByBody
 // @Accept json
 // @Body {object} model.Metrics
-// @Success 200
+// @Produce json
+// @Success 200 {object} model.UpdateAck
 // @Failure 400 {string} string "Bad request"
 // @Failure 401 {string} string "Unauthorized"
 // @Failure 500 {string} string "Internal server error"
@@ -238,11 +426,14 @@ func (mh *MetricsHandlers) updateByBodyHandler() http.HandlerFunc {
 
 // updateByPath reads inbound request body, unmarshals it to model.Metrics and tries to update corresponding metric
 // in storage (or create new if it doesn't exist).
-// Valid input body must have "id" and "mtype" fields filled (mtype can be either "counter" or "gauge") and
-// either "delta" set to some integer value (for counter-type metric)
-// or "value" set to some decimal value (for gauge-type metric).
+// Valid input body must have "id" and "mtype" fields filled (mtype can be "counter", "gauge", "histogram" or "summary")
+// and either "delta" set to some integer value (for counter-type metric)
+// or "value" set to some decimal value (for gauge-type metric)
+// or "histogram" set to a consistent bucket bounds/counts structure (for histogram-type metric)
+// or "summary" set to a consistent quantile sketch (for summary-type metric).
+// Optional "labels" object is a part of metric identity, label names must consist of letters, digits and underscores.
 // Also, metric considered as not valid if it is already stored in a storage with a different mtype.
-// in successful case HTTP code 200 is written into response
+// in successful case HTTP code 200 is written into response along with signed model.UpdateAck
 // in case body JSON can't be unmarshalled or required data for update is missing, HTTP code 400 is written into response
 // in any other case error is considered unprocessable and HTTP code 500 is written
 func (mh *MetricsHandlers) updateByBody(rw http.ResponseWriter, r *http.Request) {
@@ -278,7 +469,7 @@ func (mh *MetricsHandlers) updateByBody(rw http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	rw.WriteHeader(http.StatusOK)
+	mh.renderJSON(rw, r, &model.UpdateAck{Updated: 1})
 }
 
 // @Tags Metrics
@@ -286,8 +477,10 @@ func (mh *MetricsHandlers) updateByBody(rw http.ResponseWriter, r *http.Request)
 // @Description Accumulate batch of metric values for corresponding ids and metric types provided in body as array
 // @ID updateMetricBatch
 // @Accept json
+// @Param X-Batch-ID header string false "Batch ID in agent:seq form, batch with the same ID is applied only once"
 // @Body {array} model.Metrics
-// @Success 200
+// @Produce json
+// @Success 200 {object} model.UpdateAck
 // @Failure 400 {string} string "Bad request"
 // @Failure 401 {string} string "Unauthorized"
 // @Failure 500 {string} string "Internal server error"
@@ -300,10 +493,22 @@ func (mh *MetricsHandlers) updateBatchHandler() http.HandlerFunc {
 // updateBatch reads inbound request body, unmarshals it to array of model.Metrics and tries to update all provided
 // metrics in storage (or create new for those not existing).
 // Input body must contain only valid model.Metrics input objects (see updateByBody description for validation explanation).
-// in successful case HTTP code 200 is written into response
-// in case body JSON can't be unmarshalled or any metric is invalid, HTTP code 400 is written into response
+// If model.BatchIDHeader is provided, the batch is applied only once, and a resent batch is acknowledged
+// with Duplicate flag set without applying it again.
+// in successful case HTTP code 200 is written into response along with signed model.UpdateAck
+// in case body JSON can't be unmarshalled, batch ID or any metric is invalid, HTTP code 400 is written into response
 // in any other case error is considered unprocessable and HTTP code 500 is written
 func (mh *MetricsHandlers) updateBatch(rw http.ResponseWriter, r *http.Request) {
+	var batchID *model.BatchID
+	if header := r.Header.Get(model.BatchIDHeader); header != "" {
+		var err error
+		batchID, err = model.ParseBatchID(header)
+		if err != nil {
+			errorhandling.NewValidationHandlerError(err.Error()).Render(rw)
+			return
+		}
+	}
+
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		errorhandling.NewValidationHandlerError(fmt.Sprintf("error reading request body: %v", err)).Render(rw)
@@ -315,18 +520,112 @@ func (mh *MetricsHandlers) updateBatch(rw http.ResponseWriter, r *http.Request)
 		errorhandling.NewValidationHandlerError(fmt.Sprintf("error unmarshalling body: %v", err)).Render(rw)
 		return
 	}
+	for _, metric := range metrics {
+		if err := metric.Labels.Validate(); err != nil {
+			errorhandling.NewValidationHandlerError(fmt.Sprintf("metric %s: %v", metric.ID, err)).Render(rw)
+			return
+		}
+		if metric.Histogram != nil {
+			if err := metric.Histogram.Validate(); err != nil {
+				errorhandling.NewValidationHandlerError(fmt.Sprintf("metric %s: %v", metric.ID, err)).Render(rw)
+				return
+			}
+		}
+		if metric.Summary != nil {
+			if err := metric.Summary.Validate(); err != nil {
+				errorhandling.NewValidationHandlerError(fmt.Sprintf("metric %s: %v", metric.ID, err)).Render(rw)
+				return
+			}
+		}
+	}
 
 	mh.logger.Debugw("Trying to update metrics",
 		"count", len(metrics),
 	)
-	err = mh.msrv.BatchAccumulateMetrics(r.Context(), metrics, mh.extractRemoteIPAddress(r))
+	applied, err := mh.msrv.BatchAccumulateMetricsOnce(r.Context(), batchID, metrics, mh.extractRemoteIPAddress(r))
 	if err != nil {
-		if errors.Is(err, repository.ErrIncorrectAccess) {
+		if errors.Is(err, repository.ErrIncorrectAccess) || errors.Is(err, model.ErrHistogramBucketsMismatch) ||
+			errors.Is(err, service.ErrReservedMetricID) || errors.Is(err, model.ErrInvalidMetricID) ||
+			errors.Is(err, model.ErrValueOverflow) {
 			errorhandling.NewValidationHandlerError(err.Error()).Render(rw)
 			return
 		}
+		mh.logger.Errorw("error storing metrics batch", "error", err)
+		errorhandling.NewInternalServerError(err).Render(rw)
+		return
 	}
-	rw.WriteHeader(http.StatusOK)
+	if !applied {
+		mh.renderJSON(rw, r, &model.UpdateAck{Duplicate: true})
+		return
+	}
+	mh.renderJSON(rw, r, &model.UpdateAck{Updated: len(metrics)})
+}
+
+// @Tags Metrics
+// @Summary Accumulate metrics sent by Prometheus remote-write protocol
+// @Description Accepts snappy-compressed protobuf WriteRequest (remote-write protocol 1.0).
+// @Description Each series is stored as a gauge with the latest sample value, __name__ label is used as metric ID.
+// @ID remoteWrite
+// @Accept application/x-protobuf
+// @Param Content-Encoding header string true "Body compression" Enums(snappy)
+// @Success 204
+// @Failure 400 {string} string "Bad request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 500 {string} string "Internal server error"
+// @Security SecretKeyAuth
+// @Router /api/v1/write [post]
+func (mh *MetricsHandlers) remoteWriteHandler() http.HandlerFunc {
+	return mh.remoteWrite
+}
+
+// remoteWrite reads inbound remote-write request (already decompressed by middleware), converts its series
+// to gauge metrics and stores them the same way as updateBatch does, auditors are notified as well.
+// in successful case HTTP code 204 is written into response as prometheus expects
+// in case body can't be decoded or contains invalid series, HTTP code 400 is written into response -
+// prometheus doesn't retry such requests
+// in any other case HTTP code 500 is written and the request is retried by prometheus
+func (mh *MetricsHandlers) remoteWrite(rw http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		errorhandling.NewValidationHandlerError(fmt.Sprintf("error reading request body: %v", err)).Render(rw)
+		return
+	}
+
+	wr, err := remotewrite.Unmarshal(body)
+	if err != nil {
+		errorhandling.NewValidationHandlerError(fmt.Sprintf("error decoding remote-write request: %v", err)).Render(rw)
+		return
+	}
+	metrics, err := wr.ToMetrics()
+	if err != nil {
+		errorhandling.NewValidationHandlerError(fmt.Sprintf("error converting remote-write series: %v", err)).Render(rw)
+		return
+	}
+	for _, metric := range metrics {
+		if err := metric.Labels.Validate(); err != nil {
+			errorhandling.NewValidationHandlerError(fmt.Sprintf("metric %s: %v", metric.ID, err)).Render(rw)
+			return
+		}
+	}
+
+	mh.logger.Debugw("Trying to update metrics from remote-write request",
+		"series", len(wr.Timeseries),
+		"count", len(metrics),
+	)
+	if len(metrics) > 0 {
+		err = mh.msrv.BatchAccumulateMetrics(r.Context(), metrics, mh.extractRemoteIPAddress(r))
+		if err != nil {
+			if errors.Is(err, repository.ErrIncorrectAccess) || errors.Is(err, service.ErrReservedMetricID) ||
+				errors.Is(err, model.ErrInvalidMetricID) {
+				errorhandling.NewValidationHandlerError(err.Error()).Render(rw)
+				return
+			}
+			mh.logger.Error("error storing remote-write metrics", zap.Error(err))
+			errorhandling.NewInternalServerError(err).Render(rw)
+			return
+		}
+	}
+	rw.WriteHeader(http.StatusNoContent)
 }
 
 // @Tags Metrics
@@ -334,8 +633,11 @@ func (mh *MetricsHandlers) updateBatch(rw http.ResponseWriter, r *http.Request)
 // @Description Return metric value for ID and metric type provided in path parameters if it exists
 // @ID getMetricByPath
 // @Produce plain
-// @Param mtype path string true "Metric Type" Enums(Counter, Gauge)
+// @Param mtype path string true "Metric Type" Enums(Counter, Gauge, Histogram, Summary)
 // @Param id path string true "Metric ID"
+// @Param labels query string false "Metric labels, each label.<name> query parameter is treated as a label"
+// @Param X-Response-Key header string false "Client public key (BASE64 DER) for response encryption"
+// @Param X-Response-Key-Id header string false "Identifier of server-known client public key for response encryption"
 // @Success 200 {string} metric value
 // @Failure 400 {string} string "Bad request"
 // @Failure 401 {string} string "Unauthorized"
@@ -349,18 +651,21 @@ func (mh *MetricsHandlers) getPlainValueHandler() http.HandlerFunc {
 
 // getPlainValue gets metric ID and type from request path parameters and tries to fetch existing metric from
 // the server storage. If metric with given parameters exists, its value is written into response body as plain string.
+// Query parameters of the request prefixed with "label." are treated as metric labels.
 // in success case, HTTP code 200 is written into response
 // in case metric doesn't exist in the storage, HTTP code 404 is written into response
 // in any other case error is considered unprocessable and HTTP code 500 is written
 func (mh *MetricsHandlers) getPlainValue(rw http.ResponseWriter, r *http.Request) {
 	mtype := chi.URLParam(r, "mtype")
 	id := chi.URLParam(r, "id")
+	labels := queryLabels(r)
 	mh.logger.Debug("Fetching metric",
 		zap.String("mtype", mtype),
 		zap.String("id", id),
+		zap.Stringer("labels", labels),
 	)
 
-	metric, he := mh.getMetric(r.Context(), id, mtype)
+	metric, he := mh.getMetric(r.Context(), id, mtype, labels)
 	if he != nil {
 		if he.Error != nil {
 			mh.logger.Error(he.Message, zap.Error(he.Error))
@@ -368,7 +673,7 @@ func (mh *MetricsHandlers) getPlainValue(rw http.ResponseWriter, r *http.Request
 		he.Render(rw)
 		return
 	}
-	mh.renderMetricValue(rw, metric)
+	mh.renderMetricValue(rw, r, metric)
 }
 
 // @Tags Metrics
@@ -378,6 +683,8 @@ func (mh *MetricsHandlers) getPlainValue(rw http.ResponseWriter, r *http.Request
 // @Produce json
 // @Accept json
 // @Body {object} model.Metrics
+// @Param X-Response-Key header string false "Client public key (BASE64 DER) for response encryption"
+// @Param X-Response-Key-Id header string false "Identifier of server-known client public key for response encryption"
 // @Success 200 {string} metric value
 // @Failure 400 {string} string "Bad request"
 // @Failure 401 {string} string "Unauthorized"
@@ -413,8 +720,9 @@ func (mh *MetricsHandlers) getJSONValue(rw http.ResponseWriter, r *http.Request)
 	mh.logger.Debugw("Fetching metric",
 		"id", metric.ID,
 		"mtype", metric.MType,
+		"labels", metric.Labels,
 	)
-	metric, he := mh.getMetric(r.Context(), metric.ID, metric.MType)
+	metric, he := mh.getMetric(r.Context(), metric.ID, metric.MType, metric.Labels)
 	if he != nil {
 		if he.Error != nil {
 			mh.logger.Error(he.Message, zap.Error(he.Error))
@@ -422,7 +730,145 @@ func (mh *MetricsHandlers) getJSONValue(rw http.ResponseWriter, r *http.Request)
 		he.Render(rw)
 		return
 	}
-	mh.renderMetricJSON(rw, metric)
+	mh.renderJSON(rw, r, metric)
+}
+
+// @Tags Metrics
+// @Summary Return all metrics matching JSON filter
+// @Description Return metrics matching optional ID, metric type and labels provided in JSON body
+// @ID getMetricsByFilter
+// @Produce json
+// @Accept json
+// @Body {object} model.Filter
+// @Success 200 {array} model.Metrics
+// @Failure 400 {string} string "Bad request"
+// @Failure 500 {string} string "Internal server error"
+// @Router /values [post]
+func (mh *MetricsHandlers) getJSONValuesHandler() http.HandlerFunc {
+	return mh.getJSONValues
+}
+
+// getJSONValues reads inbound request body, unmarshals it to model.Filter and returns a JSON array of all metrics
+// matching it (sorted by ID and labels). Empty "id" and "type" match any metric, "labels" match metrics
+// having all of provided labels (metrics may have other labels as well).
+// in success case, HTTP code 200 is written into response (even if no metrics found)
+// in case body JSON can't be unmarshalled or filter is invalid, HTTP code 400 is written into response
+// in any other case error is considered unprocessable and HTTP code 500 is written
+func (mh *MetricsHandlers) getJSONValues(rw http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		errorhandling.NewValidationHandlerError(fmt.Sprintf("error reading request body: %v", err)).Render(rw)
+		return
+	}
+
+	filter := &model.Filter{}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, filter); err != nil {
+			errorhandling.NewValidationHandlerError(fmt.Sprintf("error decoding body: %v", err)).Render(rw)
+			return
+		}
+	}
+	if filter.MType != "" && !model.IsKnownType(filter.MType) {
+		errorhandling.NewValidationHandlerError("unsupported metric type: " + filter.MType).Render(rw)
+		return
+	}
+
+	mh.logger.Debugw("Fetching metrics",
+		"id", filter.ID,
+		"mtype", filter.MType,
+		"labels", filter.Labels,
+	)
+	metrics, err := mh.msrv.FindMetrics(r.Context(), filter)
+	if err != nil {
+		he := errorhandling.NewInternalServerError(fmt.Errorf("error getting metrics: %w", err))
+		mh.logger.Error(he.Message, zap.Error(he.Error))
+		he.Render(rw)
+		return
+	}
+	mh.renderJSON(rw, r, metrics)
+}
+
+// @Tags Metrics
+// @Summary Return metric value history in a time range
+// @Description Return samples recorded on metric updates for ID and metric type provided in path parameters
+// @ID getMetricHistory
+// @Produce json
+// @Param mtype path string true "Metric Type" Enums(Counter, Gauge, Histogram, Summary)
+// @Param id path string true "Metric ID"
+// @Param from query string false "Range start in RFC3339 format or unix seconds (default: an hour before range end)"
+// @Param to query string false "Range end in RFC3339 format or unix seconds (default: now)"
+// @Param labels query string false "Metric labels, each label.<name> query parameter is treated as a label"
+// @Success 200 {array} model.Sample
+// @Failure 400 {string} string "Bad request"
+// @Failure 404 {string} string "Metric not found"
+// @Failure 500 {string} string "Internal server error"
+// @Router /history/{mtype}/{id} [get]
+func (mh *MetricsHandlers) getHistoryHandler() http.HandlerFunc {
+	return mh.getHistory
+}
+
+// getHistory gets metric ID and type from request path parameters and time range from "from" and "to" query
+// parameters, then writes JSON array of metric samples recorded in this range (sorted by time) into response body.
+// in success case, HTTP code 200 is written into response
+// in case time range is invalid, HTTP code 400 is written into response
+// in case metric doesn't exist in the storage, HTTP code 404 is written into response
+// in any other case error is considered unprocessable and HTTP code 500 is written
+func (mh *MetricsHandlers) getHistory(rw http.ResponseWriter, r *http.Request) {
+	mtype := chi.URLParam(r, "mtype")
+	id := chi.URLParam(r, "id")
+
+	query := r.URL.Query()
+	to := time.Now()
+	if sto := query.Get("to"); sto != "" {
+		var err error
+		if to, err = parseTimeParam(sto); err != nil {
+			errorhandling.NewValidationHandlerError("invalid range end: " + sto).Render(rw)
+			return
+		}
+	}
+	from := to.Add(-defaultHistoryRange)
+	if sfrom := query.Get("from"); sfrom != "" {
+		var err error
+		if from, err = parseTimeParam(sfrom); err != nil {
+			errorhandling.NewValidationHandlerError("invalid range start: " + sfrom).Render(rw)
+			return
+		}
+	}
+	if from.After(to) {
+		errorhandling.NewValidationHandlerError("range start is after range end").Render(rw)
+		return
+	}
+
+	labels := queryLabels(r)
+	if err := labels.Validate(); err != nil {
+		errorhandling.NewValidationHandlerError(err.Error()).Render(rw)
+		return
+	}
+	if !model.IsKnownType(mtype) {
+		errorhandling.NewValidationHandlerError("unsupported metric type: " + mtype).Render(rw)
+		return
+	}
+
+	mh.logger.Debugw("Fetching metric history",
+		"mtype", mtype,
+		"id", id,
+		"labels", labels,
+		"from", from,
+		"to", to,
+	)
+	samples, err := mh.msrv.GetMetricHistory(r.Context(), id, mtype, labels, from, to)
+	if err != nil {
+		var he *errorhandling.Error
+		if errors.Is(err, repository.ErrMetricNotFound) || errors.Is(err, repository.ErrIncorrectAccess) {
+			he = errorhandling.NewNotFoundHandlerError("metric not found")
+		} else {
+			he = errorhandling.NewInternalServerError(fmt.Errorf("error getting metric history: %w", err))
+			mh.logger.Error(he.Message, zap.Error(he.Error))
+		}
+		he.Render(rw)
+		return
+	}
+	mh.renderJSON(rw, r, samples)
 }
 
 // @Tags Maintenance
@@ -456,6 +902,39 @@ func (mh *MetricsHandlers) pingStorage(rw http.ResponseWriter, r *http.Request)
 	rw.WriteHeader(http.StatusOK)
 }
 
+// @Tags Maintenance
+// @Summary Reload API tokens
+// @Description Reloads API token definitions from the configured file or database without server restart.
+// @Description Previously loaded tokens stay active if new definitions can't be loaded
+// @ID reloadTokens
+// @Success 200
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "API tokens are not configured"
+// @Failure 500 {string} string "Internal server error"
+// @Security TokenAuth
+// @Router /admin/tokens/reload [post]
+func (mh *MetricsHandlers) reloadTokensHandler() http.HandlerFunc {
+	return mh.reloadTokens
+}
+
+// reloadTokens re-reads API token definitions from the token store.
+// in case tokens are not configured, HTTP code 404 is written into response
+func (mh *MetricsHandlers) reloadTokens(rw http.ResponseWriter, r *http.Request) {
+	if !mh.tokens.Enabled() {
+		errorhandling.NewNotFoundHandlerError("API tokens are not configured").Render(rw)
+		return
+	}
+
+	err := mh.tokens.Reload(r.Context())
+	if err != nil {
+		mh.logger.Errorw("failed to reload API tokens", "error", err)
+		errorhandling.NewInternalServerError(err).Render(rw)
+		return
+	}
+	rw.WriteHeader(http.StatusOK)
+}
+
 func (mh *MetricsHandlers) processUpdateMetric(
 	ctx context.Context,
 	metric *model.Metrics,
@@ -463,7 +942,8 @@ func (mh *MetricsHandlers) processUpdateMetric(
 ) *errorhandling.Error {
 	err := mh.msrv.AccumulateMetric(ctx, metric, ipAddr)
 	if err != nil {
-		if errors.Is(err, service.ErrUnsupportedMetricType) {
+		if errors.Is(err, service.ErrUnsupportedMetricType) || errors.Is(err, service.ErrReservedMetricID) ||
+			errors.Is(err, model.ErrInvalidMetricID) {
 			return errorhandling.NewValidationHandlerError(err.Error())
 		}
 		if errors.Is(err, repository.ErrStore) {
@@ -473,16 +953,49 @@ func (mh *MetricsHandlers) processUpdateMetric(
 		if errors.Is(err, repository.ErrIncorrectAccess) {
 			return errorhandling.NewValidationHandlerError("wrong metric type")
 		}
+		if errors.Is(err, model.ErrHistogramBucketsMismatch) {
+			return errorhandling.NewValidationHandlerError("histogram buckets don't match stored ones")
+		}
+		if errors.Is(err, model.ErrValueOverflow) {
+			return errorhandling.NewValidationHandlerError("accumulated metric value overflows")
+		}
 		return errorhandling.NewInternalServerError(fmt.Errorf("error updating metric: %w", err))
 	}
 	return nil
 }
 
-func (mh *MetricsHandlers) buildMetric(id, mtype, svalue string) (*model.Metrics, *errorhandling.Error) {
+func (mh *MetricsHandlers) buildMetric(
+	ctx context.Context,
+	id, mtype, svalue string,
+	labels model.Labels,
+) (*model.Metrics, *errorhandling.Error) {
 	var delta *int64
 	var value *float64
 
+	if err := labels.Validate(); err != nil {
+		return nil, errorhandling.NewValidationHandlerError(err.Error())
+	}
+
 	switch mtype {
+	case model.Histogram:
+		fval, err := strconv.ParseFloat(svalue, 64)
+		if err != nil || math.IsNaN(fval) || math.IsInf(fval, 0) {
+			return nil, errorhandling.NewValidationHandlerError("invalid metric value: " + svalue)
+		}
+		metric, err := mh.msrv.NewHistogramObservation(ctx, id, labels, fval)
+		if err != nil {
+			return nil, errorhandling.NewInternalServerError(err)
+		}
+		return metric, nil
+	case model.Summary:
+		fval, err := strconv.ParseFloat(svalue, 64)
+		if err != nil || math.IsNaN(fval) || math.IsInf(fval, 0) {
+			return nil, errorhandling.NewValidationHandlerError("invalid metric value: " + svalue)
+		}
+		summary := model.NewSummaryValue()
+		summary.Add(fval)
+		summary.Compress()
+		return model.NewSummaryMetricsWithValue(id, summary).WithLabels(labels), nil
 	case model.Counter:
 		dval, err := strconv.ParseInt(svalue, 10, 64)
 		if err != nil {
@@ -499,10 +1012,14 @@ func (mh *MetricsHandlers) buildMetric(id, mtype, svalue string) (*model.Metrics
 		return nil, errorhandling.NewValidationHandlerError("unsupported metric type: " + mtype)
 	}
 
-	return model.NewMetrics(id, mtype, delta, value), nil
+	return model.NewMetrics(id, mtype, delta, value).WithLabels(labels), nil
 }
 
 func (mh *MetricsHandlers) validateMetric(metric *model.Metrics) *errorhandling.Error {
+	if err := metric.Labels.Validate(); err != nil {
+		return errorhandling.NewValidationHandlerError(err.Error())
+	}
+
 	switch metric.MType {
 	case model.Counter:
 		if metric.Delta == nil {
@@ -512,6 +1029,20 @@ func (mh *MetricsHandlers) validateMetric(metric *model.Metrics) *errorhandling.
 		if metric.Value == nil {
 			return errorhandling.NewValidationHandlerError("missing gauge metric value")
 		}
+	case model.Histogram:
+		if metric.Histogram == nil {
+			return errorhandling.NewValidationHandlerError("missing histogram metric value")
+		}
+		if err := metric.Histogram.Validate(); err != nil {
+			return errorhandling.NewValidationHandlerError(err.Error())
+		}
+	case model.Summary:
+		if metric.Summary == nil {
+			return errorhandling.NewValidationHandlerError("missing summary metric value")
+		}
+		if err := metric.Summary.Validate(); err != nil {
+			return errorhandling.NewValidationHandlerError(err.Error())
+		}
 	default:
 		return errorhandling.NewValidationHandlerError("unsupported metric type: " + metric.MType)
 	}
@@ -521,16 +1052,21 @@ func (mh *MetricsHandlers) validateMetric(metric *model.Metrics) *errorhandling.
 func (mh *MetricsHandlers) getMetric(
 	ctx context.Context,
 	id, mtype string,
+	labels model.Labels,
 ) (*model.Metrics, *errorhandling.Error) {
 
 	if len(strings.TrimSpace(id)) == 0 {
 		return nil, errorhandling.NewValidationHandlerError("missing metric id")
 	}
-	if mtype != model.Counter && mtype != model.Gauge {
+	if !model.IsKnownType(mtype) {
 		return nil, errorhandling.NewValidationHandlerError("unsupported metric type: " + mtype)
 	}
 
-	metric, err := mh.msrv.GetMetric(ctx, id, mtype)
+	if err := labels.Validate(); err != nil {
+		return nil, errorhandling.NewValidationHandlerError(err.Error())
+	}
+
+	metric, err := mh.msrv.GetMetric(ctx, id, mtype, labels)
 	if err != nil {
 		if errors.Is(err, repository.ErrMetricNotFound) || errors.Is(err, repository.ErrIncorrectAccess) {
 			return nil, errorhandling.NewNotFoundHandlerError("metric not found")
@@ -540,7 +1076,7 @@ func (mh *MetricsHandlers) getMetric(
 	return metric, nil
 }
 
-func (mh *MetricsHandlers) renderMetricValue(rw http.ResponseWriter, metric *model.Metrics) {
+func (mh *MetricsHandlers) renderMetricValue(rw http.ResponseWriter, r *http.Request, metric *model.Metrics) {
 	var payload []byte
 	switch metric.MType {
 	case model.Counter:
@@ -555,9 +1091,21 @@ func (mh *MetricsHandlers) renderMetricValue(rw http.ResponseWriter, metric *mod
 		} else {
 			payload = strconv.AppendFloat(make([]byte, 0), *metric.Value, 'f', -1, 64)
 		}
+	case model.Histogram:
+		if metric.Histogram == nil {
+			payload = []byte("nil")
+		} else {
+			payload = []byte(metric.Histogram.String())
+		}
+	case model.Summary:
+		if metric.Summary == nil {
+			payload = []byte("nil")
+		} else {
+			payload = []byte(metric.Summary.String())
+		}
 	}
 
-	encrypt.AddSignature([]byte(mh.securityCfg.SecretKey), payload, rw.Header())
+	encrypt.SignResponse(mh.keys, r.Header, payload, rw.Header())
 	rw.Header().Add("Content-Type", "text/plain")
 	rw.WriteHeader(http.StatusOK)
 	_, err := rw.Write(payload)
@@ -566,15 +1114,16 @@ func (mh *MetricsHandlers) renderMetricValue(rw http.ResponseWriter, metric *mod
 	}
 }
 
-func (mh *MetricsHandlers) renderMetricJSON(rw http.ResponseWriter, metric *model.Metrics) {
-	payload, err := json.MarshalIndent(metric, "", "  ")
+// renderJSON writes v as JSON response body signed with a key used by request signature (see encrypt.SignResponse)
+func (mh *MetricsHandlers) renderJSON(rw http.ResponseWriter, r *http.Request, v any) {
+	payload, err := json.MarshalIndent(v, "", "  ")
 	if err != nil {
 		mh.logger.Error(logErrorWriteBody, zap.Error(err))
 		rw.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	encrypt.AddSignature([]byte(mh.securityCfg.SecretKey), payload, rw.Header())
+	encrypt.SignResponse(mh.keys, r.Header, payload, rw.Header())
 	rw.Header().Add("Content-Type", "application/json")
 	rw.WriteHeader(http.StatusOK)
 	_, err = rw.Write(payload)
@@ -583,6 +1132,31 @@ func (mh *MetricsHandlers) renderMetricJSON(rw http.ResponseWriter, metric *mode
 	}
 }
 
+// queryLabels treats request query parameters named label.<name> as metric labels (only the first value
+// is taken for each one), other parameters are ignored so they don't create new series
+func queryLabels(r *http.Request) model.Labels {
+	var labels model.Labels
+	for k, v := range r.URL.Query() {
+		name, ok := strings.CutPrefix(k, labelParamPrefix)
+		if !ok {
+			continue
+		}
+		if labels == nil {
+			labels = make(model.Labels)
+		}
+		labels[name] = v[0]
+	}
+	return labels
+}
+
+// parseTimeParam accepts time either in RFC3339 format or as a number of seconds since epoch
+func parseTimeParam(s string) (time.Time, error) {
+	if sec, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
 func (mh *MetricsHandlers) extractRemoteIPAddress(r *http.Request) string {
 	ip, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
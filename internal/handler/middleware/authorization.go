@@ -3,45 +3,61 @@ package middleware
 import (
 	"bytes"
 	"errors"
+	"io"
+	"net/http"
+
 	"github.com/andrewsvn/metrics-overseer/internal/encrypt"
 	"github.com/andrewsvn/metrics-overseer/internal/handler/errorhandling"
+	"github.com/andrewsvn/metrics-overseer/internal/replay"
 	"go.uber.org/zap"
-	"io"
-	"net/http"
 )
 
+// Authorization verifies request signatures made with keys of a shared key ring. Signatures covering timestamp
+// and nonce are additionally checked for freshness and uniqueness by replay guard. In strict mode unsigned requests
+// and signatures covering payload only are rejected, otherwise they are passed for backward compatibility
 type Authorization struct {
-	secretKey []byte
-	logger    *zap.SugaredLogger
+	keys   *encrypt.KeyRing
+	strict bool
+	guard  *replay.Guard
+	logger *zap.SugaredLogger
 }
 
-func NewAuthorization(l *zap.Logger, secretKey string) *Authorization {
+func NewAuthorization(l *zap.Logger, keys *encrypt.KeyRing, strict bool, guard *replay.Guard) *Authorization {
 	authLogger := l.Sugar().With("component", "authorization-middleware")
 	return &Authorization{
-		secretKey: []byte(secretKey),
-		logger:    authLogger,
+		keys:   keys,
+		strict: strict,
+		guard:  guard,
+		logger: authLogger,
 	}
 }
 
 func (auth *Authorization) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
-		if auth.secretKey == nil {
+		if !auth.keys.Enabled() {
 			next.ServeHTTP(rw, r)
 			return
 		}
 
 		var err error
 
-		// backward compatibility: don't verify signature if it's not present in the request even if key is specified
-		reqSign, err := encrypt.GetSignature(r.Header)
+		reqSign, err := encrypt.GetRequestSignature(r.Header)
 		if err != nil {
 			auth.logger.Warnw("Error getting signature", "error", err)
 			errorhandling.NewValidationHandlerError("can't read signature from incoming request").Render(rw)
 			return
 		}
-		if reqSign == nil {
-			next.ServeHTTP(rw, r)
-			return
+		if reqSign == nil || reqSign.IsPayloadOnly() {
+			if auth.strict {
+				auth.logger.Debugw("request without signature timestamp and nonce rejected in strict mode")
+				rw.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			// backward compatibility: don't verify signature if it's not present in the request even if key is specified
+			if reqSign == nil {
+				next.ServeHTTP(rw, r)
+				return
+			}
 		}
 
 		var payload []byte
@@ -49,15 +65,17 @@ func (auth *Authorization) Middleware(next http.Handler) http.Handler {
 			payload, err = io.ReadAll(r.Body)
 			if err != nil {
 				auth.logger.Warnw("can't read incoming request body for verification", "error", err)
-				errorhandling.NewValidationHandlerError("can't read incoming request body").Render(rw)
+				errorhandling.NewBodyReadError("can't read incoming request body", err).Render(rw)
 				return
 			}
 		}
 
-		err = encrypt.CheckSignature(auth.secretKey, payload, reqSign)
+		err = encrypt.CheckRequestSignature(auth.keys, encrypt.RequestTarget(r), payload, reqSign)
 		if err != nil {
-			if errors.Is(err, encrypt.ErrSignatureInvalid) {
-				auth.logger.Debugw("incoming request signature is invalid")
+			if errors.Is(err, encrypt.ErrSignatureInvalid) ||
+				errors.Is(err, encrypt.ErrUnknownKeyID) ||
+				errors.Is(err, encrypt.ErrLegacySignature) {
+				auth.logger.Debugw("incoming request signature is invalid", "error", err)
 				rw.WriteHeader(http.StatusUnauthorized)
 				return
 			}
@@ -66,6 +84,21 @@ func (auth *Authorization) Middleware(next http.Handler) http.Handler {
 			return
 		}
 
+		if reqSign.KeyID == "" {
+			auth.logger.Warnw("accepted request signed with legacy scheme, client should switch to key id signatures",
+				"target", encrypt.RequestTarget(r),
+				"remote", r.RemoteAddr)
+		}
+
+		// nonce is remembered only after signature is verified, so it can't be spoiled by forged requests
+		if !reqSign.IsPayloadOnly() {
+			if err := auth.guard.Check(reqSign.Timestamp, reqSign.Nonce); err != nil {
+				auth.logger.Debugw("incoming request rejected by replay protection", "error", err)
+				rw.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		}
+
 		r.Body = io.NopCloser(bytes.NewBuffer(payload))
 		next.ServeHTTP(rw, r)
 	})
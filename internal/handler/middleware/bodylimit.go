@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+)
+
+// BodyLimit restricts size of request bodies as they are sent (before decompression) to maxSize bytes,
+// body reading fails as soon as the limit is exceeded and the request is rejected with 413 status by the middleware
+// reading it. BodyLimit should wrap all middlewares reading request body (including decompressing one, which
+// applies its own limit on decompressed size), so it counts requests rejected due to both limits
+type BodyLimit struct {
+	maxSize int64
+
+	rejectedSent         atomic.Int64
+	rejectedDecompressed atomic.Int64
+
+	logger *zap.SugaredLogger
+}
+
+func NewBodyLimit(l *zap.Logger, maxSize int64) *BodyLimit {
+	return &BodyLimit{
+		maxSize: maxSize,
+		logger:  l.Sugar().With(zap.String("component", "body-limit-middleware")),
+	}
+}
+
+// RejectedSent returns number of requests rejected because their body as sent exceeded the limit
+func (bl *BodyLimit) RejectedSent() int64 {
+	return bl.rejectedSent.Load()
+}
+
+// RejectedDecompressed returns number of requests rejected because their decompressed body exceeded the limit
+func (bl *BodyLimit) RejectedDecompressed() int64 {
+	return bl.rejectedDecompressed.Load()
+}
+
+// limitedBody marks that request body limit is exceeded while reading, so rejections can be told apart
+type limitedBody struct {
+	io.ReadCloser
+	exceeded bool
+}
+
+func (lb *limitedBody) Read(p []byte) (int, error) {
+	n, err := lb.ReadCloser.Read(p)
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		lb.exceeded = true
+	}
+	return n, err
+}
+
+func (bl *BodyLimit) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if bl.maxSize > 0 && r.ContentLength > bl.maxSize {
+			bl.rejectedSent.Add(1)
+			bl.logger.Warnw("request rejected: body is too large",
+				"url", r.URL.String(), "contentLength", r.ContentLength, "limit", bl.maxSize)
+			http.Error(rw, "request body is too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		var body *limitedBody
+		if bl.maxSize > 0 && r.Body != nil {
+			body = &limitedBody{ReadCloser: http.MaxBytesReader(rw, r.Body, bl.maxSize)}
+			r.Body = body
+		}
+
+		ew := newEnrichedResponseWriter(rw)
+		next.ServeHTTP(ew, r)
+		if ew.ResponseStatus != http.StatusRequestEntityTooLarge {
+			return
+		}
+
+		if body != nil && body.exceeded {
+			bl.rejectedSent.Add(1)
+			bl.logger.Warnw("request rejected: body is too large", "url", r.URL.String(), "limit", bl.maxSize)
+		} else {
+			bl.rejectedDecompressed.Add(1)
+			bl.logger.Warnw("request rejected: decompressed body is too large", "url", r.URL.String())
+		}
+	})
+}
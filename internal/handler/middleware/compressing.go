@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -17,11 +18,26 @@ type Compressing struct {
 	logger  *zap.SugaredLogger
 }
 
-func NewCompressing(l *zap.Logger) *Compressing {
+// NewCompressing creates compressing middleware, request bodies exceeding maxDecompressedSize after decompression
+// are rejected (no limit if it's not positive)
+func NewCompressing(l *zap.Logger, maxDecompressedSize int64) *Compressing {
 	cmLogger := l.Sugar().With(zap.String("component", "compress-middleware"))
+	// zstd is preferred for clients accepting several encodings equally since it is the fastest one
+	// with a compression ratio close to brotli
+	writeEngines := []compress.WriteEngine{
+		compress.NewZstdWriteEngine(),
+		compress.NewBrotliWriteEngine(),
+		compress.NewGzipWriteEngine(),
+	}
+	readEngines := []compress.ReadEngine{
+		compress.NewGzipReadEngine(),
+		compress.NewZstdReadEngine(),
+		compress.NewBrotliReadEngine(),
+		compress.NewSnappyReadEngine(),
+	}
 	return &Compressing{
-		compr:   compress.NewCompressor(l, compress.NewGzipWriteEngine()),
-		decompr: compress.NewDecompressor(l, compress.NewGzipReadEngine()),
+		compr:   compress.NewCompressor(l, writeEngines...),
+		decompr: compress.NewDecompressor(l, maxDecompressedSize, readEngines...),
 		logger:  cmLogger,
 	}
 }
@@ -29,8 +45,15 @@ func NewCompressing(l *zap.Logger) *Compressing {
 func (c *Compressing) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		body, err := c.decompr.ReadRequestBody(r)
+		if errors.Is(err, compress.ErrUnsupportedEncoding) {
+			// RFC 7694: let client know which request encodings are supported
+			w.Header().Set("Accept-Encoding", c.decompr.AcceptEncoding())
+			http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+			return
+		}
 		if err != nil {
-			errorhandling.NewValidationHandlerError(fmt.Sprintf("error decompressing body: %v", err)).Render(w)
+			c.logger.Debugw("error decompressing request body", "error", err)
+			errorhandling.NewBodyReadError(fmt.Sprintf("error decompressing body: %v", err), err).Render(w)
 			return
 		}
 		r.Body = io.NopCloser(bytes.NewBuffer(body))
@@ -10,21 +10,30 @@ import (
 	"go.uber.org/zap"
 )
 
+// Decryption decrypts request body with a decrypter chosen by encryption scheme header,
+// requests without the header are decrypted with RSA engine for backward compatibility.
+// Only decrypters having a private key are used, request body is passed as is if there are none
 type Decryption struct {
-	decrypter encrypt.Decrypter
-	logger    *zap.SugaredLogger
+	decrypters map[string]encrypt.Decrypter
+	logger     *zap.SugaredLogger
 }
 
-func NewDecryption(l *zap.Logger, dec encrypt.Decrypter) *Decryption {
+func NewDecryption(l *zap.Logger, decs ...encrypt.Decrypter) *Decryption {
+	decrypters := make(map[string]encrypt.Decrypter)
+	for _, dec := range decs {
+		if dec.DecryptingEnabled() {
+			decrypters[dec.Scheme()] = dec
+		}
+	}
 	return &Decryption{
-		decrypter: dec,
-		logger:    l.Sugar().With("component", "decryption-middleware"),
+		decrypters: decrypters,
+		logger:     l.Sugar().With("component", "decryption-middleware"),
 	}
 }
 
 func (dec *Decryption) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
-		if !dec.decrypter.DecryptingEnabled() {
+		if len(dec.decrypters) == 0 {
 			next.ServeHTTP(rw, r)
 			return
 		}
@@ -34,16 +43,27 @@ func (dec *Decryption) Middleware(next http.Handler) http.Handler {
 			return
 		}
 
+		scheme := r.Header.Get(encrypt.EncryptionHeader)
+		if scheme == "" {
+			scheme = encrypt.SchemeRSAAES
+		}
+		decrypter, ok := dec.decrypters[scheme]
+		if !ok {
+			dec.logger.Debugw("unsupported encryption scheme", "scheme", scheme)
+			errorhandling.NewValidationHandlerError("unsupported encryption scheme: " + scheme).Render(rw)
+			return
+		}
+
 		payload, err := io.ReadAll(r.Body)
 		if err != nil {
 			dec.logger.Warnw("can't read incoming request body", "error", err)
-			errorhandling.NewValidationHandlerError("can't read incoming request body").Render(rw)
+			errorhandling.NewBodyReadError("can't read incoming request body", err).Render(rw)
 			return
 		}
 
-		decrypted, err := dec.decrypter.Decrypt(payload)
+		decrypted, err := decrypter.Decrypt(payload)
 		if err != nil {
-			dec.logger.Warnw("can't decrypt incoming request body", "error", err)
+			dec.logger.Warnw("can't decrypt incoming request body", "error", err, "scheme", scheme)
 			errorhandling.NewValidationHandlerError("can't decrypt incoming request body").Render(rw)
 			return
 		}
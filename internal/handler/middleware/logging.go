@@ -1,19 +1,28 @@
 package middleware
 
 import (
-	"go.uber.org/zap"
 	"net/http"
+	"strconv"
 	"time"
+
+	"github.com/andrewsvn/metrics-overseer/internal/model"
+	"github.com/andrewsvn/metrics-overseer/internal/selfmetrics"
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
 )
 
+// HTTPLogging logs all requests and records their count, duration and response size in self-metrics registry
+// (labeled with a route pattern to keep the number of series bounded)
 type HTTPLogging struct {
 	logger *zap.SugaredLogger
+	reg    *selfmetrics.Registry
 }
 
-func NewHTTPLogging(logger *zap.Logger) *HTTPLogging {
+func NewHTTPLogging(logger *zap.Logger, reg *selfmetrics.Registry) *HTTPLogging {
 	httpLogger := logger.Sugar().With(zap.String("component", "HTTP-logging"))
 	return &HTTPLogging{
 		logger: httpLogger,
+		reg:    reg,
 	}
 }
 
@@ -66,5 +75,26 @@ func (l *HTTPLogging) Middleware(next http.Handler) http.Handler {
 			"content-type", ew.Header().Get("Content-Type"),
 			"content-encoding", ew.Header().Get("Content-Encoding"),
 			"duration", duration.String())
+
+		l.recordMetrics(r, ew, duration)
 	})
 }
+
+func (l *HTTPLogging) recordMetrics(r *http.Request, ew *enrichedResponseWriter, duration time.Duration) {
+	// route pattern is known only after routing, requests not matching any route are recorded together
+	route := "unmatched"
+	if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+		route = rctx.RoutePattern()
+	}
+	status := ew.ResponseStatus
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	labels := model.Labels{"route": route, "method": r.Method}
+	l.reg.ObserveDuration("http.request.duration.seconds", labels, duration)
+	l.reg.AddCounter("http.response.size.bytes", labels, int64(ew.ResponseSize))
+
+	labels["code"] = strconv.Itoa(status)
+	l.reg.AddCounter("http.requests", labels, 1)
+}
@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/andrewsvn/metrics-overseer/internal/encrypt"
+	"github.com/andrewsvn/metrics-overseer/internal/handler/errorhandling"
+	"go.uber.org/zap"
+)
+
+// ResponseEncryption encrypts successful response bodies with a public key passed by client in request headers
+// or with a server-known key referred by identifier. Encryption scheme is set in response header,
+// error responses and responses to clients not asking for encryption are passed as is.
+// Response signature (if any) covers the plain body, so clients must decrypt response before verifying it
+type ResponseEncryption struct {
+	keys   map[string]encrypt.Encrypter
+	logger *zap.SugaredLogger
+}
+
+func NewResponseEncryption(l *zap.Logger, keys map[string]encrypt.Encrypter) *ResponseEncryption {
+	return &ResponseEncryption{
+		keys:   keys,
+		logger: l.Sugar().With("component", "response-encryption-middleware"),
+	}
+}
+
+type bufferedResponseWriter struct {
+	http.ResponseWriter
+
+	status int
+	body   bytes.Buffer
+}
+
+func (bw *bufferedResponseWriter) WriteHeader(status int) {
+	if bw.status == 0 {
+		bw.status = status
+	}
+}
+
+func (bw *bufferedResponseWriter) Write(b []byte) (int, error) {
+	if bw.status == 0 {
+		bw.status = http.StatusOK
+	}
+	return bw.body.Write(b)
+}
+
+func (re *ResponseEncryption) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		encrypter, err := encrypt.ResponseEncrypter(r.Header, re.keys)
+		if err != nil {
+			re.logger.Debugw("invalid response key", "error", err)
+			errorhandling.NewValidationHandlerError("invalid response key").Render(rw)
+			return
+		}
+		if encrypter == nil {
+			next.ServeHTTP(rw, r)
+			return
+		}
+
+		bw := &bufferedResponseWriter{ResponseWriter: rw}
+		next.ServeHTTP(bw, r)
+		if bw.status == 0 {
+			bw.status = http.StatusOK
+		}
+
+		body := bw.body.Bytes()
+		if bw.status >= 200 && bw.status < 300 {
+			body, err = encrypter.Encrypt(body)
+			if err != nil {
+				re.logger.Errorw("can't encrypt response body", "error", err, "scheme", encrypter.Scheme())
+				errorhandling.NewInternalServerError(err).Render(rw)
+				return
+			}
+			rw.Header().Set(encrypt.EncryptionHeader, encrypter.Scheme())
+			rw.Header().Del("Content-Length")
+		}
+
+		rw.WriteHeader(bw.status)
+		if _, err = rw.Write(body); err != nil {
+			re.logger.Errorw("error writing response body", "error", err)
+		}
+	})
+}
@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/andrewsvn/metrics-overseer/internal/apitoken"
+	"go.uber.org/zap"
+)
+
+// TokenAuth authenticates requests by API token passed in Authorization header as "Bearer <token>".
+// Requests without a known token are rejected with 401 status, requests with a token lacking required scope -
+// with 403 status. Authenticated token is stored in request context. All requests are passed through
+// if API tokens are not configured
+type TokenAuth struct {
+	tokens *apitoken.Registry
+	logger *zap.SugaredLogger
+}
+
+func NewTokenAuth(l *zap.Logger, tokens *apitoken.Registry) *TokenAuth {
+	return &TokenAuth{
+		tokens: tokens,
+		logger: l.Sugar().With("component", "token-auth-middleware"),
+	}
+}
+
+// Require returns middleware checking that request token has the given scope
+func (ta *TokenAuth) Require(scope apitoken.Scope) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			if !ta.tokens.Enabled() {
+				next.ServeHTTP(rw, r)
+				return
+			}
+
+			token, ok := ta.tokens.Authenticate(apitoken.ParseBearer(r.Header.Get(apitoken.AuthorizationHeader)))
+			if !ok {
+				ta.logger.Debugw("request without valid API token rejected", "path", r.URL.Path)
+				rw.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(rw, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+			if !token.Allows(scope) {
+				ta.logger.Debugw("API token lacks required scope",
+					"token", token.Name, "scope", scope, "path", r.URL.Path)
+				http.Error(rw, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(rw, r.WithContext(apitoken.WithToken(r.Context(), token)))
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/andrewsvn/metrics-overseer/internal/subnet"
+	"go.uber.org/zap"
+)
+
+// TrustedSubnet rejects requests from clients outside of trusted subnets with 403 status.
+// Client address is taken from X-Real-IP header if present, otherwise remote address of connection is used
+type TrustedSubnet struct {
+	checker *subnet.Checker
+	logger  *zap.SugaredLogger
+}
+
+func NewTrustedSubnet(l *zap.Logger, checker *subnet.Checker) *TrustedSubnet {
+	return &TrustedSubnet{
+		checker: checker,
+		logger:  l.Sugar().With("component", "trusted-subnet-middleware"),
+	}
+}
+
+func (ts *TrustedSubnet) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if !ts.checker.Enabled() {
+			next.ServeHTTP(rw, r)
+			return
+		}
+
+		addr := r.Header.Get(subnet.RealIPHeader)
+		if addr == "" {
+			addr, _, _ = net.SplitHostPort(r.RemoteAddr)
+		}
+		if !ts.checker.Trusted(addr) {
+			ts.logger.Debugw("request from untrusted address rejected", "address", addr)
+			http.Error(rw, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(rw, r)
+	})
+}
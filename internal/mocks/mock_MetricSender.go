@@ -66,17 +66,17 @@ func (_c *MockMetricSender_SendMetric_Call) RunAndReturn(run func(*model.Metrics
 	return _c
 }
 
-// SendMetricArray provides a mock function with given fields: metrics
-func (_m *MockMetricSender) SendMetricArray(metrics []*model.Metrics) error {
-	ret := _m.Called(metrics)
+// SendMetricArray provides a mock function with given fields: batchID, metrics
+func (_m *MockMetricSender) SendMetricArray(batchID *model.BatchID, metrics []*model.Metrics) error {
+	ret := _m.Called(batchID, metrics)
 
 	if len(ret) == 0 {
 		panic("no return value specified for SendMetricArray")
 	}
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func([]*model.Metrics) error); ok {
-		r0 = rf(metrics)
+	if rf, ok := ret.Get(0).(func(*model.BatchID, []*model.Metrics) error); ok {
+		r0 = rf(batchID, metrics)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -90,14 +90,15 @@ type MockMetricSender_SendMetricArray_Call struct {
 }
 
 // SendMetricArray is a helper method to define mock.On call
+//   - batchID *model.BatchID
 //   - metrics []*model.Metrics
-func (_e *MockMetricSender_Expecter) SendMetricArray(metrics interface{}) *MockMetricSender_SendMetricArray_Call {
-	return &MockMetricSender_SendMetricArray_Call{Call: _e.mock.On("SendMetricArray", metrics)}
+func (_e *MockMetricSender_Expecter) SendMetricArray(batchID interface{}, metrics interface{}) *MockMetricSender_SendMetricArray_Call {
+	return &MockMetricSender_SendMetricArray_Call{Call: _e.mock.On("SendMetricArray", batchID, metrics)}
 }
 
-func (_c *MockMetricSender_SendMetricArray_Call) Run(run func(metrics []*model.Metrics)) *MockMetricSender_SendMetricArray_Call {
+func (_c *MockMetricSender_SendMetricArray_Call) Run(run func(batchID *model.BatchID, metrics []*model.Metrics)) *MockMetricSender_SendMetricArray_Call {
 	_c.Call.Run(func(args mock.Arguments) {
-		run(args[0].([]*model.Metrics))
+		run(args[0].(*model.BatchID), args[1].([]*model.Metrics))
 	})
 	return _c
 }
@@ -107,7 +108,7 @@ func (_c *MockMetricSender_SendMetricArray_Call) Return(_a0 error) *MockMetricSe
 	return _c
 }
 
-func (_c *MockMetricSender_SendMetricArray_Call) RunAndReturn(run func([]*model.Metrics) error) *MockMetricSender_SendMetricArray_Call {
+func (_c *MockMetricSender_SendMetricArray_Call) RunAndReturn(run func(*model.BatchID, []*model.Metrics) error) *MockMetricSender_SendMetricArray_Call {
 	_c.Call.Return(run)
 	return _c
 }
@@ -0,0 +1,9 @@
+package model
+
+// UpdateAck is a server acknowledgement of accepted metric updates, it's signed the same way
+// as other server responses, so a client can make sure that updates were accepted by a trusted server
+type UpdateAck struct {
+	Updated int `json:"updated"`
+	// Duplicate is set if the batch with the same BatchID has already been applied, and it is not applied again
+	Duplicate bool `json:"duplicate,omitempty"`
+}
@@ -0,0 +1,40 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// BatchIDHeader is a request header holding ID of a metrics batch update, server applies a batch
+// with the same ID only once, so the batch can be safely resent if server response is lost
+const BatchIDHeader = "X-Batch-ID"
+
+var ErrInvalidBatchID = errors.New("invalid batch ID")
+
+// BatchID identifies a batch update by ID of the agent sending it and sequence number of the batch,
+// sequence numbers must not repeat for the same agent
+type BatchID struct {
+	AgentID string `json:"agent_id"`
+	Seq     uint64 `json:"seq"`
+}
+
+// String renders batch ID as it is passed in BatchIDHeader, e.g. `web-1:1024`
+func (b BatchID) String() string {
+	return b.AgentID + ":" + strconv.FormatUint(b.Seq, 10)
+}
+
+// ParseBatchID parses batch ID in the form rendered by BatchID.String,
+// agent ID may contain colons since sequence number is separated by the last one
+func ParseBatchID(s string) (*BatchID, error) {
+	idx := strings.LastIndexByte(s, ':')
+	if idx <= 0 {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidBatchID, s)
+	}
+	seq, err := strconv.ParseUint(s[idx+1:], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidBatchID, s)
+	}
+	return &BatchID{AgentID: s[:idx], Seq: seq}, nil
+}
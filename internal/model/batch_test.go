@@ -0,0 +1,26 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatchID(t *testing.T) {
+	id := BatchID{AgentID: "web-1", Seq: 1024}
+	assert.Equal(t, "web-1:1024", id.String())
+
+	parsed, err := ParseBatchID(id.String())
+	require.NoError(t, err)
+	assert.Equal(t, id, *parsed)
+
+	parsed, err = ParseBatchID("[::1]:8080:7")
+	require.NoError(t, err)
+	assert.Equal(t, BatchID{AgentID: "[::1]:8080", Seq: 7}, *parsed)
+
+	for _, s := range []string{"", "web-1", ":1", "web-1:", "web-1:-1", "web-1:x"} {
+		_, err = ParseBatchID(s)
+		assert.ErrorIs(t, err, ErrInvalidBatchID, s)
+	}
+}
@@ -0,0 +1,182 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// DefaultHistogramBuckets are bucket upper bounds used for histogram metrics
+// when a client doesn't provide its own bucket layout (e.g. in path-based updates)
+var DefaultHistogramBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+var (
+	ErrInvalidHistogram         = errors.New("invalid histogram value")
+	ErrHistogramBucketsMismatch = errors.New("histogram buckets mismatch")
+	// ErrValueOverflow is returned when merged value can't be represented: accumulated counts overflow int64
+	// or sum becomes infinite
+	ErrValueOverflow = errors.New("accumulated metric value overflow")
+)
+
+// HistogramValue holds accumulated state of a histogram metric.
+// Bounds contains inclusive upper bounds of buckets in ascending order, an implicit +Inf bucket
+// always follows the last bound, so Counts has exactly len(Bounds)+1 elements.
+// Counts are stored per bucket (not cumulative) to make merging of two histograms trivial.
+type HistogramValue struct {
+	Bounds []float64 `json:"bounds"`
+	Counts []int64   `json:"counts"`
+	Count  int64     `json:"count"`
+	Sum    float64   `json:"sum"`
+}
+
+// NewHistogramValue creates an empty histogram with provided bucket bounds,
+// if no bounds are provided DefaultHistogramBuckets are used
+func NewHistogramValue(bounds ...float64) *HistogramValue {
+	if len(bounds) == 0 {
+		bounds = DefaultHistogramBuckets
+	}
+	return &HistogramValue{
+		Bounds: slices.Clone(bounds),
+		Counts: make([]int64, len(bounds)+1),
+	}
+}
+
+// Observe puts a single value into the corresponding bucket
+func (h *HistogramValue) Observe(v float64) {
+	h.ObserveN(v, 1)
+}
+
+// ObserveN puts the same value into the corresponding bucket n times,
+// it is useful for weighted (sampled) observations
+func (h *HistogramValue) ObserveN(v float64, n int64) {
+	idx, _ := slices.BinarySearch(h.Bounds, v)
+	h.Counts[idx] += n
+	h.Count += n
+	h.Sum += v * float64(n)
+}
+
+// Merge adds all observations from other histogram to this one.
+// Both histograms must have the same bucket layout, otherwise ErrHistogramBucketsMismatch is returned.
+// ErrValueOverflow is returned if counts or sum of the result overflow, the histogram is left unchanged on error
+func (h *HistogramValue) Merge(other *HistogramValue) error {
+	if !slices.Equal(h.Bounds, other.Bounds) {
+		return fmt.Errorf("%w: expected %v, got %v", ErrHistogramBucketsMismatch, h.Bounds, other.Bounds)
+	}
+	counts := slices.Clone(h.Counts)
+	for i, c := range other.Counts {
+		var ok bool
+		if counts[i], ok = addInt64(counts[i], c); !ok {
+			return fmt.Errorf("%w: histogram bucket count", ErrValueOverflow)
+		}
+	}
+	count, ok := addInt64(h.Count, other.Count)
+	if !ok {
+		return fmt.Errorf("%w: histogram count", ErrValueOverflow)
+	}
+	sum := h.Sum + other.Sum
+	if !isFinite(sum) {
+		return fmt.Errorf("%w: histogram sum", ErrValueOverflow)
+	}
+
+	h.Counts = counts
+	h.Count = count
+	h.Sum = sum
+	return nil
+}
+
+// addInt64 returns sum of a and b, false is returned if the sum overflows int64
+func addInt64(a, b int64) (int64, bool) {
+	if (b > 0 && a > math.MaxInt64-b) || (b < 0 && a < math.MinInt64-b) {
+		return 0, false
+	}
+	return a + b, true
+}
+
+func isFinite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+// Compatible checks that other histogram can be merged into this one
+func (h *HistogramValue) Compatible(other *HistogramValue) bool {
+	return slices.Equal(h.Bounds, other.Bounds)
+}
+
+// Validate checks histogram consistency - it is needed for values received from external clients
+func (h *HistogramValue) Validate() error {
+	if len(h.Counts) != len(h.Bounds)+1 {
+		return fmt.Errorf("%w: expected %d bucket counts, got %d", ErrInvalidHistogram, len(h.Bounds)+1, len(h.Counts))
+	}
+	if !isFinite(h.Sum) {
+		return fmt.Errorf("%w: sum must be finite", ErrInvalidHistogram)
+	}
+	for i, b := range h.Bounds {
+		if !isFinite(b) {
+			return fmt.Errorf("%w: bucket bound must be finite", ErrInvalidHistogram)
+		}
+		if i > 0 && h.Bounds[i-1] >= b {
+			return fmt.Errorf("%w: bucket bounds must be strictly increasing", ErrInvalidHistogram)
+		}
+	}
+	var total int64
+	for _, c := range h.Counts {
+		if c < 0 {
+			return fmt.Errorf("%w: bucket count can't be negative", ErrInvalidHistogram)
+		}
+		var ok bool
+		if total, ok = addInt64(total, c); !ok {
+			return fmt.Errorf("%w: bucket counts sum overflows", ErrInvalidHistogram)
+		}
+	}
+	if total != h.Count {
+		return fmt.Errorf("%w: total count %d doesn't match bucket counts sum %d", ErrInvalidHistogram, h.Count, total)
+	}
+	return nil
+}
+
+func (h *HistogramValue) Clone() *HistogramValue {
+	return &HistogramValue{
+		Bounds: slices.Clone(h.Bounds),
+		Counts: slices.Clone(h.Counts),
+		Count:  h.Count,
+		Sum:    h.Sum,
+	}
+}
+
+// CumulativeCounts returns bucket counts in cumulative form (each bucket includes all lower ones)
+// as it is usually expected by external monitoring systems
+func (h *HistogramValue) CumulativeCounts() []int64 {
+	cumulative := make([]int64, len(h.Counts))
+	var total int64
+	for i, c := range h.Counts {
+		total += c
+		cumulative[i] = total
+	}
+	return cumulative
+}
+
+// String renders histogram in a compact human-readable form, e.g. "count=3 sum=1.2 [0.5:1 1:2 +Inf:0]"
+func (h *HistogramValue) String() string {
+	sb := strings.Builder{}
+	sb.WriteString("count=")
+	sb.WriteString(strconv.FormatInt(h.Count, 10))
+	sb.WriteString(" sum=")
+	sb.WriteString(strconv.FormatFloat(h.Sum, 'f', -1, 64))
+	sb.WriteString(" [")
+	for i, c := range h.Counts {
+		if i > 0 {
+			sb.WriteByte(' ')
+		}
+		if i < len(h.Bounds) {
+			sb.WriteString(strconv.FormatFloat(h.Bounds[i], 'f', -1, 64))
+		} else {
+			sb.WriteString("+Inf")
+		}
+		sb.WriteByte(':')
+		sb.WriteString(strconv.FormatInt(c, 10))
+	}
+	sb.WriteByte(']')
+	return sb.String()
+}
@@ -0,0 +1,113 @@
+package model
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistogramObserve(t *testing.T) {
+	h := NewHistogramValue(1, 5, 10)
+	require.Equal(t, 4, len(h.Counts))
+
+	h.Observe(0.5)
+	h.Observe(1)
+	h.Observe(3)
+	h.Observe(10)
+	h.Observe(100)
+	h.ObserveN(7, 2)
+
+	assert.Equal(t, []int64{2, 1, 3, 1}, h.Counts)
+	assert.Equal(t, []int64{2, 3, 6, 7}, h.CumulativeCounts())
+	assert.Equal(t, int64(7), h.Count)
+	assert.InDelta(t, 128.5, h.Sum, 0.0001)
+	assert.NoError(t, h.Validate())
+	assert.Equal(t, "count=7 sum=128.5 [1:2 5:1 10:3 +Inf:1]", h.String())
+
+	h = NewHistogramValue()
+	assert.Equal(t, DefaultHistogramBuckets, h.Bounds)
+}
+
+func TestHistogramMerge(t *testing.T) {
+	h1 := NewHistogramValue(1, 2)
+	h1.Observe(0.5)
+	h2 := NewHistogramValue(1, 2)
+	h2.Observe(1.5)
+	h2.Observe(2.5)
+
+	err := h1.Merge(h2)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{1, 1, 1}, h1.Counts)
+	assert.Equal(t, int64(3), h1.Count)
+	assert.InDelta(t, 4.5, h1.Sum, 0.0001)
+
+	h3 := NewHistogramValue(1, 3)
+	h3.Observe(1)
+	err = h1.Merge(h3)
+	assert.ErrorIs(t, err, ErrHistogramBucketsMismatch)
+	assert.Equal(t, int64(3), h1.Count)
+
+	// overflowing sum or counts are rejected and the histogram is left unchanged
+	huge := &HistogramValue{Bounds: []float64{1, 2}, Counts: []int64{0, 0, 1}, Count: 1, Sum: 1e308}
+	require.NoError(t, h1.Merge(huge))
+	assert.ErrorIs(t, h1.Merge(huge), ErrValueOverflow)
+	assert.Equal(t, []int64{1, 1, 2}, h1.Counts)
+	assert.Equal(t, int64(4), h1.Count)
+
+	many := &HistogramValue{Bounds: []float64{1, 2}, Counts: []int64{math.MaxInt64, 0, 0}, Count: math.MaxInt64}
+	assert.ErrorIs(t, h1.Merge(many), ErrValueOverflow)
+	assert.Equal(t, []int64{1, 1, 2}, h1.Counts)
+	assert.Equal(t, int64(4), h1.Count)
+}
+
+func TestHistogramValidate(t *testing.T) {
+	h := &HistogramValue{Bounds: []float64{1, 2}, Counts: []int64{1, 1}, Count: 2}
+	assert.ErrorIs(t, h.Validate(), ErrInvalidHistogram)
+
+	h = &HistogramValue{Bounds: []float64{2, 1}, Counts: []int64{1, 1, 0}, Count: 2}
+	assert.ErrorIs(t, h.Validate(), ErrInvalidHistogram)
+
+	h = &HistogramValue{Bounds: []float64{1, 2}, Counts: []int64{1, 1, 0}, Count: 3}
+	assert.ErrorIs(t, h.Validate(), ErrInvalidHistogram)
+
+	h = &HistogramValue{Bounds: []float64{1, 2}, Counts: []int64{1, 1, 0}, Count: 2, Sum: math.Inf(1)}
+	assert.ErrorIs(t, h.Validate(), ErrInvalidHistogram)
+
+	h = &HistogramValue{Bounds: []float64{1, 2}, Counts: []int64{math.MaxInt64, 1, 0}, Count: math.MinInt64}
+	assert.ErrorIs(t, h.Validate(), ErrInvalidHistogram)
+
+	h = &HistogramValue{Bounds: []float64{1, 2}, Counts: []int64{1, 1, 0}, Count: 2, Sum: 2.5}
+	assert.NoError(t, h.Validate())
+}
+
+func TestHistogramMetrics(t *testing.T) {
+	const id = "latency"
+
+	m := NewHistogramMetrics(id)
+	assert.Equal(t, Histogram, m.MType)
+	assert.Nil(t, m.Histogram)
+	initialHash := m.Hash
+
+	h := NewHistogramValue(1, 2)
+	h.Observe(1.5)
+	err := m.AddHistogram(h)
+	require.NoError(t, err)
+	require.NotNil(t, m.Histogram)
+	assert.NotEqual(t, initialHash, m.Hash)
+	valHash := m.Hash
+
+	// stored histogram must not share state with provided one
+	h.Observe(0.5)
+	assert.Equal(t, int64(1), m.Histogram.Count)
+
+	err = m.AddHistogram(NewHistogramValue(5))
+	assert.ErrorIs(t, err, ErrHistogramBucketsMismatch)
+	assert.Equal(t, valHash, m.Hash)
+
+	c := m.Clone()
+	assert.Equal(t, m.Hash, c.Hash)
+	c.Histogram.Observe(3)
+	assert.Equal(t, int64(1), m.Histogram.Count)
+}
@@ -0,0 +1,103 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidLabels   = errors.New("invalid metric labels")
+	ErrInvalidMetricID = errors.New("invalid metric id")
+
+	labelNameRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// Labels is a set of key/value pairs describing metric dimensions (host, cpu number etc.).
+// Labels are a part of metric series identity - metrics with the same ID but different labels
+// are stored and accumulated independently
+type Labels map[string]string
+
+// String renders labels in canonical form with keys sorted, e.g. `{cpu="3",host="web-1"}`,
+// empty string is returned for empty labels
+func (l Labels) String() string {
+	if len(l) == 0 {
+		return ""
+	}
+
+	sb := strings.Builder{}
+	sb.WriteByte('{')
+	for i, k := range slices.Sorted(maps.Keys(l)) {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(k)
+		sb.WriteByte('=')
+		sb.WriteString(strconv.Quote(l[k]))
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+// Contains checks that all provided labels are present in this label set with the same values
+func (l Labels) Contains(other Labels) bool {
+	for k, v := range other {
+		if lv, ok := l[k]; !ok || lv != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Validate checks that label names are acceptable (letters, digits and underscores not starting with a digit)
+func (l Labels) Validate() error {
+	for k := range l {
+		if !labelNameRegexp.MatchString(k) {
+			return fmt.Errorf("%w: bad label name %q", ErrInvalidLabels, k)
+		}
+	}
+	return nil
+}
+
+func (l Labels) Clone() Labels {
+	if len(l) == 0 {
+		return nil
+	}
+	return maps.Clone(l)
+}
+
+// ValidateMetricID checks that metric ID can't be confused with labels part of series key
+func ValidateMetricID(id string) error {
+	if strings.ContainsAny(id, "{}") {
+		return fmt.Errorf("%w: %q contains curly braces", ErrInvalidMetricID, id)
+	}
+	return nil
+}
+
+// SeriesKey builds a unique key of metric series from its ID and labels.
+// For metrics without labels key is equal to ID, IDs are validated with ValidateMetricID, so keys don't collide
+func SeriesKey(id string, labels Labels) string {
+	return id + labels.String()
+}
+
+// Filter describes a selection of metric series for reading,
+// empty ID or type match any value, labels match series containing all of provided labels
+type Filter struct {
+	ID     string `json:"id,omitempty"`
+	MType  string `json:"type,omitempty"`
+	Labels Labels `json:"labels,omitempty"`
+}
+
+func (f *Filter) Matches(m *Metrics) bool {
+	if f.ID != "" && f.ID != m.ID {
+		return false
+	}
+	if f.MType != "" && f.MType != m.MType {
+		return false
+	}
+	return m.Labels.Contains(f.Labels)
+}
@@ -0,0 +1,61 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLabelsString(t *testing.T) {
+	assert.Equal(t, "", Labels(nil).String())
+	assert.Equal(t, "", Labels{}.String())
+	assert.Equal(t, `{cpu="3",host="web-1"}`, Labels{"host": "web-1", "cpu": "3"}.String())
+	assert.Equal(t, `{path="C:\\tmp \"x\""}`, Labels{"path": `C:\tmp "x"`}.String())
+
+	assert.Equal(t, "cpu", SeriesKey("cpu", nil))
+	assert.Equal(t, `cpu{core="1"}`, SeriesKey("cpu", Labels{"core": "1"}))
+}
+
+func TestLabelsValidate(t *testing.T) {
+	assert.NoError(t, Labels(nil).Validate())
+	assert.NoError(t, Labels{"host": "a", "_cpu2": ""}.Validate())
+	assert.ErrorIs(t, Labels{"": "a"}.Validate(), ErrInvalidLabels)
+	assert.ErrorIs(t, Labels{"2cpu": "a"}.Validate(), ErrInvalidLabels)
+	assert.ErrorIs(t, Labels{"host-name": "a"}.Validate(), ErrInvalidLabels)
+}
+
+func TestValidateMetricID(t *testing.T) {
+	assert.NoError(t, ValidateMetricID("node_cpu.seconds"))
+	assert.ErrorIs(t, ValidateMetricID(`x{a="1"}`), ErrInvalidMetricID)
+	assert.ErrorIs(t, ValidateMetricID("x}"), ErrInvalidMetricID)
+}
+
+func TestFilterMatches(t *testing.T) {
+	m := NewGaugeMetricsWithValue("cpu", 0.5).WithLabels(Labels{"host": "a", "core": "1"})
+
+	assert.True(t, (&Filter{}).Matches(m))
+	assert.True(t, (&Filter{ID: "cpu", MType: Gauge}).Matches(m))
+	assert.True(t, (&Filter{Labels: Labels{"host": "a"}}).Matches(m))
+	assert.False(t, (&Filter{ID: "mem"}).Matches(m))
+	assert.False(t, (&Filter{MType: Counter}).Matches(m))
+	assert.False(t, (&Filter{Labels: Labels{"host": "b"}}).Matches(m))
+	assert.False(t, (&Filter{Labels: Labels{"dc": "eu"}}).Matches(m))
+}
+
+func TestMetricsLabels(t *testing.T) {
+	labels := Labels{"host": "a"}
+	m := NewCounterMetricsWithDelta("cnt", 1)
+	plainHash := m.Hash
+
+	m.WithLabels(labels)
+	assert.Equal(t, `cnt{host="a"}`, m.Key())
+	assert.NotEqual(t, plainHash, m.Hash)
+
+	// labels are copied, so external modifications don't affect metric identity
+	labels["host"] = "b"
+	assert.Equal(t, "a", m.Labels["host"])
+
+	c := m.Clone()
+	c.Labels["host"] = "c"
+	assert.Equal(t, "a", m.Labels["host"])
+}
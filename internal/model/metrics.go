@@ -7,11 +7,22 @@ import (
 )
 
 const (
-	Counter = "counter"
-	Gauge   = "gauge"
+	Counter   = "counter"
+	Gauge     = "gauge"
+	Histogram = "histogram"
+	Summary   = "summary"
 )
 
-// store Delta and Value as pointers to support uninitialized state
+// IsKnownType checks that metric type is one of supported ones
+func IsKnownType(mtype string) bool {
+	switch mtype {
+	case Counter, Gauge, Histogram, Summary:
+		return true
+	}
+	return false
+}
+
+// store Delta, Value, Histogram and Summary as pointers to support uninitialized state
 // separated from default value without additional flags
 
 // generate:reset
@@ -20,7 +31,13 @@ type Metrics struct {
 	MType string   `json:"type"`
 	Delta *int64   `json:"delta,omitempty"`
 	Value *float64 `json:"value,omitempty"`
-	Hash  string   `json:"-"`
+
+	Labels Labels `json:"labels,omitempty"`
+
+	Histogram *HistogramValue `json:"histogram,omitempty"`
+	Summary   *SummaryValue   `json:"summary,omitempty"`
+
+	Hash string `json:"-"`
 }
 
 func NewMetrics(id string, mType string, delta *int64, value *float64) *Metrics {
@@ -72,6 +89,47 @@ func NewCounterMetricsWithDelta(id string, delta int64) *Metrics {
 	return m
 }
 
+func NewHistogramMetrics(id string) *Metrics {
+	m := &Metrics{
+		ID:    id,
+		MType: Histogram,
+	}
+	m.updateHash()
+	return m
+}
+
+func NewHistogramMetricsWithValue(id string, hist *HistogramValue) *Metrics {
+	m := &Metrics{
+		ID:        id,
+		MType:     Histogram,
+		Histogram: hist,
+	}
+	m.updateHash()
+	return m
+}
+
+func NewSummaryMetricsWithValue(id string, summary *SummaryValue) *Metrics {
+	m := &Metrics{
+		ID:      id,
+		MType:   Summary,
+		Summary: summary,
+	}
+	m.updateHash()
+	return m
+}
+
+// WithLabels sets metric labels and returns the metric itself, so it can be chained with constructors
+func (m *Metrics) WithLabels(labels Labels) *Metrics {
+	m.Labels = labels.Clone()
+	m.updateHash()
+	return m
+}
+
+// Key returns unique metric series key built from ID and labels
+func (m *Metrics) Key() string {
+	return SeriesKey(m.ID, m.Labels)
+}
+
 func (m *Metrics) SetGauge(value float64) {
 	m.Value = &value
 	m.updateHash()
@@ -86,9 +144,70 @@ func (m *Metrics) AddCounter(delta int64) {
 	m.updateHash()
 }
 
+// SetHistogram replaces the whole histogram state
+func (m *Metrics) SetHistogram(hist *HistogramValue) {
+	m.Histogram = hist
+	m.updateHash()
+}
+
+// AddHistogram merges observations of provided histogram into the metric histogram,
+// bucket layouts must be the same for both
+func (m *Metrics) AddHistogram(hist *HistogramValue) error {
+	if m.Histogram == nil {
+		m.Histogram = hist.Clone()
+	} else if err := m.Histogram.Merge(hist); err != nil {
+		return err
+	}
+	m.updateHash()
+	return nil
+}
+
+// SetSummary replaces the whole summary sketch
+func (m *Metrics) SetSummary(summary *SummaryValue) {
+	m.Summary = summary
+	m.updateHash()
+}
+
+// AddSummary merges provided quantile sketch into the metric sketch
+func (m *Metrics) AddSummary(summary *SummaryValue) error {
+	if m.Summary == nil {
+		m.Summary = summary.Clone()
+		m.Summary.Compress()
+	} else if err := m.Summary.Merge(summary); err != nil {
+		return err
+	}
+	m.updateHash()
+	return nil
+}
+
+// Clone returns a deep copy of the metric, so it can be safely stored or modified
+func (m *Metrics) Clone() *Metrics {
+	c := &Metrics{
+		ID:     m.ID,
+		MType:  m.MType,
+		Labels: m.Labels.Clone(),
+		Hash:   m.Hash,
+	}
+	if m.Delta != nil {
+		delta := *m.Delta
+		c.Delta = &delta
+	}
+	if m.Value != nil {
+		value := *m.Value
+		c.Value = &value
+	}
+	if m.Histogram != nil {
+		c.Histogram = m.Histogram.Clone()
+	}
+	if m.Summary != nil {
+		c.Summary = m.Summary.Clone()
+	}
+	return c
+}
+
 func (m *Metrics) updateHash() {
-	parts := make([]string, 4)
-	parts[0] = m.ID
+	parts := make([]string, 4, 6)
+	parts[0] = m.Key()
 	parts[1] = m.MType
 	parts[2] = "nil"
 	if m.Delta != nil {
@@ -98,6 +217,12 @@ func (m *Metrics) updateHash() {
 	if m.Value != nil {
 		parts[3] = strconv.FormatFloat(*m.Value, 'f', -1, 64)
 	}
+	if m.Histogram != nil {
+		parts = append(parts, m.Histogram.String())
+	}
+	if m.Summary != nil {
+		parts = append(parts, m.Summary.String())
+	}
 
 	hash := md5.New()
 	hash.Write([]byte(strings.Join(parts, "#")))
@@ -117,6 +242,16 @@ func (m *Metrics) StringValue() string {
 			return NotAvailable
 		}
 		return strconv.FormatFloat(*m.Value, 'f', -1, 64)
+	case Histogram:
+		if m.Histogram == nil {
+			return NotAvailable
+		}
+		return m.Histogram.String()
+	case Summary:
+		if m.Summary == nil {
+			return NotAvailable
+		}
+		return m.Summary.String()
 	default:
 		return NotAvailable
 	}
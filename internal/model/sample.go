@@ -0,0 +1,26 @@
+package model
+
+import "time"
+
+// Sample is a state of a metric series at some moment of time - it is recorded on each metric update,
+// so the sequence of samples shows how the metric changed. For counters it contains the accumulated total
+// (not the delta of a single update), histograms and summaries are stored as full snapshots
+type Sample struct {
+	Timestamp time.Time       `json:"ts"`
+	Delta     *int64          `json:"delta,omitempty"`
+	Value     *float64        `json:"value,omitempty"`
+	Histogram *HistogramValue `json:"histogram,omitempty"`
+	Summary   *SummaryValue   `json:"summary,omitempty"`
+}
+
+// NewSample takes a snapshot of metric values, so further updates of the metric don't change the sample
+func NewSample(ts time.Time, m *Metrics) *Sample {
+	c := m.Clone()
+	return &Sample{
+		Timestamp: ts,
+		Delta:     c.Delta,
+		Value:     c.Value,
+		Histogram: c.Histogram,
+		Summary:   c.Summary,
+	}
+}
@@ -0,0 +1,220 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// summaryCompression controls accuracy/size trade-off of the quantile sketch:
+// the number of centroids kept after compression is proportional to it (growing only logarithmically with count)
+const summaryCompression = 100
+
+var (
+	ErrInvalidSummary = errors.New("invalid summary value")
+)
+
+// Centroid is a cluster of close observed values represented by their mean and number of values
+type Centroid struct {
+	Mean   float64 `json:"mean"`
+	Weight int64   `json:"weight"`
+}
+
+// SummaryValue is a streaming quantile sketch (merging t-digest) accompanied with exact count, sum, min and max.
+// Observations are appended as single-weight centroids and periodically compressed into a bounded number of
+// centroids, so the sketch can be accumulated on the agent for any number of polls and merged on the server.
+// P50, P90 and P99 are refreshed on each compression - they are kept as fields to be available to clients
+// without the need to process centroids.
+type SummaryValue struct {
+	Count     int64      `json:"count"`
+	Sum       float64    `json:"sum"`
+	Min       float64    `json:"min"`
+	Max       float64    `json:"max"`
+	P50       float64    `json:"p50"`
+	P90       float64    `json:"p90"`
+	P99       float64    `json:"p99"`
+	Centroids []Centroid `json:"centroids"`
+}
+
+func NewSummaryValue() *SummaryValue {
+	return &SummaryValue{
+		Centroids: make([]Centroid, 0),
+	}
+}
+
+// Add puts a single observed value into the sketch
+func (s *SummaryValue) Add(v float64) {
+	if s.Count == 0 || v < s.Min {
+		s.Min = v
+	}
+	if s.Count == 0 || v > s.Max {
+		s.Max = v
+	}
+	s.Count++
+	s.Sum += v
+	s.Centroids = append(s.Centroids, Centroid{Mean: v, Weight: 1})
+
+	if len(s.Centroids) > 5*summaryCompression {
+		s.Compress()
+	}
+}
+
+// Merge adds all observations from other sketch to this one. ErrValueOverflow is returned
+// if count or sum of the result overflow, the sketch is left unchanged in this case
+func (s *SummaryValue) Merge(other *SummaryValue) error {
+	if other.Count == 0 {
+		return nil
+	}
+	count, ok := addInt64(s.Count, other.Count)
+	if !ok {
+		return fmt.Errorf("%w: summary count", ErrValueOverflow)
+	}
+	sum := s.Sum + other.Sum
+	if !isFinite(sum) {
+		return fmt.Errorf("%w: summary sum", ErrValueOverflow)
+	}
+
+	if s.Count == 0 || other.Min < s.Min {
+		s.Min = other.Min
+	}
+	if s.Count == 0 || other.Max > s.Max {
+		s.Max = other.Max
+	}
+	s.Count = count
+	s.Sum = sum
+	s.Centroids = append(s.Centroids, other.Centroids...)
+	s.Compress()
+	return nil
+}
+
+// Compress merges neighbour centroids while their size stays within a bound that depends on quantile position -
+// centroids are kept small close to the tails and large in the middle of the distribution.
+// It also refreshes precalculated quantiles
+func (s *SummaryValue) Compress() {
+	if len(s.Centroids) > 1 {
+		slices.SortFunc(s.Centroids, func(a, b Centroid) int {
+			switch {
+			case a.Mean < b.Mean:
+				return -1
+			case a.Mean > b.Mean:
+				return 1
+			}
+			return 0
+		})
+
+		total := float64(s.Count)
+		compressed := s.Centroids[:1]
+		var processed float64
+		for _, c := range s.Centroids[1:] {
+			cur := &compressed[len(compressed)-1]
+			proposed := float64(cur.Weight + c.Weight)
+			q := (processed + proposed/2) / total
+			limit := math.Max(1, 4*total*q*(1-q)/summaryCompression)
+			if proposed <= limit {
+				cur.Mean += (c.Mean - cur.Mean) * float64(c.Weight) / proposed
+				cur.Weight += c.Weight
+				continue
+			}
+			processed += float64(cur.Weight)
+			compressed = append(compressed, c)
+		}
+		s.Centroids = compressed
+	}
+
+	s.P50 = s.Quantile(0.5)
+	s.P90 = s.Quantile(0.9)
+	s.P99 = s.Quantile(0.99)
+}
+
+// Quantile estimates value at the given quantile q (0 <= q <= 1) by interpolating between centroid centers,
+// centroids are expected to be sorted (which is guaranteed after Compress call)
+func (s *SummaryValue) Quantile(q float64) float64 {
+	if s.Count == 0 || len(s.Centroids) == 0 {
+		return 0
+	}
+	if q <= 0 {
+		return s.Min
+	}
+	if q >= 1 {
+		return s.Max
+	}
+
+	target := q * float64(s.Count)
+	var cumulative float64
+	prevCenter, prevMean := 0.0, s.Min
+	for _, c := range s.Centroids {
+		center := cumulative + float64(c.Weight)/2
+		if target < center {
+			return interpolate(prevMean, c.Mean, prevCenter, center, target)
+		}
+		cumulative += float64(c.Weight)
+		prevCenter, prevMean = center, c.Mean
+	}
+	return interpolate(prevMean, s.Max, prevCenter, float64(s.Count), target)
+}
+
+func interpolate(from, to, fromPos, toPos, pos float64) float64 {
+	if toPos <= fromPos {
+		return to
+	}
+	return from + (to-from)*(pos-fromPos)/(toPos-fromPos)
+}
+
+// Validate checks sketch consistency - it is needed for values received from external clients
+func (s *SummaryValue) Validate() error {
+	if !isFinite(s.Sum) || !isFinite(s.Min) || !isFinite(s.Max) {
+		return fmt.Errorf("%w: sum, min and max must be finite", ErrInvalidSummary)
+	}
+	var total int64
+	for _, c := range s.Centroids {
+		if c.Weight <= 0 {
+			return fmt.Errorf("%w: centroid weight must be positive", ErrInvalidSummary)
+		}
+		if !isFinite(c.Mean) {
+			return fmt.Errorf("%w: centroid mean must be finite", ErrInvalidSummary)
+		}
+		var ok bool
+		if total, ok = addInt64(total, c.Weight); !ok {
+			return fmt.Errorf("%w: centroid weights sum overflows", ErrInvalidSummary)
+		}
+	}
+	if total != s.Count {
+		return fmt.Errorf("%w: total count %d doesn't match centroid weights sum %d", ErrInvalidSummary, s.Count, total)
+	}
+	if s.Count > 0 && s.Min > s.Max {
+		return fmt.Errorf("%w: min is greater than max", ErrInvalidSummary)
+	}
+	return nil
+}
+
+func (s *SummaryValue) Clone() *SummaryValue {
+	c := *s
+	c.Centroids = slices.Clone(s.Centroids)
+	return &c
+}
+
+// String renders summary in a compact human-readable form, e.g. "count=10 min=1 max=9 p50=5 p90=8.5 p99=9"
+func (s *SummaryValue) String() string {
+	sb := strings.Builder{}
+	sb.WriteString("count=")
+	sb.WriteString(strconv.FormatInt(s.Count, 10))
+	for _, f := range []struct {
+		name  string
+		value float64
+	}{
+		{"min", s.Min},
+		{"max", s.Max},
+		{"p50", s.P50},
+		{"p90", s.P90},
+		{"p99", s.P99},
+	} {
+		sb.WriteByte(' ')
+		sb.WriteString(f.name)
+		sb.WriteByte('=')
+		sb.WriteString(strconv.FormatFloat(f.value, 'f', -1, 64))
+	}
+	return sb.String()
+}
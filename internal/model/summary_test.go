@@ -0,0 +1,117 @@
+package model
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSummaryAdd(t *testing.T) {
+	s := NewSummaryValue()
+	for i := 1; i <= 1000; i++ {
+		s.Add(float64(i))
+	}
+	s.Compress()
+
+	assert.Equal(t, int64(1000), s.Count)
+	assert.InDelta(t, 500500, s.Sum, 0.0001)
+	assert.Equal(t, 1.0, s.Min)
+	assert.Equal(t, 1000.0, s.Max)
+	assert.InDelta(t, 500, s.P50, 10)
+	assert.InDelta(t, 900, s.P90, 10)
+	assert.InDelta(t, 990, s.P99, 5)
+	assert.Less(t, len(s.Centroids), 5*summaryCompression)
+	assert.NoError(t, s.Validate())
+}
+
+func TestSummaryMerge(t *testing.T) {
+	s1 := NewSummaryValue()
+	s2 := NewSummaryValue()
+	for i := 1; i <= 500; i++ {
+		s1.Add(float64(i))
+		s2.Add(float64(i + 500))
+	}
+
+	require.NoError(t, s1.Merge(s2))
+	assert.Equal(t, int64(1000), s1.Count)
+	assert.Equal(t, 1.0, s1.Min)
+	assert.Equal(t, 1000.0, s1.Max)
+	assert.InDelta(t, 500, s1.P50, 10)
+	assert.InDelta(t, 990, s1.P99, 5)
+	assert.NoError(t, s1.Validate())
+
+	require.NoError(t, s1.Merge(NewSummaryValue()))
+	assert.Equal(t, int64(1000), s1.Count)
+
+	// overflowing sum is rejected and the sketch is left unchanged
+	huge := &SummaryValue{Count: 1, Sum: 1.7e308, Min: 1.7e308, Max: 1.7e308,
+		Centroids: []Centroid{{Mean: 1.7e308, Weight: 1}}}
+	require.NoError(t, s1.Merge(huge))
+	assert.ErrorIs(t, s1.Merge(huge), ErrValueOverflow)
+	assert.Equal(t, int64(1001), s1.Count)
+	assert.Equal(t, 1.7e308, s1.Max)
+	assert.False(t, math.IsInf(s1.Sum, 0))
+}
+
+func TestSummarySpike(t *testing.T) {
+	s := NewSummaryValue()
+	for i := 0; i < 99; i++ {
+		s.Add(10)
+	}
+	s.Add(1000)
+	s.Compress()
+
+	assert.InDelta(t, 10, s.P50, 0.0001)
+	assert.Equal(t, 1000.0, s.Max)
+	assert.Greater(t, s.P99, 10.0)
+	assert.True(t, strings.HasPrefix(s.String(), "count=100 min=10 max=1000 p50=10 p90=10 p99="))
+}
+
+func TestSummaryValidate(t *testing.T) {
+	s := &SummaryValue{Count: 2, Centroids: []Centroid{{Mean: 1, Weight: 1}}}
+	assert.ErrorIs(t, s.Validate(), ErrInvalidSummary)
+
+	s = &SummaryValue{Count: 1, Centroids: []Centroid{{Mean: 1, Weight: 0}}}
+	assert.ErrorIs(t, s.Validate(), ErrInvalidSummary)
+
+	s = &SummaryValue{Count: 1, Min: 2, Max: 1, Centroids: []Centroid{{Mean: 1, Weight: 1}}}
+	assert.ErrorIs(t, s.Validate(), ErrInvalidSummary)
+
+	s = &SummaryValue{Count: 1, Sum: math.NaN(), Min: 1, Max: 1, Centroids: []Centroid{{Mean: 1, Weight: 1}}}
+	assert.ErrorIs(t, s.Validate(), ErrInvalidSummary)
+
+	s = &SummaryValue{Count: 1, Sum: 1, Min: math.Inf(-1), Max: 1, Centroids: []Centroid{{Mean: 1, Weight: 1}}}
+	assert.ErrorIs(t, s.Validate(), ErrInvalidSummary)
+
+	s = &SummaryValue{Count: 1, Sum: 1, Min: 1, Max: math.Inf(1), Centroids: []Centroid{{Mean: 1, Weight: 1}}}
+	assert.ErrorIs(t, s.Validate(), ErrInvalidSummary)
+
+	s = &SummaryValue{Count: 2, Min: 1, Max: 3, Centroids: []Centroid{{Mean: 1, Weight: 1}, {Mean: 3, Weight: 1}}}
+	assert.NoError(t, s.Validate())
+}
+
+func TestSummaryMetrics(t *testing.T) {
+	m := NewSummaryMetricsWithValue("latency", nil)
+	assert.Equal(t, Summary, m.MType)
+	assert.Nil(t, m.Summary)
+	initialHash := m.Hash
+
+	s := NewSummaryValue()
+	s.Add(1)
+	require.NoError(t, m.AddSummary(s))
+	require.NotNil(t, m.Summary)
+	assert.NotEqual(t, initialHash, m.Hash)
+
+	s.Add(3)
+	assert.Equal(t, int64(1), m.Summary.Count)
+
+	require.NoError(t, m.AddSummary(s))
+	assert.Equal(t, int64(3), m.Summary.Count)
+
+	c := m.Clone()
+	c.Summary.Add(5)
+	assert.Equal(t, int64(3), m.Summary.Count)
+}
@@ -0,0 +1,199 @@
+// Package promtext renders metrics in Prometheus text exposition format (version 0.0.4),
+// so metrics-overseer can be scraped by Prometheus without a custom exporter
+package promtext
+
+import (
+	"bufio"
+	"cmp"
+	"io"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/andrewsvn/metrics-overseer/internal/model"
+)
+
+// ContentType should be set to responses containing metrics in text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+var summaryQuantiles = []struct {
+	label string
+	value float64
+}{
+	{"0.5", 0.5},
+	{"0.9", 0.9},
+	{"0.99", 0.99},
+}
+
+const (
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeHistogram = "histogram"
+	typeSummary   = "summary"
+)
+
+// Encoder writes metric families: TYPE comment line followed by samples of all series of the metric.
+// Metrics are grouped by sanitized name, so provided metrics don't need to be sorted.
+// If series with the same name have different types, only series of the first met type are written,
+// since mixing types in one family makes the whole exposition invalid
+type Encoder struct {
+	w *bufio.Writer
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{
+		w: bufio.NewWriter(w),
+	}
+}
+
+func (e *Encoder) Encode(metrics []*model.Metrics) error {
+	type series struct {
+		name   string
+		metric *model.Metrics
+	}
+
+	list := make([]series, 0, len(metrics))
+	for _, m := range metrics {
+		if promType(m.MType) == "" {
+			continue
+		}
+		list = append(list, series{name: SanitizeName(m.ID), metric: m})
+	}
+	slices.SortStableFunc(list, func(a, b series) int {
+		return cmp.Compare(a.name, b.name)
+	})
+
+	var family, familyType string
+	for _, s := range list {
+		if s.name != family {
+			family, familyType = s.name, promType(s.metric.MType)
+			e.writeType(family, familyType)
+		} else if promType(s.metric.MType) != familyType {
+			continue
+		}
+		e.writeSeries(s.name, s.metric)
+	}
+	return e.w.Flush()
+}
+
+func (e *Encoder) writeType(name, mtype string) {
+	_, _ = e.w.WriteString("# TYPE ")
+	_, _ = e.w.WriteString(name)
+	_ = e.w.WriteByte(' ')
+	_, _ = e.w.WriteString(mtype)
+	_ = e.w.WriteByte('\n')
+}
+
+func (e *Encoder) writeSeries(name string, m *model.Metrics) {
+	switch m.MType {
+	case model.Counter:
+		if m.Delta != nil {
+			e.writeSample(name, m.Labels, "", "", strconv.FormatInt(*m.Delta, 10))
+		}
+	case model.Gauge:
+		if m.Value != nil {
+			e.writeSample(name, m.Labels, "", "", formatFloat(*m.Value))
+		}
+	case model.Histogram:
+		if m.Histogram == nil {
+			return
+		}
+		labels := withoutLabel(m.Labels, "le")
+		cumulative := m.Histogram.CumulativeCounts()
+		for i, c := range cumulative {
+			le := "+Inf"
+			if i < len(m.Histogram.Bounds) {
+				le = formatFloat(m.Histogram.Bounds[i])
+			}
+			e.writeSample(name+"_bucket", labels, "le", le, strconv.FormatInt(c, 10))
+		}
+		e.writeSample(name+"_sum", labels, "", "", formatFloat(m.Histogram.Sum))
+		e.writeSample(name+"_count", labels, "", "", strconv.FormatInt(m.Histogram.Count, 10))
+	case model.Summary:
+		if m.Summary == nil {
+			return
+		}
+		labels := withoutLabel(m.Labels, "quantile")
+		for _, q := range summaryQuantiles {
+			e.writeSample(name, labels, "quantile", q.label, formatFloat(m.Summary.Quantile(q.value)))
+		}
+		e.writeSample(name+"_sum", labels, "", "", formatFloat(m.Summary.Sum))
+		e.writeSample(name+"_count", labels, "", "", strconv.FormatInt(m.Summary.Count, 10))
+	}
+}
+
+// writeSample writes a single sample line, extra label (le or quantile) is appended to series labels if provided
+func (e *Encoder) writeSample(name string, labels model.Labels, extraName, extraValue, value string) {
+	_, _ = e.w.WriteString(name)
+
+	keys := slices.Sorted(maps.Keys(labels))
+
+	if len(keys) > 0 || extraName != "" {
+		_ = e.w.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				_ = e.w.WriteByte(',')
+			}
+			e.writeLabel(SanitizeLabelName(k), labels[k])
+		}
+		if extraName != "" {
+			if len(keys) > 0 {
+				_ = e.w.WriteByte(',')
+			}
+			e.writeLabel(extraName, extraValue)
+		}
+		_ = e.w.WriteByte('}')
+	}
+
+	_ = e.w.WriteByte(' ')
+	_, _ = e.w.WriteString(value)
+	_ = e.w.WriteByte('\n')
+}
+
+func (e *Encoder) writeLabel(name, value string) {
+	_, _ = e.w.WriteString(name)
+	_, _ = e.w.WriteString(`="`)
+	_, _ = e.w.WriteString(labelValueEscaper.Replace(value))
+	_ = e.w.WriteByte('"')
+}
+
+// withoutLabel drops a series label conflicting with a label reserved for the metric type (le for histogram
+// buckets, quantile for summaries), so all samples of the series are rendered with the same label set
+func withoutLabel(labels model.Labels, name string) model.Labels {
+	if _, ok := labels[name]; !ok {
+		return labels
+	}
+	res := maps.Clone(labels)
+	delete(res, name)
+	return res
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func promType(mtype string) string {
+	switch mtype {
+	case model.Counter:
+		return typeCounter
+	case model.Gauge:
+		return typeGauge
+	case model.Histogram:
+		return typeHistogram
+	case model.Summary:
+		return typeSummary
+	}
+	return ""
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
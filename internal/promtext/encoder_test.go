@@ -0,0 +1,73 @@
+package promtext
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/andrewsvn/metrics-overseer/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSanitizeName(t *testing.T) {
+	assert.Equal(t, "http_requests_total", SanitizeName("http.requests-total"))
+	assert.Equal(t, "job:rate5m", SanitizeName("job:rate5m"))
+	assert.Equal(t, "_5xx_errors", SanitizeName("5xx errors"))
+	assert.Equal(t, "_", SanitizeName(""))
+	assert.Equal(t, "job_rate", SanitizeLabelName("job:rate"))
+}
+
+func TestEncode(t *testing.T) {
+	hv := model.NewHistogramValue(1, 5)
+	hv.Observe(0.5)
+	hv.Observe(3)
+	hv.Observe(10)
+
+	sv := model.NewSummaryValue()
+	sv.Add(1)
+	sv.Add(2)
+	sv.Add(3)
+
+	metrics := []*model.Metrics{
+		model.NewGaugeMetricsWithValue("cpu.usage", 0.7).WithLabels(model.Labels{"host": "b"}),
+		model.NewGaugeMetricsWithValue("cpu.usage", 0.5).WithLabels(model.Labels{"host": `a"1`}),
+		model.NewCounterMetricsWithDelta("PollCount", 42),
+		model.NewHistogramMetricsWithValue("latency", hv).WithLabels(model.Labels{"le": "dropped"}),
+		model.NewSummaryMetricsWithValue("size", sv),
+		model.NewGaugeMetricsWithValue("inf", math.Inf(1)),
+		// same sanitized name as the gauge family above but different type - skipped
+		model.NewCounterMetricsWithDelta("cpu-usage", 1),
+	}
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, NewEncoder(buf).Encode(metrics))
+
+	expected := `# TYPE PollCount counter
+PollCount 42
+# TYPE cpu_usage gauge
+cpu_usage{host="b"} 0.7
+cpu_usage{host="a\"1"} 0.5
+# TYPE inf gauge
+inf +Inf
+# TYPE latency histogram
+latency_bucket{le="1"} 1
+latency_bucket{le="5"} 2
+latency_bucket{le="+Inf"} 3
+latency_sum 13.5
+latency_count 3
+# TYPE size summary
+size{quantile="0.5"} 2
+size{quantile="0.9"} 3
+size{quantile="0.99"} 3
+size_sum 6
+size_count 3
+`
+	assert.Equal(t, expected, buf.String())
+}
+
+func TestEncodeEmpty(t *testing.T) {
+	buf := new(bytes.Buffer)
+	require.NoError(t, NewEncoder(buf).Encode(nil))
+	assert.Empty(t, buf.String())
+}
@@ -0,0 +1,38 @@
+package promtext
+
+import "strings"
+
+// SanitizeName converts metric ID to a valid Prometheus metric name matching [a-zA-Z_:][a-zA-Z0-9_:]*
+// by replacing all unsupported characters with underscores, e.g. "http.requests-total" -> "http_requests_total".
+// Names starting with a digit are prefixed with an underscore
+func SanitizeName(name string) string {
+	return sanitize(name, true)
+}
+
+// SanitizeLabelName converts label name to a valid Prometheus label name matching [a-zA-Z_][a-zA-Z0-9_]*
+func SanitizeLabelName(name string) string {
+	return sanitize(name, false)
+}
+
+func sanitize(name string, allowColon bool) string {
+	if name == "" {
+		return "_"
+	}
+
+	sb := strings.Builder{}
+	sb.Grow(len(name) + 1)
+	for i, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_', r == ':' && allowColon:
+			sb.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				sb.WriteByte('_')
+			}
+			sb.WriteRune(r)
+		default:
+			sb.WriteByte('_')
+		}
+	}
+	return sb.String()
+}
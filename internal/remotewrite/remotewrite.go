@@ -0,0 +1,261 @@
+// Package remotewrite decodes Prometheus remote-write requests (protocol version 1) and converts them to metrics.
+// Only the fields required for ingestion are decoded, so the package doesn't depend on generated protobuf code:
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; }
+//	message TimeSeries   { repeated Label labels = 1; repeated Sample samples = 2; }
+//	message Label        { string name = 1; string value = 2; }
+//	message Sample       { double value = 1; int64 timestamp = 2; }
+//
+// All other fields (metadata, exemplars, histograms) are skipped.
+package remotewrite
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/andrewsvn/metrics-overseer/internal/model"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// ContentType is a content type of remote-write request body (before snappy compression)
+const ContentType = "application/x-protobuf"
+
+// MetricNameLabel holds metric name in prometheus series labels
+const MetricNameLabel = "__name__"
+
+var (
+	ErrMalformedRequest = errors.New("malformed remote-write request")
+	ErrMissingName      = errors.New("series without metric name")
+)
+
+const (
+	fieldWriteRequestTimeseries = 1
+
+	fieldTimeSeriesLabels  = 1
+	fieldTimeSeriesSamples = 2
+
+	fieldLabelName  = 1
+	fieldLabelValue = 2
+
+	fieldSampleValue     = 1
+	fieldSampleTimestamp = 2
+)
+
+type WriteRequest struct {
+	Timeseries []TimeSeries
+}
+
+type TimeSeries struct {
+	Labels  []Label
+	Samples []Sample
+}
+
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Sample is a single series value, timestamp is in milliseconds since epoch
+type Sample struct {
+	Value     float64
+	Timestamp int64
+}
+
+// Unmarshal decodes uncompressed protobuf WriteRequest
+func Unmarshal(data []byte) (*WriteRequest, error) {
+	wr := &WriteRequest{}
+	err := walkFields(data, func(num protowire.Number, typ protowire.Type, value []byte, _ uint64) error {
+		if num != fieldWriteRequestTimeseries || typ != protowire.BytesType {
+			return nil
+		}
+		ts, err := unmarshalTimeSeries(value)
+		if err != nil {
+			return err
+		}
+		wr.Timeseries = append(wr.Timeseries, *ts)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return wr, nil
+}
+
+// Marshal encodes WriteRequest to protobuf, it's used by clients pushing metrics in remote-write format
+func Marshal(wr *WriteRequest) []byte {
+	var data []byte
+	for _, ts := range wr.Timeseries {
+		var tsData []byte
+		for _, l := range ts.Labels {
+			var lData []byte
+			lData = protowire.AppendTag(lData, fieldLabelName, protowire.BytesType)
+			lData = protowire.AppendString(lData, l.Name)
+			lData = protowire.AppendTag(lData, fieldLabelValue, protowire.BytesType)
+			lData = protowire.AppendString(lData, l.Value)
+
+			tsData = protowire.AppendTag(tsData, fieldTimeSeriesLabels, protowire.BytesType)
+			tsData = protowire.AppendBytes(tsData, lData)
+		}
+		for _, s := range ts.Samples {
+			var sData []byte
+			sData = protowire.AppendTag(sData, fieldSampleValue, protowire.Fixed64Type)
+			sData = protowire.AppendFixed64(sData, math.Float64bits(s.Value))
+			sData = protowire.AppendTag(sData, fieldSampleTimestamp, protowire.VarintType)
+			sData = protowire.AppendVarint(sData, uint64(s.Timestamp))
+
+			tsData = protowire.AppendTag(tsData, fieldTimeSeriesSamples, protowire.BytesType)
+			tsData = protowire.AppendBytes(tsData, sData)
+		}
+		data = protowire.AppendTag(data, fieldWriteRequestTimeseries, protowire.BytesType)
+		data = protowire.AppendBytes(data, tsData)
+	}
+	return data
+}
+
+// ToMetrics converts every series having at least one sample to a gauge metric with the value of the latest sample.
+// Staleness markers and other non-finite samples (NaN, ±Inf) are ignored, since they can't be stored as gauges.
+// Metric ID is taken from __name__ label, the rest of labels become metric labels.
+//
+// Counters are converted to gauges as well, because prometheus counters carry cumulative totals
+// while metrics-overseer counters accumulate deltas - adding totals on each write would inflate the value.
+func (wr *WriteRequest) ToMetrics() ([]*model.Metrics, error) {
+	metrics := make([]*model.Metrics, 0, len(wr.Timeseries))
+	for _, ts := range wr.Timeseries {
+		var latest *Sample
+		for i := range ts.Samples {
+			s := &ts.Samples[i]
+			if math.IsNaN(s.Value) || math.IsInf(s.Value, 0) {
+				continue
+			}
+			if latest == nil || s.Timestamp >= latest.Timestamp {
+				latest = s
+			}
+		}
+		if latest == nil {
+			continue
+		}
+
+		var id string
+		labels := make(model.Labels, len(ts.Labels))
+		for _, l := range ts.Labels {
+			if l.Name == MetricNameLabel {
+				id = l.Value
+				continue
+			}
+			labels[l.Name] = l.Value
+		}
+		if id == "" {
+			return nil, ErrMissingName
+		}
+
+		metrics = append(metrics, model.NewGaugeMetricsWithValue(id, latest.Value).WithLabels(labels))
+	}
+	return metrics, nil
+}
+
+func unmarshalTimeSeries(data []byte) (*TimeSeries, error) {
+	ts := &TimeSeries{}
+	err := walkFields(data, func(num protowire.Number, typ protowire.Type, value []byte, _ uint64) error {
+		if typ != protowire.BytesType {
+			return nil
+		}
+		switch num {
+		case fieldTimeSeriesLabels:
+			l, err := unmarshalLabel(value)
+			if err != nil {
+				return err
+			}
+			ts.Labels = append(ts.Labels, *l)
+		case fieldTimeSeriesSamples:
+			s, err := unmarshalSample(value)
+			if err != nil {
+				return err
+			}
+			ts.Samples = append(ts.Samples, *s)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ts, nil
+}
+
+func unmarshalLabel(data []byte) (*Label, error) {
+	l := &Label{}
+	err := walkFields(data, func(num protowire.Number, typ protowire.Type, value []byte, _ uint64) error {
+		if typ != protowire.BytesType {
+			return nil
+		}
+		switch num {
+		case fieldLabelName:
+			l.Name = string(value)
+		case fieldLabelValue:
+			l.Value = string(value)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func unmarshalSample(data []byte) (*Sample, error) {
+	s := &Sample{}
+	err := walkFields(data, func(num protowire.Number, typ protowire.Type, _ []byte, scalar uint64) error {
+		switch {
+		case num == fieldSampleValue && typ == protowire.Fixed64Type:
+			s.Value = math.Float64frombits(scalar)
+		case num == fieldSampleTimestamp && typ == protowire.VarintType:
+			s.Timestamp = int64(scalar)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// walkFields iterates over top-level fields of a protobuf message calling fn for each of them.
+// For length-delimited fields value contains field bytes, for scalar fields (varint, fixed32, fixed64) - scalar value.
+// Deprecated group fields are skipped
+func walkFields(data []byte, fn func(num protowire.Number, typ protowire.Type, value []byte, scalar uint64) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return fmt.Errorf("%w: %v", ErrMalformedRequest, protowire.ParseError(n))
+		}
+		data = data[n:]
+
+		var (
+			value  []byte
+			scalar uint64
+		)
+		switch typ {
+		case protowire.VarintType:
+			scalar, n = protowire.ConsumeVarint(data)
+		case protowire.Fixed64Type:
+			scalar, n = protowire.ConsumeFixed64(data)
+		case protowire.Fixed32Type:
+			var v uint32
+			v, n = protowire.ConsumeFixed32(data)
+			scalar = uint64(v)
+		case protowire.BytesType:
+			value, n = protowire.ConsumeBytes(data)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return fmt.Errorf("%w: %v", ErrMalformedRequest, protowire.ParseError(n))
+		}
+		data = data[n:]
+
+		if err := fn(num, typ, value, scalar); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package remotewrite
+
+import (
+	"math"
+	"testing"
+
+	"github.com/andrewsvn/metrics-overseer/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// staleNaN is a special NaN value prometheus sends as a staleness marker when a series disappears
+const staleNaN uint64 = 0x7ff0000000000002
+
+func TestUnmarshal(t *testing.T) {
+	data := Marshal(&WriteRequest{
+		Timeseries: []TimeSeries{
+			{
+				Labels:  []Label{{"__name__", "up"}, {"job", "node"}},
+				Samples: []Sample{{Value: 1, Timestamp: 1000}},
+			},
+			{
+				Labels:  []Label{{"__name__", "temp"}},
+				Samples: []Sample{{Value: -2.5, Timestamp: 2000}, {Value: 3.5, Timestamp: 3000}},
+			},
+		},
+	})
+	// unknown field (metadata) must be skipped
+	data = protowire.AppendTag(data, 3, protowire.BytesType)
+	data = protowire.AppendBytes(data, []byte{0x08, 0x01})
+
+	wr, err := Unmarshal(data)
+	require.NoError(t, err)
+	require.Len(t, wr.Timeseries, 2)
+	assert.Equal(t, []Label{{"__name__", "up"}, {"job", "node"}}, wr.Timeseries[0].Labels)
+	assert.Equal(t, []Sample{{Value: 1, Timestamp: 1000}}, wr.Timeseries[0].Samples)
+	assert.Equal(t, []Sample{{Value: -2.5, Timestamp: 2000}, {Value: 3.5, Timestamp: 3000}}, wr.Timeseries[1].Samples)
+
+	_, err = Unmarshal([]byte{0x0a, 0x05, 0x01})
+	assert.ErrorIs(t, err, ErrMalformedRequest)
+}
+
+func TestToMetrics(t *testing.T) {
+	wr := &WriteRequest{
+		Timeseries: []TimeSeries{
+			{
+				Labels:  []Label{{"__name__", "temp"}, {"room", "a"}},
+				Samples: []Sample{{Value: 3.5, Timestamp: 3000}, {Value: -2.5, Timestamp: 2000}},
+			},
+			{
+				Labels: []Label{{"__name__", "empty"}},
+			},
+			{
+				Labels:  []Label{{"__name__", "stale"}},
+				Samples: []Sample{{Value: math.Float64frombits(staleNaN), Timestamp: 1}},
+			},
+			{
+				Labels:  []Label{{"__name__", "nan"}},
+				Samples: []Sample{{Value: math.NaN(), Timestamp: 1}},
+			},
+			{
+				Labels:  []Label{{"__name__", "inf"}},
+				Samples: []Sample{{Value: math.Inf(1), Timestamp: 1}},
+			},
+			{
+				Labels:  []Label{{"__name__", "up"}},
+				Samples: []Sample{{Value: math.Inf(-1), Timestamp: 2}, {Value: 1, Timestamp: 1}},
+			},
+		},
+	}
+
+	metrics, err := wr.ToMetrics()
+	require.NoError(t, err)
+	require.Len(t, metrics, 2)
+	assert.Equal(t, "temp", metrics[0].ID)
+	assert.Equal(t, model.Gauge, metrics[0].MType)
+	assert.Equal(t, model.Labels{"room": "a"}, metrics[0].Labels)
+	assert.Equal(t, 3.5, *metrics[0].Value)
+	assert.Equal(t, "up", metrics[1].ID)
+	assert.Equal(t, 1.0, *metrics[1].Value)
+	assert.Nil(t, metrics[1].Labels)
+
+	wr.Timeseries = append(wr.Timeseries, TimeSeries{
+		Labels:  []Label{{"job", "node"}},
+		Samples: []Sample{{Value: 1}},
+	})
+	_, err = wr.ToMetrics()
+	assert.ErrorIs(t, err, ErrMissingName)
+}
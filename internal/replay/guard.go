@@ -0,0 +1,108 @@
+// Package replay provides protection from replaying signed requests based on request timestamp and nonce
+package replay
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultMaxAge   = 5 * time.Minute
+	DefaultCapacity = 100000
+)
+
+var (
+	ErrStale    = errors.New("request timestamp is out of allowed window")
+	ErrReplayed = errors.New("request nonce has already been used")
+)
+
+type entry struct {
+	nonce string
+	ts    time.Time
+}
+
+// Guard remembers nonces of accepted requests and rejects requests which are too old (or too far in the future)
+// or which nonce has already been seen. Memory is bounded by capacity: when the cache is full, the oldest nonce
+// is evicted and requests with timestamp not newer than the evicted one are rejected from then on,
+// so an evicted nonce can't be replayed either
+type Guard struct {
+	maxAge time.Duration
+
+	mu    sync.Mutex
+	seen  map[string]struct{}
+	queue []entry
+	head  int
+	size  int
+	// minTS is the newest timestamp of nonces evicted before their expiration
+	minTS time.Time
+
+	now func() time.Time
+}
+
+// NewGuard creates guard accepting timestamps within maxAge from current time and remembering up to capacity
+// nonces, defaults are used for non-positive values
+func NewGuard(maxAge time.Duration, capacity int) *Guard {
+	if maxAge <= 0 {
+		maxAge = DefaultMaxAge
+	}
+	if capacity <= 0 {
+		capacity = DefaultCapacity
+	}
+	return &Guard{
+		maxAge: maxAge,
+		seen:   make(map[string]struct{}, capacity),
+		queue:  make([]entry, capacity),
+		now:    time.Now,
+	}
+}
+
+// Check accepts request with the given timestamp and nonce once, subsequent checks of the same nonce fail
+func (g *Guard) Check(ts time.Time, nonce string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+	if ts.Before(now.Add(-g.maxAge)) || ts.After(now.Add(g.maxAge)) {
+		return ErrStale
+	}
+	if _, ok := g.seen[nonce]; ok {
+		return ErrReplayed
+	}
+	if !ts.After(g.minTS) {
+		return ErrReplayed
+	}
+
+	g.expire(now)
+	if g.size == len(g.queue) {
+		evicted := g.pop()
+		if evicted.ts.After(g.minTS) {
+			g.minTS = evicted.ts
+		}
+	}
+	g.push(entry{nonce: nonce, ts: ts})
+	return nil
+}
+
+// expire drops the oldest nonces which timestamps are out of allowed window,
+// requests carrying them are rejected as stale anyway
+func (g *Guard) expire(now time.Time) {
+	for g.size > 0 && g.queue[g.head].ts.Before(now.Add(-g.maxAge)) {
+		g.pop()
+	}
+}
+
+func (g *Guard) push(e entry) {
+	g.queue[(g.head+g.size)%len(g.queue)] = e
+	g.size++
+	g.seen[e.nonce] = struct{}{}
+}
+
+func (g *Guard) pop() entry {
+	e := g.queue[g.head]
+	g.queue[g.head] = entry{}
+	g.head = (g.head + 1) % len(g.queue)
+	g.size--
+	delete(g.seen, e.nonce)
+	return e
+}
@@ -0,0 +1,35 @@
+package replay
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGuard(t *testing.T) {
+	now := time.Unix(1_000_000, 0)
+	g := NewGuard(time.Minute, 3)
+	g.now = func() time.Time { return now }
+
+	assert.NoError(t, g.Check(now, "a"))
+	assert.ErrorIs(t, g.Check(now, "a"), ErrReplayed)
+	assert.ErrorIs(t, g.Check(now.Add(-2*time.Minute), "b"), ErrStale)
+	assert.ErrorIs(t, g.Check(now.Add(2*time.Minute), "b"), ErrStale)
+
+	assert.NoError(t, g.Check(now.Add(time.Second), "b"))
+	assert.NoError(t, g.Check(now.Add(2*time.Second), "c"))
+
+	// cache is full: "a" is evicted, but it can't be replayed since its timestamp is not newer than evicted one
+	assert.NoError(t, g.Check(now.Add(3*time.Second), "d"))
+	assert.ErrorIs(t, g.Check(now, "a"), ErrReplayed)
+	assert.ErrorIs(t, g.Check(now, "e"), ErrReplayed)
+	assert.NoError(t, g.Check(now.Add(4*time.Second), "e"))
+	assert.ErrorIs(t, g.Check(now.Add(3*time.Second), "d"), ErrReplayed)
+
+	// expired nonces are dropped without raising the lower bound
+	now = now.Add(90 * time.Second)
+	assert.NoError(t, g.Check(now, "f"))
+	assert.Equal(t, 1, g.size)
+	assert.NoError(t, g.Check(now.Add(-50*time.Second), "g"))
+}
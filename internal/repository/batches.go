@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/andrewsvn/metrics-overseer/internal/model"
+)
+
+const (
+	// AppliedBatchRetention is a period during which IDs of applied batches are remembered,
+	// a batch resent after this period is applied again
+	AppliedBatchRetention = 24 * time.Hour
+	// appliedBatchesPerAgent limits a number of batch IDs remembered by in-memory storages for each agent
+	appliedBatchesPerAgent = 10000
+)
+
+type appliedBatch struct {
+	seq       uint64
+	appliedAt time.Time
+}
+
+// agentBatches keeps batch IDs of an agent in the order they were applied
+type agentBatches struct {
+	seqs  map[uint64]struct{}
+	order []appliedBatch
+}
+
+// appliedBatches remembers IDs of recently applied batches per agent.
+// It is not thread-safe and relies on the owner's synchronization
+type appliedBatches struct {
+	agents map[string]*agentBatches
+}
+
+func newAppliedBatches() *appliedBatches {
+	return &appliedBatches{
+		agents: make(map[string]*agentBatches),
+	}
+}
+
+func (ab *appliedBatches) contains(id model.BatchID) bool {
+	batches, exists := ab.agents[id.AgentID]
+	if !exists {
+		return false
+	}
+	_, exists = batches.seqs[id.Seq]
+	return exists
+}
+
+// add remembers batch ID forgetting expired IDs of the same agent, and the oldest ones
+// if there are too many of them
+func (ab *appliedBatches) add(id model.BatchID, now time.Time) {
+	batches := ab.agents[id.AgentID]
+	if batches == nil {
+		batches = &agentBatches{seqs: make(map[uint64]struct{})}
+		ab.agents[id.AgentID] = batches
+	}
+	batches.seqs[id.Seq] = struct{}{}
+	batches.order = append(batches.order, appliedBatch{seq: id.Seq, appliedAt: now})
+
+	deadline := now.Add(-AppliedBatchRetention)
+	drop := 0
+	for drop < len(batches.order) &&
+		(len(batches.order)-drop > appliedBatchesPerAgent || batches.order[drop].appliedAt.Before(deadline)) {
+		delete(batches.seqs, batches.order[drop].seq)
+		drop++
+	}
+	batches.order = batches.order[drop:]
+}
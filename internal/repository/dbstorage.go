@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/Masterminds/squirrel"
 	"github.com/andrewsvn/metrics-overseer/internal/db"
@@ -39,12 +40,20 @@ func isPgErrorRetryable(err error) bool {
 	return false
 }
 
-func NewPostgresDBStorage(conn db.Connection, logger *zap.Logger, retryPolicy retrying.Policy) *PostgresDBStorage {
+// NewPostgresDBStorage creates storage executing queries with retries on temporary errors,
+// retryObserver is optional and can be used to count query retries
+func NewPostgresDBStorage(
+	conn db.Connection,
+	logger *zap.Logger,
+	retryPolicy retrying.Policy,
+	retryObserver retrying.Observer,
+) *PostgresDBStorage {
 	pgLogger := logger.Sugar().With(zap.String("component", "postgres-storage"))
 
 	retrier := retrying.NewExecutorBuilder(retryPolicy).
 		WithLogger(pgLogger, "executing query").
 		WithRetryablePredicate(isPgErrorRetryable).
+		WithObserver(retryObserver).
 		Build()
 
 	return &PostgresDBStorage{
@@ -55,12 +64,12 @@ func NewPostgresDBStorage(conn db.Connection, logger *zap.Logger, retryPolicy re
 	}
 }
 
-func (pgs *PostgresDBStorage) SetGauge(ctx context.Context, id string, value float64) error {
+func (pgs *PostgresDBStorage) SetGauge(ctx context.Context, id string, labels model.Labels, value float64) error {
 	query, args, err := pgs.sqrl.Insert("metrics").
-		Columns("id", "mtype", "value").
-		Values(id, model.Gauge, value).
+		Columns("series_key", "id", "labels", "mtype", "value").
+		Values(model.SeriesKey(id, labels), id, dbLabels(labels), model.Gauge, value).
 		Suffix(`
-            ON CONFLICT (id) DO UPDATE
+            ON CONFLICT (series_key) DO UPDATE
             SET value = EXCLUDED.value
             WHERE metrics.mtype = '` + model.Gauge + `'`).
 		ToSql()
@@ -88,12 +97,12 @@ func (pgs *PostgresDBStorage) SetGauge(ctx context.Context, id string, value flo
 	return nil
 }
 
-func (pgs *PostgresDBStorage) AddCounter(ctx context.Context, id string, delta int64) error {
+func (pgs *PostgresDBStorage) AddCounter(ctx context.Context, id string, labels model.Labels, delta int64) error {
 	query, args, err := pgs.sqrl.Insert("metrics").
-		Columns("id", "mtype", "delta").
-		Values(id, model.Counter, delta).
+		Columns("series_key", "id", "labels", "mtype", "delta").
+		Values(model.SeriesKey(id, labels), id, dbLabels(labels), model.Counter, delta).
 		Suffix(`
-            ON CONFLICT (id) DO UPDATE
+            ON CONFLICT (series_key) DO UPDATE
             SET delta = COALESCE(metrics.delta, 0) + EXCLUDED.delta
             WHERE metrics.mtype = '` + model.Counter + `'`).
 		ToSql()
@@ -121,10 +130,162 @@ func (pgs *PostgresDBStorage) AddCounter(ctx context.Context, id string, delta i
 	return nil
 }
 
-func (pgs *PostgresDBStorage) GetByID(ctx context.Context, id string) (*model.Metrics, error) {
-	query, args, err := pgs.sqrl.Select("mtype", "delta", "value").
+// AddHistogram can't be expressed as a single upsert since histograms are merged bucket by bucket,
+// so the stored histogram is locked, merged in code and written back in one transaction
+func (pgs *PostgresDBStorage) AddHistogram(
+	ctx context.Context,
+	id string,
+	labels model.Labels,
+	hist *model.HistogramValue,
+) error {
+	return pgs.runInTx(ctx, func(tx pgx.Tx) error {
+		return pgs.mergeHistogramInTx(ctx, tx, id, labels, hist)
+	})
+}
+
+// AddSummary merges quantile sketches the same way as AddHistogram does
+func (pgs *PostgresDBStorage) AddSummary(
+	ctx context.Context,
+	id string,
+	labels model.Labels,
+	summary *model.SummaryValue,
+) error {
+	return pgs.runInTx(ctx, func(tx pgx.Tx) error {
+		return pgs.mergeSummaryInTx(ctx, tx, id, labels, summary)
+	})
+}
+
+func (pgs *PostgresDBStorage) runInTx(ctx context.Context, f func(tx pgx.Tx) error) error {
+	return pgs.retrier.Run(func() error {
+		tx, err := pgs.conn.Pool().BeginTx(ctx, pgx.TxOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to initialize DB transaction: %w", err)
+		}
+		defer pgs.rollbackTx(ctx, tx)
+
+		if err := f(tx); err != nil {
+			return err
+		}
+		return tx.Commit(ctx)
+	})
+}
+
+func (pgs *PostgresDBStorage) mergeHistogramInTx(
+	ctx context.Context,
+	tx pgx.Tx,
+	id string,
+	labels model.Labels,
+	hist *model.HistogramValue,
+) error {
+	return mergeInTx(ctx, pgs, tx, id, labels, model.Histogram, "histogram",
+		func(stored *model.HistogramValue) (*model.HistogramValue, error) {
+			if stored == nil {
+				return hist, nil
+			}
+			if err := stored.Merge(hist); err != nil {
+				return nil, fmt.Errorf("failed to merge histogram %s: %w", id, err)
+			}
+			return stored, nil
+		})
+}
+
+func (pgs *PostgresDBStorage) mergeSummaryInTx(
+	ctx context.Context,
+	tx pgx.Tx,
+	id string,
+	labels model.Labels,
+	summary *model.SummaryValue,
+) error {
+	return mergeInTx(ctx, pgs, tx, id, labels, model.Summary, "summary",
+		func(stored *model.SummaryValue) (*model.SummaryValue, error) {
+			if stored == nil {
+				stored = model.NewSummaryValue()
+			}
+			if err := stored.Merge(summary); err != nil {
+				return nil, fmt.Errorf("failed to merge summary %s: %w", id, err)
+			}
+			return stored, nil
+		})
+}
+
+// mergeInTx locks the stored metric row, reads a complex value from the given JSON column,
+// merges it in code with provided merge function and writes the result back.
+// Stored value passed to merge function is nil if there's no metric with such ID and labels yet.
+// A placeholder row is inserted before locking, so concurrent first writes of the same metric
+// are serialized on the row lock instead of overwriting each other.
+// The placeholder has no value, so it isn't recorded to history by the samples trigger
+func mergeInTx[T any](
+	ctx context.Context,
+	pgs *PostgresDBStorage,
+	tx pgx.Tx,
+	id string,
+	labels model.Labels,
+	mtype string,
+	column string,
+	merge func(stored *T) (*T, error),
+) error {
+	key := model.SeriesKey(id, labels)
+	query, args, err := pgs.sqrl.Insert("metrics").
+		Columns("series_key", "id", "labels", "mtype").
+		Values(key, id, dbLabels(labels), mtype).
+		Suffix("ON CONFLICT (series_key) DO NOTHING").
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("failed to compose insert %s placeholder query: %w", mtype, err)
+	}
+
+	pgs.logger.Debugw("insert metric placeholder query", "query", query, "args", args)
+	if _, err := tx.Exec(ctx, query, args...); err != nil {
+		return fmt.Errorf("failed to insert %s placeholder: %w", mtype, err)
+	}
+
+	query, args, err = pgs.sqrl.Select("mtype", column).
 		From("metrics").
-		Where(squirrel.Eq{"id": id}).
+		Where(squirrel.Eq{"series_key": key}).
+		Suffix("FOR UPDATE").
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("failed to compose get %s query: %w", mtype, err)
+	}
+
+	pgs.logger.Debugw("get metric for update query", "query", query, "args", args)
+	var storedType string
+	var stored *T
+	err = tx.QueryRow(ctx, query, args...).Scan(&storedType, &stored)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("failed to extract %s from DB row: %w", mtype, err)
+	}
+	if err == nil && storedType != mtype {
+		return ErrIncorrectAccess
+	}
+
+	merged, err := merge(stored)
+	if err != nil {
+		return err
+	}
+
+	query, args, err = pgs.sqrl.Insert("metrics").
+		Columns("series_key", "id", "labels", "mtype", column).
+		Values(key, id, dbLabels(labels), mtype, merged).
+		Suffix(`
+            ON CONFLICT (series_key) DO UPDATE
+            SET ` + column + ` = EXCLUDED.` + column).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("failed to compose set %s query: %w", mtype, err)
+	}
+
+	pgs.logger.Debugw("set merged metric query", "query", query, "args", args)
+	if _, err := tx.Exec(ctx, query, args...); err != nil {
+		return fmt.Errorf("failed to execute set %s query: %w", mtype, err)
+	}
+	return nil
+}
+
+func (pgs *PostgresDBStorage) GetByID(ctx context.Context, id string, labels model.Labels) (*model.Metrics, error) {
+	query, args, err := pgs.sqrl.Select("mtype", "delta", "value", "histogram", "summary").
+		From("metrics").
+		Where(squirrel.Eq{"series_key": model.SeriesKey(id, labels)}).
 		ToSql()
 	if err != nil {
 		return nil, fmt.Errorf("failed to compose get metric query: %w", err)
@@ -133,11 +294,13 @@ func (pgs *PostgresDBStorage) GetByID(ctx context.Context, id string) (*model.Me
 	var mtype string
 	var delta *int64
 	var value *float64
+	var hist *model.HistogramValue
+	var summary *model.SummaryValue
 
 	err = pgs.retrier.Run(func() error {
 		pgs.logger.Debugw("get metric by ID query", "query", query, "args", args)
 		row := pgs.conn.Pool().QueryRow(ctx, query, args...)
-		if err := row.Scan(&mtype, &delta, &value); err != nil {
+		if err := row.Scan(&mtype, &delta, &value, &hist, &summary); err != nil {
 			if errors.Is(err, pgx.ErrNoRows) {
 				return ErrMetricNotFound
 			}
@@ -149,7 +312,56 @@ func (pgs *PostgresDBStorage) GetByID(ctx context.Context, id string) (*model.Me
 	if err != nil {
 		return nil, err
 	}
-	return model.NewMetrics(id, mtype, delta, value), nil
+	return newMetricsFromRow(id, labels, mtype, delta, value, hist, summary), nil
+}
+
+// GetHistory reads samples which are appended to metric_samples table by a trigger on each metrics row change
+func (pgs *PostgresDBStorage) GetHistory(
+	ctx context.Context,
+	id string,
+	labels model.Labels,
+	from, to time.Time,
+) ([]*model.Sample, error) {
+	query, args, err := pgs.sqrl.Select("ts", "delta", "value", "histogram", "summary").
+		From("metric_samples").
+		Where(squirrel.Eq{"series_key": model.SeriesKey(id, labels)}).
+		Where(squirrel.GtOrEq{"ts": from}).
+		Where(squirrel.LtOrEq{"ts": to}).
+		OrderBy("ts ASC").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to compose get metric history query: %w", err)
+	}
+
+	var samples []*model.Sample
+
+	err = pgs.retrier.Run(func() error {
+		pgs.logger.Debugw("get metric history query", "query", query, "args", args)
+		rows, err := pgs.conn.Pool().Query(ctx, query, args...)
+		if err != nil {
+			return fmt.Errorf("failed to execute get metric history query: %w", err)
+		}
+		defer rows.Close()
+
+		samples = make([]*model.Sample, 0)
+		for rows.Next() {
+			sample := &model.Sample{}
+			err := rows.Scan(&sample.Timestamp, &sample.Delta, &sample.Value, &sample.Histogram, &sample.Summary)
+			if err != nil {
+				return fmt.Errorf("failed to extract sample from DB row: %w", err)
+			}
+			samples = append(samples, sample)
+		}
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("failed to extract samples from DB rows: %w", err)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return samples, nil
 }
 
 func (pgs *PostgresDBStorage) BatchUpdate(ctx context.Context, metrics []*model.Metrics) error {
@@ -161,43 +373,81 @@ func (pgs *PostgresDBStorage) BatchUpdate(ctx context.Context, metrics []*model.
 	}
 
 	return pgs.retrier.Run(func() error {
-		return pgs.batchSet(ctx, metrics)
+		_, err := pgs.batchSet(ctx, nil, metrics)
+		return err
+	})
+}
+
+func (pgs *PostgresDBStorage) BatchUpdateOnce(
+	ctx context.Context,
+	batchID model.BatchID,
+	metrics []*model.Metrics,
+) (bool, error) {
+	err := pgs.retrier.Run(func() error {
+		return pgs.batchValidate(ctx, metrics)
+	})
+	if err != nil {
+		return false, err
+	}
+
+	var applied bool
+	err = pgs.retrier.Run(func() error {
+		var err error
+		applied, err = pgs.batchSet(ctx, &batchID, metrics)
+		return err
 	})
+	return applied, err
 }
 
 func (pgs *PostgresDBStorage) GetAllSorted(ctx context.Context) ([]*model.Metrics, error) {
-	query, args, err := pgs.sqrl.Select("id", "mtype", "delta", "value").
+	return pgs.FindSorted(ctx, &model.Filter{})
+}
+
+func (pgs *PostgresDBStorage) FindSorted(ctx context.Context, filter *model.Filter) ([]*model.Metrics, error) {
+	builder := pgs.sqrl.Select("id", "labels", "mtype", "delta", "value", "histogram", "summary").
 		From("metrics").
-		OrderBy("id ASC").
-		ToSql()
+		OrderBy("id ASC", "series_key ASC")
+	if filter.ID != "" {
+		builder = builder.Where(squirrel.Eq{"id": filter.ID})
+	}
+	if filter.MType != "" {
+		builder = builder.Where(squirrel.Eq{"mtype": filter.MType})
+	}
+	if len(filter.Labels) > 0 {
+		builder = builder.Where(squirrel.Expr("labels @> ?", filter.Labels))
+	}
+	query, args, err := builder.ToSql()
 	if err != nil {
-		return nil, fmt.Errorf("failed to compose get all metrics query: %w", err)
+		return nil, fmt.Errorf("failed to compose get metrics query: %w", err)
 	}
 
 	var metrics []*model.Metrics
 
 	err = pgs.retrier.Run(func() error {
-		pgs.logger.Debugw("get all metrics query", "query", query, "args", args)
+		pgs.logger.Debugw("get metrics query", "query", query, "args", args)
 		rows, err := pgs.conn.Pool().Query(ctx, query, args...)
 		if err != nil {
-			return fmt.Errorf("failed to execute get all metrics query: %w", err)
+			return fmt.Errorf("failed to execute get metrics query: %w", err)
 		}
 		defer rows.Close()
 
 		metrics = make([]*model.Metrics, 0)
 		for rows.Next() {
 			var id string
+			var labels model.Labels
 			var mtype string
 			var delta *int64
 			var value *float64
-			err := rows.Scan(&id, &mtype, &delta, &value)
+			var hist *model.HistogramValue
+			var summary *model.SummaryValue
+			err := rows.Scan(&id, &labels, &mtype, &delta, &value, &hist, &summary)
 			if err != nil {
 				return fmt.Errorf("failed to extract metrics from DB row: %w", err)
 			}
-			metrics = append(metrics, model.NewMetrics(id, mtype, delta, value))
+			metrics = append(metrics, newMetricsFromRow(id, labels, mtype, delta, value, hist, summary))
 		}
 		if err := rows.Err(); err != nil {
-			return fmt.Errorf("failed to extract all metrics from DB rows: %w", err)
+			return fmt.Errorf("failed to extract metrics from DB rows: %w", err)
 		}
 		return nil
 	})
@@ -214,7 +464,7 @@ func (pgs *PostgresDBStorage) SetAll(ctx context.Context, metrics []*model.Metri
 
 func (pgs *PostgresDBStorage) ResetAll(ctx context.Context) error {
 	return pgs.retrier.Run(func() error {
-		if _, err := pgs.conn.Pool().Exec(ctx, "TRUNCATE TABLE metrics"); err != nil {
+		if _, err := pgs.conn.Pool().Exec(ctx, "TRUNCATE TABLE metrics, metric_samples"); err != nil {
 			return fmt.Errorf("failed to truncate all metrics table: %w", err)
 		}
 		return nil
@@ -227,7 +477,7 @@ func (pgs *PostgresDBStorage) Close() error {
 }
 
 func (pgs *PostgresDBStorage) batchValidate(ctx context.Context, metrics []*model.Metrics) error {
-	query, args, err := pgs.sqrl.Select("id", "mtype").From("metrics").ToSql()
+	query, args, err := pgs.sqrl.Select("series_key", "mtype").From("metrics").ToSql()
 	if err != nil {
 		return fmt.Errorf("failed to compose get metric types query: %w", err)
 	}
@@ -241,62 +491,152 @@ func (pgs *PostgresDBStorage) batchValidate(ctx context.Context, metrics []*mode
 
 	mtypes := make(map[string]string)
 	for rows.Next() {
-		var id string
+		var key string
 		var mtype string
-		if err := rows.Scan(&id, &mtype); err != nil {
+		if err := rows.Scan(&key, &mtype); err != nil {
 			return fmt.Errorf("failed to read metric type: %w", err)
 		}
-		mtypes[id] = mtype
+		mtypes[key] = mtype
 	}
 	if err := rows.Err(); err != nil {
 		return fmt.Errorf("failed to read metric types: %w", err)
 	}
 
 	for _, metric := range metrics {
-		mtype, ok := mtypes[metric.ID]
+		mtype, ok := mtypes[metric.Key()]
 		if ok && metric.MType != mtype {
 			return fmt.Errorf("%w: for metric id=%s expected=%s, actual=%s",
-				ErrIncorrectAccess, metric.ID, mtype, metric.MType)
+				ErrIncorrectAccess, metric.Key(), mtype, metric.MType)
 		}
 	}
 	return nil
 }
 
-func (pgs *PostgresDBStorage) batchSet(ctx context.Context, metrics []*model.Metrics) error {
+// batchSet updates metrics in a single transaction, if batch ID is specified it is registered
+// in the same transaction and false is returned without updating metrics for a duplicate batch
+func (pgs *PostgresDBStorage) batchSet(ctx context.Context, batchID *model.BatchID, metrics []*model.Metrics) (bool, error) {
 	tx, err := pgs.conn.Pool().BeginTx(ctx, pgx.TxOptions{})
 	if err != nil {
-		return fmt.Errorf("failed to initialize DB transaction: %w", err)
-	}
-	defer func() {
-		err := tx.Rollback(ctx)
-		if err != nil && !errors.Is(err, pgx.ErrTxClosed) {
-			pgs.logger.Warnw("failed to rollback transaction",
-				"err", err,
-			)
+		return false, fmt.Errorf("failed to initialize DB transaction: %w", err)
+	}
+	defer pgs.rollbackTx(ctx, tx)
+
+	if batchID != nil {
+		registered, err := pgs.registerBatchInTx(ctx, tx, *batchID)
+		if err != nil || !registered {
+			return false, err
 		}
-	}()
+	}
 
 	for _, m := range metrics {
+		switch m.MType {
+		case model.Histogram:
+			if m.Histogram != nil {
+				if err := pgs.mergeHistogramInTx(ctx, tx, m.ID, m.Labels, m.Histogram); err != nil {
+					return false, err
+				}
+			}
+			continue
+		case model.Summary:
+			if m.Summary != nil {
+				if err := pgs.mergeSummaryInTx(ctx, tx, m.ID, m.Labels, m.Summary); err != nil {
+					return false, err
+				}
+			}
+			continue
+		}
+
 		query, args, err := pgs.sqrl.Insert("metrics").
-			Columns("id", "mtype", "delta", "value").
-			Values(m.ID, m.MType, m.Delta, m.Value).
+			Columns("series_key", "id", "labels", "mtype", "delta", "value").
+			Values(m.Key(), m.ID, dbLabels(m.Labels), m.MType, m.Delta, m.Value).
 			Suffix(`
-				ON CONFLICT (id) DO UPDATE
+				ON CONFLICT (series_key) DO UPDATE
 				SET mtype = EXCLUDED.mtype,
 					delta = COALESCE(metrics.delta, 0) + EXCLUDED.delta,
 					value = EXCLUDED.value
         	`).
 			ToSql()
 		if err != nil {
-			return fmt.Errorf("failed to compose set metrics query: %w", err)
+			return false, fmt.Errorf("failed to compose set metrics query: %w", err)
 		}
 
 		if _, err := tx.Exec(ctx, query, args...); err != nil {
-			return fmt.Errorf("failed to set metrics: %w", err)
+			return false, fmt.Errorf("failed to set metrics: %w", err)
 		}
 	}
 
-	return tx.Commit(ctx)
+	if err := tx.Commit(ctx); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// registerBatchInTx records applied batch ID, false is returned if the batch has already been applied.
+// Expired IDs of the same agent are removed along the way
+func (pgs *PostgresDBStorage) registerBatchInTx(ctx context.Context, tx pgx.Tx, batchID model.BatchID) (bool, error) {
+	query, args, err := pgs.sqrl.Delete("applied_batches").
+		Where(squirrel.Eq{"agent_id": batchID.AgentID}).
+		Where(squirrel.Lt{"applied_at": time.Now().Add(-AppliedBatchRetention)}).
+		ToSql()
+	if err != nil {
+		return false, fmt.Errorf("failed to compose delete expired batches query: %w", err)
+	}
+	if _, err := tx.Exec(ctx, query, args...); err != nil {
+		return false, fmt.Errorf("failed to delete expired batches: %w", err)
+	}
+
+	// sequence is stored as signed bigint, conversion keeps distinct values distinct
+	query, args, err = pgs.sqrl.Insert("applied_batches").
+		Columns("agent_id", "seq").
+		Values(batchID.AgentID, int64(batchID.Seq)).
+		Suffix("ON CONFLICT (agent_id, seq) DO NOTHING").
+		ToSql()
+	if err != nil {
+		return false, fmt.Errorf("failed to compose register batch query: %w", err)
+	}
+
+	pgs.logger.Debugw("register batch query", "query", query, "args", args)
+	res, err := tx.Exec(ctx, query, args...)
+	if err != nil {
+		return false, fmt.Errorf("failed to register batch: %w", err)
+	}
+	return res.RowsAffected() > 0, nil
+}
+
+func (pgs *PostgresDBStorage) rollbackTx(ctx context.Context, tx pgx.Tx) {
+	err := tx.Rollback(ctx)
+	if err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+		pgs.logger.Warnw("failed to rollback transaction",
+			"err", err,
+		)
+	}
+}
+
+// dbLabels substitutes nil labels with an empty set since labels column can't contain null
+func dbLabels(labels model.Labels) model.Labels {
+	if labels == nil {
+		return model.Labels{}
+	}
+	return labels
+}
+
+func newMetricsFromRow(
+	id string,
+	labels model.Labels,
+	mtype string,
+	delta *int64,
+	value *float64,
+	hist *model.HistogramValue,
+	summary *model.SummaryValue,
+) *model.Metrics {
+	m := model.NewMetrics(id, mtype, delta, value).WithLabels(labels)
+	if hist != nil {
+		m.SetHistogram(hist)
+	}
+	if summary != nil {
+		m.SetSummary(summary)
+	}
+	return m
 }
 
 func (pgs *PostgresDBStorage) Ping(ctx context.Context) error {
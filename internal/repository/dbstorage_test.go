@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/andrewsvn/metrics-overseer/internal/config/servercfg"
+	"github.com/andrewsvn/metrics-overseer/internal/db"
+	"github.com/andrewsvn/metrics-overseer/internal/model"
+	"github.com/andrewsvn/metrics-overseer/internal/retrying"
+	"github.com/andrewsvn/metrics-overseer/migrations"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// setupPostgresDBStorage connects to the database from TEST_DATABASE_DSN and migrates it,
+// tests using it are skipped when no test database is configured
+func setupPostgresDBStorage(t *testing.T) *PostgresDBStorage {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+
+	cfg := &servercfg.DatabaseConfig{DBConnString: dsn}
+	require.NoError(t, migrations.MigrateDB(cfg, zap.NewNop()))
+
+	conn, err := db.NewPostgresDB(context.Background(), cfg)
+	require.NoError(t, err)
+	t.Cleanup(conn.Close)
+
+	return NewPostgresDBStorage(conn, zap.NewNop(), &retrying.NoRetryPolicy{}, nil)
+}
+
+func TestPostgresDBStorageHistory(t *testing.T) {
+	pgs := setupPostgresDBStorage(t)
+	ctx := context.Background()
+	// series are unique per run, so a wide window tolerates clock skew between test and database
+	from, to := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	suffix := fmt.Sprintf("%d", time.Now().UnixNano())
+
+	// first write of a complex metric records exactly one sample with the merged value
+	hv := model.NewHistogramValue(1, 5)
+	hv.Observe(3)
+	require.NoError(t, pgs.AddHistogram(ctx, "hist"+suffix, nil, hv))
+
+	samples, err := pgs.GetHistory(ctx, "hist"+suffix, nil, from, to)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(samples))
+	require.NotNil(t, samples[0].Histogram)
+	assert.Equal(t, int64(1), samples[0].Histogram.Count)
+
+	sv := model.NewSummaryValue()
+	sv.Add(2)
+	require.NoError(t, pgs.AddSummary(ctx, "sum"+suffix, nil, sv))
+	require.NoError(t, pgs.AddSummary(ctx, "sum"+suffix, nil, sv))
+
+	samples, err = pgs.GetHistory(ctx, "sum"+suffix, nil, from, to)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(samples))
+	for _, s := range samples {
+		require.NotNil(t, s.Summary)
+	}
+	assert.Equal(t, int64(2), samples[1].Summary.Count)
+}
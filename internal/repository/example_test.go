@@ -12,16 +12,16 @@ func ExampleMemStorage_AddCounter() {
 	ctx := context.Background()
 
 	// set new metric value and check it afterward
-	_ = ms.AddCounter(ctx, "foo", 1)
+	_ = ms.AddCounter(ctx, "foo", nil, 1)
 	fmt.Println("Added 1 to foo counter")
-	m, _ := ms.GetByID(ctx, "foo")
+	m, _ := ms.GetByID(ctx, "foo", nil)
 	// metric delta is a pointer so it should be checked for nil
 	fmt.Printf("Foo delta: %d\n", *m.Delta)
 
 	// accumulate this metric further
-	_ = ms.AddCounter(ctx, "foo", 2)
+	_ = ms.AddCounter(ctx, "foo", nil, 2)
 	fmt.Println("Added 2 to foo counter")
-	m, _ = ms.GetByID(ctx, "foo")
+	m, _ = ms.GetByID(ctx, "foo", nil)
 	fmt.Printf("Foo delta: %d\n", *m.Delta)
 
 	// Output:
@@ -36,16 +36,16 @@ func ExampleMemStorage_SetGauge() {
 	ctx := context.Background()
 
 	// set new metric value and check it afterward
-	_ = ms.SetGauge(ctx, "foo", 1.0)
+	_ = ms.SetGauge(ctx, "foo", nil, 1.0)
 	fmt.Println("Set 1 to foo gauge")
-	m, _ := ms.GetByID(ctx, "foo")
+	m, _ := ms.GetByID(ctx, "foo", nil)
 	// metric value is a pointer so it should be checked for nil
 	fmt.Printf("Foo value: %f\n", *m.Value)
 
 	// accumulate this metric further
-	_ = ms.SetGauge(ctx, "foo", 1.5)
+	_ = ms.SetGauge(ctx, "foo", nil, 1.5)
 	fmt.Println("Set 1.5 to foo gauge")
-	m, _ = ms.GetByID(ctx, "foo")
+	m, _ = ms.GetByID(ctx, "foo", nil)
 	fmt.Printf("Foo value: %f\n", *m.Value)
 
 	// Output:
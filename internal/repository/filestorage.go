@@ -28,7 +28,7 @@ type FileStorage struct {
 func NewFileStorage(cfg *servercfg.FileStorageConfig, logger *zap.Logger) *FileStorage {
 	fstLogger := logger.Sugar().With(zap.String("component", "file-storage"))
 	fst := &FileStorage{
-		MemStorage: NewMemStorage(),
+		MemStorage: NewMemStorageWithHistorySize(cfg.HistorySize),
 		filename:   cfg.StorageFilePath,
 		logger:     fstLogger,
 	}
@@ -70,8 +70,8 @@ func (fst *FileStorage) Close() error {
 	return nil
 }
 
-func (fst *FileStorage) AddCounter(ctx context.Context, id string, value int64) error {
-	err := fst.MemStorage.AddCounter(ctx, id, value)
+func (fst *FileStorage) AddCounter(ctx context.Context, id string, labels model.Labels, value int64) error {
+	err := fst.MemStorage.AddCounter(ctx, id, labels, value)
 	if err != nil {
 		return err
 	}
@@ -84,8 +84,36 @@ func (fst *FileStorage) AddCounter(ctx context.Context, id string, value int64)
 	return nil
 }
 
-func (fst *FileStorage) SetGauge(ctx context.Context, id string, value float64) error {
-	err := fst.MemStorage.SetGauge(ctx, id, value)
+func (fst *FileStorage) SetGauge(ctx context.Context, id string, labels model.Labels, value float64) error {
+	err := fst.MemStorage.SetGauge(ctx, id, labels, value)
+	if err != nil {
+		return err
+	}
+	if fst.synchronous {
+		err := fst.store(context.Background())
+		if err != nil {
+			return fmt.Errorf("%w, reason: %s", ErrStore, err.Error())
+		}
+	}
+	return nil
+}
+
+func (fst *FileStorage) AddHistogram(ctx context.Context, id string, labels model.Labels, hist *model.HistogramValue) error {
+	err := fst.MemStorage.AddHistogram(ctx, id, labels, hist)
+	if err != nil {
+		return err
+	}
+	if fst.synchronous {
+		err := fst.store(context.Background())
+		if err != nil {
+			return fmt.Errorf("%w, reason: %s", ErrStore, err.Error())
+		}
+	}
+	return nil
+}
+
+func (fst *FileStorage) AddSummary(ctx context.Context, id string, labels model.Labels, summary *model.SummaryValue) error {
+	err := fst.MemStorage.AddSummary(ctx, id, labels, summary)
 	if err != nil {
 		return err
 	}
@@ -112,6 +140,24 @@ func (fst *FileStorage) BatchUpdate(ctx context.Context, metrics []*model.Metric
 	return nil
 }
 
+func (fst *FileStorage) BatchUpdateOnce(
+	ctx context.Context,
+	batchID model.BatchID,
+	metrics []*model.Metrics,
+) (bool, error) {
+	applied, err := fst.MemStorage.BatchUpdateOnce(ctx, batchID, metrics)
+	if err != nil || !applied {
+		return applied, err
+	}
+	if fst.synchronous {
+		err := fst.store(context.Background())
+		if err != nil {
+			return true, fmt.Errorf("%w, reason: %s", ErrStore, err.Error())
+		}
+	}
+	return true, nil
+}
+
 func (fst *FileStorage) load(ctx context.Context) error {
 	fst.logger.Infow("Loading metrics from file",
 		"filename", fst.filename,
@@ -0,0 +1,51 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/andrewsvn/metrics-overseer/internal/model"
+)
+
+// DefaultHistorySize is a number of samples kept per metric series by in-memory storages if not configured explicitly
+const DefaultHistorySize = 1000
+
+// sampleRing is a fixed-size ring buffer of metric samples, when it's full the oldest sample is overwritten.
+// It is not thread-safe and relies on the owner's synchronization
+type sampleRing struct {
+	samples []*model.Sample
+	start   int
+	size    int
+}
+
+func newSampleRing(capacity int) *sampleRing {
+	return &sampleRing{
+		samples: make([]*model.Sample, capacity),
+	}
+}
+
+func (r *sampleRing) push(s *model.Sample) {
+	if len(r.samples) == 0 {
+		return
+	}
+
+	idx := (r.start + r.size) % len(r.samples)
+	r.samples[idx] = s
+	if r.size < len(r.samples) {
+		r.size++
+	} else {
+		r.start = (r.start + 1) % len(r.samples)
+	}
+}
+
+// between returns samples with timestamps in [from, to] range in the order they were pushed
+func (r *sampleRing) between(from, to time.Time) []*model.Sample {
+	res := make([]*model.Sample, 0)
+	for i := 0; i < r.size; i++ {
+		s := r.samples[(r.start+i)%len(r.samples)]
+		if s.Timestamp.Before(from) || s.Timestamp.After(to) {
+			continue
+		}
+		res = append(res, s)
+	}
+	return res
+}
@@ -0,0 +1,35 @@
+package repository
+
+import (
+	"testing"
+	"time"
+
+	"github.com/andrewsvn/metrics-overseer/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSampleRing(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	ring := newSampleRing(3)
+	assert.Empty(t, ring.between(base, base.Add(time.Hour)))
+
+	for i := 0; i < 5; i++ {
+		ring.push(model.NewSample(base.Add(time.Duration(i)*time.Minute),
+			model.NewCounterMetricsWithDelta("cnt", int64(i))))
+	}
+
+	// only last 3 samples are kept
+	samples := ring.between(base, base.Add(time.Hour))
+	require.Equal(t, 3, len(samples))
+	assert.Equal(t, int64(2), *samples[0].Delta)
+	assert.Equal(t, int64(4), *samples[2].Delta)
+
+	samples = ring.between(base.Add(3*time.Minute), base.Add(3*time.Minute))
+	require.Equal(t, 1, len(samples))
+	assert.Equal(t, int64(3), *samples[0].Delta)
+
+	empty := newSampleRing(0)
+	empty.push(model.NewSample(base, model.NewCounterMetricsWithDelta("cnt", 1)))
+	assert.Empty(t, empty.between(base, base))
+}
@@ -1,11 +1,13 @@
 package repository
 
 import (
+	"cmp"
 	"context"
 	"fmt"
 	"slices"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/andrewsvn/metrics-overseer/internal/model"
 )
@@ -13,75 +15,217 @@ import (
 type MemStorage struct {
 	data map[string]*model.Metrics
 
+	// history keeps last historySize samples for each metric series
+	history     map[string]*sampleRing
+	historySize int
+
+	// batches remembers IDs of applied batches for BatchUpdateOnce
+	batches *appliedBatches
+
 	mutex *sync.RWMutex
 }
 
 func NewMemStorage() *MemStorage {
+	return NewMemStorageWithHistorySize(DefaultHistorySize)
+}
+
+// NewMemStorageWithHistorySize creates memory storage keeping the given number of samples per metric series,
+// history is disabled if size is zero or negative
+func NewMemStorageWithHistorySize(historySize int) *MemStorage {
 	return &MemStorage{
-		data:  make(map[string]*model.Metrics),
-		mutex: &sync.RWMutex{},
+		data:        make(map[string]*model.Metrics),
+		history:     make(map[string]*sampleRing),
+		historySize: historySize,
+		batches:     newAppliedBatches(),
+		mutex:       &sync.RWMutex{},
+	}
+}
+
+func (ms *MemStorage) SetGauge(_ context.Context, id string, labels model.Labels, value float64) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	return ms.setGaugeInMutex(id, labels, value)
+}
+
+func (ms *MemStorage) setGaugeInMutex(id string, labels model.Labels, value float64) error {
+	key := model.SeriesKey(id, labels)
+	if ms.data[key] == nil {
+		ms.data[key] = model.NewGaugeMetricsWithValue(id, value).WithLabels(labels)
+		ms.recordSampleInMutex(key)
+		return nil
+	}
+	if ms.data[key].MType != model.Gauge {
+		return ErrIncorrectAccess
 	}
+
+	ms.data[key].SetGauge(value)
+	ms.recordSampleInMutex(key)
+	return nil
 }
 
-func (ms *MemStorage) SetGauge(_ context.Context, id string, value float64) error {
+func (ms *MemStorage) AddCounter(_ context.Context, id string, labels model.Labels, delta int64) error {
 	ms.mutex.Lock()
 	defer ms.mutex.Unlock()
-	return ms.setGaugeInMutex(id, value)
+	return ms.addCounterInMutex(id, labels, delta)
 }
 
-func (ms *MemStorage) setGaugeInMutex(id string, value float64) error {
-	if ms.data[id] == nil {
-		ms.data[id] = model.NewGaugeMetricsWithValue(id, value)
+func (ms *MemStorage) addCounterInMutex(id string, labels model.Labels, delta int64) error {
+	key := model.SeriesKey(id, labels)
+	if ms.data[key] == nil {
+		ms.data[key] = model.NewCounterMetricsWithDelta(id, delta).WithLabels(labels)
+		ms.recordSampleInMutex(key)
 		return nil
 	}
-	if ms.data[id].MType != model.Gauge {
+	if ms.data[key].MType != model.Counter {
 		return ErrIncorrectAccess
 	}
 
-	ms.data[id].SetGauge(value)
+	ms.data[key].AddCounter(delta)
+	ms.recordSampleInMutex(key)
 	return nil
 }
 
-func (ms *MemStorage) AddCounter(_ context.Context, id string, delta int64) error {
+func (ms *MemStorage) AddHistogram(_ context.Context, id string, labels model.Labels, hist *model.HistogramValue) error {
 	ms.mutex.Lock()
 	defer ms.mutex.Unlock()
-	return ms.addCounterInMutex(id, delta)
+	return ms.addHistogramInMutex(id, labels, hist)
 }
 
-func (ms *MemStorage) addCounterInMutex(id string, delta int64) error {
-	if ms.data[id] == nil {
-		ms.data[id] = model.NewCounterMetricsWithDelta(id, delta)
+func (ms *MemStorage) addHistogramInMutex(id string, labels model.Labels, hist *model.HistogramValue) error {
+	key := model.SeriesKey(id, labels)
+	if ms.data[key] == nil {
+		ms.data[key] = model.NewHistogramMetricsWithValue(id, hist.Clone()).WithLabels(labels)
+		ms.recordSampleInMutex(key)
 		return nil
 	}
-	if ms.data[id].MType != model.Counter {
+	if ms.data[key].MType != model.Histogram {
 		return ErrIncorrectAccess
 	}
 
-	ms.data[id].AddCounter(delta)
+	if err := ms.data[key].AddHistogram(hist); err != nil {
+		return err
+	}
+	ms.recordSampleInMutex(key)
 	return nil
 }
 
-func (ms *MemStorage) GetByID(_ context.Context, id string) (*model.Metrics, error) {
+func (ms *MemStorage) AddSummary(_ context.Context, id string, labels model.Labels, summary *model.SummaryValue) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	return ms.addSummaryInMutex(id, labels, summary)
+}
+
+func (ms *MemStorage) addSummaryInMutex(id string, labels model.Labels, summary *model.SummaryValue) error {
+	key := model.SeriesKey(id, labels)
+	if ms.data[key] == nil {
+		ms.data[key] = model.NewSummaryMetricsWithValue(id, nil).WithLabels(labels)
+	}
+	if ms.data[key].MType != model.Summary {
+		return ErrIncorrectAccess
+	}
+
+	if err := ms.data[key].AddSummary(summary); err != nil {
+		return err
+	}
+	ms.recordSampleInMutex(key)
+	return nil
+}
+
+func (ms *MemStorage) recordSampleInMutex(key string) {
+	if ms.historySize <= 0 {
+		return
+	}
+	if ms.history[key] == nil {
+		ms.history[key] = newSampleRing(ms.historySize)
+	}
+	ms.history[key].push(model.NewSample(time.Now(), ms.data[key]))
+}
+
+func (ms *MemStorage) GetByID(_ context.Context, id string, labels model.Labels) (*model.Metrics, error) {
 	ms.mutex.RLock()
 	defer ms.mutex.RUnlock()
 
-	m, exists := ms.data[id]
+	m, exists := ms.data[model.SeriesKey(id, labels)]
 	if !exists {
 		return nil, ErrMetricNotFound
 	}
-	return m, nil
+	return m.Clone(), nil
+}
+
+func (ms *MemStorage) GetHistory(
+	_ context.Context,
+	id string,
+	labels model.Labels,
+	from, to time.Time,
+) ([]*model.Sample, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	ring, exists := ms.history[model.SeriesKey(id, labels)]
+	if !exists {
+		return []*model.Sample{}, nil
+	}
+	return ring.between(from, to), nil
 }
 
 func (ms *MemStorage) BatchUpdate(_ context.Context, metrics []*model.Metrics) error {
 	ms.mutex.Lock()
 	defer ms.mutex.Unlock()
+	return ms.batchUpdateInMutex(metrics)
+}
+
+func (ms *MemStorage) BatchUpdateOnce(_ context.Context, batchID model.BatchID, metrics []*model.Metrics) (bool, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
 
-	// perform all validations before update to prevent partial update
+	if ms.batches.contains(batchID) {
+		return false, nil
+	}
+	if err := ms.batchUpdateInMutex(metrics); err != nil {
+		return false, err
+	}
+	ms.batches.add(batchID, time.Now())
+	return true, nil
+}
+
+func (ms *MemStorage) batchUpdateInMutex(metrics []*model.Metrics) error {
+	// perform all validations before update to prevent partial update,
+	// histograms and summaries are merged into copies to detect mismatching buckets and overflows
+	hists := make(map[string]*model.HistogramValue)
+	summaries := make(map[string]*model.SummaryValue)
 	for _, m := range metrics {
-		old := ms.data[m.ID]
+		key := m.Key()
+		old := ms.data[key]
 		if old != nil && old.MType != m.MType {
 			return fmt.Errorf("%w: for metric id=%s old type=%s, new type=%s",
-				ErrIncorrectAccess, m.ID, old.MType, m.MType)
+				ErrIncorrectAccess, key, old.MType, m.MType)
+		}
+		if m.Histogram != nil {
+			h, ok := hists[key]
+			if !ok && old != nil && old.Histogram != nil {
+				h = old.Histogram.Clone()
+			}
+			if h == nil {
+				hists[key] = m.Histogram.Clone()
+				continue
+			}
+			if err := h.Merge(m.Histogram); err != nil {
+				return fmt.Errorf("%w: for metric id=%s", err, key)
+			}
+			hists[key] = h
+		}
+		if m.Summary != nil {
+			s, ok := summaries[key]
+			if !ok {
+				s = model.NewSummaryValue()
+				if old != nil && old.Summary != nil {
+					s = old.Summary.Clone()
+				}
+			}
+			if err := s.Merge(m.Summary); err != nil {
+				return fmt.Errorf("%w: for metric id=%s", err, key)
+			}
+			summaries[key] = s
 		}
 	}
 
@@ -89,11 +233,19 @@ func (ms *MemStorage) BatchUpdate(_ context.Context, metrics []*model.Metrics) e
 		switch m.MType {
 		case model.Counter:
 			if m.Delta != nil {
-				_ = ms.addCounterInMutex(m.ID, *m.Delta)
+				_ = ms.addCounterInMutex(m.ID, m.Labels, *m.Delta)
 			}
 		case model.Gauge:
 			if m.Value != nil {
-				_ = ms.setGaugeInMutex(m.ID, *m.Value)
+				_ = ms.setGaugeInMutex(m.ID, m.Labels, *m.Value)
+			}
+		case model.Histogram:
+			if m.Histogram != nil {
+				_ = ms.addHistogramInMutex(m.ID, m.Labels, m.Histogram)
+			}
+		case model.Summary:
+			if m.Summary != nil {
+				_ = ms.addSummaryInMutex(m.ID, m.Labels, m.Summary)
 			}
 		}
 	}
@@ -106,18 +258,39 @@ func (ms *MemStorage) GetAllSorted(_ context.Context) ([]*model.Metrics, error)
 
 	mlist := make([]*model.Metrics, 0, len(ms.data))
 	for _, v := range ms.data {
-		mlist = append(mlist, v)
+		mlist = append(mlist, v.Clone())
 	}
+	sortMetrics(mlist)
+	return mlist, nil
+}
+
+func (ms *MemStorage) FindSorted(_ context.Context, filter *model.Filter) ([]*model.Metrics, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	mlist := make([]*model.Metrics, 0)
+	for _, v := range ms.data {
+		if filter.Matches(v) {
+			mlist = append(mlist, v.Clone())
+		}
+	}
+	sortMetrics(mlist)
+	return mlist, nil
+}
+
+func sortMetrics(mlist []*model.Metrics) {
 	slices.SortFunc(mlist, func(a *model.Metrics, b *model.Metrics) int {
-		return strings.Compare(a.ID, b.ID)
+		return cmp.Or(
+			strings.Compare(a.ID, b.ID),
+			strings.Compare(a.Labels.String(), b.Labels.String()),
+		)
 	})
-	return mlist, nil
 }
 
 func (ms *MemStorage) SetAll(_ context.Context, metrics []*model.Metrics) error {
 	ms.mutex.Lock()
 	for _, m := range metrics {
-		ms.data[m.ID] = model.NewMetrics(m.ID, m.MType, m.Delta, m.Value)
+		ms.data[m.Key()] = m.Clone()
 	}
 	ms.mutex.Unlock()
 	return nil
@@ -126,6 +299,7 @@ func (ms *MemStorage) SetAll(_ context.Context, metrics []*model.Metrics) error
 func (ms *MemStorage) ResetAll(_ context.Context) error {
 	ms.mutex.Lock()
 	clear(ms.data)
+	clear(ms.history)
 	ms.mutex.Unlock()
 	return nil
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/andrewsvn/metrics-overseer/internal/model"
 	"github.com/stretchr/testify/assert"
@@ -14,39 +15,39 @@ func TestMemStorageCounters(t *testing.T) {
 	ms := NewMemStorage()
 	ctx := context.Background()
 
-	_ = ms.AddCounter(ctx, "cnt1", 1)
-	_ = ms.AddCounter(ctx, "cnt2", 2)
-	_ = ms.AddCounter(ctx, "cnt1", 3)
-	_ = ms.AddCounter(ctx, "cnt2", 4)
+	_ = ms.AddCounter(ctx, "cnt1", nil, 1)
+	_ = ms.AddCounter(ctx, "cnt2", nil, 2)
+	_ = ms.AddCounter(ctx, "cnt1", nil, 3)
+	_ = ms.AddCounter(ctx, "cnt2", nil, 4)
 
-	cnt1, err := ms.GetByID(ctx, "cnt1")
+	cnt1, err := ms.GetByID(ctx, "cnt1", nil)
 	assert.NoError(t, err)
 	assert.Equal(t, "cnt1", cnt1.ID)
 	assert.Equal(t, model.Counter, cnt1.MType)
 	assert.Equal(t, int64(4), *cnt1.Delta)
 	assert.Nil(t, cnt1.Value)
 
-	cnt2, err := ms.GetByID(ctx, "cnt2")
+	cnt2, err := ms.GetByID(ctx, "cnt2", nil)
 	assert.NoError(t, err)
 	assert.Equal(t, "cnt2", cnt2.ID)
 	assert.Equal(t, model.Counter, cnt2.MType)
 	assert.Equal(t, int64(6), *cnt2.Delta)
 	assert.Nil(t, cnt2.Value)
 
-	_, err = ms.GetByID(ctx, "cnt3")
+	_, err = ms.GetByID(ctx, "cnt3", nil)
 	assert.ErrorAs(t, err, &ErrMetricNotFound)
 
-	_ = ms.AddCounter(ctx, "cnt1", -2)
-	_ = ms.AddCounter(ctx, "cnt2", -8)
+	_ = ms.AddCounter(ctx, "cnt1", nil, -2)
+	_ = ms.AddCounter(ctx, "cnt2", nil, -8)
 
-	cnt1, _ = ms.GetByID(ctx, "cnt1")
+	cnt1, _ = ms.GetByID(ctx, "cnt1", nil)
 	assert.Equal(t, int64(2), *cnt1.Delta)
-	cnt2, _ = ms.GetByID(ctx, "cnt2")
+	cnt2, _ = ms.GetByID(ctx, "cnt2", nil)
 	assert.Equal(t, int64(-2), *cnt2.Delta)
 
 	err = ms.ResetAll(ctx)
 	require.NoError(t, err)
-	_, err = ms.GetByID(ctx, "cnt1")
+	_, err = ms.GetByID(ctx, "cnt1", nil)
 	assert.Error(t, err)
 }
 
@@ -54,41 +55,138 @@ func TestMemStorageGauges(t *testing.T) {
 	ms := NewMemStorage()
 	ctx := context.Background()
 
-	_ = ms.SetGauge(ctx, "gauge1", 1.11)
-	_ = ms.SetGauge(ctx, "gauge2", 3.33)
+	_ = ms.SetGauge(ctx, "gauge1", nil, 1.11)
+	_ = ms.SetGauge(ctx, "gauge2", nil, 3.33)
 
-	g1, err := ms.GetByID(ctx, "gauge1")
+	g1, err := ms.GetByID(ctx, "gauge1", nil)
 	assert.NoError(t, err)
 	assert.Equal(t, "gauge1", g1.ID)
 	assert.Equal(t, model.Gauge, g1.MType)
 	assert.Equal(t, 1.11, *g1.Value)
 	assert.Nil(t, g1.Delta)
 
-	g2, err := ms.GetByID(ctx, "gauge2")
+	g2, err := ms.GetByID(ctx, "gauge2", nil)
 	assert.NoError(t, err)
 	assert.Equal(t, "gauge2", g2.ID)
 	assert.Equal(t, model.Gauge, g2.MType)
 	assert.Equal(t, 3.33, *g2.Value)
 
-	_, err = ms.GetByID(ctx, "gauge3")
+	_, err = ms.GetByID(ctx, "gauge3", nil)
 	assert.ErrorAs(t, err, &ErrMetricNotFound)
 
-	_ = ms.SetGauge(ctx, "gauge1", 0.0)
-	_ = ms.SetGauge(ctx, "gauge2", -2.22)
-	g1, _ = ms.GetByID(ctx, "gauge1")
+	_ = ms.SetGauge(ctx, "gauge1", nil, 0.0)
+	_ = ms.SetGauge(ctx, "gauge2", nil, -2.22)
+	g1, _ = ms.GetByID(ctx, "gauge1", nil)
 	assert.Equal(t, 0.0, *g1.Value)
-	g2, _ = ms.GetByID(ctx, "gauge2")
+	g2, _ = ms.GetByID(ctx, "gauge2", nil)
 	assert.Equal(t, -2.22, *g2.Value)
 }
 
+func TestMemStorageHistograms(t *testing.T) {
+	ms := NewMemStorage()
+	ctx := context.Background()
+
+	h := model.NewHistogramValue(1, 10)
+	h.Observe(0.5)
+	h.Observe(5)
+	err := ms.AddHistogram(ctx, "hist1", nil, h)
+	require.NoError(t, err)
+
+	h = model.NewHistogramValue(1, 10)
+	h.Observe(20)
+	err = ms.AddHistogram(ctx, "hist1", nil, h)
+	require.NoError(t, err)
+
+	h1, err := ms.GetByID(ctx, "hist1", nil)
+	require.NoError(t, err)
+	assert.Equal(t, model.Histogram, h1.MType)
+	require.NotNil(t, h1.Histogram)
+	assert.Equal(t, []int64{1, 1, 1}, h1.Histogram.Counts)
+	assert.Equal(t, int64(3), h1.Histogram.Count)
+	assert.InDelta(t, 25.5, h1.Histogram.Sum, 0.0001)
+
+	err = ms.AddHistogram(ctx, "hist1", nil, model.NewHistogramValue(2))
+	assert.ErrorIs(t, err, model.ErrHistogramBucketsMismatch)
+
+	_ = ms.SetGauge(ctx, "gauge1", nil, 1.0)
+	err = ms.AddHistogram(ctx, "gauge1", nil, model.NewHistogramValue())
+	assert.ErrorIs(t, err, ErrIncorrectAccess)
+
+	// batch with incompatible histogram must be discarded completely
+	err = ms.BatchUpdate(ctx, []*model.Metrics{
+		model.NewGaugeMetricsWithValue("gauge1", 2.0),
+		model.NewHistogramMetricsWithValue("hist1", model.NewHistogramValue(1, 2, 3)),
+	})
+	assert.ErrorIs(t, err, model.ErrHistogramBucketsMismatch)
+	g1, _ := ms.GetByID(ctx, "gauge1", nil)
+	assert.Equal(t, 1.0, *g1.Value)
+
+	// batch overflowing histogram sum is discarded as well, stored sum stays finite
+	huge := &model.HistogramValue{Bounds: []float64{1, 10}, Counts: []int64{0, 0, 1}, Count: 1, Sum: 1e308}
+	err = ms.BatchUpdate(ctx, []*model.Metrics{
+		model.NewGaugeMetricsWithValue("gauge1", 2.0),
+		model.NewHistogramMetricsWithValue("hist1", huge),
+		model.NewHistogramMetricsWithValue("hist1", huge),
+	})
+	assert.ErrorIs(t, err, model.ErrValueOverflow)
+	g1, _ = ms.GetByID(ctx, "gauge1", nil)
+	assert.Equal(t, 1.0, *g1.Value)
+	h1, _ = ms.GetByID(ctx, "hist1", nil)
+	assert.Equal(t, int64(3), h1.Histogram.Count)
+	assert.InDelta(t, 25.5, h1.Histogram.Sum, 0.0001)
+}
+
+func TestMemStorageSummaries(t *testing.T) {
+	ms := NewMemStorage()
+	ctx := context.Background()
+
+	s := model.NewSummaryValue()
+	s.Add(1)
+	s.Add(2)
+	err := ms.AddSummary(ctx, "sum1", nil, s)
+	require.NoError(t, err)
+
+	s = model.NewSummaryValue()
+	s.Add(10)
+	err = ms.BatchUpdate(ctx, []*model.Metrics{model.NewSummaryMetricsWithValue("sum1", s)})
+	require.NoError(t, err)
+
+	s1, err := ms.GetByID(ctx, "sum1", nil)
+	require.NoError(t, err)
+	assert.Equal(t, model.Summary, s1.MType)
+	require.NotNil(t, s1.Summary)
+	assert.Equal(t, int64(3), s1.Summary.Count)
+	assert.Equal(t, 1.0, s1.Summary.Min)
+	assert.Equal(t, 10.0, s1.Summary.Max)
+	assert.InDelta(t, 13.0, s1.Summary.Sum, 0.0001)
+
+	_ = ms.SetGauge(ctx, "gauge1", nil, 1.0)
+	err = ms.AddSummary(ctx, "gauge1", nil, model.NewSummaryValue())
+	assert.ErrorIs(t, err, ErrIncorrectAccess)
+
+	// batch overflowing summary sum is discarded completely
+	huge := model.NewSummaryValue()
+	huge.Add(1.7e308)
+	err = ms.BatchUpdate(ctx, []*model.Metrics{
+		model.NewGaugeMetricsWithValue("gauge1", 2.0),
+		model.NewSummaryMetricsWithValue("sum1", huge),
+		model.NewSummaryMetricsWithValue("sum1", huge),
+	})
+	assert.ErrorIs(t, err, model.ErrValueOverflow)
+	g1, _ := ms.GetByID(ctx, "gauge1", nil)
+	assert.Equal(t, 1.0, *g1.Value)
+	s1, _ = ms.GetByID(ctx, "sum1", nil)
+	assert.Equal(t, int64(3), s1.Summary.Count)
+}
+
 func TestMemStorageGetAll(t *testing.T) {
 	ms := NewMemStorage()
 	ctx := context.Background()
 
-	_ = ms.SetGauge(ctx, "1gauge1", 1.11)
-	_ = ms.SetGauge(ctx, "2gauge2", 3.33)
-	_ = ms.AddCounter(ctx, "1cnt1", 1)
-	_ = ms.AddCounter(ctx, "2cnt2", 2)
+	_ = ms.SetGauge(ctx, "1gauge1", nil, 1.11)
+	_ = ms.SetGauge(ctx, "2gauge2", nil, 3.33)
+	_ = ms.AddCounter(ctx, "1cnt1", nil, 1)
+	_ = ms.AddCounter(ctx, "2cnt2", nil, 2)
 
 	metrics, err := ms.GetAllSorted(ctx)
 	require.NoError(t, err)
@@ -96,10 +194,10 @@ func TestMemStorageGetAll(t *testing.T) {
 	assert.Equal(t, "1cnt1", metrics[0].ID)
 	assert.Equal(t, "1gauge1", metrics[1].ID)
 
-	_ = ms.SetGauge(ctx, "0gauge0", 2.22)
-	_ = ms.SetGauge(ctx, "2gauge2", -3.33)
-	_ = ms.AddCounter(ctx, "0cnt0", 3)
-	_ = ms.AddCounter(ctx, "2cnt2", -2)
+	_ = ms.SetGauge(ctx, "0gauge0", nil, 2.22)
+	_ = ms.SetGauge(ctx, "2gauge2", nil, -3.33)
+	_ = ms.AddCounter(ctx, "0cnt0", nil, 3)
+	_ = ms.AddCounter(ctx, "2cnt2", nil, -2)
 
 	metrics, err = ms.GetAllSorted(ctx)
 	require.NoError(t, err)
@@ -110,6 +208,170 @@ func TestMemStorageGetAll(t *testing.T) {
 	assert.Equal(t, "2gauge2", metrics[5].ID)
 }
 
+func TestMemStorageReadsAreDetached(t *testing.T) {
+	ms := NewMemStorage()
+	ctx := context.Background()
+
+	_ = ms.AddHistogram(ctx, "hist1", nil, model.NewHistogramValue(1, 5))
+
+	// mutating metrics returned from storage must not affect stored state
+	h1, err := ms.GetByID(ctx, "hist1", nil)
+	require.NoError(t, err)
+	h1.Histogram.Observe(3)
+	metrics, err := ms.GetAllSorted(ctx)
+	require.NoError(t, err)
+	metrics[0].Histogram.Observe(3)
+	metrics, err = ms.FindSorted(ctx, &model.Filter{})
+	require.NoError(t, err)
+	metrics[0].Histogram.Observe(3)
+
+	h1, err = ms.GetByID(ctx, "hist1", nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), h1.Histogram.Count)
+}
+
+func TestMemStorageLabels(t *testing.T) {
+	ms := NewMemStorage()
+	ctx := context.Background()
+
+	_ = ms.AddCounter(ctx, "requests", nil, 1)
+	_ = ms.AddCounter(ctx, "requests", model.Labels{"host": "a"}, 2)
+	_ = ms.AddCounter(ctx, "requests", model.Labels{"host": "b"}, 3)
+	_ = ms.AddCounter(ctx, "requests", model.Labels{"host": "a"}, 4)
+	_ = ms.SetGauge(ctx, "cpu", model.Labels{"host": "a", "core": "1"}, 0.5)
+
+	m, err := ms.GetByID(ctx, "requests", nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), *m.Delta)
+
+	m, err = ms.GetByID(ctx, "requests", model.Labels{"host": "a"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), *m.Delta)
+	assert.Equal(t, model.Labels{"host": "a"}, m.Labels)
+
+	_, err = ms.GetByID(ctx, "requests", model.Labels{"host": "c"})
+	assert.ErrorIs(t, err, ErrMetricNotFound)
+
+	err = ms.SetGauge(ctx, "requests", model.Labels{"host": "b"}, 1.0)
+	assert.ErrorIs(t, err, ErrIncorrectAccess)
+
+	err = ms.BatchUpdate(ctx, []*model.Metrics{
+		model.NewCounterMetricsWithDelta("requests", 10).WithLabels(model.Labels{"host": "b"}),
+		model.NewGaugeMetricsWithValue("cpu", 0.7).WithLabels(model.Labels{"host": "b", "core": "1"}),
+	})
+	require.NoError(t, err)
+
+	metrics, err := ms.GetAllSorted(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 5, len(metrics))
+	assert.Equal(t, `cpu{core="1",host="a"}`, metrics[0].Key())
+	assert.Equal(t, `cpu{core="1",host="b"}`, metrics[1].Key())
+	assert.Equal(t, "requests", metrics[2].Key())
+	assert.Equal(t, `requests{host="b"}`, metrics[4].Key())
+	assert.Equal(t, int64(13), *metrics[4].Delta)
+
+	metrics, err = ms.FindSorted(ctx, &model.Filter{Labels: model.Labels{"host": "b"}})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(metrics))
+	assert.Equal(t, "cpu", metrics[0].ID)
+	assert.Equal(t, "requests", metrics[1].ID)
+
+	metrics, err = ms.FindSorted(ctx, &model.Filter{ID: "requests", MType: model.Counter})
+	require.NoError(t, err)
+	assert.Equal(t, 3, len(metrics))
+}
+
+func TestMemStorageBatchUpdateOnce(t *testing.T) {
+	ms := NewMemStorage()
+	ctx := context.Background()
+	batch := []*model.Metrics{model.NewCounterMetricsWithDelta("cnt1", 2)}
+
+	applied, err := ms.BatchUpdateOnce(ctx, model.BatchID{AgentID: "agent1", Seq: 1}, batch)
+	require.NoError(t, err)
+	assert.True(t, applied)
+	applied, err = ms.BatchUpdateOnce(ctx, model.BatchID{AgentID: "agent1", Seq: 1}, batch)
+	require.NoError(t, err)
+	assert.False(t, applied)
+	applied, err = ms.BatchUpdateOnce(ctx, model.BatchID{AgentID: "agent2", Seq: 1}, batch)
+	require.NoError(t, err)
+	assert.True(t, applied)
+
+	// invalid batch is not remembered and can be fixed and resent
+	invalid := []*model.Metrics{model.NewGaugeMetricsWithValue("cnt1", 1)}
+	_, err = ms.BatchUpdateOnce(ctx, model.BatchID{AgentID: "agent1", Seq: 2}, invalid)
+	assert.ErrorIs(t, err, ErrIncorrectAccess)
+	applied, err = ms.BatchUpdateOnce(ctx, model.BatchID{AgentID: "agent1", Seq: 2}, batch)
+	require.NoError(t, err)
+	assert.True(t, applied)
+
+	cnt1, err := ms.GetByID(ctx, "cnt1", nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), *cnt1.Delta)
+}
+
+func TestAppliedBatches(t *testing.T) {
+	ab := newAppliedBatches()
+	now := time.Now()
+
+	ab.add(model.BatchID{AgentID: "agent1", Seq: 1}, now.Add(-AppliedBatchRetention-time.Second))
+	ab.add(model.BatchID{AgentID: "agent2", Seq: 1}, now.Add(-AppliedBatchRetention-time.Second))
+	ab.add(model.BatchID{AgentID: "agent1", Seq: 2}, now)
+	// expired IDs are forgotten when the same agent applies a new batch
+	assert.False(t, ab.contains(model.BatchID{AgentID: "agent1", Seq: 1}))
+	assert.True(t, ab.contains(model.BatchID{AgentID: "agent1", Seq: 2}))
+	assert.True(t, ab.contains(model.BatchID{AgentID: "agent2", Seq: 1}))
+
+	for seq := uint64(3); seq < appliedBatchesPerAgent+3; seq++ {
+		ab.add(model.BatchID{AgentID: "agent1", Seq: seq}, now)
+	}
+	assert.False(t, ab.contains(model.BatchID{AgentID: "agent1", Seq: 2}))
+	assert.True(t, ab.contains(model.BatchID{AgentID: "agent1", Seq: 3}))
+	assert.Len(t, ab.agents["agent1"].seqs, appliedBatchesPerAgent)
+}
+
+func TestMemStorageHistory(t *testing.T) {
+	ms := NewMemStorageWithHistorySize(3)
+	ctx := context.Background()
+	from := time.Now()
+
+	_ = ms.AddCounter(ctx, "cnt", nil, 1)
+	_ = ms.AddCounter(ctx, "cnt", nil, 2)
+	_ = ms.AddCounter(ctx, "cnt", model.Labels{"host": "a"}, 10)
+	_ = ms.SetGauge(ctx, "cnt", nil, 1.0)
+
+	samples, err := ms.GetHistory(ctx, "cnt", nil, from, time.Now())
+	require.NoError(t, err)
+	require.Equal(t, 2, len(samples))
+	assert.Equal(t, int64(1), *samples[0].Delta)
+	assert.Equal(t, int64(3), *samples[1].Delta)
+	assert.False(t, samples[1].Timestamp.Before(samples[0].Timestamp))
+
+	_ = ms.AddCounter(ctx, "cnt", nil, 3)
+	_ = ms.AddCounter(ctx, "cnt", nil, 4)
+	samples, err = ms.GetHistory(ctx, "cnt", nil, from, time.Now())
+	require.NoError(t, err)
+	require.Equal(t, 3, len(samples))
+	assert.Equal(t, int64(3), *samples[0].Delta)
+	assert.Equal(t, int64(10), *samples[2].Delta)
+
+	samples, err = ms.GetHistory(ctx, "cnt", model.Labels{"host": "a"}, from, time.Now())
+	require.NoError(t, err)
+	require.Equal(t, 1, len(samples))
+
+	samples, err = ms.GetHistory(ctx, "cnt", nil, from.Add(-time.Hour), from.Add(-time.Minute))
+	require.NoError(t, err)
+	assert.Empty(t, samples)
+
+	samples, err = ms.GetHistory(ctx, "unknown", nil, from, time.Now())
+	require.NoError(t, err)
+	assert.Empty(t, samples)
+	disabled := NewMemStorageWithHistorySize(-1)
+	_ = disabled.AddCounter(ctx, "cnt", nil, 1)
+	samples, err = disabled.GetHistory(ctx, "cnt", nil, from, time.Now())
+	require.NoError(t, err)
+	assert.Empty(t, samples)
+}
+
 func BenchmarkMemStorageAddCounter(b *testing.B) {
 	b.StopTimer()
 	ms := setupMemStorageForBenchmark()
@@ -117,7 +379,7 @@ func BenchmarkMemStorageAddCounter(b *testing.B) {
 	b.StartTimer()
 
 	for i := 0; i < b.N; i++ {
-		_ = ms.AddCounter(ctx, "cnt255", 5)
+		_ = ms.AddCounter(ctx, "cnt255", nil, 5)
 	}
 }
 
@@ -128,7 +390,7 @@ func BenchmarkMemStorageSetGauge(b *testing.B) {
 	b.StartTimer()
 
 	for i := 0; i < b.N; i++ {
-		_ = ms.SetGauge(ctx, "gauge511", 3.14)
+		_ = ms.SetGauge(ctx, "gauge511", nil, 3.14)
 	}
 }
 
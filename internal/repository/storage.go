@@ -3,6 +3,7 @@ package repository
 import (
 	"context"
 	"errors"
+	"time"
 
 	"github.com/andrewsvn/metrics-overseer/internal/model"
 )
@@ -10,20 +11,41 @@ import (
 // all methods can return error in case when some internal storage problem occurs
 // or method is unsupported for chosen metric
 // error is not returned if data is not found in get methods
+//
+// metric series is identified by ID together with labels (see model.SeriesKey),
+// nil or empty labels identify a series without dimensions
 
 type Storage interface {
-	SetGauge(ctx context.Context, id string, value float64) error
-	AddCounter(ctx context.Context, id string, delta int64) error
+	SetGauge(ctx context.Context, id string, labels model.Labels, value float64) error
+	AddCounter(ctx context.Context, id string, labels model.Labels, delta int64) error
+	// AddHistogram merges provided histogram observations into the stored one,
+	// bucket layouts of stored and provided histograms must match
+	AddHistogram(ctx context.Context, id string, labels model.Labels, hist *model.HistogramValue) error
+	// AddSummary merges provided quantile sketch into the stored one
+	AddSummary(ctx context.Context, id string, labels model.Labels, summary *model.SummaryValue) error
 
-	GetByID(ctx context.Context, id string) (*model.Metrics, error)
+	GetByID(ctx context.Context, id string, labels model.Labels) (*model.Metrics, error)
+
+	// GetHistory returns samples of metric series recorded on its updates within [from, to] time range,
+	// sorted by time. Empty list is returned if there are no samples
+	GetHistory(ctx context.Context, id string, labels model.Labels, from, to time.Time) ([]*model.Sample, error)
 
 	// BatchUpdate allows receiving multiple metrics values and accumulate them simultaneously
 	// if any metric is invalid, all data is discarded, and an error returned on the validation step
 	BatchUpdate(ctx context.Context, metrics []*model.Metrics) error
 
+	// BatchUpdateOnce performs BatchUpdate unless a batch with the same ID has been applied
+	// within AppliedBatchRetention period. Batch ID is remembered atomically with the update,
+	// false is returned without updating metrics if the batch is a duplicate
+	BatchUpdateOnce(ctx context.Context, batchID model.BatchID, metrics []*model.Metrics) (bool, error)
+
 	// GetAllSorted should return the full list of metrics sorted by ID lexicographically
+	// (series with the same ID are sorted by labels canonical form)
 	GetAllSorted(ctx context.Context) ([]*model.Metrics, error)
 
+	// FindSorted returns metrics matching the filter in the same order as GetAllSorted does
+	FindSorted(ctx context.Context, filter *model.Filter) ([]*model.Metrics, error)
+
 	// SetAll allows to bulk set data from an external source (no validations performed)
 	SetAll(ctx context.Context, metrics []*model.Metrics) error
 
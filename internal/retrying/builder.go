@@ -15,6 +15,7 @@ func defaultRetryablePredicate(err error) bool {
 // - retry policy, should always be provided
 // - retryablePredicate - customizable method to determine if execution error is retryable
 // - zap logger with optional custom prefix - can be used to log retry attempts in executor
+// - observer - can be used to count retry attempts in executor
 type ExecutorBuilder struct {
 	e *Executor
 }
@@ -49,3 +50,10 @@ func (b *ExecutorBuilder) WithRetryablePredicate(predicate func(err error) bool)
 	b.e.isRetryablePredicate = predicate
 	return b
 }
+
+// WithObserver adds Observer notified about retry attempts and exhausted retries
+// If this method is not called, retry attempts are not observed
+func (b *ExecutorBuilder) WithObserver(o Observer) *ExecutorBuilder {
+	b.e.observer = o
+	return b
+}
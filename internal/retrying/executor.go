@@ -14,6 +14,15 @@ type Executor struct {
 	logger               *zap.SugaredLogger
 	logPrefix            string
 	isRetryablePredicate func(err error) bool
+	observer             Observer
+}
+
+// Observer is notified about retries performed by Executor, e.g. to count them in server self-metrics
+type Observer interface {
+	// ObserveRetry is called before each retry attempt
+	ObserveRetry(attempt int)
+	// ObserveExhausted is called when execution fails after the last allowed retry attempt
+	ObserveExhausted()
 }
 
 // Run performs one or more executions of provided function f - until it either returns no error or
@@ -44,6 +53,9 @@ func (e *Executor) Run(f func() error) error {
 		time.Sleep(delay)
 
 		attempt += 1
+		if e.observer != nil {
+			e.observer.ObserveRetry(attempt)
+		}
 		e.logInfo("retry attempt",
 			"attempt", attempt,
 		)
@@ -63,6 +75,9 @@ func (e *Executor) Run(f func() error) error {
 	}
 
 	e.logError("max number of retry attempts reached")
+	if e.observer != nil {
+		e.observer.ObserveExhausted()
+	}
 	if errors.Is(err, &RetryableError{}) {
 		return errors.Unwrap(err)
 	}
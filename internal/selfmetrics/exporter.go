@@ -0,0 +1,110 @@
+package selfmetrics
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/andrewsvn/metrics-overseer/internal/model"
+	"github.com/andrewsvn/metrics-overseer/internal/repository"
+	"go.uber.org/zap"
+)
+
+// Exporter periodically writes self-metrics to the server storage, so they are available in the same way
+// as metrics sent by clients (including history). Registry keeps cumulative values, so only increments
+// since the previous export are written for counters and histograms, and gauges are written when changed.
+// Storage is updated directly - self-metrics updates are not audited. It must not be an ObservedStorage,
+// otherwise each export changes storage operation metrics, and they are exported again on the next run
+type Exporter struct {
+	reg      *Registry
+	storage  repository.Storage
+	exported map[string]*model.Metrics
+	logger   *zap.SugaredLogger
+}
+
+func NewExporter(reg *Registry, st repository.Storage, logger *zap.Logger) *Exporter {
+	return &Exporter{
+		reg:      reg,
+		storage:  st,
+		exported: make(map[string]*model.Metrics),
+		logger:   logger.Sugar().With(zap.String("component", "self-metrics-exporter")),
+	}
+}
+
+// Run exports self-metrics with given interval until context is done, the last export is made on exit
+func (e *Exporter) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			e.export(context.Background())
+			return
+		case <-ticker.C:
+			e.export(ctx)
+		}
+	}
+}
+
+func (e *Exporter) export(ctx context.Context) {
+	if err := e.Export(ctx); err != nil {
+		e.logger.Errorw("error exporting self-metrics", "error", err)
+	}
+}
+
+// Export writes changes of self-metrics since the previous successful export to storage
+func (e *Exporter) Export(ctx context.Context) error {
+	current := e.reg.Snapshot()
+
+	updates := make([]*model.Metrics, 0, len(current))
+	for _, m := range current {
+		if upd := increment(e.exported[m.Key()], m); upd != nil {
+			updates = append(updates, upd)
+		}
+	}
+	if len(updates) == 0 {
+		return nil
+	}
+
+	if err := e.storage.BatchUpdate(ctx, updates); err != nil {
+		return fmt.Errorf("can't write self-metrics to storage: %w", err)
+	}
+	for _, m := range current {
+		e.exported[m.Key()] = m
+	}
+	return nil
+}
+
+// increment returns an update turning previously exported metric value into the current one,
+// nil is returned if metric is not changed
+func increment(prev, cur *model.Metrics) *model.Metrics {
+	if prev == nil {
+		return cur
+	}
+
+	switch cur.MType {
+	case model.Counter:
+		if *cur.Delta == *prev.Delta {
+			return nil
+		}
+		return model.NewCounterMetricsWithDelta(cur.ID, *cur.Delta-*prev.Delta).WithLabels(cur.Labels)
+	case model.Gauge:
+		if *cur.Value == *prev.Value {
+			return nil
+		}
+		return cur
+	case model.Histogram:
+		if cur.Histogram.Count == prev.Histogram.Count {
+			return nil
+		}
+		hist := cur.Histogram.Clone()
+		for i, c := range prev.Histogram.Counts {
+			hist.Counts[i] -= c
+		}
+		hist.Count -= prev.Histogram.Count
+		hist.Sum -= prev.Histogram.Sum
+		return model.NewHistogramMetricsWithValue(cur.ID, hist).WithLabels(cur.Labels)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+// Package selfmetrics collects metrics describing metrics-overseer server itself: served requests,
+// storage operations, retries and audit failures. Collected metrics are exposed in Prometheus text format
+// and exported to the server storage as ordinary metrics with IDs under reserved Prefix
+package selfmetrics
+
+import (
+	"cmp"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andrewsvn/metrics-overseer/internal/model"
+	"github.com/andrewsvn/metrics-overseer/internal/promtext"
+)
+
+// Prefix is prepended to IDs of all self-metrics, clients are not allowed to update metrics with such IDs
+const Prefix = "overseer."
+
+// IsReserved checks that metric ID belongs to self-metrics
+func IsReserved(id string) bool {
+	return strings.HasPrefix(id, Prefix)
+}
+
+type series struct {
+	id     string
+	labels model.Labels
+
+	mtype string
+	delta int64
+	value float64
+	hist  *model.HistogramValue
+	// counterFunc provides current counter value for counters maintained outside the registry
+	counterFunc func() int64
+}
+
+// Registry accumulates self-metrics in memory. Counters and histograms are cumulative since server start.
+// All methods are safe for concurrent use and do nothing on a nil Registry,
+// so components can be used without self-metrics (e.g. in tests)
+type Registry struct {
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		series: make(map[string]*series),
+	}
+}
+
+// AddCounter adds delta to a counter, creating it if needed
+func (r *Registry) AddCounter(name string, labels model.Labels, delta int64) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.getSeries(name, labels, model.Counter).delta += delta
+}
+
+// SetGauge sets a gauge value, creating it if needed
+func (r *Registry) SetGauge(name string, labels model.Labels, value float64) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.getSeries(name, labels, model.Gauge).value = value
+}
+
+// ObserveDuration puts duration in seconds into a histogram with model.DefaultHistogramBuckets
+func (r *Registry) ObserveDuration(name string, labels model.Labels, d time.Duration) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.getSeries(name, labels, model.Histogram).hist.Observe(d.Seconds())
+}
+
+// CounterFunc registers a counter whose value is read from f on each snapshot,
+// it allows exposing counters already maintained by other components
+func (r *Registry) CounterFunc(name string, labels model.Labels, f func() int64) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.getSeries(name, labels, model.Counter).counterFunc = f
+}
+
+// Snapshot returns current values of all self-metrics sorted by ID and labels
+func (r *Registry) Snapshot() []*model.Metrics {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	metrics := make([]*model.Metrics, 0, len(r.series))
+	for _, s := range r.series {
+		var m *model.Metrics
+		switch s.mtype {
+		case model.Counter:
+			delta := s.delta
+			if s.counterFunc != nil {
+				delta = s.counterFunc()
+			}
+			m = model.NewCounterMetricsWithDelta(s.id, delta)
+		case model.Gauge:
+			m = model.NewGaugeMetricsWithValue(s.id, s.value)
+		case model.Histogram:
+			m = model.NewHistogramMetricsWithValue(s.id, s.hist.Clone())
+		}
+		metrics = append(metrics, m.WithLabels(s.labels))
+	}
+	slices.SortFunc(metrics, func(a, b *model.Metrics) int {
+		return cmp.Or(cmp.Compare(a.ID, b.ID), cmp.Compare(a.Labels.String(), b.Labels.String()))
+	})
+	return metrics
+}
+
+// WritePrometheus writes current values of all self-metrics in Prometheus text exposition format
+func (r *Registry) WritePrometheus(w io.Writer) error {
+	return promtext.NewEncoder(w).Encode(r.Snapshot())
+}
+
+func (r *Registry) getSeries(name string, labels model.Labels, mtype string) *series {
+	id := Prefix + name
+	key := model.SeriesKey(id, labels)
+	s, ok := r.series[key]
+	if !ok {
+		s = &series{
+			id:     id,
+			labels: labels.Clone(),
+			mtype:  mtype,
+		}
+		if mtype == model.Histogram {
+			s.hist = model.NewHistogramValue()
+		}
+		r.series[key] = s
+	}
+	return s
+}
@@ -0,0 +1,140 @@
+package selfmetrics
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/andrewsvn/metrics-overseer/internal/model"
+	"github.com/andrewsvn/metrics-overseer/internal/repository"
+	"github.com/andrewsvn/metrics-overseer/internal/retrying"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestRegistry(t *testing.T) {
+	reg := NewRegistry()
+	reg.AddCounter("requests", model.Labels{"code": "200"}, 2)
+	reg.AddCounter("requests", model.Labels{"code": "200"}, 1)
+	reg.AddCounter("requests", model.Labels{"code": "500"}, 1)
+	reg.SetGauge("workers", nil, 4)
+	reg.ObserveDuration("latency", nil, 20*time.Millisecond)
+	var external int64 = 7
+	reg.CounterFunc("rejected", nil, func() int64 { return external })
+
+	snapshot := reg.Snapshot()
+	require.Len(t, snapshot, 5)
+	assert.Equal(t, "overseer.latency", snapshot[0].ID)
+	assert.Equal(t, int64(1), snapshot[0].Histogram.Count)
+	assert.Equal(t, "overseer.rejected", snapshot[1].ID)
+	assert.Equal(t, int64(7), *snapshot[1].Delta)
+	assert.Equal(t, model.Labels{"code": "200"}, snapshot[2].Labels)
+	assert.Equal(t, int64(3), *snapshot[2].Delta)
+	assert.Equal(t, int64(1), *snapshot[3].Delta)
+	assert.Equal(t, 4.0, *snapshot[4].Value)
+	assert.True(t, IsReserved(snapshot[4].ID))
+	assert.False(t, IsReserved("requests"))
+
+	var buf bytes.Buffer
+	require.NoError(t, reg.WritePrometheus(&buf))
+	assert.Contains(t, buf.String(), `overseer_requests{code="500"} 1`)
+	assert.Contains(t, buf.String(), `overseer_latency_bucket{le="0.025"} 1`)
+
+	// nil registry is a no-op
+	var nilReg *Registry
+	nilReg.AddCounter("requests", nil, 1)
+	nilReg.ObserveDuration("latency", nil, time.Second)
+	nilReg.RetryObserver("op").ObserveRetry(1)
+	assert.Empty(t, nilReg.Snapshot())
+}
+
+func TestExporter(t *testing.T) {
+	reg := NewRegistry()
+	st := repository.NewMemStorage()
+	exp := NewExporter(reg, st, zap.NewNop())
+	ctx := context.Background()
+
+	reg.AddCounter("requests", nil, 3)
+	reg.SetGauge("workers", nil, 4)
+	reg.ObserveDuration("latency", nil, 20*time.Millisecond)
+	require.NoError(t, exp.Export(ctx))
+
+	reg.AddCounter("requests", nil, 2)
+	reg.ObserveDuration("latency", nil, 2*time.Second)
+	require.NoError(t, exp.Export(ctx))
+	// nothing changed - nothing is written
+	require.NoError(t, exp.Export(ctx))
+
+	m, err := st.GetByID(ctx, "overseer.requests", nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), *m.Delta)
+	m, err = st.GetByID(ctx, "overseer.workers", nil)
+	require.NoError(t, err)
+	assert.Equal(t, 4.0, *m.Value)
+	m, err = st.GetByID(ctx, "overseer.latency", nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), m.Histogram.Count)
+	assert.InDelta(t, 2.02, m.Histogram.Sum, 1e-9)
+
+	history, err := st.GetHistory(ctx, "overseer.requests", nil, time.Time{}, time.Now())
+	require.NoError(t, err)
+	assert.Len(t, history, 2)
+}
+
+type failingPing struct {
+	repository.Storage
+}
+
+func (fp failingPing) Ping(_ context.Context) error {
+	return errors.New("connection refused")
+}
+
+func TestObservedStorage(t *testing.T) {
+	reg := NewRegistry()
+	st := NewObservedStorage(failingPing{repository.NewMemStorage()}, reg)
+	ctx := context.Background()
+
+	require.NoError(t, st.AddCounter(ctx, "cnt", nil, 1))
+	_, err := st.GetByID(ctx, "missing", nil)
+	require.ErrorIs(t, err, repository.ErrMetricNotFound)
+	require.Error(t, st.Ping(ctx))
+
+	errorsByOp := make(map[string]int64)
+	durationsByOp := make(map[string]int64)
+	for _, m := range reg.Snapshot() {
+		switch m.ID {
+		case Prefix + storageErrorsMetric:
+			errorsByOp[m.Labels["op"]] = *m.Delta
+		case Prefix + storageDurationMetric:
+			durationsByOp[m.Labels["op"]] = m.Histogram.Count
+		}
+	}
+	assert.Equal(t, map[string]int64{"ping": 1}, errorsByOp)
+	assert.Equal(t, map[string]int64{"add_counter": 1, "get_by_id": 1, "ping": 1}, durationsByOp)
+}
+
+func TestRetryObserver(t *testing.T) {
+	reg := NewRegistry()
+	retrier := retrying.NewExecutorBuilder(retrying.NewLinearPolicy(2, 0, 0)).
+		WithObserver(reg.RetryObserver("query")).
+		Build()
+
+	calls := 0
+	err := retrier.Run(func() error {
+		calls++
+		return retrying.NewRetryableError(errors.New("temporary failure"))
+	})
+	require.Error(t, err)
+	assert.Equal(t, 3, calls)
+
+	snapshot := reg.Snapshot()
+	require.Len(t, snapshot, 2)
+	assert.Equal(t, Prefix+retryAttemptsMetric, snapshot[0].ID)
+	assert.Equal(t, int64(2), *snapshot[0].Delta)
+	assert.Equal(t, Prefix+retryExhaustedMetric, snapshot[1].ID)
+	assert.Equal(t, int64(1), *snapshot[1].Delta)
+	assert.Equal(t, model.Labels{"operation": "query"}, snapshot[1].Labels)
+}
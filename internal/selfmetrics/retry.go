@@ -0,0 +1,32 @@
+package selfmetrics
+
+import (
+	"github.com/andrewsvn/metrics-overseer/internal/model"
+	"github.com/andrewsvn/metrics-overseer/internal/retrying"
+)
+
+const (
+	retryAttemptsMetric  = "retry.attempts"
+	retryExhaustedMetric = "retry.exhausted"
+)
+
+type retryObserver struct {
+	reg    *Registry
+	labels model.Labels
+}
+
+// RetryObserver returns retrying.Observer counting retry attempts and exhausted retries of an operation
+func (r *Registry) RetryObserver(operation string) retrying.Observer {
+	return &retryObserver{
+		reg:    r,
+		labels: model.Labels{"operation": operation},
+	}
+}
+
+func (o *retryObserver) ObserveRetry(_ int) {
+	o.reg.AddCounter(retryAttemptsMetric, o.labels, 1)
+}
+
+func (o *retryObserver) ObserveExhausted() {
+	o.reg.AddCounter(retryExhaustedMetric, o.labels, 1)
+}
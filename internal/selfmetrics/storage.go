@@ -0,0 +1,162 @@
+package selfmetrics
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/andrewsvn/metrics-overseer/internal/model"
+	"github.com/andrewsvn/metrics-overseer/internal/repository"
+)
+
+const (
+	storageDurationMetric = "storage.operation.duration.seconds"
+	storageErrorsMetric   = "storage.errors"
+)
+
+// ObservedStorage is a repository.Storage decorator recording duration of each storage operation
+// and number of failed operations. Missing metrics and wrong access methods are client errors,
+// so they are not counted as storage errors
+type ObservedStorage struct {
+	st  repository.Storage
+	reg *Registry
+}
+
+func NewObservedStorage(st repository.Storage, reg *Registry) *ObservedStorage {
+	return &ObservedStorage{
+		st:  st,
+		reg: reg,
+	}
+}
+
+func (obs *ObservedStorage) SetGauge(ctx context.Context, id string, labels model.Labels, value float64) error {
+	return obs.observe("set_gauge", func() error {
+		return obs.st.SetGauge(ctx, id, labels, value)
+	})
+}
+
+func (obs *ObservedStorage) AddCounter(ctx context.Context, id string, labels model.Labels, delta int64) error {
+	return obs.observe("add_counter", func() error {
+		return obs.st.AddCounter(ctx, id, labels, delta)
+	})
+}
+
+func (obs *ObservedStorage) AddHistogram(
+	ctx context.Context,
+	id string,
+	labels model.Labels,
+	hist *model.HistogramValue,
+) error {
+	return obs.observe("add_histogram", func() error {
+		return obs.st.AddHistogram(ctx, id, labels, hist)
+	})
+}
+
+func (obs *ObservedStorage) AddSummary(
+	ctx context.Context,
+	id string,
+	labels model.Labels,
+	summary *model.SummaryValue,
+) error {
+	return obs.observe("add_summary", func() error {
+		return obs.st.AddSummary(ctx, id, labels, summary)
+	})
+}
+
+func (obs *ObservedStorage) GetByID(ctx context.Context, id string, labels model.Labels) (*model.Metrics, error) {
+	var m *model.Metrics
+	err := obs.observe("get_by_id", func() error {
+		var err error
+		m, err = obs.st.GetByID(ctx, id, labels)
+		return err
+	})
+	return m, err
+}
+
+func (obs *ObservedStorage) GetHistory(
+	ctx context.Context,
+	id string,
+	labels model.Labels,
+	from, to time.Time,
+) ([]*model.Sample, error) {
+	var samples []*model.Sample
+	err := obs.observe("get_history", func() error {
+		var err error
+		samples, err = obs.st.GetHistory(ctx, id, labels, from, to)
+		return err
+	})
+	return samples, err
+}
+
+func (obs *ObservedStorage) BatchUpdate(ctx context.Context, metrics []*model.Metrics) error {
+	return obs.observe("batch_update", func() error {
+		return obs.st.BatchUpdate(ctx, metrics)
+	})
+}
+
+func (obs *ObservedStorage) BatchUpdateOnce(
+	ctx context.Context,
+	batchID model.BatchID,
+	metrics []*model.Metrics,
+) (bool, error) {
+	var applied bool
+	err := obs.observe("batch_update_once", func() error {
+		var err error
+		applied, err = obs.st.BatchUpdateOnce(ctx, batchID, metrics)
+		return err
+	})
+	return applied, err
+}
+
+func (obs *ObservedStorage) GetAllSorted(ctx context.Context) ([]*model.Metrics, error) {
+	var metrics []*model.Metrics
+	err := obs.observe("get_all", func() error {
+		var err error
+		metrics, err = obs.st.GetAllSorted(ctx)
+		return err
+	})
+	return metrics, err
+}
+
+func (obs *ObservedStorage) FindSorted(ctx context.Context, filter *model.Filter) ([]*model.Metrics, error) {
+	var metrics []*model.Metrics
+	err := obs.observe("find", func() error {
+		var err error
+		metrics, err = obs.st.FindSorted(ctx, filter)
+		return err
+	})
+	return metrics, err
+}
+
+func (obs *ObservedStorage) SetAll(ctx context.Context, metrics []*model.Metrics) error {
+	return obs.observe("set_all", func() error {
+		return obs.st.SetAll(ctx, metrics)
+	})
+}
+
+func (obs *ObservedStorage) ResetAll(ctx context.Context) error {
+	return obs.observe("reset_all", func() error {
+		return obs.st.ResetAll(ctx)
+	})
+}
+
+func (obs *ObservedStorage) Ping(ctx context.Context) error {
+	return obs.observe("ping", func() error {
+		return obs.st.Ping(ctx)
+	})
+}
+
+func (obs *ObservedStorage) Close() error {
+	return obs.st.Close()
+}
+
+func (obs *ObservedStorage) observe(op string, f func() error) error {
+	labels := model.Labels{"op": op}
+	start := time.Now()
+	err := f()
+	obs.reg.ObserveDuration(storageDurationMetric, labels, time.Since(start))
+	if err != nil && !errors.Is(err, repository.ErrMetricNotFound) && !errors.Is(err, repository.ErrIncorrectAccess) {
+		obs.reg.AddCounter(storageErrorsMetric, labels, 1)
+	}
+	return err
+}
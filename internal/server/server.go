@@ -4,22 +4,31 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"os"
 	"os/signal"
 	"strings"
 	"syscall"
 	"time"
 
+	"github.com/andrewsvn/metrics-overseer/internal/apitoken"
 	"github.com/andrewsvn/metrics-overseer/internal/audit"
 	"github.com/andrewsvn/metrics-overseer/internal/config/servercfg"
 	"github.com/andrewsvn/metrics-overseer/internal/db"
+	"github.com/andrewsvn/metrics-overseer/internal/grpcapi"
 	"github.com/andrewsvn/metrics-overseer/internal/handler"
 	"github.com/andrewsvn/metrics-overseer/internal/logging"
 	"github.com/andrewsvn/metrics-overseer/internal/repository"
 	"github.com/andrewsvn/metrics-overseer/internal/retrying"
+	"github.com/andrewsvn/metrics-overseer/internal/selfmetrics"
 	"github.com/andrewsvn/metrics-overseer/internal/service"
+	"github.com/andrewsvn/metrics-overseer/internal/statsd"
+	"github.com/andrewsvn/metrics-overseer/internal/subnet"
+	"github.com/andrewsvn/metrics-overseer/internal/tlsconfig"
 	"github.com/andrewsvn/metrics-overseer/migrations"
 	"go.uber.org/zap"
+	"google.golang.org/grpc"
 
 	_ "net/http/pprof"
 )
@@ -36,10 +45,15 @@ func Run() error {
 	}
 	sl := logger.Sugar()
 
-	stor, err := InitializeStorage(cfg, logger)
+	selfMetrics := selfmetrics.NewRegistry()
+
+	rawStor, err := InitializeStorage(cfg, logger, selfMetrics)
 	if err != nil {
 		return fmt.Errorf("can't initialize storage: %w", err)
 	}
+	// self-metrics exporter writes to the raw storage, otherwise each export would change
+	// storage operation metrics and the next export would have something to write forever
+	stor := selfmetrics.NewObservedStorage(rawStor, selfMetrics)
 	defer func() {
 		err := stor.Close()
 		if err != nil {
@@ -48,6 +62,7 @@ func Run() error {
 	}()
 
 	msrv := service.NewMetricsService(stor, logger)
+	msrv.UseSelfMetrics(selfMetrics)
 	var fw *audit.FileWriter
 	if cfg.AuditFilePath != "" {
 		sl.Infow("subscribing file auditor", "path", cfg.AuditFilePath)
@@ -59,7 +74,17 @@ func Run() error {
 		msrv.SubscribeAuditor(audit.NewHTTPWriter(cfg.AuditURL))
 	}
 
-	mhandlers, err := handler.NewMetricsHandlers(msrv, &cfg.SecurityConfig, logger)
+	tokenStore, closeTokenStore, err := InitializeTokenStore(cfg, logger)
+	if err != nil {
+		return fmt.Errorf("can't initialize API token store: %w", err)
+	}
+	defer closeTokenStore()
+	tokens := apitoken.NewRegistry(tokenStore, logger)
+	if err := tokens.Reload(context.Background()); err != nil {
+		return fmt.Errorf("can't load API tokens: %w", err)
+	}
+
+	mhandlers, err := handler.NewMetricsHandlers(msrv, &cfg.SecurityConfig, tokens, selfMetrics, logger)
 	if err != nil {
 		return fmt.Errorf("can't initialize metrics handlers: %w", err)
 	}
@@ -70,19 +95,87 @@ func Run() error {
 		Addr:    addr,
 		Handler: r,
 	}
+	if cfg.TLSConfig.IsSetUp() {
+		server.TLSConfig, err = tlsconfig.NewServerConfig(cfg.TLSCertPath, cfg.TLSKeyPath, cfg.TLSClientCAPath)
+		if err != nil {
+			return fmt.Errorf("can't configure TLS: %w", err)
+		}
+	}
 
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 	defer cancel()
 
+	// API tokens are reloaded periodically and on SIGHUP
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	go tokens.Watch(ctx, time.Duration(cfg.TokensReloadIntervalSec)*time.Second, hup)
+
+	// self-metrics are written to storage until shutdown, the last export happens before storage is closed
+	exporterDone := make(chan struct{})
+	go func(ctx context.Context) {
+		defer close(exporterDone)
+		selfmetrics.NewExporter(selfMetrics, rawStor, logger).
+			Run(ctx, time.Duration(cfg.SelfMetricsIntervalSec)*time.Second)
+	}(ctx)
+
 	go func() {
 		logger.Sugar().Infow("starting metric-overseer server",
 			"address", addr,
+			"tls", server.TLSConfig != nil,
 		)
-		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		var err error
+		if server.TLSConfig != nil {
+			// certificates are already loaded into TLS config
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			logger.Fatal("failed to start server", zap.Error(err))
 		}
 	}()
 
+	// gRPC server
+	var gs *grpc.Server
+	if cfg.GRPCAddr != "" {
+		gs, err = grpcapi.NewGRPCServer(msrv, &cfg.SecurityConfig, tokens, server.TLSConfig, selfMetrics, logger)
+		if err != nil {
+			return fmt.Errorf("can't initialize gRPC server: %w", err)
+		}
+		lis, err := net.Listen("tcp", cfg.GRPCAddr)
+		if err != nil {
+			return fmt.Errorf("can't listen gRPC address: %w", err)
+		}
+		go func() {
+			logger.Sugar().Infow("starting metric-overseer gRPC server",
+				"address", cfg.GRPCAddr)
+			if err := gs.Serve(lis); err != nil {
+				logger.Fatal("failed to start gRPC server", zap.Error(err))
+			}
+		}()
+	}
+
+	// StatsD listener
+	var sdl *statsd.Listener
+	if cfg.StatsDConfig.IsSetUp() {
+		subnets, err := subnet.NewChecker(cfg.TrustedSubnets)
+		if err != nil {
+			return fmt.Errorf("can't initialize statsd listener: %w", err)
+		}
+		sdl, err = statsd.NewListener(cfg.StatsDAddr, cfg.StatsDMaxPacketSize, subnets, msrv, logger)
+		if err != nil {
+			return fmt.Errorf("can't initialize statsd listener: %w", err)
+		}
+		go func() {
+			logger.Sugar().Infow("starting statsd listener",
+				"address", cfg.StatsDAddr)
+			if err := sdl.Serve(); err != nil {
+				logger.Fatal("statsd listener failed", zap.Error(err))
+			}
+		}()
+	}
+
 	// pprof server
 	if cfg.PprofAddr != "" {
 		go func() {
@@ -102,7 +195,16 @@ func Run() error {
 	if err := server.Shutdown(ctx); err != nil {
 		return fmt.Errorf("failed to shutdown server: %w", err)
 	}
+	if gs != nil {
+		gs.GracefulStop()
+	}
+	if sdl != nil {
+		if err := sdl.Close(); err != nil {
+			logger.Error("failed to close statsd listener", zap.Error(err))
+		}
+	}
 
+	<-exporterDone
 	err = stor.Close()
 	if err != nil {
 		logger.Error("failed to close storage", zap.Error(err))
@@ -116,7 +218,13 @@ func Run() error {
 	return nil
 }
 
-func InitializeStorage(cfg *servercfg.Config, logger *zap.Logger) (repository.Storage, error) {
+// InitializeStorage chooses storage depending on configuration, retries of database operations
+// are recorded in self-metrics
+func InitializeStorage(
+	cfg *servercfg.Config,
+	logger *zap.Logger,
+	selfMetrics *selfmetrics.Registry,
+) (repository.Storage, error) {
 	if cfg.DatabaseConfig.IsSetUp() {
 		dbcfg := &cfg.DatabaseConfig
 
@@ -138,7 +246,7 @@ func InitializeStorage(cfg *servercfg.Config, logger *zap.Logger) (repository.St
 			time.Duration(cfg.InitialRetryDelaySec)*time.Second,
 			time.Duration(cfg.RetryDelayIncrementSec)*time.Second,
 		)
-		return repository.NewPostgresDBStorage(dbconn, logger, policy), nil
+		return repository.NewPostgresDBStorage(dbconn, logger, policy, selfMetrics.RetryObserver("postgres")), nil
 	}
 
 	if cfg.FileStorageConfig.IsSetUp() {
@@ -147,5 +255,29 @@ func InitializeStorage(cfg *servercfg.Config, logger *zap.Logger) (repository.St
 	}
 
 	logger.Info("initializing memory storage")
-	return repository.NewMemStorage(), nil
+	return repository.NewMemStorageWithHistorySize(cfg.HistorySize), nil
+}
+
+// InitializeTokenStore chooses API token store depending on configuration, nil store is returned
+// if tokens are not configured. Returned function releases resources held by the store
+func InitializeTokenStore(cfg *servercfg.Config, logger *zap.Logger) (apitoken.Store, func(), error) {
+	noop := func() {}
+	if cfg.TokensFilePath != "" {
+		logger.Info("reading API tokens from file", zap.String("path", cfg.TokensFilePath))
+		return apitoken.NewFileStore(cfg.TokensFilePath), noop, nil
+	}
+
+	if cfg.TokensFromDB {
+		if !cfg.DatabaseConfig.IsSetUp() {
+			return nil, noop, errors.New("database must be set up to read API tokens from it")
+		}
+		logger.Info("reading API tokens from database")
+		dbconn, err := db.NewPostgresDB(context.Background(), &cfg.DatabaseConfig)
+		if err != nil {
+			return nil, noop, fmt.Errorf("can't create postgres database connection pool: %w", err)
+		}
+		return apitoken.NewDBStore(dbconn), dbconn.Close, nil
+	}
+
+	return nil, noop, nil
 }
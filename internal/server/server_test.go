@@ -2,21 +2,40 @@ package server
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/andrewsvn/metrics-overseer/internal/agent/sending"
+	"github.com/andrewsvn/metrics-overseer/internal/apitoken"
 	"github.com/andrewsvn/metrics-overseer/internal/config/servercfg"
 	"github.com/andrewsvn/metrics-overseer/internal/db"
+	"github.com/andrewsvn/metrics-overseer/internal/encrypt"
 	"github.com/andrewsvn/metrics-overseer/internal/logging"
 	"github.com/andrewsvn/metrics-overseer/internal/mocks"
+	"github.com/andrewsvn/metrics-overseer/internal/remotewrite"
 	"github.com/andrewsvn/metrics-overseer/internal/retrying"
+	"github.com/andrewsvn/metrics-overseer/internal/selfmetrics"
+	"github.com/golang/snappy"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/stretchr/testify/mock"
 
@@ -61,6 +80,64 @@ func TestUpdateByPathHandler(t *testing.T) {
 				code: http.StatusOK,
 			},
 		},
+		{
+			name:   "histogram_metric",
+			method: http.MethodPost,
+			url:    "/update/histogram/hist1/0.3",
+			want: testWant{
+				code: http.StatusOK,
+			},
+		},
+		{
+			name:   "wrong_histogram_metric_value",
+			method: http.MethodPost,
+			url:    "/update/histogram/hist1/NaN",
+			want: testWant{
+				code:     http.StatusBadRequest,
+				response: "invalid metric value: NaN",
+			},
+		},
+		{
+			name:   "infinite_histogram_metric_value",
+			method: http.MethodPost,
+			url:    "/update/histogram/hist1/+Inf",
+			want: testWant{
+				code:     http.StatusBadRequest,
+				response: "invalid metric value: +Inf",
+			},
+		},
+		{
+			name:   "labeled_gauge_metric",
+			method: http.MethodPost,
+			url:    "/update/gauge/cpu/0.9?label.host=c&label.core=2",
+			want: testWant{
+				code: http.StatusOK,
+			},
+		},
+		{
+			name:   "metric_id_with_braces",
+			method: http.MethodPost,
+			url:    "/update/gauge/cpu%7Bhost=%22c%22%7D/0.9",
+			want: testWant{
+				code: http.StatusBadRequest,
+			},
+		},
+		{
+			name:   "wrong_label_name",
+			method: http.MethodPost,
+			url:    "/update/gauge/cpu/0.9?label.host-name=c",
+			want: testWant{
+				code: http.StatusBadRequest,
+			},
+		},
+		{
+			name:   "summary_metric",
+			method: http.MethodPost,
+			url:    "/update/summary/sum1/4",
+			want: testWant{
+				code: http.StatusOK,
+			},
+		},
 		{
 			name:   "wrong_http_method",
 			method: http.MethodPut,
@@ -151,6 +228,108 @@ func TestUpdateValueByJSONHandler(t *testing.T) {
 				code: http.StatusOK,
 			},
 		},
+		{
+			name:   "histogram_metric",
+			method: http.MethodPost,
+			body:   `{"id": "hist1", "type": "histogram", "histogram": {"bounds": [1, 5], "counts": [1, 0, 2], "count": 3, "sum": 20.5}}`,
+			want: testWant{
+				code: http.StatusOK,
+			},
+		},
+		{
+			name:   "histogram_metric_buckets_mismatch",
+			method: http.MethodPost,
+			body:   `{"id": "hist1", "type": "histogram", "histogram": {"bounds": [1, 10], "counts": [1, 0, 0], "count": 1, "sum": 0.5}}`,
+			want: testWant{
+				code:     http.StatusBadRequest,
+				response: "histogram buckets don't match stored ones",
+			},
+		},
+		{
+			name:   "histogram_metric_huge_sum",
+			method: http.MethodPost,
+			body:   `{"id": "hist1", "type": "histogram", "histogram": {"bounds": [1, 5], "counts": [0, 0, 1], "count": 1, "sum": 1.7e308}}`,
+			want: testWant{
+				code: http.StatusOK,
+			},
+		},
+		{
+			name:   "histogram_metric_sum_overflow",
+			method: http.MethodPost,
+			body:   `{"id": "hist1", "type": "histogram", "histogram": {"bounds": [1, 5], "counts": [0, 0, 1], "count": 1, "sum": 1.7e308}}`,
+			want: testWant{
+				code:     http.StatusBadRequest,
+				response: "accumulated metric value overflows",
+			},
+		},
+		{
+			name:   "inconsistent_histogram_metric",
+			method: http.MethodPost,
+			body:   `{"id": "hist1", "type": "histogram", "histogram": {"bounds": [1, 5], "counts": [1, 0], "count": 1, "sum": 0.5}}`,
+			want: testWant{
+				code: http.StatusBadRequest,
+			},
+		},
+		{
+			name:   "missing_histogram_metric_value",
+			method: http.MethodPost,
+			body:   `{"id": "hist1", "type": "histogram", "value": 1.05}`,
+			want: testWant{
+				code:     http.StatusBadRequest,
+				response: "missing histogram metric value",
+			},
+		},
+		{
+			name:   "summary_metric",
+			method: http.MethodPost,
+			body: `{"id": "sum1", "type": "summary", "summary": {"count": 2, "sum": 9, "min": 4, "max": 5,` +
+				` "centroids": [{"mean": 4, "weight": 1}, {"mean": 5, "weight": 1}]}}`,
+			want: testWant{
+				code: http.StatusOK,
+			},
+		},
+		{
+			name:   "labeled_counter_metric",
+			method: http.MethodPost,
+			body:   `{"id": "cnt1", "type": "counter", "delta": 1, "labels": {"host": "a"}}`,
+			want: testWant{
+				code: http.StatusOK,
+			},
+		},
+		{
+			name:   "labeled_metric_type_mismatch",
+			method: http.MethodPost,
+			body:   `{"id": "cpu", "type": "counter", "delta": 1, "labels": {"host": "a", "core": "1"}}`,
+			want: testWant{
+				code:     http.StatusBadRequest,
+				response: "wrong metric type",
+			},
+		},
+		{
+			name:   "wrong_label_name",
+			method: http.MethodPost,
+			body:   `{"id": "cnt1", "type": "counter", "delta": 1, "labels": {"1host": "a"}}`,
+			want: testWant{
+				code: http.StatusBadRequest,
+			},
+		},
+		{
+			name:   "inconsistent_summary_metric",
+			method: http.MethodPost,
+			body:   `{"id": "sum1", "type": "summary", "summary": {"count": 3, "centroids": [{"mean": 4, "weight": 1}]}}`,
+			want: testWant{
+				code: http.StatusBadRequest,
+			},
+		},
+		{
+			name:   "missing_summary_metric_value",
+			method: http.MethodPost,
+			body:   `{"id": "sum1", "type": "summary", "value": 1.05}`,
+			want: testWant{
+				code:     http.StatusBadRequest,
+				response: "missing summary metric value",
+			},
+		},
 		{
 			name:   "wrong_http_method",
 			method: http.MethodPut,
@@ -239,6 +418,47 @@ func TestGetPlainValueHandler(t *testing.T) {
 				resultType:  model.Gauge,
 			},
 		},
+		{
+			name:   "get_existing_labeled_gauge",
+			method: http.MethodGet,
+			url:    "/value/gauge/cpu?label.host=a&label.core=1",
+			want: testWant{
+				code:        http.StatusOK,
+				response:    "0.5",
+				contentType: "text/plain",
+				resultType:  model.Gauge,
+			},
+		},
+		{
+			name:   "get_labeled_gauge_ignoring_other_params",
+			method: http.MethodGet,
+			url:    "/value/gauge/cpu?label.host=a&label.core=1&_=1700000000",
+			want: testWant{
+				code:        http.StatusOK,
+				response:    "0.5",
+				contentType: "text/plain",
+				resultType:  model.Gauge,
+			},
+		},
+		{
+			name:   "get_labeled_gauge_by_partial_labels",
+			method: http.MethodGet,
+			url:    "/value/gauge/cpu?label.host=a",
+			want: testWant{
+				code: http.StatusNotFound,
+			},
+		},
+		{
+			name:   "get_existing_summary",
+			method: http.MethodGet,
+			url:    "/value/summary/sum1",
+			want: testWant{
+				code:        http.StatusOK,
+				response:    "count=3 min=1 max=3 p50=2 p90=3 p99=3",
+				contentType: "text/plain",
+				resultType:  model.Summary,
+			},
+		},
 		{
 			name:   "get_nonexisting_counter",
 			method: http.MethodGet,
@@ -354,6 +574,16 @@ func TestGetJSONValueHandler(t *testing.T) {
 				contentType: "application/json",
 			},
 		},
+		{
+			name:   "get_existing_labeled_gauge",
+			method: http.MethodPost,
+			body:   `{ "id": "cpu", "type": "gauge", "labels": { "core": "1", "host": "b" } }`,
+			want: testWant{
+				code:        http.StatusOK,
+				response:    `{ "id": "cpu", "type": "gauge", "value": 0.7, "labels": { "core": "1", "host": "b" } }`,
+				contentType: "application/json",
+			},
+		},
 		{
 			name:   "get_nonexisting_counter",
 			method: http.MethodPost,
@@ -434,6 +664,194 @@ func getJSONValueHandlerSingleTest(t *testing.T, test testCase, srv *httptest.Se
 	}
 }
 
+func TestGetJSONValuesHandler(t *testing.T) {
+	tests := []testCase{
+		{
+			name:   "get_by_id",
+			method: http.MethodPost,
+			body:   `{ "id": "cpu" }`,
+			want: testWant{
+				code: http.StatusOK,
+				response: `[
+					{ "id": "cpu", "type": "gauge", "value": 0.5, "labels": { "core": "1", "host": "a" } },
+					{ "id": "cpu", "type": "gauge", "value": 0.7, "labels": { "core": "1", "host": "b" } }
+				]`,
+				contentType: "application/json",
+			},
+		},
+		{
+			name:   "get_by_labels",
+			method: http.MethodPost,
+			body:   `{ "labels": { "host": "b" } }`,
+			want: testWant{
+				code:        http.StatusOK,
+				response:    `[{ "id": "cpu", "type": "gauge", "value": 0.7, "labels": { "core": "1", "host": "b" } }]`,
+				contentType: "application/json",
+			},
+		},
+		{
+			name:   "get_by_type",
+			method: http.MethodPost,
+			body:   `{ "type": "counter" }`,
+			want: testWant{
+				code:        http.StatusOK,
+				response:    `[{ "id": "cnt1", "type": "counter", "delta": 10 }]`,
+				contentType: "application/json",
+			},
+		},
+		{
+			name:   "get_nothing",
+			method: http.MethodPost,
+			body:   `{ "id": "cpu", "labels": { "host": "c" } }`,
+			want: testWant{
+				code:        http.StatusOK,
+				response:    `[]`,
+				contentType: "application/json",
+			},
+		},
+		{
+			name:   "get_unknown_metric_type",
+			method: http.MethodPost,
+			body:   `{ "type": "string" }`,
+			want: testWant{
+				code: http.StatusBadRequest,
+			},
+		},
+	}
+
+	srv := setupServerWithMemStorage()
+	defer srv.Close()
+
+	for _, test := range tests {
+		getJSONValuesHandlerSingleTest(t, test, srv)
+	}
+}
+
+func getJSONValuesHandlerSingleTest(t *testing.T, test testCase, srv *httptest.Server) {
+	req, err := http.NewRequest(test.method, srv.URL+"/values", bytes.NewBufferString(test.body))
+	require.NoError(t, err)
+
+	res, err := srv.Client().Do(req)
+	require.NoError(t, err)
+	defer func() {
+		_ = res.Body.Close()
+	}()
+
+	assert.Equal(t, test.want.code, res.StatusCode)
+	if test.want.contentType != "" {
+		assert.Equal(t, test.want.contentType, strings.Split(res.Header.Get("Content-Type"), ";")[0])
+	}
+
+	resBody, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	if test.want.response != "" {
+		assert.JSONEq(t, test.want.response, string(resBody))
+	}
+}
+
+func TestGetHistoryHandler(t *testing.T) {
+	tests := []testCase{
+		{
+			name:   "get_counter_history",
+			method: http.MethodGet,
+			url:    "/history/counter/cnt1",
+			want: testWant{
+				code:        http.StatusOK,
+				response:    `[{ "delta": 10 }, { "delta": 15 }]`,
+				contentType: "application/json",
+			},
+		},
+		{
+			name:   "get_labeled_gauge_history",
+			method: http.MethodGet,
+			url:    "/history/gauge/cpu?label.host=b&label.core=1",
+			want: testWant{
+				code:        http.StatusOK,
+				response:    `[{ "value": 0.7 }]`,
+				contentType: "application/json",
+			},
+		},
+		{
+			name:   "get_history_out_of_range",
+			method: http.MethodGet,
+			url:    "/history/counter/cnt1?from=2020-01-01T00:00:00Z&to=1600000000",
+			want: testWant{
+				code:        http.StatusOK,
+				response:    `[]`,
+				contentType: "application/json",
+			},
+		},
+		{
+			name:   "get_history_wrong_range",
+			method: http.MethodGet,
+			url:    "/history/counter/cnt1?from=2020-01-02T00:00:00Z&to=2020-01-01T00:00:00Z",
+			want: testWant{
+				code: http.StatusBadRequest,
+			},
+		},
+		{
+			name:   "get_history_bad_time",
+			method: http.MethodGet,
+			url:    "/history/counter/cnt1?from=yesterday",
+			want: testWant{
+				code: http.StatusBadRequest,
+			},
+		},
+		{
+			name:   "get_history_wrong_type",
+			method: http.MethodGet,
+			url:    "/history/gauge/cnt1",
+			want: testWant{
+				code: http.StatusNotFound,
+			},
+		},
+	}
+
+	srv := setupServerWithMemStorage()
+	defer srv.Close()
+
+	req, err := http.NewRequest(http.MethodPost, srv.URL+"/update/counter/cnt1/5", nil)
+	require.NoError(t, err)
+	res, err := srv.Client().Do(req)
+	require.NoError(t, err)
+	_ = res.Body.Close()
+
+	for _, test := range tests {
+		getHistoryHandlerSingleTest(t, test, srv)
+	}
+}
+
+func getHistoryHandlerSingleTest(t *testing.T, test testCase, srv *httptest.Server) {
+	req, err := http.NewRequest(test.method, srv.URL+test.url, nil)
+	require.NoError(t, err)
+
+	res, err := srv.Client().Do(req)
+	require.NoError(t, err)
+	defer func() {
+		_ = res.Body.Close()
+	}()
+
+	assert.Equal(t, test.want.code, res.StatusCode)
+	if test.want.contentType != "" {
+		assert.Equal(t, test.want.contentType, strings.Split(res.Header.Get("Content-Type"), ";")[0])
+	}
+
+	resBody, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	if test.want.response != "" {
+		// timestamps are not predictable, so they are removed before comparison
+		var samples []map[string]any
+		require.NoError(t, json.Unmarshal(resBody, &samples))
+		for _, s := range samples {
+			assert.NotEmpty(t, s["ts"])
+			delete(s, "ts")
+		}
+		actual, err := json.Marshal(samples)
+		require.NoError(t, err)
+		assert.JSONEq(t, test.want.response, string(actual))
+	}
+}
+
 func TestGetAllMetricsPage(t *testing.T) {
 	srv := setupServerWithMemStorage()
 	defer srv.Close()
@@ -456,6 +874,797 @@ func TestGetAllMetricsPage(t *testing.T) {
 		string(resBody))
 	assert.Regexp(t, regexp.MustCompile(`(?s)<tr>.*<td>gauge1</td>\s*<td>gauge</td>\s*<td>3.14</td>`),
 		string(resBody))
+	assert.Regexp(t, regexp.MustCompile(`(?s)<tr>.*<td>hist1</td>\s*<td>histogram</td>\s*<td>count=2 sum=3.5 \[1:1 5:1 (&#43;|\+)Inf:0\]</td>`),
+		string(resBody))
+	assert.Regexp(t, regexp.MustCompile(`(?s)<tr>.*<td>sum1</td>\s*<td>summary</td>\s*<td>count=3 min=1 max=3 p50=2 p90=3 p99=3</td>`),
+		string(resBody))
+	assert.Regexp(t, regexp.MustCompile(`(?s)<tr>.*<td>cpu</td>\s*<td>gauge</td>\s*<td>0.5</td>\s*<td>{core=(&#34;|")1(&#34;|"),host=(&#34;|")a(&#34;|")}</td>`),
+		string(resBody))
+}
+
+func TestPrometheusMetrics(t *testing.T) {
+	srv := setupServerWithMemStorage()
+	defer srv.Close()
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/metrics", nil)
+	require.NoError(t, err)
+
+	res, err := srv.Client().Do(req)
+	require.NoError(t, err)
+	defer func() {
+		_ = res.Body.Close()
+	}()
+
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", res.Header.Get("Content-Type"))
+
+	resBody, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, `# TYPE cnt1 counter
+cnt1 10
+# TYPE cpu gauge
+cpu{core="1",host="a"} 0.5
+cpu{core="1",host="b"} 0.7
+# TYPE gauge1 gauge
+gauge1 3.14
+# TYPE hist1 histogram
+hist1_bucket{le="1"} 1
+hist1_bucket{le="5"} 2
+hist1_bucket{le="+Inf"} 2
+hist1_sum 3.5
+hist1_count 2
+# TYPE sum1 summary
+sum1{quantile="0.5"} 2
+sum1{quantile="0.9"} 3
+sum1{quantile="0.99"} 3
+sum1_sum 6
+sum1_count 3
+`, string(resBody))
+}
+
+type recordingAuditor struct {
+	metrics    []*model.Metrics
+	tokenNames []string
+}
+
+func (ra *recordingAuditor) OnMetricsUpdate(_ time.Time, _ string, tokenName string, metrics ...*model.Metrics) error {
+	ra.metrics = append(ra.metrics, metrics...)
+	ra.tokenNames = append(ra.tokenNames, tokenName)
+	return nil
+}
+
+func TestRemoteWrite(t *testing.T) {
+	logger, _ := logging.NewZapLogger("info")
+	msrv := service.NewMetricsService(repository.NewMemStorage(), logger)
+	auditor := &recordingAuditor{}
+	msrv.SubscribeAuditor(auditor)
+	mhandlers, _ := handler.NewMetricsHandlers(msrv, &servercfg.SecurityConfig{}, apitoken.NewRegistry(nil, logger), nil, logger)
+	srv := httptest.NewServer(mhandlers.GetRouter())
+	defer srv.Close()
+
+	payload := remotewrite.Marshal(&remotewrite.WriteRequest{
+		Timeseries: []remotewrite.TimeSeries{
+			{
+				Labels:  []remotewrite.Label{{Name: "__name__", Value: "node_load1"}, {Name: "instance", Value: "web-1"}},
+				Samples: []remotewrite.Sample{{Value: 0.25, Timestamp: 1000}, {Value: 0.5, Timestamp: 2000}},
+			},
+			{
+				Labels:  []remotewrite.Label{{Name: "__name__", Value: "up"}},
+				Samples: []remotewrite.Sample{{Value: 1, Timestamp: 2000}},
+			},
+		},
+	})
+
+	req, err := http.NewRequest(http.MethodPost, srv.URL+"/api/v1/write", bytes.NewReader(snappy.Encode(nil, payload)))
+	require.NoError(t, err)
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", remotewrite.ContentType)
+	res, err := srv.Client().Do(req)
+	require.NoError(t, err)
+	_ = res.Body.Close()
+	assert.Equal(t, http.StatusNoContent, res.StatusCode)
+
+	assert.Len(t, auditor.metrics, 2)
+
+	res, err = srv.Client().Get(srv.URL + "/value/gauge/node_load1?label.instance=web-1")
+	require.NoError(t, err)
+	resBody, err := io.ReadAll(res.Body)
+	_ = res.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "0.5", string(resBody))
+
+	// series without metric name is rejected as a whole
+	payload = remotewrite.Marshal(&remotewrite.WriteRequest{
+		Timeseries: []remotewrite.TimeSeries{
+			{
+				Labels:  []remotewrite.Label{{Name: "instance", Value: "web-1"}},
+				Samples: []remotewrite.Sample{{Value: 1, Timestamp: 1000}},
+			},
+		},
+	})
+	req, err = http.NewRequest(http.MethodPost, srv.URL+"/api/v1/write", bytes.NewReader(snappy.Encode(nil, payload)))
+	require.NoError(t, err)
+	req.Header.Set("Content-Encoding", "snappy")
+	res, err = srv.Client().Do(req)
+	require.NoError(t, err)
+	_ = res.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	// not a snappy payload
+	req, err = http.NewRequest(http.MethodPost, srv.URL+"/api/v1/write", bytes.NewReader(payload))
+	require.NoError(t, err)
+	req.Header.Set("Content-Encoding", "snappy")
+	res, err = srv.Client().Do(req)
+	require.NoError(t, err)
+	_ = res.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	assert.Len(t, auditor.metrics, 2)
+}
+
+func TestTrustedSubnet(t *testing.T) {
+	logger, _ := logging.NewZapLogger("info")
+	msrv := service.NewMetricsService(repository.NewMemStorage(), logger)
+	mhandlers, err := handler.NewMetricsHandlers(msrv, &servercfg.SecurityConfig{
+		TrustedSubnets: []string{"192.168.1.0/24"},
+	}, apitoken.NewRegistry(nil, logger), nil, logger)
+	require.NoError(t, err)
+	srv := httptest.NewServer(mhandlers.GetRouter())
+	defer srv.Close()
+
+	tests := []struct {
+		name   string
+		realIP string
+		code   int
+	}{
+		{name: "trusted_real_ip", realIP: "192.168.1.10", code: http.StatusOK},
+		{name: "untrusted_real_ip", realIP: "192.168.2.10", code: http.StatusForbidden},
+		{name: "invalid_real_ip", realIP: "localhost", code: http.StatusForbidden},
+		// test client connects from 127.0.0.1 which is out of trusted subnet
+		{name: "no_real_ip", code: http.StatusForbidden},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, srv.URL+"/update/counter/cnt1/1", nil)
+			require.NoError(t, err)
+			if test.realIP != "" {
+				req.Header.Set("X-Real-IP", test.realIP)
+			}
+			res, err := srv.Client().Do(req)
+			require.NoError(t, err)
+			_ = res.Body.Close()
+			assert.Equal(t, test.code, res.StatusCode)
+		})
+	}
+
+	// reading endpoints are not restricted
+	res, err := srv.Client().Get(srv.URL + "/value/counter/cnt1")
+	require.NoError(t, err)
+	_ = res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	_, err = handler.NewMetricsHandlers(msrv, &servercfg.SecurityConfig{
+		TrustedSubnets: []string{"192.168.1.0"},
+	}, apitoken.NewRegistry(nil, logger), nil, logger)
+	assert.Error(t, err)
+}
+
+func TestAPITokens(t *testing.T) {
+	tokensPath := filepath.Join(t.TempDir(), "tokens.json")
+	writeTokens := func(tokens ...*apitoken.Token) {
+		data, err := json.Marshal(tokens)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(tokensPath, data, 0600))
+	}
+	writeTokens(
+		&apitoken.Token{Name: "agent", Hash: apitoken.Hash("agent-token"), Scopes: []apitoken.Scope{apitoken.ScopeWrite}},
+		&apitoken.Token{Name: "grafana", Hash: apitoken.Hash("grafana-token"), Scopes: []apitoken.Scope{apitoken.ScopeRead}},
+		&apitoken.Token{Name: "ops", Hash: apitoken.Hash("ops-token"), Scopes: []apitoken.Scope{apitoken.ScopeAdmin}},
+	)
+
+	logger, _ := logging.NewZapLogger("info")
+	msrv := service.NewMetricsService(repository.NewMemStorage(), logger)
+	auditor := &recordingAuditor{}
+	msrv.SubscribeAuditor(auditor)
+	tokens := apitoken.NewRegistry(apitoken.NewFileStore(tokensPath), logger)
+	require.NoError(t, tokens.Reload(context.Background()))
+	mhandlers, err := handler.NewMetricsHandlers(msrv, &servercfg.SecurityConfig{}, tokens, nil, logger)
+	require.NoError(t, err)
+	srv := httptest.NewServer(mhandlers.GetRouter())
+	defer srv.Close()
+
+	doRequest := func(method, path, token string) int {
+		req, err := http.NewRequest(method, srv.URL+path, nil)
+		require.NoError(t, err)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		res, err := srv.Client().Do(req)
+		require.NoError(t, err)
+		_ = res.Body.Close()
+		return res.StatusCode
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		code   int
+	}{
+		{name: "update_no_token", method: http.MethodPost, path: "/update/counter/cnt1/1", code: http.StatusUnauthorized},
+		{name: "update_unknown_token", method: http.MethodPost, path: "/update/counter/cnt1/1", token: "wrong", code: http.StatusUnauthorized},
+		{name: "update_read_token", method: http.MethodPost, path: "/update/counter/cnt1/1", token: "grafana-token", code: http.StatusForbidden},
+		{name: "update_write_token", method: http.MethodPost, path: "/update/counter/cnt1/1", token: "agent-token", code: http.StatusOK},
+		{name: "update_admin_token", method: http.MethodPost, path: "/update/counter/cnt1/1", token: "ops-token", code: http.StatusOK},
+		{name: "read_no_token", method: http.MethodGet, path: "/value/counter/cnt1", code: http.StatusUnauthorized},
+		{name: "read_write_token", method: http.MethodGet, path: "/value/counter/cnt1", token: "agent-token", code: http.StatusForbidden},
+		{name: "read_read_token", method: http.MethodGet, path: "/value/counter/cnt1", token: "grafana-token", code: http.StatusOK},
+		{name: "metrics_read_token", method: http.MethodGet, path: "/metrics", token: "grafana-token", code: http.StatusOK},
+		{name: "ping_no_token", method: http.MethodGet, path: "/ping", code: http.StatusOK},
+		{name: "reload_read_token", method: http.MethodPost, path: "/admin/tokens/reload", token: "grafana-token", code: http.StatusForbidden},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.code, doRequest(test.method, test.path, test.token))
+		})
+	}
+	assert.Equal(t, []string{"agent", "ops"}, auditor.tokenNames)
+
+	// tokens are reloaded without restart: agent token is revoked and a new one is issued
+	writeTokens(
+		&apitoken.Token{Name: "agent-v2", Hash: apitoken.Hash("new-agent-token"), Scopes: []apitoken.Scope{apitoken.ScopeWrite}},
+		&apitoken.Token{Name: "ops", Hash: apitoken.Hash("ops-token"), Scopes: []apitoken.Scope{apitoken.ScopeAdmin}},
+	)
+	assert.Equal(t, http.StatusOK, doRequest(http.MethodPost, "/admin/tokens/reload", "ops-token"))
+	assert.Equal(t, http.StatusUnauthorized, doRequest(http.MethodPost, "/update/counter/cnt1/1", "agent-token"))
+	assert.Equal(t, http.StatusOK, doRequest(http.MethodPost, "/update/counter/cnt1/1", "new-agent-token"))
+	assert.Equal(t, "agent-v2", auditor.tokenNames[len(auditor.tokenNames)-1])
+
+	// broken definitions don't replace active tokens
+	require.NoError(t, os.WriteFile(tokensPath, []byte("not json"), 0600))
+	assert.Equal(t, http.StatusInternalServerError, doRequest(http.MethodPost, "/admin/tokens/reload", "ops-token"))
+	assert.Equal(t, http.StatusOK, doRequest(http.MethodPost, "/update/counter/cnt1/1", "new-agent-token"))
+}
+
+func TestSignatureReplayProtection(t *testing.T) {
+	const secretKey = "secret"
+	logger, _ := logging.NewZapLogger("info")
+
+	setup := func(strict bool) *httptest.Server {
+		msrv := service.NewMetricsService(repository.NewMemStorage(), logger)
+		mhandlers, err := handler.NewMetricsHandlers(msrv, &servercfg.SecurityConfig{
+			SecretKey:             secretKey,
+			StrictSignatures:      strict,
+			LegacySignaturesUntil: "2100-01-01T00:00:00Z",
+		}, apitoken.NewRegistry(nil, logger), nil, logger)
+		require.NoError(t, err)
+		return httptest.NewServer(mhandlers.GetRouter())
+	}
+	newRequest := func(srv *httptest.Server, body string) *http.Request {
+		req, err := http.NewRequest(http.MethodPost, srv.URL+"/updates/", strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		return req
+	}
+	doRequest := func(srv *httptest.Server, req *http.Request, body string) int {
+		req.Body = io.NopCloser(strings.NewReader(body))
+		res, err := srv.Client().Do(req)
+		require.NoError(t, err)
+		_ = res.Body.Close()
+		return res.StatusCode
+	}
+	body := `[{"id":"cnt1","type":"counter","delta":1}]`
+	keys, err := encrypt.NewKeyRingBuilder().PrimaryKey("", secretKey).Build()
+	require.NoError(t, err)
+
+	strict := setup(true)
+	defer strict.Close()
+
+	// unsigned, payload-only and legacy-signed requests are rejected in strict mode
+	req := newRequest(strict, body)
+	assert.Equal(t, http.StatusUnauthorized, doRequest(strict, req, body))
+	encrypt.AddSignature(keys, []byte(body), req.Header)
+	assert.Equal(t, http.StatusUnauthorized, doRequest(strict, req, body))
+	req = newRequest(strict, body)
+	req.Header.Set(encrypt.SignHeader, legacySignature(secretKey, body))
+	assert.Equal(t, http.StatusUnauthorized, doRequest(strict, req, body))
+
+	// request is accepted once, the same request replayed is rejected
+	req = newRequest(strict, body)
+	require.NoError(t, encrypt.SignRequest(keys, req, []byte(body)))
+	assert.Equal(t, http.StatusOK, doRequest(strict, req, body))
+	assert.Equal(t, http.StatusUnauthorized, doRequest(strict, req, body))
+
+	// signature is bound to request target
+	req = newRequest(strict, body)
+	require.NoError(t, encrypt.SignRequest(keys, req, []byte(body)))
+	req.URL.Path = "/update/"
+	assert.Equal(t, http.StatusUnauthorized, doRequest(strict, req, body))
+
+	// stale signature with a fresh nonce
+	req = newRequest(strict, body)
+	ts := time.Now().Add(-time.Hour).Unix()
+	keyID, sign := keys.Sign(encrypt.RequestContent(encrypt.RequestTarget(req), ts, "nonce", []byte(body)))
+	req.Header.Set(encrypt.SignKeyIDHeader, keyID)
+	req.Header.Set(encrypt.SignTimestampHeader, strconv.FormatInt(ts, 10))
+	req.Header.Set(encrypt.SignNonceHeader, "nonce")
+	req.Header.Set(encrypt.SignHeader, base64.StdEncoding.EncodeToString(sign))
+	assert.Equal(t, http.StatusUnauthorized, doRequest(strict, req, body))
+
+	res, err := strict.Client().Get(strict.URL + "/value/counter/cnt1")
+	require.NoError(t, err)
+	resBody, err := io.ReadAll(res.Body)
+	_ = res.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "1", string(resBody))
+
+	// without strict mode unsigned and legacy requests are accepted, but replay protection still applies
+	lenient := setup(false)
+	defer lenient.Close()
+
+	assert.Equal(t, http.StatusOK, doRequest(lenient, newRequest(lenient, body), body))
+	req = newRequest(lenient, body)
+	encrypt.AddSignature(keys, []byte(body), req.Header)
+	assert.Equal(t, http.StatusOK, doRequest(lenient, req, body))
+	req = newRequest(lenient, body)
+	req.Header.Set(encrypt.SignHeader, legacySignature(secretKey, body))
+	assert.Equal(t, http.StatusOK, doRequest(lenient, req, body))
+	req = newRequest(lenient, body)
+	require.NoError(t, encrypt.SignRequest(keys, req, []byte(body)))
+	assert.Equal(t, http.StatusOK, doRequest(lenient, req, body))
+	assert.Equal(t, http.StatusUnauthorized, doRequest(lenient, req, body))
+}
+
+func TestSigningKeyRotation(t *testing.T) {
+	logger, _ := logging.NewZapLogger("info")
+	msrv := service.NewMetricsService(repository.NewMemStorage(), logger)
+	mhandlers, err := handler.NewMetricsHandlers(msrv, &servercfg.SecurityConfig{
+		SecretKeyID:           "k2",
+		SecretKey:             "new-secret",
+		SigningKeys:           []string{"k1:old-secret"},
+		LegacySignaturesUntil: "2020-01-01T00:00:00Z",
+	}, apitoken.NewRegistry(nil, logger), nil, logger)
+	require.NoError(t, err)
+	srv := httptest.NewServer(mhandlers.GetRouter())
+	defer srv.Close()
+
+	body := `[{"id":"cnt1","type":"counter","delta":1}]`
+	doRequest := func(sign func(req *http.Request)) int {
+		req, err := http.NewRequest(http.MethodPost, srv.URL+"/updates/", strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		sign(req)
+		res, err := srv.Client().Do(req)
+		require.NoError(t, err)
+		_ = res.Body.Close()
+		return res.StatusCode
+	}
+	signWith := func(keyID string, secret string) func(req *http.Request) {
+		keys, err := encrypt.NewKeyRingBuilder().PrimaryKey(keyID, secret).Build()
+		require.NoError(t, err)
+		return func(req *http.Request) {
+			require.NoError(t, encrypt.SignRequest(keys, req, []byte(body)))
+		}
+	}
+
+	// both current and previous keys are accepted during rotation
+	assert.Equal(t, http.StatusOK, doRequest(signWith("k2", "new-secret")))
+	assert.Equal(t, http.StatusOK, doRequest(signWith("k1", "old-secret")))
+	assert.Equal(t, http.StatusUnauthorized, doRequest(signWith("k1", "new-secret")))
+	assert.Equal(t, http.StatusUnauthorized, doRequest(signWith("k3", "new-secret")))
+
+	// legacy signatures are rejected after deadline
+	assert.Equal(t, http.StatusUnauthorized, doRequest(func(req *http.Request) {
+		req.Header.Set(encrypt.SignHeader, legacySignature("old-secret", body))
+	}))
+}
+
+func TestResponseSignatures(t *testing.T) {
+	logger, _ := logging.NewZapLogger("info")
+	msrv := service.NewMetricsService(repository.NewMemStorage(), logger)
+	mhandlers, err := handler.NewMetricsHandlers(msrv, &servercfg.SecurityConfig{
+		SecretKeyID: "k2",
+		SecretKey:   "new-secret",
+		SigningKeys: []string{"k1:old-secret"},
+	}, apitoken.NewRegistry(nil, logger), nil, logger)
+	require.NoError(t, err)
+	srv := httptest.NewServer(mhandlers.GetRouter())
+	defer srv.Close()
+
+	keys, err := encrypt.NewKeyRingBuilder().PrimaryKey("k1", "old-secret").Build()
+	require.NoError(t, err)
+	doRequest := func(url string, body string) (*http.Response, []byte) {
+		req, err := http.NewRequest(http.MethodPost, srv.URL+url, strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		require.NoError(t, encrypt.SignRequest(keys, req, []byte(body)))
+		res, err := srv.Client().Do(req)
+		require.NoError(t, err)
+		resBody, err := io.ReadAll(res.Body)
+		_ = res.Body.Close()
+		require.NoError(t, err)
+
+		rs, err := encrypt.GetRequestSignature(res.Header)
+		require.NoError(t, err)
+		require.NotNil(t, rs)
+		assert.NoError(t, encrypt.CheckResponseSignature(keys, req.Header.Get(encrypt.SignNonceHeader), resBody, rs))
+		return res, resBody
+	}
+
+	// update acknowledgements are signed with the key of request signature
+	res, resBody := doRequest("/updates/", `[{"id":"cnt1","type":"counter","delta":1},{"id":"g1","type":"gauge","value":2}]`)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "k1", res.Header.Get(encrypt.SignKeyIDHeader))
+	assert.JSONEq(t, `{"updated":2}`, string(resBody))
+
+	res, resBody = doRequest("/update/", `{"id":"cnt1","type":"counter","delta":1}`)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.JSONEq(t, `{"updated":1}`, string(resBody))
+
+	res, resBody = doRequest("/value/", `{"id":"cnt1","type":"counter"}`)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.JSONEq(t, `{"id":"cnt1","type":"counter","delta":2}`, string(resBody))
+}
+
+// legacySignature calculates signature made by older clients: sha256 of payload prefixed with secret key
+func legacySignature(secretKey string, payload string) string {
+	hash := sha256.Sum256([]byte(payload))
+	return base64.StdEncoding.EncodeToString(append([]byte(secretKey), hash[:]...))
+}
+
+func TestEncryptionSchemes(t *testing.T) {
+	dir := t.TempDir()
+	rsaPriv, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	rsaPath := filepath.Join(dir, "rsa.pem")
+	require.NoError(t, os.WriteFile(rsaPath, pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(rsaPriv),
+	}), 0600))
+	x25519Priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	x25519DER, err := x509.MarshalPKCS8PrivateKey(x25519Priv)
+	require.NoError(t, err)
+	x25519Path := filepath.Join(dir, "x25519.pem")
+	require.NoError(t, os.WriteFile(x25519Path, pem.EncodeToMemory(&pem.Block{
+		Type:  "PRIVATE KEY",
+		Bytes: x25519DER,
+	}), 0600))
+
+	logger, _ := logging.NewZapLogger("info")
+	msrv := service.NewMetricsService(repository.NewMemStorage(), logger)
+	mhandlers, err := handler.NewMetricsHandlers(msrv, &servercfg.SecurityConfig{
+		PrivateKeyPath:       rsaPath,
+		X25519PrivateKeyPath: x25519Path,
+	}, apitoken.NewRegistry(nil, logger), nil, logger)
+	require.NoError(t, err)
+	srv := httptest.NewServer(mhandlers.GetRouter())
+	defer srv.Close()
+
+	rsaEngine := encrypt.NewRSAEngineBuilder().PublicKey(&rsaPriv.PublicKey).Build()
+	x25519Engine := encrypt.NewX25519EngineBuilder().PublicKey(x25519Priv.PublicKey()).Build()
+	doRequest := func(encrypter encrypt.Encrypter, scheme string) int {
+		body, err := encrypter.Encrypt([]byte(`{"id":"cnt1","type":"counter","delta":1}`))
+		require.NoError(t, err)
+		req, err := http.NewRequest(http.MethodPost, srv.URL+"/update/", bytes.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		if scheme != "" {
+			req.Header.Set(encrypt.EncryptionHeader, scheme)
+		}
+		res, err := srv.Client().Do(req)
+		require.NoError(t, err)
+		_ = res.Body.Close()
+		return res.StatusCode
+	}
+
+	// RSA is assumed if encryption scheme is not specified
+	assert.Equal(t, http.StatusOK, doRequest(rsaEngine, ""))
+	assert.Equal(t, http.StatusOK, doRequest(rsaEngine, encrypt.SchemeRSAAES))
+	assert.Equal(t, http.StatusOK, doRequest(x25519Engine, encrypt.SchemeX25519))
+	assert.Equal(t, http.StatusBadRequest, doRequest(x25519Engine, ""))
+	assert.Equal(t, http.StatusBadRequest, doRequest(x25519Engine, "rot13"))
+}
+
+func TestEncryptedResponses(t *testing.T) {
+	dir := t.TempDir()
+	rsaPriv, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	rsaPath := filepath.Join(dir, "rsa.pem")
+	require.NoError(t, os.WriteFile(rsaPath, pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(rsaPriv),
+	}), 0600))
+	x25519Priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	x25519DER, err := x509.MarshalPKCS8PrivateKey(x25519Priv)
+	require.NoError(t, err)
+	x25519Path := filepath.Join(dir, "x25519.pem")
+	require.NoError(t, os.WriteFile(x25519Path, pem.EncodeToMemory(&pem.Block{
+		Type:  "PRIVATE KEY",
+		Bytes: x25519DER,
+	}), 0600))
+	x25519PubDER, err := x509.MarshalPKIXPublicKey(x25519Priv.PublicKey())
+	require.NoError(t, err)
+	x25519PubPath := filepath.Join(dir, "x25519.pub")
+	require.NoError(t, os.WriteFile(x25519PubPath, pem.EncodeToMemory(&pem.Block{
+		Type:  "PUBLIC KEY",
+		Bytes: x25519PubDER,
+	}), 0600))
+
+	logger, _ := logging.NewZapLogger("info")
+	msrv := service.NewMetricsService(repository.NewMemStorage(), logger)
+	require.NoError(t, msrv.AccumulateMetric(context.Background(), model.NewCounterMetricsWithDelta("cnt1", 5), ""))
+	mhandlers, err := handler.NewMetricsHandlers(msrv, &servercfg.SecurityConfig{
+		SecretKey:    "secret",
+		ResponseKeys: []string{"agent1:" + x25519PubPath},
+	}, apitoken.NewRegistry(nil, logger), nil, logger)
+	require.NoError(t, err)
+	srv := httptest.NewServer(mhandlers.GetRouter())
+	defer srv.Close()
+
+	keys, err := encrypt.NewKeyRingBuilder().PrimaryKey("", "secret").Build()
+	require.NoError(t, err)
+
+	// read client passes its public key and gets decrypted and verified responses
+	for _, path := range []string{rsaPath, x25519Path} {
+		reader, err := sending.NewRestReader(srv.URL, &retrying.NoRetryPolicy{}, keys, "", path, nil, logger)
+		require.NoError(t, err)
+		metric, err := reader.GetMetric("cnt1", model.Counter)
+		require.NoError(t, err)
+		require.NotNil(t, metric.Delta)
+		assert.Equal(t, int64(5), *metric.Delta)
+		value, err := reader.GetMetricValue("cnt1", model.Counter)
+		require.NoError(t, err)
+		assert.Equal(t, "5", value)
+
+		// error responses are not encrypted
+		_, err = reader.GetMetric("cnt2", model.Counter)
+		assert.Error(t, err)
+	}
+
+	decrypter, _, err := encrypt.ReadResponseKeyFromFile(x25519Path)
+	require.NoError(t, err)
+	doRequest := func(keyID string, path string) (*http.Response, []byte) {
+		req, err := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+		require.NoError(t, err)
+		req.Header.Set(encrypt.ResponseKeyIDHeader, keyID)
+		req.Header.Set("Accept-Encoding", "gzip")
+		res, err := srv.Client().Do(req)
+		require.NoError(t, err)
+		resBody, err := io.ReadAll(res.Body)
+		_ = res.Body.Close()
+		require.NoError(t, err)
+		return res, resBody
+	}
+
+	// client refers to a key known by server, HTML page is encrypted before compression
+	res, resBody := doRequest("agent1", "/")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, encrypt.SchemeX25519, res.Header.Get(encrypt.EncryptionHeader))
+	assert.Equal(t, "gzip", res.Header.Get("Content-Encoding"))
+	gz, err := gzip.NewReader(bytes.NewReader(resBody))
+	require.NoError(t, err)
+	resBody, err = io.ReadAll(gz)
+	require.NoError(t, err)
+	page, err := decrypter.Decrypt(resBody)
+	require.NoError(t, err)
+	assert.Contains(t, string(page), "cnt1")
+	rs, err := encrypt.GetRequestSignature(res.Header)
+	require.NoError(t, err)
+	assert.NoError(t, encrypt.CheckResponseSignature(keys, "", page, rs))
+
+	res, _ = doRequest("agent2", "/")
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	res, _ = doRequest("agent1", "/value/counter/cnt2")
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+	assert.Empty(t, res.Header.Get(encrypt.EncryptionHeader))
+}
+
+func TestRequestBodyLimits(t *testing.T) {
+	logger, _ := logging.NewZapLogger("info")
+	msrv := service.NewMetricsService(repository.NewMemStorage(), logger)
+	mhandlers, err := handler.NewMetricsHandlers(msrv, &servercfg.SecurityConfig{
+		SecretKey:               "secret",
+		MaxBodySize:             8 << 10,
+		MaxDecompressedBodySize: 64 << 10,
+	}, apitoken.NewRegistry(nil, logger), nil, logger)
+	require.NoError(t, err)
+	srv := httptest.NewServer(mhandlers.GetRouter())
+	defer srv.Close()
+
+	keys, err := encrypt.NewKeyRingBuilder().PrimaryKey("", "secret").Build()
+	require.NoError(t, err)
+	doRequest := func(body io.Reader, payload []byte, encoding string) int {
+		req, err := http.NewRequest(http.MethodPost, srv.URL+"/updates/", body)
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		if encoding != "" {
+			req.Header.Set("Content-Encoding", encoding)
+		}
+		require.NoError(t, encrypt.SignRequest(keys, req, payload))
+		res, err := srv.Client().Do(req)
+		require.NoError(t, err)
+		_ = res.Body.Close()
+		return res.StatusCode
+	}
+	gzipped := func(data []byte) []byte {
+		var b bytes.Buffer
+		gw := gzip.NewWriter(&b)
+		_, err := gw.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, gw.Close())
+		return b.Bytes()
+	}
+
+	// compressed body within limits
+	payload := gzipped([]byte(`[{"id":"cnt1","type":"counter","delta":1}]`))
+	assert.Equal(t, http.StatusOK, doRequest(bytes.NewReader(payload), payload, "gzip"))
+
+	// small gzip bomb is rejected while decompressing
+	bomb := append([]byte(`[{"id":"cnt1","type":"counter","delta":1}`), bytes.Repeat([]byte(" "), 1<<20)...)
+	payload = gzipped(append(bomb, ']'))
+	require.Less(t, len(payload), 8<<10)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, doRequest(bytes.NewReader(payload), payload, "gzip"))
+
+	// large body is rejected by its declared length or while it's read (chunked body of unknown length)
+	payload = bytes.Repeat([]byte(" "), 16<<10)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, doRequest(bytes.NewReader(payload), payload, ""))
+	chunked := struct{ io.Reader }{bytes.NewReader(payload)}
+	assert.Equal(t, http.StatusRequestEntityTooLarge, doRequest(chunked, payload, ""))
+
+	// administration endpoints are limited as well
+	res, err := srv.Client().Post(srv.URL+"/admin/tokens/reload", "application/json", bytes.NewReader(payload))
+	require.NoError(t, err)
+	_ = res.Body.Close()
+	assert.Equal(t, http.StatusRequestEntityTooLarge, res.StatusCode)
+
+	assert.Equal(t, int64(3), mhandlers.BodyLimit().RejectedSent())
+	assert.Equal(t, int64(1), mhandlers.BodyLimit().RejectedDecompressed())
+}
+
+type failingAuditor struct{}
+
+func (fa failingAuditor) OnMetricsUpdate(_ time.Time, _ string, _ string, _ ...*model.Metrics) error {
+	return errors.New("audit service is unavailable")
+}
+
+func TestSelfMetrics(t *testing.T) {
+	logger, _ := logging.NewZapLogger("info")
+	reg := selfmetrics.NewRegistry()
+	stor := selfmetrics.NewObservedStorage(repository.NewMemStorage(), reg)
+	msrv := service.NewMetricsService(stor, logger)
+	msrv.UseSelfMetrics(reg)
+	msrv.SubscribeAuditor(failingAuditor{})
+	mhandlers, err := handler.NewMetricsHandlers(msrv, &servercfg.SecurityConfig{}, apitoken.NewRegistry(nil, logger), reg, logger)
+	require.NoError(t, err)
+	srv := httptest.NewServer(mhandlers.GetRouter())
+	defer srv.Close()
+
+	doRequest := func(method, path, body string) (int, string) {
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		res, err := srv.Client().Do(req)
+		require.NoError(t, err)
+		defer func() { _ = res.Body.Close() }()
+		data, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		return res.StatusCode, string(data)
+	}
+
+	code, _ := doRequest(http.MethodPost, "/update/counter/cnt1/1", "")
+	require.Equal(t, http.StatusOK, code)
+	code, _ = doRequest(http.MethodPost, "/update/counter/cnt1/2", "")
+	require.Equal(t, http.StatusOK, code)
+	code, _ = doRequest(http.MethodGet, "/value/counter/unknown", "")
+	require.Equal(t, http.StatusNotFound, code)
+
+	// clients can't update metrics under reserved prefix
+	code, _ = doRequest(http.MethodPost, "/update/counter/overseer.http.requests/1", "")
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = doRequest(http.MethodPost, "/updates/", `[{"id":"overseer.audit.errors","type":"counter","delta":1}]`)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, body := doRequest(http.MethodGet, "/metrics/self", "")
+	require.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body,
+		`overseer_http_requests{code="200",method="POST",route="/update/{mtype}/{id}/{value}"} 2`)
+	assert.Contains(t, body, `overseer_http_requests{code="404",method="GET",route="/value/{mtype}/{id}"} 1`)
+	assert.Contains(t, body, `overseer_http_request_duration_seconds_count{method="POST",route="/updates"} 1`)
+	assert.Contains(t, body, "overseer_audit_errors 2")
+	assert.Contains(t, body, `overseer_storage_operation_duration_seconds_count{op="add_counter"} 2`)
+	assert.Contains(t, body, `overseer_http_body_rejected{limit="sent"} 0`)
+	assert.NotContains(t, body, "overseer_storage_errors")
+
+	// exported self-metrics are readable as ordinary ones
+	require.NoError(t, selfmetrics.NewExporter(reg, stor, logger).Export(context.Background()))
+	code, body = doRequest(http.MethodGet, "/value/counter/overseer.audit.errors", "")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "2", body)
+}
+
+func TestExactlyOnceBatches(t *testing.T) {
+	logger, _ := logging.NewZapLogger("info")
+	reg := selfmetrics.NewRegistry()
+	msrv := service.NewMetricsService(repository.NewMemStorage(), logger)
+	msrv.UseSelfMetrics(reg)
+	auditor := &recordingAuditor{}
+	msrv.SubscribeAuditor(auditor)
+	mhandlers, err := handler.NewMetricsHandlers(msrv, &servercfg.SecurityConfig{}, apitoken.NewRegistry(nil, logger), reg, logger)
+	require.NoError(t, err)
+
+	// responses to the first lostResponses batch updates are lost after the batch is applied
+	var lostResponses, updates int
+	router := mhandlers.GetRouter()
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/updates" || updates >= lostResponses {
+			router.ServeHTTP(rw, r)
+			return
+		}
+		updates++
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, r)
+		require.Equal(t, http.StatusOK, rec.Code)
+		conn, _, err := rw.(http.Hijacker).Hijack()
+		require.NoError(t, err)
+		_ = conn.Close()
+	}))
+	defer srv.Close()
+
+	rs, err := sending.NewRestSender(srv.URL, retrying.NewLinearPolicy(3, 0, 0), nil, nil, "", "", nil, nil, logger)
+	require.NoError(t, err)
+	getCounter := func(id string) int64 {
+		m, err := msrv.GetMetric(context.Background(), id, model.Counter, nil)
+		require.NoError(t, err)
+		return *m.Delta
+	}
+	batch := []*model.Metrics{
+		model.NewCounterMetricsWithDelta("cnt1", 5),
+		model.NewGaugeMetricsWithValue("gauge1", 1.5),
+	}
+
+	// batch is resent after lost responses and applied once
+	lostResponses = 2
+	require.NoError(t, rs.SendMetricArray(&model.BatchID{AgentID: "agent1", Seq: 1}, batch))
+	assert.Equal(t, int64(5), getCounter("cnt1"))
+	assert.Len(t, auditor.metrics, 2)
+
+	// batches of other agents and next batches of the same agent are applied
+	lostResponses, updates = 0, 0
+	require.NoError(t, rs.SendMetricArray(&model.BatchID{AgentID: "agent2", Seq: 1}, batch))
+	require.NoError(t, rs.SendMetricArray(&model.BatchID{AgentID: "agent1", Seq: 2}, batch))
+	assert.Equal(t, int64(15), getCounter("cnt1"))
+
+	// batches without ID are not resent after lost response, so they are not applied twice
+	lostResponses, updates = 1, 0
+	require.Error(t, rs.SendMetricArray(nil, batch))
+	assert.Equal(t, int64(20), getCounter("cnt1"))
+
+	// duplicate is acknowledged, invalid batch ID is rejected
+	doRequest := func(batchID string) (int, string) {
+		req, err := http.NewRequest(http.MethodPost, srv.URL+"/updates", strings.NewReader(`[{"id":"cnt1","type":"counter","delta":1}]`))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(model.BatchIDHeader, batchID)
+		res, err := srv.Client().Do(req)
+		require.NoError(t, err)
+		defer func() { _ = res.Body.Close() }()
+		data, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		return res.StatusCode, string(data)
+	}
+	code, body := doRequest("agent1:2")
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"updated":0,"duplicate":true}`, body)
+	code, _ = doRequest("agent1")
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, int64(20), getCounter("cnt1"))
+
+	var buf bytes.Buffer
+	require.NoError(t, reg.WritePrometheus(&buf))
+	assert.Contains(t, buf.String(), "overseer_batch_duplicates 3")
 }
 
 func TestDBConnectionPing(t *testing.T) {
@@ -502,7 +1711,23 @@ func setupServerWithMemStorage() *httptest.Server {
 	gm.SetGauge(3.14)
 	_ = msrv.AccumulateMetric(ctx, gm, "")
 
-	mhandlers, _ := handler.NewMetricsHandlers(msrv, &servercfg.SecurityConfig{}, logger)
+	hv := model.NewHistogramValue(1, 5)
+	hv.Observe(0.5)
+	hv.Observe(3)
+	_ = msrv.AccumulateMetric(ctx, model.NewHistogramMetricsWithValue("hist1", hv), "")
+
+	_ = msrv.AccumulateMetric(ctx, model.NewGaugeMetricsWithValue("cpu", 0.5).
+		WithLabels(model.Labels{"host": "a", "core": "1"}), "")
+	_ = msrv.AccumulateMetric(ctx, model.NewGaugeMetricsWithValue("cpu", 0.7).
+		WithLabels(model.Labels{"host": "b", "core": "1"}), "")
+
+	sv := model.NewSummaryValue()
+	sv.Add(1)
+	sv.Add(2)
+	sv.Add(3)
+	_ = msrv.AccumulateMetric(ctx, model.NewSummaryMetricsWithValue("sum1", sv), "")
+
+	mhandlers, _ := handler.NewMetricsHandlers(msrv, &servercfg.SecurityConfig{}, apitoken.NewRegistry(nil, logger), nil, logger)
 
 	return httptest.NewServer(mhandlers.GetRouter())
 }
@@ -510,9 +1735,9 @@ func setupServerWithMemStorage() *httptest.Server {
 func setupServerWithDummyDBStorage(conn db.Connection) *httptest.Server {
 	logger, _ := logging.NewZapLogger("info")
 
-	mstor := repository.NewPostgresDBStorage(conn, logger, &retrying.NoRetryPolicy{})
+	mstor := repository.NewPostgresDBStorage(conn, logger, &retrying.NoRetryPolicy{}, nil)
 	msrv := service.NewMetricsService(mstor, logger)
-	mhandlers, _ := handler.NewMetricsHandlers(msrv, &servercfg.SecurityConfig{}, logger)
+	mhandlers, _ := handler.NewMetricsHandlers(msrv, &servercfg.SecurityConfig{}, apitoken.NewRegistry(nil, logger), nil, logger)
 
 	return httptest.NewServer(mhandlers.GetRouter())
 }
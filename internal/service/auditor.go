@@ -7,5 +7,7 @@ import (
 )
 
 type Auditor interface {
-	OnMetricsUpdate(ts time.Time, ipAddr string, metrics ...*model.Metrics) error
+	// OnMetricsUpdate is called after metrics are updated, tokenName is a name of API token used
+	// by the client - it's empty if API tokens are not configured
+	OnMetricsUpdate(ts time.Time, ipAddr string, tokenName string, metrics ...*model.Metrics) error
 }
@@ -9,8 +9,11 @@ import (
 	"io"
 	"time"
 
+	"github.com/andrewsvn/metrics-overseer/internal/apitoken"
 	"github.com/andrewsvn/metrics-overseer/internal/model"
+	"github.com/andrewsvn/metrics-overseer/internal/promtext"
 	"github.com/andrewsvn/metrics-overseer/internal/repository"
+	"github.com/andrewsvn/metrics-overseer/internal/selfmetrics"
 	"go.uber.org/zap"
 )
 
@@ -21,12 +24,14 @@ type MetricsService struct {
 	storage        repository.Storage
 	auditors       []Auditor
 	allMetricsTmpl *template.Template
+	selfMetrics    *selfmetrics.Registry
 	logger         *zap.SugaredLogger
 }
 
 var (
 	ErrUnsupportedMetricType  = errors.New("unsupported metric type")
 	ErrMetricValueNotProvided = errors.New("metric value not provided")
+	ErrReservedMetricID       = errors.New("metric id is reserved for server self-metrics")
 )
 
 type MetricsPage struct {
@@ -44,35 +49,72 @@ func (ms *MetricsService) SubscribeAuditor(auditor Auditor) {
 	ms.auditors = append(ms.auditors, auditor)
 }
 
+// UseSelfMetrics sets registry counting service failures (e.g. audit errors)
+func (ms *MetricsService) UseSelfMetrics(reg *selfmetrics.Registry) {
+	ms.selfMetrics = reg
+}
+
 // AccumulateMetric is an aggregated method of updating metric value based on metric type provided
 // for Counter metric it adds delta value to existing metric value (or creates a new one in storage if not exists)
 // for Gauge metric it simply stores gauge value, overwriting an existing one
+// for Histogram metric it merges provided bucket counts, count and sum into the existing histogram
+// for Summary metric it merges provided quantile sketch into the existing one
 func (ms *MetricsService) AccumulateMetric(ctx context.Context, metric *model.Metrics, ipAddr string) error {
+	if err := validateMetricID(metric.ID); err != nil {
+		return err
+	}
+
 	switch metric.MType {
 	case model.Counter:
 		if metric.Delta == nil {
 			return ErrMetricValueNotProvided
 		}
-		if err := ms.storage.AddCounter(ctx, metric.ID, *metric.Delta); err != nil {
+		if err := ms.storage.AddCounter(ctx, metric.ID, metric.Labels, *metric.Delta); err != nil {
 			return fmt.Errorf("unable to update metric: %w", err)
 		}
 	case model.Gauge:
 		if metric.Value == nil {
 			return ErrMetricValueNotProvided
 		}
-		if err := ms.storage.SetGauge(ctx, metric.ID, *metric.Value); err != nil {
+		if err := ms.storage.SetGauge(ctx, metric.ID, metric.Labels, *metric.Value); err != nil {
+			return fmt.Errorf("unable to update metric: %w", err)
+		}
+	case model.Histogram:
+		if metric.Histogram == nil {
+			return ErrMetricValueNotProvided
+		}
+		if err := ms.storage.AddHistogram(ctx, metric.ID, metric.Labels, metric.Histogram); err != nil {
+			return fmt.Errorf("unable to update metric: %w", err)
+		}
+	case model.Summary:
+		if metric.Summary == nil {
+			return ErrMetricValueNotProvided
+		}
+		if err := ms.storage.AddSummary(ctx, metric.ID, metric.Labels, metric.Summary); err != nil {
 			return fmt.Errorf("unable to update metric: %w", err)
 		}
 	default:
 		return fmt.Errorf("%w: %s", ErrUnsupportedMetricType, metric.MType)
 	}
 
-	ms.notifyAuditors(ipAddr, metric)
+	ms.notifyAuditors(ctx, ipAddr, metric)
 	return nil
 }
 
-func (ms *MetricsService) GetMetric(ctx context.Context, id, mtype string) (*model.Metrics, error) {
-	mi, err := ms.storage.GetByID(ctx, id)
+// validateMetricID checks that metric with given ID can be written by clients
+func validateMetricID(id string) error {
+	if selfmetrics.IsReserved(id) {
+		return fmt.Errorf("%w: %s", ErrReservedMetricID, id)
+	}
+	return model.ValidateMetricID(id)
+}
+
+func (ms *MetricsService) GetMetric(
+	ctx context.Context,
+	id, mtype string,
+	labels model.Labels,
+) (*model.Metrics, error) {
+	mi, err := ms.storage.GetByID(ctx, id, labels)
 	if err != nil {
 		return nil, err
 	}
@@ -82,14 +124,86 @@ func (ms *MetricsService) GetMetric(ctx context.Context, id, mtype string) (*mod
 	return mi, nil
 }
 
+// GetMetricHistory returns samples of metric series recorded within [from, to] time range,
+// repository.ErrMetricNotFound or repository.ErrIncorrectAccess is returned if there's no such series
+func (ms *MetricsService) GetMetricHistory(
+	ctx context.Context,
+	id, mtype string,
+	labels model.Labels,
+	from, to time.Time,
+) ([]*model.Sample, error) {
+	if _, err := ms.GetMetric(ctx, id, mtype, labels); err != nil {
+		return nil, err
+	}
+	return ms.storage.GetHistory(ctx, id, labels, from, to)
+}
+
+// FindMetrics returns all metric series matching the filter sorted by ID and labels
+func (ms *MetricsService) FindMetrics(ctx context.Context, filter *model.Filter) ([]*model.Metrics, error) {
+	return ms.storage.FindSorted(ctx, filter)
+}
+
+// NewHistogramObservation builds a histogram metric containing a single observed value.
+// Bucket layout is taken from the already stored histogram with the same ID and labels,
+// so observations can be merged into it, model.DefaultHistogramBuckets are used for a new histogram
+func (ms *MetricsService) NewHistogramObservation(
+	ctx context.Context,
+	id string,
+	labels model.Labels,
+	v float64,
+) (*model.Metrics, error) {
+	var bounds []float64
+	stored, err := ms.storage.GetByID(ctx, id, labels)
+	if err != nil && !errors.Is(err, repository.ErrMetricNotFound) {
+		return nil, fmt.Errorf("unable to get stored histogram: %w", err)
+	}
+	if stored != nil && stored.Histogram != nil {
+		bounds = stored.Histogram.Bounds
+	}
+
+	hist := model.NewHistogramValue(bounds...)
+	hist.Observe(v)
+	return model.NewHistogramMetricsWithValue(id, hist).WithLabels(labels), nil
+}
+
 func (ms *MetricsService) BatchAccumulateMetrics(ctx context.Context, metrics []*model.Metrics, ipAddr string) error {
-	err := ms.storage.BatchUpdate(ctx, metrics)
+	_, err := ms.BatchAccumulateMetricsOnce(ctx, nil, metrics, ipAddr)
+	return err
+}
+
+// BatchAccumulateMetricsOnce accumulates metrics the same way as BatchAccumulateMetrics does, but a batch
+// with non-nil ID is applied only once, so it can be safely resent when client didn't get the response.
+// false is returned for a duplicate batch, auditors are not notified about it
+func (ms *MetricsService) BatchAccumulateMetricsOnce(
+	ctx context.Context,
+	batchID *model.BatchID,
+	metrics []*model.Metrics,
+	ipAddr string,
+) (bool, error) {
+	for _, m := range metrics {
+		if err := validateMetricID(m.ID); err != nil {
+			return false, err
+		}
+	}
+
+	applied := true
+	var err error
+	if batchID == nil {
+		err = ms.storage.BatchUpdate(ctx, metrics)
+	} else {
+		applied, err = ms.storage.BatchUpdateOnce(ctx, *batchID, metrics)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to store metric values: %w", err)
+		return false, fmt.Errorf("failed to store metric values: %w", err)
 	}
 
-	ms.notifyAuditors(ipAddr, metrics...)
-	return nil
+	if !applied {
+		ms.logger.Infow("skipping already applied batch", "batch", batchID.String())
+		ms.selfMetrics.AddCounter("batch.duplicates", nil, 1)
+		return false, nil
+	}
+	ms.notifyAuditors(ctx, ipAddr, metrics...)
+	return true, nil
 }
 
 func (ms *MetricsService) GenerateAllMetricsHTML(ctx context.Context, w io.Writer) error {
@@ -113,16 +227,28 @@ func (ms *MetricsService) GenerateAllMetricsHTML(ctx context.Context, w io.Write
 	return ms.allMetricsTmpl.Execute(w, page)
 }
 
+// WritePrometheusMetrics writes all stored metrics in Prometheus text exposition format
+func (ms *MetricsService) WritePrometheusMetrics(ctx context.Context, w io.Writer) error {
+	metrics, err := ms.storage.GetAllSorted(ctx)
+	if err != nil {
+		return fmt.Errorf("can't get all metrics from storage: %w", err)
+	}
+	return promtext.NewEncoder(w).Encode(metrics)
+}
+
 func (ms *MetricsService) PingStorage(ctx context.Context) error {
 	return ms.storage.Ping(ctx)
 }
 
-func (ms *MetricsService) notifyAuditors(ipAddr string, metrics ...*model.Metrics) {
+// notifyAuditors passes update details to all auditors, API token name is taken from request context
+func (ms *MetricsService) notifyAuditors(ctx context.Context, ipAddr string, metrics ...*model.Metrics) {
 	ts := time.Now()
+	tokenName := apitoken.NameFromContext(ctx)
 	for _, auditor := range ms.auditors {
-		err := auditor.OnMetricsUpdate(ts, ipAddr, metrics...)
+		err := auditor.OnMetricsUpdate(ts, ipAddr, tokenName, metrics...)
 		if err != nil {
 			ms.logger.Errorw("error performing metrics audit", "error", err)
+			ms.selfMetrics.AddCounter("audit.errors", nil, 1)
 		}
 	}
 }
@@ -0,0 +1,99 @@
+package statsd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/andrewsvn/metrics-overseer/internal/service"
+	"github.com/andrewsvn/metrics-overseer/internal/subnet"
+	"go.uber.org/zap"
+)
+
+// Listener receives StatsD packets over UDP, each packet is parsed and accumulated as a single batch.
+// StatsD has no means of authentication, packets are only checked against trusted subnets, and UDP source
+// addresses can be spoofed, so the listener should be bound to localhost (or a private interface)
+type Listener struct {
+	conn          net.PacketConn
+	subnets       *subnet.Checker
+	msrv          *service.MetricsService
+	maxPacketSize int
+	logger        *zap.SugaredLogger
+}
+
+// NewListener binds UDP address, packets are not read until Serve is called.
+// Packets from addresses outside of trusted subnets are dropped
+func NewListener(
+	addr string,
+	maxPacketSize int,
+	subnets *subnet.Checker,
+	msrv *service.MetricsService,
+	l *zap.Logger,
+) (*Listener, error) {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("can't listen statsd address %s: %w", addr, err)
+	}
+
+	lsn := &Listener{
+		conn:          conn,
+		subnets:       subnets,
+		msrv:          msrv,
+		maxPacketSize: maxPacketSize,
+		logger:        l.Sugar().With("component", "statsd-listener"),
+	}
+	if udpAddr, ok := conn.LocalAddr().(*net.UDPAddr); ok && !udpAddr.IP.IsLoopback() {
+		lsn.logger.Warnw("statsd listener accepts unauthenticated packets on non-loopback address",
+			"address", udpAddr.String())
+	}
+	return lsn, nil
+}
+
+func (lsn *Listener) Addr() net.Addr {
+	return lsn.conn.LocalAddr()
+}
+
+// Serve reads packets until the listener is closed, nil is returned in this case
+func (lsn *Listener) Serve() error {
+	buf := make([]byte, lsn.maxPacketSize)
+	for {
+		n, addr, err := lsn.conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return fmt.Errorf("error reading statsd packet: %w", err)
+		}
+		lsn.handlePacket(buf[:n], addr)
+	}
+}
+
+func (lsn *Listener) Close() error {
+	return lsn.conn.Close()
+}
+
+func (lsn *Listener) handlePacket(packet []byte, addr net.Addr) {
+	ipAddr := "N/A"
+	if udpAddr, ok := addr.(*net.UDPAddr); ok {
+		ipAddr = udpAddr.IP.String()
+	}
+	if !lsn.subnets.Trusted(ipAddr) {
+		lsn.logger.Warnw("dropping statsd packet from untrusted address", "from", addr.String())
+		return
+	}
+
+	metrics, err := ParsePacket(packet)
+	if err != nil {
+		// invalid lines don't affect valid ones in the same packet
+		lsn.logger.Warnw("error parsing statsd lines", "from", addr.String(), "error", err)
+	}
+	if len(metrics) == 0 {
+		return
+	}
+
+	err = lsn.msrv.BatchAccumulateMetrics(context.Background(), metrics, ipAddr)
+	if err != nil {
+		lsn.logger.Errorw("error accumulating statsd metrics", "count", len(metrics), "error", err)
+	}
+}
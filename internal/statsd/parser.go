@@ -0,0 +1,138 @@
+// Package statsd implements StatsD protocol ingestion - a UDP listener accepting StatsD lines
+// and converting them to metrics-overseer metrics
+package statsd
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/andrewsvn/metrics-overseer/internal/model"
+)
+
+const (
+	typeCounter   = "c"
+	typeGauge     = "g"
+	typeTimer     = "ms"
+	typeHistogram = "h"
+)
+
+// TimerBuckets are histogram bucket bounds for timer metrics, StatsD timings are measured in milliseconds
+var TimerBuckets = []float64{1, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+var ErrInvalidLine = errors.New("invalid statsd line")
+
+// ParseLine converts a single StatsD line `<name>:<value>|<type>[|@<sample rate>][|#<tag>:<value>,...]` to a metric:
+//   - counters (c) are converted to counter metric, value is scaled by sample rate and rounded to integer
+//   - gauges (g) are converted to gauge metric, sample rate is ignored. Relative updates (+N/-N) are not supported
+//     since they can't be applied atomically
+//   - timers (ms) and histograms (h) are converted to histogram metric with TimerBuckets,
+//     sampled observation is counted 1/rate times
+//
+// Tags (DogStatsD extension) are converted to metric labels, tags without value get empty label value
+func ParseLine(line string) (*model.Metrics, error) {
+	name, rest, ok := strings.Cut(line, ":")
+	if !ok || name == "" {
+		return nil, fmt.Errorf("%w: missing metric name in %q", ErrInvalidLine, line)
+	}
+
+	parts := strings.Split(rest, "|")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("%w: missing metric type in %q", ErrInvalidLine, line)
+	}
+	svalue, mtype := parts[0], parts[1]
+
+	rate := 1.0
+	var labels model.Labels
+	for _, p := range parts[2:] {
+		switch {
+		case strings.HasPrefix(p, "@"):
+			r, err := strconv.ParseFloat(p[1:], 64)
+			if err != nil || r <= 0 || r > 1 {
+				return nil, fmt.Errorf("%w: bad sample rate in %q", ErrInvalidLine, line)
+			}
+			rate = r
+		case strings.HasPrefix(p, "#"):
+			labels = parseTags(p[1:])
+		default:
+			return nil, fmt.Errorf("%w: unknown section %q in %q", ErrInvalidLine, p, line)
+		}
+	}
+	if err := labels.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidLine, err)
+	}
+
+	value, err := strconv.ParseFloat(svalue, 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return nil, fmt.Errorf("%w: bad value in %q", ErrInvalidLine, line)
+	}
+
+	var metric *model.Metrics
+	switch mtype {
+	case typeCounter:
+		delta, ok := roundToInt64(value / rate)
+		if !ok {
+			return nil, fmt.Errorf("%w: counter value out of range in %q", ErrInvalidLine, line)
+		}
+		metric = model.NewCounterMetricsWithDelta(name, delta)
+	case typeGauge:
+		if strings.HasPrefix(svalue, "+") || strings.HasPrefix(svalue, "-") {
+			return nil, fmt.Errorf("%w: relative gauge updates are not supported: %q", ErrInvalidLine, line)
+		}
+		metric = model.NewGaugeMetricsWithValue(name, value)
+	case typeTimer, typeHistogram:
+		count, ok := roundToInt64(1 / rate)
+		if !ok {
+			return nil, fmt.Errorf("%w: sample rate out of range in %q", ErrInvalidLine, line)
+		}
+		hist := model.NewHistogramValue(TimerBuckets...)
+		hist.ObserveN(value, count)
+		metric = model.NewHistogramMetricsWithValue(name, hist)
+	default:
+		return nil, fmt.Errorf("%w: unsupported metric type %q", ErrInvalidLine, mtype)
+	}
+	return metric.WithLabels(labels), nil
+}
+
+// roundToInt64 rounds a float value, false is returned if it doesn't fit into int64
+func roundToInt64(v float64) (int64, bool) {
+	v = math.Round(v)
+	// float64(math.MaxInt64) is equal to 2^63 which already overflows int64
+	if math.IsNaN(v) || v < math.MinInt64 || v >= math.MaxInt64 {
+		return 0, false
+	}
+	return int64(v), true
+}
+
+// ParsePacket parses all non-empty lines of a packet, invalid lines are skipped and reported as joined error
+func ParsePacket(packet []byte) ([]*model.Metrics, error) {
+	var errs []error
+	metrics := make([]*model.Metrics, 0)
+	for _, line := range strings.Split(string(packet), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		m, err := ParseLine(line)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics, errors.Join(errs...)
+}
+
+func parseTags(s string) model.Labels {
+	labels := make(model.Labels)
+	for _, tag := range strings.Split(s, ",") {
+		if tag == "" {
+			continue
+		}
+		k, v, _ := strings.Cut(tag, ":")
+		labels[k] = v
+	}
+	return labels
+}
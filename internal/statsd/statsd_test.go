@@ -0,0 +1,126 @@
+package statsd
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/andrewsvn/metrics-overseer/internal/logging"
+	"github.com/andrewsvn/metrics-overseer/internal/model"
+	"github.com/andrewsvn/metrics-overseer/internal/repository"
+	"github.com/andrewsvn/metrics-overseer/internal/service"
+	"github.com/andrewsvn/metrics-overseer/internal/subnet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLine(t *testing.T) {
+	m, err := ParseLine("requests:3|c")
+	require.NoError(t, err)
+	assert.Equal(t, model.Counter, m.MType)
+	assert.Equal(t, int64(3), *m.Delta)
+
+	m, err = ParseLine("requests:1|c|@0.1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), *m.Delta)
+
+	m, err = ParseLine("temp:3.2|g|@0.5|#room:a,floor:1")
+	require.NoError(t, err)
+	assert.Equal(t, model.Gauge, m.MType)
+	assert.Equal(t, 3.2, *m.Value)
+	assert.Equal(t, model.Labels{"room": "a", "floor": "1"}, m.Labels)
+
+	m, err = ParseLine("latency:12|ms|@0.25")
+	require.NoError(t, err)
+	assert.Equal(t, model.Histogram, m.MType)
+	assert.Equal(t, TimerBuckets, m.Histogram.Bounds)
+	assert.Equal(t, int64(4), m.Histogram.Count)
+	assert.Equal(t, 48.0, m.Histogram.Sum)
+	assert.Equal(t, int64(4), m.Histogram.Counts[3])
+
+	for _, line := range []string{
+		"requests",
+		":1|c",
+		"requests:1",
+		"requests:x|c",
+		"requests:1|s",
+		"requests:1|c|@2",
+		"requests:1|c|@0",
+		"requests:1|c|x",
+		"temp:+1|g",
+		"temp:1|g|#bad-tag:1",
+		"requests:1e30|c",
+		"requests:-1e19|c",
+		"requests:1|c|@1e-300",
+		"latency:12|ms|@1e-300",
+	} {
+		_, err := ParseLine(line)
+		assert.ErrorIs(t, err, ErrInvalidLine, line)
+	}
+}
+
+func TestParsePacket(t *testing.T) {
+	metrics, err := ParsePacket([]byte("a:1|c\n\nb:2|g\nbad\n"))
+	assert.ErrorIs(t, err, ErrInvalidLine)
+	require.Len(t, metrics, 2)
+	assert.Equal(t, "a", metrics[0].ID)
+	assert.Equal(t, "b", metrics[1].ID)
+}
+
+func TestListener(t *testing.T) {
+	logger, _ := logging.NewZapLogger("info")
+	stor := repository.NewMemStorage()
+	msrv := service.NewMetricsService(stor, logger)
+
+	lsn, err := NewListener("127.0.0.1:0", 1024, &subnet.Checker{}, msrv, logger)
+	require.NoError(t, err)
+	served := make(chan error)
+	go func() {
+		served <- lsn.Serve()
+	}()
+
+	conn, err := net.Dial("udp", lsn.Addr().String())
+	require.NoError(t, err)
+	defer func() {
+		_ = conn.Close()
+	}()
+	_, err = conn.Write([]byte("hits:2|c\nhits:3|c\nload:0.5|g\nbad line"))
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	require.Eventually(t, func() bool {
+		m, err := stor.GetByID(ctx, "load", nil)
+		return err == nil && m != nil
+	}, time.Second, 10*time.Millisecond)
+
+	hits, err := stor.GetByID(ctx, "hits", nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), *hits.Delta)
+
+	require.NoError(t, lsn.Close())
+	assert.NoError(t, <-served)
+}
+
+func TestListenerTrustedSubnets(t *testing.T) {
+	logger, _ := logging.NewZapLogger("info")
+	stor := repository.NewMemStorage()
+	msrv := service.NewMetricsService(stor, logger)
+
+	subnets, err := subnet.NewChecker([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+	lsn, err := NewListener("127.0.0.1:0", 1024, subnets, msrv, logger)
+	require.NoError(t, err)
+	defer func() {
+		_ = lsn.Close()
+	}()
+
+	lsn.handlePacket([]byte("hits:2|c"), &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	_, err = stor.GetByID(context.Background(), "hits", nil)
+	assert.ErrorIs(t, err, repository.ErrMetricNotFound)
+
+	lsn.handlePacket([]byte("hits:2|c"), &net.UDPAddr{IP: net.ParseIP("10.1.2.3")})
+	hits, err := stor.GetByID(context.Background(), "hits", nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), *hits.Delta)
+}
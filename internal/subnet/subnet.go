@@ -0,0 +1,55 @@
+// Package subnet provides checks of client addresses against trusted subnets
+package subnet
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// RealIPHeader is an HTTP header (and gRPC metadata key in lower case) used by clients to pass their IP address
+const RealIPHeader = "X-Real-IP"
+
+// Checker verifies that IP address belongs to one of trusted subnets.
+// Checker without subnets is disabled and trusts any address
+type Checker struct {
+	nets []*net.IPNet
+}
+
+// NewChecker parses trusted subnets in CIDR notation (e.g. 192.168.1.0/24), empty entries are ignored
+func NewChecker(cidrs []string) (*Checker, error) {
+	c := &Checker{}
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted subnet %q: %w", cidr, err)
+		}
+		c.nets = append(c.nets, ipnet)
+	}
+	return c, nil
+}
+
+func (c *Checker) Enabled() bool {
+	return len(c.nets) > 0
+}
+
+// Trusted checks IP address provided in string form, unparseable address is never trusted if checker is enabled
+func (c *Checker) Trusted(addr string) bool {
+	if !c.Enabled() {
+		return true
+	}
+	ip := net.ParseIP(strings.TrimSpace(addr))
+	if ip == nil {
+		return false
+	}
+	for _, ipnet := range c.nets {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,30 @@
+package subnet
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChecker(t *testing.T) {
+	c, err := NewChecker(nil)
+	require.NoError(t, err)
+	assert.False(t, c.Enabled())
+	assert.True(t, c.Trusted("8.8.8.8"))
+	assert.True(t, c.Trusted("garbage"))
+
+	c, err = NewChecker([]string{"192.168.1.0/24", " 10.0.0.0/8", "", "fd00::/8"})
+	require.NoError(t, err)
+	assert.True(t, c.Enabled())
+	assert.True(t, c.Trusted("192.168.1.15"))
+	assert.True(t, c.Trusted("10.20.30.40"))
+	assert.True(t, c.Trusted("fd00::1"))
+	assert.False(t, c.Trusted("192.168.2.1"))
+	assert.False(t, c.Trusted("::1"))
+	assert.False(t, c.Trusted(""))
+	assert.False(t, c.Trusted("garbage"))
+
+	_, err = NewChecker([]string{"192.168.1.0"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,78 @@
+// Package tlsconfig builds TLS configurations for metrics-overseer server and agent from PEM files.
+// Mutual TLS is enabled on server side by specifying a CA for client certificates, agent presents
+// its certificate if one is specified
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// NewServerConfig creates server TLS configuration with a certificate and its private key.
+// If clientCAPath is specified, clients must present a certificate signed by this CA
+func NewServerConfig(certPath string, keyPath string, clientCAPath string) (*tls.Config, error) {
+	if certPath == "" || keyPath == "" {
+		return nil, errors.New("both server certificate and private key must be specified for TLS")
+	}
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("can't load server certificate: %w", err)
+	}
+
+	cfg := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+	if clientCAPath != "" {
+		pool, err := readCertPool(clientCAPath)
+		if err != nil {
+			return nil, fmt.Errorf("can't read client CA: %w", err)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
+
+// NewClientConfig creates client TLS configuration. Server certificate is verified against CA from caPath
+// or system roots if it's not specified. Client certificate is presented to server if both certPath
+// and keyPath are specified
+func NewClientConfig(caPath string, certPath string, keyPath string) (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	if caPath != "" {
+		pool, err := readCertPool(caPath)
+		if err != nil {
+			return nil, fmt.Errorf("can't read server CA: %w", err)
+		}
+		cfg.RootCAs = pool
+	}
+
+	if certPath != "" || keyPath != "" {
+		if certPath == "" || keyPath == "" {
+			return nil, errors.New("both client certificate and private key must be specified for TLS")
+		}
+		cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+		if err != nil {
+			return nil, fmt.Errorf("can't load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+func readCertPool(path string) (*x509.CertPool, error) {
+	pemBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pemBytes) {
+		return nil, fmt.Errorf("no PEM certificates found in %s", path)
+	}
+	return pool, nil
+}
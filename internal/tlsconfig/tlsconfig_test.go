@@ -0,0 +1,71 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andrewsvn/metrics-overseer/internal/tlsconfig/tlstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMutualTLS(t *testing.T) {
+	files := tlstest.GenerateFiles(t)
+
+	serverCfg, err := NewServerConfig(files.ServerCert, files.ServerKey, files.CACert)
+	require.NoError(t, err)
+	assert.Equal(t, tls.RequireAndVerifyClientCert, serverCfg.ClientAuth)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "agent", r.TLS.PeerCertificates[0].Subject.CommonName)
+		rw.WriteHeader(http.StatusOK)
+	}))
+	srv.TLS = serverCfg
+	srv.StartTLS()
+	defer srv.Close()
+
+	get := func(clientCfg *tls.Config) error {
+		cl := &http.Client{Transport: &http.Transport{TLSClientConfig: clientCfg}}
+		res, err := cl.Get(srv.URL)
+		if err != nil {
+			return err
+		}
+		return res.Body.Close()
+	}
+
+	clientCfg, err := NewClientConfig(files.CACert, files.ClientCert, files.ClientKey)
+	require.NoError(t, err)
+	assert.NoError(t, get(clientCfg))
+
+	// client without certificate is rejected
+	clientCfg, err = NewClientConfig(files.CACert, "", "")
+	require.NoError(t, err)
+	assert.Error(t, get(clientCfg))
+
+	// server certificate is not trusted without CA
+	clientCfg, err = NewClientConfig("", files.ClientCert, files.ClientKey)
+	require.NoError(t, err)
+	assert.Error(t, get(clientCfg))
+}
+
+func TestConfigErrors(t *testing.T) {
+	files := tlstest.GenerateFiles(t)
+
+	_, err := NewServerConfig(files.ServerCert, "", "")
+	assert.Error(t, err)
+	_, err = NewServerConfig(files.ServerCert, files.ClientKey, "")
+	assert.Error(t, err)
+	_, err = NewServerConfig(files.ServerCert, files.ServerKey, files.ServerKey)
+	assert.Error(t, err)
+
+	cfg, err := NewServerConfig(files.ServerCert, files.ServerKey, "")
+	require.NoError(t, err)
+	assert.Equal(t, tls.NoClientCert, cfg.ClientAuth)
+
+	_, err = NewClientConfig("missing.pem", "", "")
+	assert.Error(t, err)
+	_, err = NewClientConfig(files.CACert, files.ClientCert, "")
+	assert.Error(t, err)
+}
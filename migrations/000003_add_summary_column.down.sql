@@ -0,0 +1 @@
+ALTER TABLE METRICS DROP COLUMN IF EXISTS SUMMARY;
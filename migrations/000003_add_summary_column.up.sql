@@ -0,0 +1 @@
+ALTER TABLE METRICS ADD COLUMN IF NOT EXISTS SUMMARY JSONB;
@@ -0,0 +1,11 @@
+DELETE FROM METRICS WHERE LABELS <> '{}'::JSONB;
+
+DROP INDEX IF EXISTS METRICS_IXLABELS;
+DROP INDEX IF EXISTS METRICS_IXID;
+ALTER TABLE METRICS DROP CONSTRAINT IF EXISTS METRICS_PKEY;
+
+ALTER TABLE METRICS DROP COLUMN IF EXISTS SERIES_KEY;
+ALTER TABLE METRICS DROP COLUMN IF EXISTS LABELS;
+
+ALTER TABLE METRICS ADD PRIMARY KEY (ID);
+CREATE UNIQUE INDEX IF NOT EXISTS METRICS_IXID ON METRICS (ID);
@@ -0,0 +1,12 @@
+ALTER TABLE METRICS ADD COLUMN IF NOT EXISTS LABELS JSONB NOT NULL DEFAULT '{}'::JSONB;
+ALTER TABLE METRICS ADD COLUMN IF NOT EXISTS SERIES_KEY TEXT;
+UPDATE METRICS SET SERIES_KEY = ID WHERE SERIES_KEY IS NULL;
+ALTER TABLE METRICS ALTER COLUMN SERIES_KEY SET NOT NULL;
+
+ALTER TABLE METRICS DROP CONSTRAINT IF EXISTS METRICS_PKEY;
+ALTER TABLE METRICS DROP CONSTRAINT IF EXISTS METRICS_ID_KEY;
+DROP INDEX IF EXISTS METRICS_IXID;
+
+ALTER TABLE METRICS ADD PRIMARY KEY (SERIES_KEY);
+CREATE INDEX IF NOT EXISTS METRICS_IXID ON METRICS (ID);
+CREATE INDEX IF NOT EXISTS METRICS_IXLABELS ON METRICS USING GIN (LABELS);
@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS METRICS_RECORD_SAMPLE ON METRICS;
+DROP FUNCTION IF EXISTS RECORD_METRIC_SAMPLE();
+DROP TABLE IF EXISTS METRIC_SAMPLES;
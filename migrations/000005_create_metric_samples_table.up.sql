@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS METRIC_SAMPLES (
+    SERIES_KEY TEXT NOT NULL,
+    TS TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    MTYPE VARCHAR(10) NOT NULL,
+    DELTA DECIMAL,
+    VALUE DOUBLE PRECISION,
+    HISTOGRAM JSONB,
+    SUMMARY JSONB
+);
+
+CREATE INDEX IF NOT EXISTS METRIC_SAMPLES_IXKEYTS ON METRIC_SAMPLES (SERIES_KEY, TS);
+
+-- every insert or update of a metric appends its new state to the samples table,
+-- so history is recorded for all storage operations including batch updates
+CREATE OR REPLACE FUNCTION RECORD_METRIC_SAMPLE() RETURNS TRIGGER AS $$
+BEGIN
+    INSERT INTO METRIC_SAMPLES (SERIES_KEY, TS, MTYPE, DELTA, VALUE, HISTOGRAM, SUMMARY)
+    VALUES (NEW.SERIES_KEY, CLOCK_TIMESTAMP(), NEW.MTYPE, NEW.DELTA, NEW.VALUE, NEW.HISTOGRAM, NEW.SUMMARY);
+    RETURN NEW;
+END;
+$$ LANGUAGE PLPGSQL;
+
+CREATE OR REPLACE TRIGGER METRICS_RECORD_SAMPLE
+    AFTER INSERT OR UPDATE ON METRICS
+    FOR EACH ROW EXECUTE FUNCTION RECORD_METRIC_SAMPLE();
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS API_TOKENS (
+    NAME TEXT PRIMARY KEY,
+    TOKEN_HASH VARCHAR(64) NOT NULL UNIQUE,
+    SCOPES TEXT[] NOT NULL DEFAULT '{}',
+    CREATED_AT TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS APPLIED_BATCHES (
+    AGENT_ID TEXT NOT NULL,
+    SEQ BIGINT NOT NULL,
+    APPLIED_AT TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (AGENT_ID, SEQ)
+);
@@ -0,0 +1,3 @@
+CREATE OR REPLACE TRIGGER METRICS_RECORD_SAMPLE
+    AFTER INSERT OR UPDATE ON METRICS
+    FOR EACH ROW EXECUTE FUNCTION RECORD_METRIC_SAMPLE();
@@ -0,0 +1,10 @@
+-- placeholder rows inserted before locking a complex metric for merge have no value yet,
+-- they must not be recorded as samples
+CREATE OR REPLACE TRIGGER METRICS_RECORD_SAMPLE
+    AFTER INSERT OR UPDATE ON METRICS
+    FOR EACH ROW
+    WHEN (NEW.HISTOGRAM IS NOT NULL OR NEW.SUMMARY IS NOT NULL OR NEW.DELTA IS NOT NULL OR NEW.VALUE IS NOT NULL)
+    EXECUTE FUNCTION RECORD_METRIC_SAMPLE();
+
+DELETE FROM METRIC_SAMPLES
+WHERE HISTOGRAM IS NULL AND SUMMARY IS NULL AND DELTA IS NULL AND VALUE IS NULL;